@@ -0,0 +1,340 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+// Package airgap moves transactions between an online machine, which builds them,
+// and an offline machine with the Ledger, which signs them.
+//
+// The online side creates an unsigned Bundle with NewBundle. The offline side
+// checks it and signs it with Sign. Back online, AssembleTx verifies the
+// signature and returns the protobuf TxRaw to broadcast, signed in
+// SIGN_MODE_LEGACY_AMINO_JSON. Only MsgSend and MsgDeposit can be assembled.
+package airgap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+// FormatVersion is the version of the bundle file format written by this package
+const FormatVersion = 1
+
+// userHardenCount is the number of path elements hardened by the THORChain app
+const userHardenCount = 3
+
+// Bundle is an amino JSON sign doc with everything needed to sign it offline
+type Bundle struct {
+	Version int    `json:"version"`
+	Network string `json:"network"`
+	ChainID string `json:"chain_id"`
+	// Path is the bip32 path of the signer, e.g. m/44'/931'/0'/0/0
+	Path string `json:"path"`
+	// PubKey is the hex encoded compressed public key expected at Path
+	PubKey  string `json:"pubkey"`
+	Address string `json:"address"`
+	// Summary describes the transaction for the person signing it
+	Summary string `json:"summary"`
+	// SignDoc is the canonical amino JSON sign doc. It is kept in a string so that its bytes are preserved
+	SignDoc string `json:"sign_doc"`
+	// Signature is the base64 compact signature, empty until the bundle is signed
+	Signature string `json:"signature,omitempty"`
+}
+
+// aminoSignDoc contains the fields of a StdSignDoc used to describe and check the transaction
+type aminoSignDoc struct {
+	AccountNumber string            `json:"account_number"`
+	ChainID       string            `json:"chain_id"`
+	Fee           json.RawMessage   `json:"fee"`
+	Memo          string            `json:"memo"`
+	Msgs          []json.RawMessage `json:"msgs"`
+	Sequence      string            `json:"sequence"`
+}
+
+// NewBundle creates an unsigned bundle. The sign doc is canonicalized, must pass PreflightSignDoc
+// and must contain messages that AssembleTx can encode
+func NewBundle(network ledger.Network, bip32Path []uint32, pubKey []byte, signDoc []byte) (*Bundle, error) {
+	canonical, err := ledger.CanonicalizeAminoJSON(signDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	address, err := ledger.AddressFromPubKey(network.AccountHRP, pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bundle{
+		Version: FormatVersion,
+		Network: network.Name,
+		ChainID: network.ChainID,
+		Path:    ledger.FormatBip32Path(bip32Path, userHardenCount),
+		PubKey:  hex.EncodeToString(pubKey),
+		Address: address,
+		SignDoc: string(canonical),
+	}
+	if b.Summary, err = summarize(canonical); err != nil {
+		return nil, err
+	}
+	if err := b.Verify(); err != nil {
+		return nil, err
+	}
+
+	// Refuse what AssembleTx cannot encode before the sign doc goes to the offline machine
+	doc, err := ledger.ParseAminoSignDoc(canonical)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := encodeTxRaw(network.AccountHRP, doc, pubKey, nil); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ReadFile reads a bundle and verifies it
+func ReadFile(file string) (*Bundle, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bundle{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(b); err != nil {
+		return nil, fmt.Errorf("invalid bundle %s: %w", file, err)
+	}
+	if err := b.Verify(); err != nil {
+		return nil, fmt.Errorf("invalid bundle %s: %w", file, err)
+	}
+	return b, nil
+}
+
+// WriteFile writes a bundle. Existing files are not overwritten
+func (b *Bundle) WriteFile(file string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Verify checks that the fields of the bundle are consistent with each other:
+// the network, the chain id of the sign doc, the address of the public key,
+// the summary and, if the bundle is signed, the signature
+func (b *Bundle) Verify() error {
+	if b.Version != FormatVersion {
+		return fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+
+	network, err := ledger.NetworkByName(b.Network)
+	if err != nil {
+		return err
+	}
+	if b.ChainID != network.ChainID {
+		return fmt.Errorf("chain id %q does not match network %s", b.ChainID, network.Name)
+	}
+
+	if _, err := b.bip32Path(); err != nil {
+		return err
+	}
+
+	pubKey, err := b.pubKey()
+	if err != nil {
+		return err
+	}
+	address, err := ledger.AddressFromPubKey(network.AccountHRP, pubKey.SerializeCompressed())
+	if err != nil {
+		return err
+	}
+	if address != b.Address {
+		return fmt.Errorf("address %s does not match the public key (%s)", b.Address, address)
+	}
+
+	if err := ledger.PreflightSignDoc([]byte(b.SignDoc), ledger.DefaultSignDocLimits()); err != nil {
+		return err
+	}
+	doc, err := decodeSignDoc([]byte(b.SignDoc))
+	if err != nil {
+		return err
+	}
+	if doc.ChainID != b.ChainID {
+		return fmt.Errorf("sign doc chain id %q does not match %q", doc.ChainID, b.ChainID)
+	}
+
+	// The summary is shown to the signer, so it must describe this sign doc
+	summary, err := summarize([]byte(b.SignDoc))
+	if err != nil {
+		return err
+	}
+	if summary != b.Summary {
+		return errors.New("summary does not match the sign doc")
+	}
+
+	if b.Signature != "" {
+		return b.verifySignature(pubKey)
+	}
+	return nil
+}
+
+// IsSigned returns true if the bundle contains a signature
+func (b *Bundle) IsSigned() bool {
+	return b.Signature != ""
+}
+
+// Sign verifies an unsigned bundle, checks that the device holds the expected key and
+// signs the sign doc. It returns a signed copy of the bundle
+// this command requires user confirmation in the device
+func Sign(device ledger.SECP256K1Device, b *Bundle) (*Bundle, error) {
+	if b.IsSigned() {
+		return nil, errors.New("bundle is already signed")
+	}
+	if err := b.Verify(); err != nil {
+		return nil, err
+	}
+
+	path, _ := b.bip32Path()
+	devicePubKey, err := device.GetPublicKeySECP256K1(path)
+	if err != nil {
+		return nil, err
+	}
+	if hex.EncodeToString(devicePubKey) != b.PubKey {
+		return nil, fmt.Errorf("the device key at %s does not match the bundle public key", b.Path)
+	}
+
+	der, err := device.SignSECP256K1(path, []byte(b.SignDoc), byte(ledger.SignModeLegacyAmino))
+	if err != nil {
+		return nil, err
+	}
+	compact, err := ledger.SignatureToCompact(der)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := *b
+	signed.Signature = base64.StdEncoding.EncodeToString(compact)
+	if err := signed.Verify(); err != nil {
+		return nil, err
+	}
+	return &signed, nil
+}
+
+// AssembleTx verifies a signed bundle and returns the protobuf encoded TxRaw to broadcast.
+// BroadcastRequest wraps it for POST /cosmos/tx/v1beta1/txs
+func AssembleTx(b *Bundle) ([]byte, error) {
+	if !b.IsSigned() {
+		return nil, errors.New("bundle is not signed")
+	}
+	if err := b.Verify(); err != nil {
+		return nil, err
+	}
+
+	network, _ := ledger.NetworkByName(b.Network)
+	doc, err := ledger.ParseAminoSignDoc([]byte(b.SignDoc))
+	if err != nil {
+		return nil, err
+	}
+	pubKey, _ := hex.DecodeString(b.PubKey)
+	signature, _ := base64.StdEncoding.DecodeString(b.Signature)
+	return encodeTxRaw(network.AccountHRP, doc, pubKey, signature)
+}
+
+func (b *Bundle) bip32Path() ([]uint32, error) {
+	return ledger.ParseBip32Path(b.Path, userHardenCount)
+}
+
+func (b *Bundle) pubKey() (*btcec.PublicKey, error) {
+	data, err := hex.DecodeString(b.PubKey)
+	if err != nil {
+		return nil, errors.New("invalid public key encoding")
+	}
+	if len(data) != 33 {
+		return nil, errors.New("expected a 33 byte compressed public key")
+	}
+	return btcec.ParsePubKey(data)
+}
+
+func (b *Bundle) verifySignature(pubKey *btcec.PublicKey) error {
+	compact, err := base64.StdEncoding.DecodeString(b.Signature)
+	if err != nil || len(compact) != 64 {
+		return errors.New("invalid signature encoding: expected 64 bytes in base64")
+	}
+
+	var r, s btcec.ModNScalar
+	if r.SetByteSlice(compact[:32]) || s.SetByteSlice(compact[32:]) {
+		return errors.New("invalid signature: R or S overflows the curve order")
+	}
+
+	hash := sha256.Sum256([]byte(b.SignDoc))
+	if !ecdsa.NewSignature(&r, &s).Verify(hash[:], pubKey) {
+		return errors.New("signature verification failed")
+	}
+	return nil
+}
+
+func decodeSignDoc(signDoc []byte) (*aminoSignDoc, error) {
+	doc := &aminoSignDoc{}
+	if err := json.Unmarshal(signDoc, doc); err != nil {
+		return nil, fmt.Errorf("invalid sign doc: %w", err)
+	}
+	return doc, nil
+}
+
+// summarize describes a sign doc in a few lines
+func summarize(signDoc []byte) (string, error) {
+	doc, err := decodeSignDoc(signDoc)
+	if err != nil {
+		return "", err
+	}
+
+	lines := []string{
+		"chain: " + doc.ChainID,
+		fmt.Sprintf("account: %s, sequence: %s", doc.AccountNumber, doc.Sequence),
+		"fee: " + string(doc.Fee),
+	}
+	if doc.Memo != "" {
+		lines = append(lines, "memo: "+doc.Memo)
+	}
+	for i, msg := range doc.Msgs {
+		var typed struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(msg, &typed); err != nil || typed.Type == "" {
+			typed.Type = "unknown"
+		}
+		lines = append(lines, fmt.Sprintf("message %d: %s", i+1, typed.Type))
+	}
+	return strings.Join(lines, "\n"), nil
+}
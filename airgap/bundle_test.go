@@ -0,0 +1,151 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package airgap
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+// keyDevice signs with an in-memory key like the THORChain app
+type keyDevice struct {
+	key *btcec.PrivateKey
+}
+
+func (d *keyDevice) GetPublicKeySECP256K1(bip32Path []uint32) ([]byte, error) {
+	return d.key.PubKey().SerializeCompressed(), nil
+}
+
+func (d *keyDevice) SignSECP256K1(bip32Path []uint32, transaction []byte, p2 byte) ([]byte, error) {
+	hash := sha256.Sum256(transaction)
+	return ecdsa.Sign(d.key, hash[:]).Serialize(), nil
+}
+
+const testSignDoc = `{
+  "account_number": "12",
+  "chain_id": "thorchain-1",
+  "fee": {"amount": [], "gas": "4000000"},
+  "memo": "",
+  "msgs": [{"type": "thorchain/MsgSend", "value": {"amount": [{"amount": "100000000", "denom": "rune"}], "from_address": "thor10xcqpzrky6eff2g52qdye53xkk9jxkvr88r84u", "to_address": "thor1a0qwuze2h85zw7nqpsj3ga0z9geyrgwp3c7f03"}}],
+  "sequence": "3"
+}`
+
+func newTestBundle(t *testing.T) (*Bundle, *keyDevice) {
+	key, err := btcec.NewPrivateKey()
+	require.Nil(t, err)
+	device := &keyDevice{key}
+
+	b, err := NewBundle(ledger.Mainnet, []uint32{44, 931, 0, 0, 0}, key.PubKey().SerializeCompressed(), []byte(testSignDoc))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	return b, device
+}
+
+func Test_Bundle_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	unsigned, device := newTestBundle(t)
+
+	assert.Equal(t, "m/44'/931'/0'/0/0", unsigned.Path)
+	assert.Equal(t, "chain: thorchain-1\n"+
+		"account: 12, sequence: 3\n"+
+		`fee: {"amount":[],"gas":"4000000"}`+"\n"+
+		"message 1: thorchain/MsgSend", unsigned.Summary)
+	assert.True(t, strings.HasPrefix(unsigned.SignDoc, `{"account_number":"12","chain_id"`), "sign doc should be canonical")
+
+	// Online: write the unsigned bundle
+	require.Nil(t, unsigned.WriteFile(filepath.Join(dir, "unsigned.json")))
+	assert.Error(t, unsigned.WriteFile(filepath.Join(dir, "unsigned.json")), "existing files are not overwritten")
+
+	// Offline: read, sign, write
+	loaded, err := ReadFile(filepath.Join(dir, "unsigned.json"))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, unsigned, loaded)
+
+	signed, err := Sign(device, loaded)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.False(t, loaded.IsSigned())
+	assert.True(t, signed.IsSigned())
+	require.Nil(t, signed.WriteFile(filepath.Join(dir, "signed.json")))
+
+	_, err = Sign(device, signed)
+	assert.Error(t, err)
+
+	// Online: verify and assemble
+	loaded, err = ReadFile(filepath.Join(dir, "signed.json"))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	tx, err := AssembleTx(loaded)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	txRaw := decodeTestFields(t, tx)
+	body := decodeTestFields(t, txRaw[1][0])
+	require.Len(t, body[1], 1)
+	assert.Equal(t, "/types.MsgSend", string(decodeTestFields(t, body[1][0])[1][0]))
+	assert.Empty(t, body[2], "empty memo")
+
+	signerInfo := decodeTestFields(t, decodeTestFields(t, txRaw[2][0])[1][0])
+	assert.Equal(t, device.key.PubKey().SerializeCompressed(), decodeTestFields(t, decodeTestFields(t, signerInfo[1][0])[2][0])[1][0])
+	require.Len(t, txRaw[3], 1)
+	assert.Equal(t, signed.Signature, base64.StdEncoding.EncodeToString(txRaw[3][0]))
+
+	_, err = AssembleTx(unsigned)
+	assert.Error(t, err)
+}
+
+func Test_Bundle_Tampered(t *testing.T) {
+	unsigned, device := newTestBundle(t)
+	signed, err := Sign(device, unsigned)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	tampered := map[string]func(b *Bundle){
+		"version":   func(b *Bundle) { b.Version = 2 },
+		"network":   func(b *Bundle) { b.Network = "stagenet" },
+		"path":      func(b *Bundle) { b.Path = "m/44/931/0/0/0" },
+		"address":   func(b *Bundle) { b.Address = "thor1w508d6qejxtdg4y5r3zarvary0c5xw7ku6wp68" },
+		"summary":   func(b *Bundle) { b.Summary = "chain: thorchain-1" },
+		"sign doc":  func(b *Bundle) { b.SignDoc = strings.Replace(b.SignDoc, "100000000", "900000000", 1) },
+		"signature": func(b *Bundle) { b.Signature = unsigned.Signature + "AAAA" },
+	}
+	for name, change := range tampered {
+		b := *signed
+		change(&b)
+		assert.Error(t, b.Verify(), name)
+	}
+	assert.Nil(t, signed.Verify())
+
+	// The sign doc must match the chain of the network
+	_, err = NewBundle(ledger.Stagenet, []uint32{44, 931, 0, 0, 0}, device.key.PubKey().SerializeCompressed(), []byte(testSignDoc))
+	assert.Error(t, err)
+}
+
+func Test_Sign_WrongDevice(t *testing.T) {
+	unsigned, _ := newTestBundle(t)
+
+	other, err := btcec.NewPrivateKey()
+	require.Nil(t, err)
+	_, err = Sign(&keyDevice{other}, unsigned)
+	assert.Error(t, err)
+}
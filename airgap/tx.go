@@ -0,0 +1,274 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package airgap
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+// Type URLs of the messages and keys encoded in a transaction
+const (
+	msgSendTypeURL       = "/types.MsgSend"
+	msgDepositTypeURL    = "/types.MsgDeposit"
+	cosmosMsgSendTypeURL = "/cosmos.bank.v1beta1.MsgSend"
+	pubKeyTypeURL        = "/cosmos.crypto.secp256k1.PubKey"
+)
+
+// signModeLegacyAminoJSON is SIGN_MODE_LEGACY_AMINO_JSON in cosmos.tx.signing.v1beta1.SignMode
+const signModeLegacyAminoJSON = 127
+
+// BroadcastRequest returns the body of POST /cosmos/tx/v1beta1/txs for an assembled transaction
+func BroadcastRequest(txBytes []byte) ([]byte, error) {
+	return json.Marshal(struct {
+		TxBytes []byte `json:"tx_bytes"`
+		Mode    string `json:"mode"`
+	}{txBytes, "BROADCAST_MODE_SYNC"})
+}
+
+// encodeTxRaw returns the cosmos.tx.v1beta1.TxRaw of an amino sign doc signed by one key.
+// The chain rebuilds the amino JSON from the protobuf fields to check the signature, so
+// values that would not be written back identically are refused
+func encodeTxRaw(hrp string, doc *ledger.AminoSignDoc, pubKey []byte, signature []byte) ([]byte, error) {
+	body, err := encodeTxBody(hrp, doc)
+	if err != nil {
+		return nil, err
+	}
+	authInfo, err := encodeAuthInfo(doc, pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := appendMessage(nil, 1, body)
+	tx = appendMessage(tx, 2, authInfo)
+	return appendMessage(tx, 3, signature), nil
+}
+
+// encodeTxBody returns the cosmos.tx.v1beta1.TxBody with the messages and memo of a sign doc
+func encodeTxBody(hrp string, doc *ledger.AminoSignDoc) ([]byte, error) {
+	var body []byte
+	for i, msg := range doc.Msgs {
+		typeURL, value, err := encodeMsg(hrp, msg)
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %w", i+1, err)
+		}
+		body = appendMessage(body, 1, encodeAny(typeURL, value))
+	}
+	return appendString(body, 2, doc.Memo), nil
+}
+
+// encodeAuthInfo returns the cosmos.tx.v1beta1.AuthInfo with the signer and the fee of a sign doc
+func encodeAuthInfo(doc *ledger.AminoSignDoc, pubKey []byte) ([]byte, error) {
+	sequence, err := parseUint(doc.Sequence)
+	if err != nil {
+		return nil, fmt.Errorf("sequence: %w", err)
+	}
+	gas, err := parseUint(doc.Fee.Gas)
+	if err != nil {
+		return nil, fmt.Errorf("gas: %w", err)
+	}
+	feeAmount, err := encodeCoins(1, doc.Fee.Amount)
+	if err != nil {
+		return nil, fmt.Errorf("fee: %w", err)
+	}
+
+	single := appendUvarint(nil, 1, signModeLegacyAminoJSON)
+	signerInfo := appendMessage(nil, 1, encodeAny(pubKeyTypeURL, appendMessage(nil, 1, pubKey)))
+	signerInfo = appendMessage(signerInfo, 2, appendMessage(nil, 1, single))
+	signerInfo = appendUvarint(signerInfo, 3, sequence)
+
+	fee := appendUvarint(feeAmount, 2, gas)
+	return appendMessage(appendMessage(nil, 1, signerInfo), 2, fee), nil
+}
+
+// encodeMsg returns the type URL and the protobuf encoding of an amino message
+func encodeMsg(hrp string, msg ledger.AminoMsg) (string, []byte, error) {
+	decoded, err := msg.Decode()
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch m := decoded.(type) {
+	case *ledger.MsgSend:
+		from, err := decodeAddress(hrp, m.FromAddress)
+		if err != nil {
+			return "", nil, err
+		}
+		to, err := decodeAddress(hrp, m.ToAddress)
+		if err != nil {
+			return "", nil, err
+		}
+		amount, err := encodeCoins(3, m.Amount)
+		if err != nil {
+			return "", nil, err
+		}
+		if msg.Type == ledger.CosmosMsgSendType {
+			// The bank module keeps the bech32 addresses
+			value := appendString(appendString(nil, 1, m.FromAddress), 2, m.ToAddress)
+			return cosmosMsgSendTypeURL, append(value, amount...), nil
+		}
+		value := appendMessage(appendMessage(nil, 1, from), 2, to)
+		return msgSendTypeURL, append(value, amount...), nil
+
+	case *ledger.MsgDeposit:
+		var value []byte
+		for _, coin := range m.Coins {
+			encoded, err := encodeDepositCoin(coin)
+			if err != nil {
+				return "", nil, err
+			}
+			value = appendMessage(value, 1, encoded)
+		}
+		signer, err := decodeAddress(hrp, m.Signer)
+		if err != nil {
+			return "", nil, err
+		}
+		value = appendString(value, 2, m.Memo)
+		return msgDepositTypeURL, appendMessage(value, 3, signer), nil
+
+	default:
+		return "", nil, fmt.Errorf("message type %q cannot be encoded in a transaction", msg.Type)
+	}
+}
+
+// encodeCoins appends each coin as a cosmos.base.v1beta1.Coin in the given field
+func encodeCoins(field protowire.Number, coins []ledger.Coin) ([]byte, error) {
+	var encoded []byte
+	for _, coin := range coins {
+		if err := checkAmount(coin.Amount); err != nil {
+			return nil, err
+		}
+		encoded = appendMessage(encoded, field, appendString(appendString(nil, 1, coin.Denom), 2, coin.Amount))
+	}
+	return encoded, nil
+}
+
+// encodeDepositCoin returns the common.Coin of THORChain for a deposited coin
+func encodeDepositCoin(coin ledger.DepositCoin) ([]byte, error) {
+	asset, err := encodeAsset(coin.Asset)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAmount(coin.Amount); err != nil {
+		return nil, err
+	}
+
+	var decimals uint64
+	if coin.Decimals != "" {
+		if decimals, err = parseUint(coin.Decimals); err != nil || decimals > 1<<63-1 {
+			return nil, fmt.Errorf("invalid decimals %q", coin.Decimals)
+		}
+	}
+
+	encoded := appendMessage(nil, 1, asset)
+	encoded = appendString(encoded, 2, coin.Amount)
+	return appendUvarint(encoded, 3, decimals), nil
+}
+
+// encodeAsset returns the common.Asset of THORChain for an asset such as BTC.BTC. The chain and
+// symbol are split at the first separator, which also tells synth (/), trade (~) and secured (-) assets
+func encodeAsset(s string) ([]byte, error) {
+	i := strings.IndexAny(s, "./~-")
+	if i <= 0 || i == len(s)-1 {
+		return nil, fmt.Errorf("invalid asset %q", s)
+	}
+	if s != strings.ToUpper(s) {
+		return nil, fmt.Errorf("invalid asset %q: THORChain writes assets in upper case", s)
+	}
+
+	chain, symbol := s[:i], s[i+1:]
+	ticker := strings.SplitN(symbol, "-", 2)[0]
+
+	encoded := appendString(nil, 1, chain)
+	encoded = appendString(encoded, 2, symbol)
+	encoded = appendString(encoded, 3, ticker)
+	switch s[i] {
+	case '/':
+		encoded = appendUvarint(encoded, 4, 1)
+	case '~':
+		encoded = appendUvarint(encoded, 5, 1)
+	case '-':
+		encoded = appendUvarint(encoded, 6, 1)
+	}
+	return encoded, nil
+}
+
+// decodeAddress returns the bytes of a bech32 account address of the network
+func decodeAddress(hrp string, address string) ([]byte, error) {
+	addrHRP, data, err := ledger.Bech32Decode(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: %w", address, err)
+	}
+	if addrHRP != hrp || address != strings.ToLower(address) {
+		return nil, fmt.Errorf("invalid address %q: expected a lower case %s address", address, hrp)
+	}
+	return data, nil
+}
+
+// checkAmount verifies that an amount is written as the chain writes it, without leading zeros
+func checkAmount(s string) error {
+	amount, err := ledger.ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	if amount.String() != s {
+		return fmt.Errorf("invalid amount %q: expected %s", s, amount)
+	}
+	return nil
+}
+
+func parseUint(s string) (uint64, error) {
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || strconv.FormatUint(n, 10) != s {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return n, nil
+}
+
+func encodeAny(typeURL string, value []byte) []byte {
+	return appendMessage(appendString(nil, 1, typeURL), 2, value)
+}
+
+// appendMessage appends a length delimited field, even if it is empty
+func appendMessage(buf []byte, num protowire.Number, msg []byte) []byte {
+	buf = protowire.AppendTag(buf, num, protowire.BytesType)
+	return protowire.AppendBytes(buf, msg)
+}
+
+// appendString appends a string field. Proto3 omits empty strings
+func appendString(buf []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return buf
+	}
+	buf = protowire.AppendTag(buf, num, protowire.BytesType)
+	return protowire.AppendString(buf, s)
+}
+
+// appendUvarint appends a varint field. Proto3 omits zero values
+func appendUvarint(buf []byte, num protowire.Number, n uint64) []byte {
+	if n == 0 {
+		return buf
+	}
+	buf = protowire.AppendTag(buf, num, protowire.VarintType)
+	return protowire.AppendVarint(buf, n)
+}
@@ -0,0 +1,183 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package airgap
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+// decodeTestFields returns the values of each field of a protobuf message. Varints are returned encoded
+func decodeTestFields(t *testing.T, bz []byte) map[protowire.Number][][]byte {
+	fields := map[protowire.Number][][]byte{}
+	for len(bz) > 0 {
+		num, typ, n := protowire.ConsumeTag(bz)
+		require.True(t, n > 0, "invalid tag")
+		bz = bz[n:]
+
+		var value []byte
+		switch typ {
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(bz)
+		case protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(bz)
+			value = protowire.AppendVarint(nil, v)
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+		require.True(t, n > 0, "invalid field %d", num)
+		fields[num] = append(fields[num], value)
+		bz = bz[n:]
+	}
+	return fields
+}
+
+func varint(n uint64) []byte {
+	return protowire.AppendVarint(nil, n)
+}
+
+const (
+	testFrom = "thor10xcqpzrky6eff2g52qdye53xkk9jxkvr88r84u"
+	testTo   = "thor1a0qwuze2h85zw7nqpsj3ga0z9geyrgwp3c7f03"
+)
+
+func Test_AssembleTx_CosmosSDKVector(t *testing.T) {
+	// Encoded by the TxEncoder of the Cosmos SDK v0.50.10, which rebuilds the same sign doc from it
+	const vector = "0a98010a8d010a1c2f636f736d6f732e62616e6b2e763162657461312e4d736753656e64126d0a2b74686f723130786371707a726b79" +
+		"3665666632673532716479653533786b6b396a786b7672383872383475122b74686f723161307177757a65326838357a77376e7170736a33" +
+		"6761307a39676579726777703363376630331a110a0472756e6512093130303030303030301206616972676170126a0a500a460a1f2f636f" +
+		"736d6f732e63727970746f2e736563703235366b312e5075624b657912230a21031b84c5567b126440995d3ed5aaba0565d71e1834604819" +
+		"ff9c17f5e9d5dd078f12040a02087f180312160a0f0a0472756e65120732303030303030108092f4011a406fe7eab81e9bb120f869aa3cf1" +
+		"9ce076bf302c2f9e6feb57a875107c10c99e5e372b3503701f881b5a4b1d90363dbd687c629bbe5c6170c0a1c021c8e668ba23"
+	signDoc := `{"account_number":"12","chain_id":"thorchain-1","fee":{"amount":[{"amount":"2000000","denom":"rune"}],"gas":"4000000"},` +
+		`"memo":"airgap","msgs":[{"type":"cosmos-sdk/MsgSend","value":{"amount":[{"amount":"100000000","denom":"rune"}],` +
+		`"from_address":"` + testFrom + `","to_address":"` + testTo + `"}}],"sequence":"3"}`
+
+	key, _ := btcec.PrivKeyFromBytes(bytes.Repeat([]byte{0x01}, 32))
+	unsigned, err := NewBundle(ledger.Mainnet, []uint32{44, 931, 0, 0, 0}, key.PubKey().SerializeCompressed(), []byte(signDoc))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	signed, err := Sign(&keyDevice{key}, unsigned)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	tx, err := AssembleTx(signed)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, vector, hex.EncodeToString(tx))
+
+	// The TxRaw contains the body, the auth info and the signature
+	txRaw := decodeTestFields(t, tx)
+	authInfo := decodeTestFields(t, txRaw[2][0])
+	signerInfo := decodeTestFields(t, authInfo[1][0])
+	modeInfo := decodeTestFields(t, signerInfo[2][0])
+	assert.Equal(t, varint(127), decodeTestFields(t, modeInfo[1][0])[1][0], "SIGN_MODE_LEGACY_AMINO_JSON")
+	assert.Equal(t, varint(3), signerInfo[3][0])
+	assert.Equal(t, varint(4000000), decodeTestFields(t, authInfo[2][0])[2][0])
+
+	request, err := BroadcastRequest(tx)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.JSONEq(t, `{"tx_bytes":"`+base64.StdEncoding.EncodeToString(tx)+`","mode":"BROADCAST_MODE_SYNC"}`, string(request))
+}
+
+func Test_EncodeTxBody_Deposit(t *testing.T) {
+	doc, err := ledger.ParseAminoSignDoc([]byte(`{"account_number":"12","chain_id":"thorchain-1","fee":{"amount":[],"gas":"0"},"memo":"",` +
+		`"msgs":[{"type":"thorchain/MsgDeposit","value":{"coins":[{"amount":"250000000","asset":"THOR.RUNE"},{"amount":"1000","asset":"BTC/BTC","decimals":"8"}],` +
+		`"memo":"=:BTC.BTC:bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4","signer":"` + testFrom + `"}}],"sequence":"0"}`))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	body, err := encodeTxBody(ledger.Mainnet.AccountHRP, doc)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	fields := decodeTestFields(t, body)
+	assert.Empty(t, fields[2], "empty memo")
+	msg := decodeTestFields(t, fields[1][0])
+	assert.Equal(t, "/types.MsgDeposit", string(msg[1][0]))
+
+	deposit := decodeTestFields(t, msg[2][0])
+	require.Len(t, deposit[1], 2)
+	assert.Equal(t, "=:BTC.BTC:bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", string(deposit[2][0]))
+	_, signer, err := ledger.Bech32Decode(testFrom)
+	require.Nil(t, err)
+	assert.Equal(t, signer, deposit[3][0])
+
+	rune := decodeTestFields(t, deposit[1][0])
+	assert.Equal(t, "250000000", string(rune[2][0]))
+	assert.Empty(t, rune[3], "no decimals")
+	asset := decodeTestFields(t, rune[1][0])
+	assert.Equal(t, [][]byte{[]byte("THOR")}, asset[1])
+	assert.Equal(t, [][]byte{[]byte("RUNE")}, asset[2])
+	assert.Equal(t, [][]byte{[]byte("RUNE")}, asset[3])
+
+	synth := decodeTestFields(t, deposit[1][1])
+	assert.Equal(t, varint(8), synth[3][0])
+	assert.Equal(t, varint(1), decodeTestFields(t, synth[1][0])[4][0], "synth")
+}
+
+func Test_EncodeAsset(t *testing.T) {
+	valid := map[string][]string{
+		"ETH.USDC-0XA0B86991C6218B36C1D19D4A2E9EB0CE3606EB48": {"ETH", "USDC-0XA0B86991C6218B36C1D19D4A2E9EB0CE3606EB48", "USDC", ""},
+		"BTC/BTC": {"BTC", "BTC", "BTC", "synth"},
+		"BTC~BTC": {"BTC", "BTC", "BTC", "trade"},
+		"BTC-BTC": {"BTC", "BTC", "BTC", "secured"},
+	}
+	flags := map[string]protowire.Number{"synth": 4, "trade": 5, "secured": 6}
+	for s, expected := range valid {
+		encoded, err := encodeAsset(s)
+		require.Nil(t, err, "Detected error, err: %s\n", err)
+		fields := decodeTestFields(t, encoded)
+		assert.Equal(t, expected[0], string(fields[1][0]), s)
+		assert.Equal(t, expected[1], string(fields[2][0]), s)
+		assert.Equal(t, expected[2], string(fields[3][0]), s)
+		for name, num := range flags {
+			assert.Equal(t, name == expected[3], len(fields[num]) == 1, s+" "+name)
+		}
+	}
+
+	for _, s := range []string{"btc.btc", "BTC", ".BTC", "BTC.", ""} {
+		_, err := encodeAsset(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func Test_NewBundle_NotEncodable(t *testing.T) {
+	key, _ := btcec.PrivKeyFromBytes(bytes.Repeat([]byte{0x01}, 32))
+	cosmosAddress, err := ledger.Bech32Encode("cosmos", bytes.Repeat([]byte{1}, 20))
+	require.Nil(t, err)
+
+	// Sign docs that cannot be broadcast are refused before they are signed
+	invalid := map[string]func(doc string) string{
+		"message type": func(doc string) string {
+			return strings.Replace(doc, "thorchain/MsgSend", "cosmos-sdk/MsgMultiSend", 1)
+		},
+		"leading zero":   func(doc string) string { return strings.Replace(doc, `"100000000"`, `"0100000000"`, 1) },
+		"address prefix": func(doc string) string { return strings.Replace(doc, testTo, cosmosAddress, 1) },
+		"address":        func(doc string) string { return strings.Replace(doc, testTo, "thor1b", 1) },
+		"gas":            func(doc string) string { return strings.Replace(doc, `"4000000"`, `"-1"`, 1) },
+	}
+	for name, change := range invalid {
+		_, err := NewBundle(ledger.Mainnet, []uint32{44, 931, 0, 0, 0}, key.PubKey().SerializeCompressed(), []byte(change(testSignDoc)))
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,145 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Amino types of the messages decoded by AminoMsg.Decode
+const (
+	MsgSendType       = "thorchain/MsgSend"
+	CosmosMsgSendType = "cosmos-sdk/MsgSend"
+	MsgDepositType    = "thorchain/MsgDeposit"
+)
+
+// AminoSignDoc is a decoded amino JSON StdSignDoc
+type AminoSignDoc struct {
+	AccountNumber string     `json:"account_number"`
+	ChainID       string     `json:"chain_id"`
+	Fee           StdFee     `json:"fee"`
+	Memo          string     `json:"memo"`
+	Msgs          []AminoMsg `json:"msgs"`
+	Sequence      string     `json:"sequence"`
+}
+
+// StdFee is the fee of a sign doc
+type StdFee struct {
+	Amount []Coin `json:"amount"`
+	Gas    string `json:"gas"`
+}
+
+// Coin is an amount of a denom, in base units
+type Coin struct {
+	Amount string `json:"amount"`
+	Denom  string `json:"denom"`
+}
+
+// Int returns the amount of the coin
+func (c Coin) Int() (*big.Int, error) {
+	return ParseAmount(c.Amount)
+}
+
+// AminoMsg is a message of a sign doc, with its value still encoded
+type AminoMsg struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// MsgSend transfers coins between two accounts
+type MsgSend struct {
+	FromAddress string `json:"from_address"`
+	ToAddress   string `json:"to_address"`
+	Amount      []Coin `json:"amount"`
+}
+
+// MsgDeposit sends coins to the THORChain protocol, which acts on the memo
+type MsgDeposit struct {
+	Coins  []DepositCoin `json:"coins"`
+	Memo   string        `json:"memo"`
+	Signer string        `json:"signer"`
+}
+
+// DepositCoin is an amount of a THORChain asset such as THOR.RUNE
+type DepositCoin struct {
+	Asset    string `json:"asset"`
+	Amount   string `json:"amount"`
+	Decimals string `json:"decimals,omitempty"`
+}
+
+// Denom returns the native denom of the asset: THOR.RUNE is rune, other assets are lower cased
+func (c DepositCoin) Denom() string {
+	return strings.ToLower(strings.TrimPrefix(strings.ToUpper(c.Asset), "THOR."))
+}
+
+// Coin returns the deposit as a Coin of its denom
+func (c DepositCoin) Coin() Coin {
+	return Coin{Amount: c.Amount, Denom: c.Denom()}
+}
+
+// ParseAminoSignDoc decodes an amino JSON sign doc. Unknown fields are rejected so that
+// nothing in the document escapes the callers that inspect it
+func ParseAminoSignDoc(doc []byte) (*AminoSignDoc, error) {
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.DisallowUnknownFields()
+
+	signDoc := &AminoSignDoc{}
+	if err := decoder.Decode(signDoc); err != nil {
+		return nil, fmt.Errorf("invalid amino sign doc: %w", err)
+	}
+	if signDoc.ChainID == "" {
+		return nil, errors.New("invalid amino sign doc: chain_id is empty")
+	}
+	if len(signDoc.Msgs) == 0 {
+		return nil, errors.New("invalid amino sign doc: no messages")
+	}
+	return signDoc, nil
+}
+
+// Decode returns a *MsgSend or a *MsgDeposit, or nil for other message types
+func (m AminoMsg) Decode() (interface{}, error) {
+	var msg interface{}
+	switch m.Type {
+	case MsgSendType, CosmosMsgSendType:
+		msg = &MsgSend{}
+	case MsgDepositType:
+		msg = &MsgDeposit{}
+	default:
+		return nil, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(m.Value))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(msg); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", m.Type, err)
+	}
+	return msg, nil
+}
+
+// ParseAmount parses a non negative amount in base units
+func ParseAmount(s string) (*big.Int, error) {
+	amount, ok := new(big.Int).SetString(s, 10)
+	if !ok || amount.Sign() < 0 || strings.HasPrefix(s, "+") {
+		return nil, fmt.Errorf("invalid amount %q", s)
+	}
+	return amount, nil
+}
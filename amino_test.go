@@ -0,0 +1,70 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAminoSignDoc = `{"account_number":"12","chain_id":"thorchain-1","fee":{"amount":[{"amount":"2000000","denom":"rune"}],"gas":"4000000"},"memo":"","msgs":[` +
+	`{"type":"thorchain/MsgSend","value":{"amount":[{"amount":"100000000","denom":"rune"}],"from_address":"thor1a","to_address":"thor1b"}},` +
+	`{"type":"thorchain/MsgDeposit","value":{"coins":[{"amount":"50000000","asset":"THOR.RUNE"}],"memo":"=:BTC.BTC:bc1qdest","signer":"thor1a"}},` +
+	`{"type":"thorchain/MsgSetNodeKeys","value":{}}],"sequence":"3"}`
+
+func Test_ParseAminoSignDoc(t *testing.T) {
+	doc, err := ParseAminoSignDoc([]byte(testAminoSignDoc))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, "thorchain-1", doc.ChainID)
+	assert.Equal(t, []Coin{{Amount: "2000000", Denom: "rune"}}, doc.Fee.Amount)
+	require.Len(t, doc.Msgs, 3)
+
+	msg, err := doc.Msgs[0].Decode()
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	send := msg.(*MsgSend)
+	assert.Equal(t, "thor1b", send.ToAddress)
+	amount, err := send.Amount[0].Int()
+	require.Nil(t, err)
+	assert.Equal(t, big.NewInt(100000000), amount)
+
+	msg, err = doc.Msgs[1].Decode()
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	deposit := msg.(*MsgDeposit)
+	assert.Equal(t, Coin{Amount: "50000000", Denom: "rune"}, deposit.Coins[0].Coin())
+
+	msg, err = doc.Msgs[2].Decode()
+	assert.Nil(t, err)
+	assert.Nil(t, msg)
+}
+
+func Test_ParseAminoSignDoc_Errors(t *testing.T) {
+	_, err := ParseAminoSignDoc([]byte(`{"chain_id":"thorchain-1","msgs":[],"extra":1}`))
+	assert.Error(t, err)
+	_, err = ParseAminoSignDoc([]byte(`{"chain_id":"thorchain-1","msgs":[]}`))
+	assert.Error(t, err)
+
+	_, err = AminoMsg{Type: MsgSendType, Value: []byte(`{"to_address":"thor1b","hidden":true}`)}.Decode()
+	assert.Error(t, err)
+
+	for _, amount := range []string{"", "-1", "+1", "1.5", "0x10"} {
+		_, err = ParseAmount(amount)
+		assert.Error(t, err, amount)
+	}
+}
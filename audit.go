@@ -0,0 +1,321 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Outcomes of an audited sign request
+const (
+	AuditSigned   = "signed"
+	AuditRejected = "rejected"
+	AuditError    = "error"
+)
+
+// Apps reported in audit records
+const (
+	AuditAppTHORChain = "thorchain"
+	AuditAppValidator = "validator"
+)
+
+// AuditRecord is a line of the audit log
+type AuditRecord struct {
+	Seq           uint64    `json:"seq"`
+	Time          time.Time `json:"time"`
+	App           string    `json:"app"`
+	Path          string    `json:"path"`
+	PubKey        string    `json:"pubkey,omitempty"`
+	SignMode      string    `json:"sign_mode"`
+	PayloadSHA256 string    `json:"payload_sha256"`
+	Outcome       string    `json:"outcome"`
+	Signature     string    `json:"signature,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	// Prev is the SHA-256 of the previous line, empty for the first one
+	Prev string `json:"prev"`
+}
+
+// AuditRecorder receives a record for every sign request sent to a device.
+// It is implemented by AuditLog
+type AuditRecorder interface {
+	Record(record AuditRecord) error
+}
+
+// AuditHead identifies the last line of an audit log. Keeping heads outside of the
+// log allows VerifyAuditLog to detect that lines were removed from the end.
+// AuditLog persists its head in a head file after every record
+type AuditHead struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// AuditLogError an audit log was modified or truncated
+type AuditLogError struct {
+	Line   int
+	Reason string
+}
+
+func (e AuditLogError) Error() string {
+	if e.Line == 0 {
+		return "audit log verification failed: " + e.Reason
+	}
+	return fmt.Sprintf("audit log verification failed at line %d: %s", e.Line, e.Reason)
+}
+
+// AuditLog appends hash-chained JSON lines to a file
+type AuditLog struct {
+	mtx      sync.Mutex
+	file     *os.File
+	headFile string
+	head     AuditHead
+	now      func() time.Time
+}
+
+// AuditHeadFile is the head file used by OpenAuditLog for a log
+func AuditHeadFile(file string) string {
+	return file + ".head"
+}
+
+// OpenAuditLog opens an audit log for appending, keeping its head in AuditHeadFile(file)
+func OpenAuditLog(file string) (*AuditLog, error) {
+	return OpenAuditLogHead(file, AuditHeadFile(file))
+}
+
+// OpenAuditLogHead opens an audit log for appending and keeps its head in headFile.
+// Placing the head file in another directory than the log, e.g. one the log rotation
+// and shipping tools cannot write, means that truncating the log and rewriting its
+// head takes access to both.
+// An existing log is verified against the head first and the chain continues from there
+func OpenAuditLogHead(file string, headFile string) (*AuditLog, error) {
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := verifyAuditLogHead(f, headFile)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	// The log is ahead of the head file if the process stopped between the two writes
+	if err := writeAuditHead(headFile, head); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &AuditLog{file: f, headFile: headFile, head: head, now: time.Now}, nil
+}
+
+// verifyAuditLogHead verifies a log against its head file. A missing head file is
+// only accepted for an empty log
+func verifyAuditLogHead(r io.Reader, headFile string) (AuditHead, error) {
+	persisted, err := ReadAuditHead(headFile)
+	if errors.Is(err, os.ErrNotExist) {
+		head, err := VerifyAuditLog(r)
+		if err == nil && head.Seq != 0 {
+			return AuditHead{}, &AuditLogError{0, "the head file " + headFile + " is missing"}
+		}
+		return head, err
+	}
+	if err != nil {
+		return AuditHead{}, err
+	}
+	return VerifyAuditLog(r, persisted)
+}
+
+// ReadAuditHead reads a head file written by AuditLog
+func ReadAuditHead(headFile string) (AuditHead, error) {
+	data, err := os.ReadFile(headFile)
+	if err != nil {
+		return AuditHead{}, err
+	}
+
+	var head AuditHead
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&head); err != nil {
+		return AuditHead{}, fmt.Errorf("%s: invalid head: %w", headFile, err)
+	}
+	return head, nil
+}
+
+// writeAuditHead replaces the head file, so that it is never partially written
+func writeAuditHead(headFile string, head AuditHead) error {
+	data, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+
+	tmp := headFile + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, headFile)
+}
+
+// Record completes a record with its sequence number, time and chain hash and writes it.
+// The log and the head file are synced before Record returns
+func (l *AuditLog) Record(record AuditRecord) error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.file == nil {
+		return errors.New("audit log is closed")
+	}
+
+	record.Seq = l.head.Seq + 1
+	record.Time = l.now().UTC()
+	record.Prev = l.head.Hash
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+
+	l.head = AuditHead{Seq: record.Seq, Hash: auditLineHash(line)}
+	return writeAuditHead(l.headFile, l.head)
+}
+
+// Head returns the last line written
+func (l *AuditLog) Head() AuditHead {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.head
+}
+
+// Close closes the file
+func (l *AuditLog) Close() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// VerifyAuditLog checks the chain of an audit log and returns its head.
+// Each checkpoint must match the line with the same sequence number
+func VerifyAuditLog(r io.Reader, checkpoints ...AuditHead) (AuditHead, error) {
+	expected := map[uint64]string{}
+	for _, c := range checkpoints {
+		expected[c.Seq] = c.Hash
+	}
+
+	var head AuditHead
+	reader := bufio.NewReader(r)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err == io.EOF {
+			return AuditHead{}, &AuditLogError{lineNumber, "the last line is incomplete"}
+		}
+		if err != nil {
+			return AuditHead{}, err
+		}
+		line = bytes.TrimSuffix(line, []byte{'\n'})
+
+		var record AuditRecord
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&record); err != nil {
+			return AuditHead{}, &AuditLogError{lineNumber, "invalid record: " + err.Error()}
+		}
+		if record.Seq != head.Seq+1 {
+			return AuditHead{}, &AuditLogError{lineNumber, fmt.Sprintf("sequence %d follows %d", record.Seq, head.Seq)}
+		}
+		if record.Prev != head.Hash {
+			return AuditHead{}, &AuditLogError{lineNumber, "the hash of the previous line does not match"}
+		}
+
+		head = AuditHead{Seq: record.Seq, Hash: auditLineHash(line)}
+		if hash, ok := expected[head.Seq]; ok && hash != head.Hash {
+			return AuditHead{}, &AuditLogError{lineNumber, "the line does not match the checkpoint"}
+		}
+	}
+
+	for _, c := range checkpoints {
+		if c.Seq > head.Seq {
+			return AuditHead{}, &AuditLogError{0, fmt.Sprintf("the log ends at %d, before the checkpoint %d", head.Seq, c.Seq)}
+		}
+	}
+	return head, nil
+}
+
+func auditLineHash(line []byte) string {
+	hash := sha256.Sum256(line)
+	return hex.EncodeToString(hash[:])
+}
+
+// auditSign records the result of a sign request. The signature is withheld if the record cannot be written
+func auditSign(recorder AuditRecorder, record AuditRecord, payload []byte, signature []byte, signErr error) ([]byte, error) {
+	hash := sha256.Sum256(payload)
+	record.PayloadSHA256 = hex.EncodeToString(hash[:])
+
+	switch {
+	case signErr == nil:
+		record.Outcome = AuditSigned
+		record.Signature = hex.EncodeToString(signature)
+	case StatusWord(signErr) == StatusWordRejected:
+		record.Outcome = AuditRejected
+		record.Error = signErr.Error()
+	default:
+		record.Outcome = AuditError
+		record.Error = signErr.Error()
+	}
+
+	if err := recorder.Record(record); err != nil {
+		if signErr != nil {
+			return nil, signErr
+		}
+		return nil, fmt.Errorf("signature withheld, could not write the audit record: %w", err)
+	}
+	return signature, signErr
+}
@@ -0,0 +1,290 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestAuditLog(t *testing.T) (*AuditLog, string) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+	log, err := OpenAuditLog(file)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	log.now = func() time.Time { return time.Unix(1700000000, 0) }
+	return log, file
+}
+
+func readAuditRecords(t *testing.T, file string) []AuditRecord {
+	data, err := os.ReadFile(file)
+	require.Nil(t, err)
+
+	var records []AuditRecord
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var record AuditRecord
+		require.Nil(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func Test_AuditLog_Validator(t *testing.T) {
+	device, key := newMockValidatorVersion(1, VersionInfo{0, 1, 0, 0}, FramingPayloadDescriptor)
+	validatorApp := newNegotiatedValidator(t, device)
+	log, file := openTestAuditLog(t)
+	validatorApp.SetAuditLog(log)
+	path := []uint32{44, 118, 0, 0, 0}
+
+	message := []byte("vote")
+	signature, err := validatorApp.SignED25519(path, message)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	// The device rejects the next request
+	handler := device.handler
+	device.handler = func(command []byte) ([]byte, error) {
+		if command[1] == validatorINSSignED25519 && command[2] == payloadLast {
+			return nil, errors.New(errUserRejected)
+		}
+		return handler(command)
+	}
+	_, err = validatorApp.SignED25519(path, []byte("proposal"))
+	assert.EqualError(t, err, errUserRejected)
+	require.Nil(t, log.Close())
+
+	// The public key is requested once per path
+	pubKeyRequests := 0
+	for _, command := range device.sent {
+		if command[1] == validatorINSPublicKeyED25519 {
+			pubKeyRequests++
+		}
+	}
+	assert.Equal(t, 2, pubKeyRequests, "the negotiation probe and the first record")
+
+	records := readAuditRecords(t, file)
+	require.Len(t, records, 2)
+	assert.Equal(t, AuditRecord{
+		Seq:           1,
+		Time:          time.Unix(1700000000, 0).UTC(),
+		App:           AuditAppValidator,
+		Path:          "m/44'/118'/0'/0'/0'",
+		PubKey:        hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+		SignMode:      "ed25519",
+		PayloadSHA256: "ab274474a6aa82c100dddca63977facb556f66f489fb558c044a456f9ba919ce",
+		Outcome:       AuditSigned,
+		Signature:     hex.EncodeToString(signature),
+	}, records[0])
+	assert.Equal(t, AuditRejected, records[1].Outcome)
+	assert.Equal(t, uint64(2), records[1].Seq)
+	assert.NotEmpty(t, records[1].Prev)
+}
+
+func Test_AuditLog_User(t *testing.T) {
+	pubKey := bytes.Repeat([]byte{2}, 33)
+	device := newMockDevice()
+	device.handler = func(command []byte) ([]byte, error) {
+		switch command[1] {
+		case userINSGetAddrSecp256k1:
+			return append(append([]byte{}, pubKey...), "thor1address"...), nil
+		case userINSSignSECP256K1:
+			if command[2] == payloadLast {
+				return []byte{0x30, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01, 0x01}, nil
+			}
+			return nil, nil
+		}
+		return nil, errors.New("[APDU_CODE_INS_NOT_SUPPORTED] Instruction code not supported or invalid")
+	}
+	userApp := &LedgerTHORChain{api: device, version: VersionInfo{0, 2, 34, 0}}
+	log, file := openTestAuditLog(t)
+	userApp.SetAuditLog(log)
+
+	signDoc := []byte(`{"account_number":"1","chain_id":"thorchain-1","fee":{},"memo":"","msgs":[],"sequence":"0"}`)
+	_, err := userApp.SignSECP256K1([]uint32{44, 931, 0, 0, 0}, signDoc, 0)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	// Sign docs refused before reaching the device are recorded as errors
+	_, err = userApp.SignSECP256K1([]uint32{44, 931, 0, 0, 0}, []byte(`{"b":1, "a":2}`), 0)
+	assert.Error(t, err)
+
+	// The public key is requested once per path
+	pubKeyRequests := 0
+	for _, command := range device.sent {
+		if command[1] == userINSGetAddrSecp256k1 {
+			pubKeyRequests++
+		}
+	}
+	assert.Equal(t, 1, pubKeyRequests)
+
+	records := readAuditRecords(t, file)
+	require.Len(t, records, 2)
+	assert.Equal(t, records[0].PubKey, records[1].PubKey)
+	assert.Equal(t, AuditAppTHORChain, records[0].App)
+	assert.Equal(t, "m/44'/931'/0'/0/0", records[0].Path)
+	assert.Equal(t, hex.EncodeToString(pubKey), records[0].PubKey)
+	assert.Equal(t, "amino", records[0].SignMode)
+	assert.Equal(t, AuditSigned, records[0].Outcome)
+	assert.Equal(t, "3006020101020101", records[0].Signature)
+	assert.Equal(t, AuditError, records[1].Outcome)
+	assert.Empty(t, records[1].Signature)
+}
+
+// failingRecorder cannot write records
+type failingRecorder struct{}
+
+func (failingRecorder) Record(AuditRecord) error {
+	return errors.New("disk full")
+}
+
+func Test_AuditLog_Withheld(t *testing.T) {
+	device, _ := newMockValidatorVersion(1, VersionInfo{0, 1, 0, 0}, FramingPayloadDescriptor)
+	validatorApp := newNegotiatedValidator(t, device)
+	validatorApp.SetAuditLog(failingRecorder{})
+
+	signature, err := validatorApp.SignED25519([]uint32{44, 118, 0, 0, 0}, []byte("vote"))
+	assert.Nil(t, signature)
+	assert.ErrorContains(t, err, "signature withheld")
+}
+
+// lastRecorder keeps the last record
+type lastRecorder struct {
+	record AuditRecord
+}
+
+func (r *lastRecorder) Record(record AuditRecord) error {
+	r.record = record
+	return nil
+}
+
+func Test_AuditSign_Outcome(t *testing.T) {
+	// Rejections are found by status word, whatever the message of the transport
+	outcomes := map[string]string{
+		errUserRejected:    AuditRejected,
+		"Error code: 6986": AuditRejected,
+		"Error code: 6985": AuditError,
+		"hidapi: failed":   AuditError,
+	}
+	for message, outcome := range outcomes {
+		recorder := &lastRecorder{}
+		_, err := auditSign(recorder, AuditRecord{}, []byte("vote"), nil, errors.New(message))
+		assert.EqualError(t, err, message)
+		assert.Equal(t, outcome, recorder.record.Outcome, message)
+	}
+}
+
+func Test_VerifyAuditLog(t *testing.T) {
+	log, file := openTestAuditLog(t)
+	for i := 0; i < 3; i++ {
+		require.Nil(t, log.Record(AuditRecord{App: AuditAppTHORChain, Outcome: AuditSigned}))
+	}
+	head := log.Head()
+	require.Nil(t, log.Close())
+
+	data, err := os.ReadFile(file)
+	require.Nil(t, err)
+	verified, err := VerifyAuditLog(bytes.NewReader(data), head)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, head, verified)
+	assert.Equal(t, uint64(3), head.Seq)
+
+	// Reopening continues the chain
+	log, err = OpenAuditLog(file)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	require.Nil(t, log.Record(AuditRecord{App: AuditAppValidator, Outcome: AuditError}))
+	assert.Equal(t, uint64(4), log.Head().Seq)
+	require.Nil(t, log.Close())
+	data, err = os.ReadFile(file)
+	require.Nil(t, err)
+	_, err = VerifyAuditLog(bytes.NewReader(data), head)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	lines := strings.SplitAfter(string(data), "\n")
+	tampered := map[string]string{
+		"edited":       strings.Join(lines[:1], "") + strings.Replace(lines[1], `"signed"`, `"error"`, 1) + strings.Join(lines[2:], ""),
+		"removed":      lines[0] + strings.Join(lines[2:], ""),
+		"partial line": string(data[:len(data)-10]),
+		"truncated":    strings.Join(lines[:2], ""),
+		"reordered":    lines[1] + lines[0] + strings.Join(lines[2:], ""),
+	}
+	for name, content := range tampered {
+		_, err := VerifyAuditLog(strings.NewReader(content), head)
+		var logErr *AuditLogError
+		assert.True(t, errors.As(err, &logErr), name)
+	}
+
+	require.Nil(t, os.WriteFile(file, []byte(tampered["edited"]), 0o600))
+	_, err = OpenAuditLog(file)
+	assert.Error(t, err)
+}
+
+func Test_AuditLog_HeadFile(t *testing.T) {
+	log, file := openTestAuditLog(t)
+	for i := 0; i < 3; i++ {
+		require.Nil(t, log.Record(AuditRecord{App: AuditAppTHORChain, Outcome: AuditSigned}))
+	}
+	head := log.Head()
+	require.Nil(t, log.Close())
+
+	persisted, err := ReadAuditHead(AuditHeadFile(file))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, head, persisted)
+
+	data, err := os.ReadFile(file)
+	require.Nil(t, err)
+	lines := strings.SplitAfter(string(data), "\n")
+
+	// A log truncated to a valid chain does not match its head
+	require.Nil(t, os.WriteFile(file, []byte(strings.Join(lines[:2], "")), 0o600))
+	_, err = OpenAuditLog(file)
+	var logErr *AuditLogError
+	assert.True(t, errors.As(err, &logErr), "Detected error, err: %s\n", err)
+
+	// A log without its head file
+	require.Nil(t, os.WriteFile(file, data, 0o600))
+	require.Nil(t, os.Rename(AuditHeadFile(file), file+".saved"))
+	_, err = OpenAuditLog(file)
+	assert.True(t, errors.As(err, &logErr), "Detected error, err: %s\n", err)
+
+	// The head file is behind when the process stops between the two writes
+	require.Nil(t, os.Rename(file+".saved", AuditHeadFile(file)))
+	require.Nil(t, os.WriteFile(file, []byte(string(data)+`{"seq":4,"time":"2023-11-14T22:13:20Z","app":"thorchain","path":"","sign_mode":"","payload_sha256":"","outcome":"signed","prev":"`+head.Hash+`"}`+"\n"), 0o600))
+	log, err = OpenAuditLog(file)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	require.Nil(t, log.Close())
+	persisted, err = ReadAuditHead(AuditHeadFile(file))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, uint64(4), persisted.Seq)
+
+	// The head file can be kept elsewhere
+	headFile := filepath.Join(t.TempDir(), "audit.head")
+	log, err = OpenAuditLogHead(filepath.Join(t.TempDir(), "audit.log"), headFile)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	require.Nil(t, log.Record(AuditRecord{App: AuditAppValidator, Outcome: AuditSigned}))
+	require.Nil(t, log.Close())
+	persisted, err = ReadAuditHead(headFile)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, log.Head(), persisted)
+}
@@ -0,0 +1,210 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var bech32Generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+// bech32mConst is the checksum constant of bech32m, used by the segwit addresses of version 1 and above
+const bech32mConst = 0x2bc830a3
+
+// bech32MaxLength is the longest bech32 string allowed by BIP-0173
+const bech32MaxLength = 90
+
+// Bech32Encode encodes data (8 bits per byte) with the given human readable part, as defined by BIP-0173
+func Bech32Encode(hrp string, data []byte) (string, error) {
+	if len(hrp) == 0 {
+		return "", errors.New("hrp should not be empty")
+	}
+	for _, b := range []byte(hrp) {
+		if !validHRPByte(b) {
+			return "", errors.New("all characters in the HRP must be in the [33, 126] range")
+		}
+	}
+	hrp = strings.ToLower(hrp)
+
+	values, err := convertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	values = append(values, bech32Checksum(hrp, values)...)
+
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, v := range values {
+		sb.WriteByte(bech32Charset[v])
+	}
+	return sb.String(), nil
+}
+
+// Bech32Decode decodes a bech32 string of at most 90 characters and returns the human readable part
+// and the data (8 bits per byte)
+func Bech32Decode(s string) (string, []byte, error) {
+	if len(s) > bech32MaxLength {
+		return "", nil, fmt.Errorf("invalid bech32 string: longer than %d characters", bech32MaxLength)
+	}
+	hrp, values, err := bech32Values(s)
+	if err != nil {
+		return "", nil, err
+	}
+	if bech32Polymod(append(bech32HRPExpand(hrp), values...)) != 1 {
+		return "", nil, errors.New("invalid bech32 checksum")
+	}
+
+	data, err := convertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+	return hrp, data, nil
+}
+
+// SegwitAddressDecode decodes a segwit address of the given human readable part, as defined by BIP-0173
+// for version 0 and BIP-0350 (bech32m) for the next versions. It returns the witness version and program
+func SegwitAddressDecode(hrp string, addr string) (byte, []byte, error) {
+	if len(addr) > bech32MaxLength {
+		return 0, nil, fmt.Errorf("invalid segwit address: longer than %d characters", bech32MaxLength)
+	}
+	addrHRP, values, err := bech32Values(addr)
+	if err != nil {
+		return 0, nil, err
+	}
+	if addrHRP != hrp {
+		return 0, nil, fmt.Errorf("invalid address prefix %q, expected %q", addrHRP, hrp)
+	}
+	if len(values) < 7 || values[0] > 16 {
+		return 0, nil, errors.New("invalid witness version")
+	}
+
+	version := values[0]
+	checksum := uint32(1)
+	if version > 0 {
+		checksum = bech32mConst
+	}
+	if bech32Polymod(append(bech32HRPExpand(hrp), values...)) != checksum {
+		return 0, nil, errors.New("invalid bech32 checksum")
+	}
+
+	program, err := convertBits(values[1:len(values)-6], 5, 8, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(program) < 2 || len(program) > 40 || (version == 0 && len(program) != 20 && len(program) != 32) {
+		return 0, nil, fmt.Errorf("invalid witness program of %d bytes", len(program))
+	}
+	return version, program, nil
+}
+
+// bech32Values splits a bech32 string in the human readable part and the 5 bit values, including the checksum
+func bech32Values(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("bech32 string should not mix upper and lower case")
+	}
+	s = strings.ToLower(s)
+
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+7 > len(s) {
+		return "", nil, errors.New("invalid bech32 string: separator")
+	}
+	hrp := s[:sep]
+	for _, b := range []byte(hrp) {
+		if !validHRPByte(b) {
+			return "", nil, errors.New("all characters in the HRP must be in the [33, 126] range")
+		}
+	}
+
+	values := make([]byte, 0, len(s)-sep-1)
+	for _, c := range s[sep+1:] {
+		v := strings.IndexRune(bech32Charset, c)
+		if v < 0 {
+			return "", nil, fmt.Errorf("invalid bech32 character %q", c)
+		}
+		values = append(values, byte(v))
+	}
+	return hrp, values, nil
+}
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= bech32Generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	result := make([]byte, 0, len(hrp)*2+1)
+	for _, c := range []byte(hrp) {
+		result = append(result, c>>5)
+	}
+	result = append(result, 0)
+	for _, c := range []byte(hrp) {
+		result = append(result, c&31)
+	}
+	return result
+}
+
+func bech32Checksum(hrp string, values []byte) []byte {
+	polymod := bech32Polymod(append(append(bech32HRPExpand(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	checksum := make([]byte, 6)
+	for i := range checksum {
+		checksum[i] = byte(polymod>>uint(5*(5-i))) & 31
+	}
+	return checksum
+}
+
+// convertBits regroups bits, e.g. from bytes to the 5 bit groups used by bech32
+func convertBits(data []byte, fromBits uint, toBits uint, pad bool) ([]byte, error) {
+	acc := uint32(0)
+	bits := uint(0)
+	maxv := uint32(1)<<toBits - 1
+	result := make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1)
+
+	for _, value := range data {
+		if uint32(value)>>fromBits != 0 {
+			return nil, errors.New("invalid data range")
+		}
+		acc = acc<<fromBits | uint32(value)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			result = append(result, byte(acc>>bits&maxv))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			result = append(result, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return result, nil
+}
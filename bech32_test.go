@@ -0,0 +1,115 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Bech32Decode_BIP173(t *testing.T) {
+	// Valid test vectors from BIP-0173
+	valid := []string{
+		"A12UEL5L",
+		"a12uel5l",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+		"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+		"11qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqc8247j",
+	}
+	for _, s := range valid {
+		hrp, data, err := Bech32Decode(s)
+		require.Nil(t, err, "Detected error, err: %s\n", err)
+
+		encoded, err := Bech32Encode(hrp, data)
+		require.Nil(t, err, "Detected error, err: %s\n", err)
+		assert.Equal(t, strings.ToLower(s), encoded)
+	}
+}
+
+func Test_Bech32Decode_Invalid(t *testing.T) {
+	invalid := []string{
+		"pzry9x0s0muk",     // no separator
+		"1pzry9x0s0muk",    // empty hrp
+		"x1b4n0q5v",        // invalid data character
+		"li1dgmt3",         // checksum too short
+		"A1G7SGD8",         // checksum calculated with uppercase hrp
+		"a12UEL5L",         // mixed case
+		"thor1qqqqqqqqqqq", // wrong checksum
+		// overall max length exceeded
+		"an84characterslonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1569pvx",
+	}
+	for _, s := range invalid {
+		_, _, err := Bech32Decode(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func Test_Bech32Encode(t *testing.T) {
+	encoded, err := Bech32Encode("thor", []byte{0, 1, 2, 3, 4, 5})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	hrp, data, err := Bech32Decode(encoded)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, "thor", hrp)
+	assert.Equal(t, []byte{0, 1, 2, 3, 4, 5}, data)
+
+	_, err = Bech32Encode("", []byte{1})
+	assert.Error(t, err)
+}
+
+func Test_SegwitAddressDecode(t *testing.T) {
+	// Test vectors from BIP-0173 and BIP-0350
+	valid := []struct {
+		hrp     string
+		address string
+		version byte
+		program string
+	}{
+		{"bc", "BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", 0, "751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"tb", "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", 0, "1863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		{"bc", "bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7kt5nd6y", 1, "751e76e8199196d454941c45d1b3a323f1433bd6751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"bitcoinsegwittestnet", "bitcoinsegwittestnet1pw46h2at4w46h2at4w46h2at4w46h2at4w46h2at4w46h2at4w46spete87", 1, strings.Repeat("75", 32)},
+	}
+	for _, tc := range valid {
+		version, program, err := SegwitAddressDecode(tc.hrp, tc.address)
+		require.Nil(t, err, "Detected error, err: %s\n", err)
+		assert.Equal(t, tc.version, version, tc.address)
+		assert.Equal(t, tc.program, hex.EncodeToString(program), tc.address)
+	}
+
+	invalid := []struct {
+		hrp     string
+		address string
+	}{
+		{"bc", "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7"},
+		// version 1 with a bech32 checksum
+		{"bc", "bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7k7grplx"},
+		// version 0 with a bech32m checksum
+		{"tb", "tb1q0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq24jc47"},
+		// a valid checksum and 40 byte program, but 92 characters
+		{"bitcoinsegwittestnet", "bitcoinsegwittestnet1pw46h2at4w46h2at4w46h2at4w46h2at4w46h2at4w46h2at4w46h2at4w46h2at4lql3t3"},
+	}
+	for _, tc := range invalid {
+		_, _, err := SegwitAddressDecode(tc.hrp, tc.address)
+		assert.Error(t, err, tc.address)
+	}
+}
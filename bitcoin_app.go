@@ -0,0 +1,362 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/zondax/ledger-go"
+)
+
+const (
+	btcCLA          = 0xE1
+	btcCLAFramework = 0xF8
+
+	btcINSGetExtendedPubKey    = 0x00
+	btcINSRegisterWallet       = 0x02
+	btcINSGetWalletAddress     = 0x03
+	btcINSSignPSBT             = 0x04
+	btcINSGetMasterFingerprint = 0x05
+	btcINSContinueInterrupted  = 0x01
+
+	// btcProtocolVersion is sent in P2 of every command
+	btcProtocolVersion = 1
+
+	// btcSWInterrupted is returned when the app needs the client to answer a command
+	btcSWInterrupted = 0xE000
+
+	// BitcoinAppName is the name of the Bitcoin app
+	BitcoinAppName = "Bitcoin"
+	// BitcoinTestAppName is the name of the Bitcoin app for testnet
+	BitcoinTestAppName = "Bitcoin Test"
+	// BitcoinCoinType is the SLIP-0044 coin type of Bitcoin
+	BitcoinCoinType = 0
+	// BitcoinTestnetCoinType is the coin type used by all the testnets
+	BitcoinTestnetCoinType = 1
+
+	// errBTCUserRejected is returned by the transport when the request is rejected in the Bitcoin app
+	errBTCUserRejected = "[APDU_CODE_CONDITIONS_NOT_SATISFIED] Conditions of use not satisfied"
+)
+
+// BitcoinMinVersion is the first version of the Bitcoin app that implements version 1 of its protocol
+var BitcoinMinVersion = VersionInfo{0, 2, 1, 0}
+
+// LedgerBitcoin represents a connection to the Bitcoin app, used for the UTXO legs of swaps
+type LedgerBitcoin struct {
+	api     ledger_go.LedgerDevice
+	appName string
+	version VersionInfo
+	device  DeviceInfo
+}
+
+// BitcoinPartialSignature is a signature of an input returned by the Bitcoin app
+type BitcoinPartialSignature struct {
+	InputIndex int
+	// PubKey is the compressed key of an ECDSA signature, the x-only key of a taproot key path
+	// signature, or the x-only key followed by the leaf hash of a taproot script path signature
+	PubKey []byte
+	// Signature includes the sighash type, unless it is the taproot default
+	Signature []byte
+}
+
+// FindLedgerBitcoinApp finds a Bitcoin app, for mainnet or testnet, running in a ledger device.
+// A WrongAppError is returned if a different app is open
+func FindLedgerBitcoinApp() (_ *LedgerBitcoin, rerr error) {
+	ledgerAdmin := ledger_go.NewLedgerAdmin()
+	ledgerAPI, err := ledgerAdmin.Connect(0)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if rerr != nil {
+			ledgerAPI.Close()
+		}
+	}()
+
+	app, err := newLedgerBitcoin(ledgerAPI)
+	if err != nil {
+		return nil, err
+	}
+	app.device = detectDevice(0)
+	return app, nil
+}
+
+// newLedgerBitcoin checks that the Bitcoin app is running and supports the protocol
+func newLedgerBitcoin(device ledger_go.LedgerDevice) (*LedgerBitcoin, error) {
+	info, err := GetAppAndVersion(device)
+	if err != nil {
+		return nil, err
+	}
+	if info.Name != BitcoinAppName && info.Name != BitcoinTestAppName {
+		return nil, &WrongAppError{Expected: BitcoinAppName, Running: info.Name}
+	}
+
+	version, err := ParseVersion(info.Version)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckVersion(version, BitcoinMinVersion); err != nil {
+		return nil, err
+	}
+	return &LedgerBitcoin{api: device, appName: info.Name, version: version}, nil
+}
+
+// Close closes a connection with the Bitcoin app
+func (ledger *LedgerBitcoin) Close() error {
+	return ledger.api.Close()
+}
+
+// DeviceInfo returns the model of the device
+func (ledger *LedgerBitcoin) DeviceInfo() DeviceInfo {
+	return ledger.device
+}
+
+// GetVersion returns the version of the Bitcoin app
+func (ledger *LedgerBitcoin) GetVersion() VersionInfo {
+	return ledger.version
+}
+
+// Testnet returns true if the app is the testnet Bitcoin app
+func (ledger *LedgerBitcoin) Testnet() bool {
+	return ledger.appName == BitcoinTestAppName
+}
+
+// GetMasterFingerprint returns the fingerprint of the master key, used in the key origins of the wallet policies
+func (ledger *LedgerBitcoin) GetMasterFingerprint() ([]byte, error) {
+	response, err := ledger.exchange(btcINSGetMasterFingerprint, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(response) != 4 {
+		return nil, errors.New("invalid response")
+	}
+	return response, nil
+}
+
+// GetExtendedPubKey returns the serialized extended public key of a path. The first three elements are hardened
+// this command requires user confirmation in the device if display is true
+func (ledger *LedgerBitcoin) GetExtendedPubKey(bip32Path []uint32, display bool) (string, error) {
+	pathBytes, err := bip32bytesBE(bip32Path)
+	if err != nil {
+		return "", err
+	}
+
+	response, err := ledger.exchange(btcINSGetExtendedPubKey, append([]byte{btcFlag(display)}, pathBytes...), nil)
+	if err != nil {
+		return "", err
+	}
+	return string(response), nil
+}
+
+// RegisterWallet registers a named wallet policy and returns its hmac, needed to use the policy later.
+// Standard wallets do not need to be registered
+// this command requires user confirmation in the device
+func (ledger *LedgerBitcoin) RegisterWallet(policy *WalletPolicy) ([]byte, error) {
+	if policy.IsDefault() {
+		return nil, errors.New("the wallet policy needs a name to be registered")
+	}
+
+	client := newBTCClientInterpreter()
+	policy.addTo(client)
+
+	response, err := ledger.exchange(btcINSRegisterWallet, appendVarBytes(nil, policy.Serialize()), client)
+	if err != nil {
+		return nil, err
+	}
+	if len(response) != 64 {
+		return nil, errors.New("invalid response")
+	}
+	if id := policy.ID(); !bytes.Equal(response[:32], id[:]) {
+		return nil, errors.New("the app registered a different wallet policy")
+	}
+	return response[32:], nil
+}
+
+// GetWalletAddress returns the address at an index of a wallet policy.
+// hmac is the result of RegisterWallet, or nil for standard wallets
+// this command requires user confirmation in the device if display is true
+func (ledger *LedgerBitcoin) GetWalletAddress(policy *WalletPolicy, hmac []byte, change bool, index uint32, display bool) (string, error) {
+	hmac, err := btcWalletHMAC(policy, hmac)
+	if err != nil {
+		return "", err
+	}
+
+	client := newBTCClientInterpreter()
+	policy.addTo(client)
+
+	id := policy.ID()
+	data := append([]byte{btcFlag(display)}, id[:]...)
+	data = append(data, hmac...)
+	data = append(data, btcFlag(change), 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[len(data)-4:], index)
+
+	response, err := ledger.exchange(btcINSGetWalletAddress, data, client)
+	if err != nil {
+		return "", err
+	}
+	return string(response), nil
+}
+
+// SignPSBT signs the inputs of a PSBT that belong to a wallet policy, and adds the signatures to the PSBT.
+// hmac is the result of RegisterWallet, or nil for standard wallets
+// this command requires user confirmation in the device
+func (ledger *LedgerBitcoin) SignPSBT(psbt *PSBT, policy *WalletPolicy, hmac []byte) ([]BitcoinPartialSignature, error) {
+	hmac, err := btcWalletHMAC(policy, hmac)
+	if err != nil {
+		return nil, err
+	}
+	v2, err := psbt.toV2()
+	if err != nil {
+		return nil, err
+	}
+
+	client := newBTCClientInterpreter()
+	policy.addTo(client)
+
+	global := newMerkleizedMap(v2.Global)
+	client.addKnownMap(global)
+	data := global.commitment()
+	for _, maps := range [][]PSBTMap{v2.Inputs, v2.Outputs} {
+		var commitments [][]byte
+		for _, m := range maps {
+			merkleized := newMerkleizedMap(m)
+			client.addKnownMap(merkleized)
+			commitments = append(commitments, merkleized.commitment())
+		}
+		client.addKnownList(commitments)
+		root := merkleRoot(merkleLeaves(commitments))
+		data = appendCompactSize(data, uint64(len(maps)))
+		data = append(data, root[:]...)
+	}
+	id := policy.ID()
+	data = append(append(data, id[:]...), hmac...)
+
+	if _, err := ledger.exchange(btcINSSignPSBT, data, client); err != nil {
+		return nil, err
+	}
+
+	var signatures []BitcoinPartialSignature
+	for _, yielded := range client.yielded {
+		signature, err := parseBTCPartialSignature(yielded, len(psbt.Inputs))
+		if err != nil {
+			return nil, err
+		}
+		signatures = append(signatures, *signature)
+	}
+	for _, signature := range signatures {
+		if err := psbt.addSignature(signature); err != nil {
+			return nil, err
+		}
+	}
+	return signatures, nil
+}
+
+// parseBTCPartialSignature reads a signature yielded by SIGN_PSBT: the input index,
+// the length prefixed public key and the signature
+func parseBTCPartialSignature(yielded []byte, inputCount int) (*BitcoinPartialSignature, error) {
+	index, rest, err := readCompactSize(yielded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	if index >= uint64(inputCount) {
+		return nil, fmt.Errorf("signature for unknown input %d", index)
+	}
+	pubKey, signature, err := readVarBytes(rest)
+	if err != nil || len(signature) == 0 {
+		return nil, fmt.Errorf("invalid signature for input %d", index)
+	}
+
+	return &BitcoinPartialSignature{
+		InputIndex: int(index),
+		PubKey:     append([]byte{}, pubKey...),
+		Signature:  append([]byte{}, signature...),
+	}, nil
+}
+
+// addSignature adds a signature to its input, with the key type that matches the public key
+func (p *PSBT) addSignature(signature BitcoinPartialSignature) error {
+	input := &p.Inputs[signature.InputIndex]
+	switch len(signature.PubKey) {
+	case 33:
+		input.Set(append([]byte{PSBTInPartialSig}, signature.PubKey...), signature.Signature)
+	case 32:
+		input.Set([]byte{PSBTInTapKeySig}, signature.Signature)
+	case 64:
+		input.Set(append([]byte{PSBTInTapScriptSig}, signature.PubKey...), signature.Signature)
+	default:
+		return fmt.Errorf("unexpected public key of %d bytes for input %d", len(signature.PubKey), signature.InputIndex)
+	}
+	return nil
+}
+
+// exchange sends a command and answers the client commands of the app until it completes.
+// Commands that do not need data from the client are sent with a nil client
+func (ledger *LedgerBitcoin) exchange(ins byte, data []byte, client *btcClientInterpreter) ([]byte, error) {
+	if len(data) > 255 {
+		return nil, errors.New("the command does not fit in an APDU")
+	}
+
+	message := append([]byte{btcCLA, ins, 0, btcProtocolVersion, byte(len(data))}, data...)
+	for {
+		response, err := ledger.api.Exchange(message)
+		if err == nil {
+			return response, nil
+		}
+		if client == nil || StatusWord(err) != btcSWInterrupted {
+			return nil, btcError(err)
+		}
+
+		answer, err := client.execute(response)
+		if err != nil {
+			return nil, fmt.Errorf("cannot answer the Bitcoin app: %w", err)
+		}
+		message = append([]byte{btcCLAFramework, btcINSContinueInterrupted, 0, btcProtocolVersion, byte(len(answer))}, answer...)
+	}
+}
+
+// btcWalletHMAC checks the hmac of a policy. Standard wallets use a zero hmac
+func btcWalletHMAC(policy *WalletPolicy, hmac []byte) ([]byte, error) {
+	if hmac == nil {
+		if !policy.IsDefault() {
+			return nil, errors.New("the hmac of the registered wallet policy is required")
+		}
+		return make([]byte, 32), nil
+	}
+	if len(hmac) != 32 {
+		return nil, errors.New("the wallet hmac should contain 32 bytes")
+	}
+	return hmac, nil
+}
+
+func btcFlag(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// btcError explains the errors of the Bitcoin app
+func btcError(err error) error {
+	if err.Error() == errBTCUserRejected {
+		return fmt.Errorf("the request was rejected in the device: %w", err)
+	}
+	return err
+}
@@ -0,0 +1,441 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testXPub = "xpub6CatWdiZiodmUeTDp8LT5or8nmbKNcuyvz7WyksVFkKB4RHwCD3XyuvPEbvqAQY3rAPshWcMLoP2fMFMKHPJ4ZeZXYVUhLv1VMrjPC7PW6V"
+
+var testFingerprint = []byte{0xF5, 0xAC, 0xC2, 0xFD}
+
+// mockBitcoinApp answers like the Bitcoin app. The commands run in a goroutine which
+// interrupts them to make client commands, until the client continues them
+type mockBitcoinApp struct {
+	key       *btcec.PrivateKey
+	device    *mockDevice
+	name      string
+	version   string
+	reject    bool
+	continues chan []byte
+	replies   chan mockReply
+
+	// Recorded while running the commands
+	walletName string
+	template   string
+	keys       []string
+	amounts    []uint64
+	signed     []int
+}
+
+func newMockBitcoinApp(t *testing.T) (*LedgerBitcoin, *mockBitcoinApp) {
+	key, err := btcec.NewPrivateKey()
+	require.Nil(t, err)
+	app := &mockBitcoinApp{
+		key:       key,
+		device:    newMockDevice(),
+		name:      BitcoinAppName,
+		version:   "2.1.3",
+		continues: make(chan []byte),
+		replies:   make(chan mockReply),
+	}
+	app.device.handler = app.handle
+
+	ledger, err := newLedgerBitcoin(app.device)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	return ledger, app
+}
+
+func (a *mockBitcoinApp) handle(command []byte) ([]byte, error) {
+	switch command[0] {
+	case dashboardCLA:
+		return appAndVersionResponse(a.name, a.version), nil
+	case btcCLA:
+		if command[3] != btcProtocolVersion {
+			return nil, errors.New("[APDU_CODE_INVALID_P1P2] Wrong parameter(s) P1-P2")
+		}
+		go a.run(command[1], command[5:])
+	case btcCLAFramework:
+		a.continues <- append([]byte{}, command[5:]...)
+	default:
+		return nil, errors.New("[APDU_CODE_CLA_NOT_SUPPORTED] CLA not supported")
+	}
+	reply := <-a.replies
+	return reply.response, reply.err
+}
+
+// call interrupts the running command with a client command and waits for the answer
+func (a *mockBitcoinApp) call(request []byte) []byte {
+	a.replies <- mockReply{request, fmt.Errorf("Error code: %04x", btcSWInterrupted)}
+	return <-a.continues
+}
+
+func (a *mockBitcoinApp) run(ins byte, data []byte) {
+	client := &btcAppClient{call: a.call}
+	response, err := a.execute(ins, data, client)
+	if client.err != nil {
+		response, err = nil, client.err
+	}
+	a.replies <- mockReply{response, err}
+}
+
+func (a *mockBitcoinApp) execute(ins byte, data []byte, client *btcAppClient) ([]byte, error) {
+	r := &btcReader{data: data}
+	switch ins {
+	case btcINSGetMasterFingerprint:
+		return testFingerprint, nil
+	case btcINSGetExtendedPubKey:
+		return []byte("xpub" + hex.EncodeToString(data[1:])), nil
+	case btcINSRegisterWallet:
+		serialized := r.varBytes()
+		a.readPolicy(client, serialized)
+		if a.reject {
+			return nil, errors.New(errBTCUserRejected)
+		}
+		id := sha256.Sum256(serialized)
+		return append(id[:], a.hmac(id)...), nil
+	case btcINSGetWalletAddress:
+		r.bytes(1)
+		id := a.wallet(client, r)
+		change, index := r.bytes(1), binary.BigEndian.Uint32(r.bytes(4))
+		return []byte(fmt.Sprintf("bc1q-%x-%d-%d", id[:4], change[0], index)), nil
+	case btcINSSignPSBT:
+		return a.signPSBT(client, r)
+	}
+	return nil, errors.New("[APDU_CODE_INS_NOT_SUPPORTED] Instruction code not supported or invalid")
+}
+
+func (a *mockBitcoinApp) hmac(id [32]byte) []byte {
+	hmac := sha256.Sum256(append([]byte("mock hmac"), id[:]...))
+	return hmac[:]
+}
+
+// wallet reads the id and the hmac of a wallet policy, and fetches the policy
+func (a *mockBitcoinApp) wallet(client *btcAppClient, r *btcReader) [32]byte {
+	var id [32]byte
+	copy(id[:], r.bytes(32))
+	hmac := r.bytes(32)
+
+	a.readPolicy(client, client.preimage(id))
+	expected := make([]byte, 32)
+	if a.walletName != "" {
+		expected = a.hmac(id)
+	}
+	if !bytes.Equal(hmac, expected) {
+		client.fail("invalid wallet hmac")
+	}
+	return id
+}
+
+func (a *mockBitcoinApp) readPolicy(client *btcAppClient, serialized []byte) {
+	r := &btcReader{data: serialized}
+	if version := r.bytes(1); len(version) != 1 || version[0] != btcWalletPolicyV2 {
+		client.fail("unsupported wallet policy %x", serialized)
+		return
+	}
+	a.walletName = string(r.varBytes())
+	r.compactSize()
+	var templateHash, keysRoot [32]byte
+	copy(templateHash[:], r.bytes(32))
+	keyCount := int(r.compactSize())
+	copy(keysRoot[:], r.bytes(32))
+	if r.err != nil {
+		client.fail("invalid wallet policy: %s", r.err)
+		return
+	}
+
+	a.template = string(client.preimage(templateHash))
+	a.keys = nil
+	for i := 0; i < keyCount; i++ {
+		a.keys = append(a.keys, string(client.element(keysRoot, keyCount, i)))
+	}
+}
+
+// signPSBT signs the inputs with a witness utxo. The signed hash is a mock of the sighash
+func (a *mockBitcoinApp) signPSBT(client *btcAppClient, r *btcReader) ([]byte, error) {
+	commitment := func() []byte {
+		commitment := append([]byte{}, r.data[:1]...)
+		r.compactSize()
+		return append(commitment, r.bytes(64)...)
+	}
+	list := func() ([32]byte, int) {
+		var root [32]byte
+		size := int(r.compactSize())
+		copy(root[:], r.bytes(32))
+		return root, size
+	}
+
+	global := commitment()
+	inputsRoot, inputCount := list()
+	outputsRoot, outputCount := list()
+	a.wallet(client, r)
+
+	if version, _ := client.mapValue(global, []byte{PSBTGlobalVersion}); !bytes.Equal(version, []byte{2, 0, 0, 0}) {
+		client.fail("the PSBT is not in version 2")
+	}
+	a.amounts = nil
+	for i := 0; i < outputCount; i++ {
+		amount, _ := client.mapValue(client.element(outputsRoot, outputCount, i), []byte{PSBTOutAmount})
+		if len(amount) != 8 {
+			client.fail("missing amount of output %d", i)
+			return nil, nil
+		}
+		a.amounts = append(a.amounts, binary.LittleEndian.Uint64(amount))
+	}
+	if a.reject {
+		return nil, errors.New(errBTCUserRejected)
+	}
+
+	a.signed = nil
+	for i := 0; i < inputCount; i++ {
+		input := client.element(inputsRoot, inputCount, i)
+		utxo, ok := client.mapValue(input, []byte{PSBTInWitnessUTXO})
+		if !ok {
+			continue
+		}
+		if prevTx, ok := client.mapValue(input, []byte{PSBTInNonWitnessUTXO}); ok {
+			if _, err := ParseBitcoinTx(prevTx); err != nil {
+				client.fail("invalid previous transaction of input %d", i)
+			}
+		}
+		txID, _ := client.mapValue(input, []byte{PSBTInPreviousTxID})
+
+		hash := sha256.Sum256(append(txID, utxo...))
+		signature := append(ecdsa.Sign(a.key, hash[:]).Serialize(), 0x01)
+		yield := appendCompactSize([]byte{btcClientYield}, uint64(i))
+		yield = appendVarBytes(yield, a.key.PubKey().SerializeCompressed())
+		client.request(append(yield, signature...))
+		a.signed = append(a.signed, i)
+	}
+	return nil, nil
+}
+
+func Test_NewLedgerBitcoin(t *testing.T) {
+	ledger, app := newMockBitcoinApp(t)
+	assert.False(t, ledger.Testnet())
+	assert.Equal(t, "2.1.3", ledger.GetVersion().String())
+
+	app.name = BitcoinTestAppName
+	ledger, err := newLedgerBitcoin(app.device)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.True(t, ledger.Testnet())
+
+	app.version = "2.0.6"
+	_, err = newLedgerBitcoin(app.device)
+	assert.EqualError(t, err, "App Version required 2.1.0 - Version found: 2.0.6")
+
+	app.name = "Ethereum"
+	_, err = newLedgerBitcoin(app.device)
+	assert.EqualError(t, err, "the Bitcoin app is not open: Ethereum app is running")
+}
+
+func Test_BitcoinGetExtendedPubKey(t *testing.T) {
+	ledger, app := newMockBitcoinApp(t)
+
+	fingerprint, err := ledger.GetMasterFingerprint()
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, testFingerprint, fingerprint)
+
+	xpub, err := ledger.GetExtendedPubKey([]uint32{84, 0, 0}, true)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, "xpub03800000548000000080000000", xpub)
+	assert.Equal(t, "e10000010e01", hex.EncodeToString(app.device.sent[len(app.device.sent)-1][:6]))
+}
+
+func Test_WalletPolicy(t *testing.T) {
+	policy, err := NewBIP84WalletPolicy(testFingerprint, BitcoinCoinType, 0, testXPub)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, "wpkh(@0/**)", policy.DescriptorTemplate)
+	assert.Equal(t, []string{"[f5acc2fd/84'/0'/0']" + testXPub}, policy.Keys)
+	assert.True(t, policy.IsDefault())
+
+	templateHash := sha256.Sum256([]byte("wpkh(@0/**)"))
+	keysRoot := merkleLeafHash([]byte(policy.Keys[0]))
+	expected := append([]byte{0x02, 0x00, 0x0B}, templateHash[:]...)
+	expected = append(append(expected, 0x01), keysRoot[:]...)
+	assert.Equal(t, expected, policy.Serialize())
+	assert.Equal(t, sha256.Sum256(expected), policy.ID())
+
+	_, err = NewBIP84WalletPolicy([]byte{1}, BitcoinCoinType, 0, testXPub)
+	assert.Error(t, err)
+}
+
+// The vectors were computed with Python's hashlib from the encoding documented by the
+// Bitcoin app (doc/wallet.md in LedgerHQ/app-bitcoin-new), independently of this package
+func Test_WalletPolicy_Vectors(t *testing.T) {
+	policy, err := NewBIP84WalletPolicy(testFingerprint, BitcoinCoinType, 0, testXPub)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, "02000bc8974a0d8bdd29024b2ddb7a7fe8df1d9801b270f4e6c1e7e1011ae39e7c9b00019ed31b910c2a48c6f91e5b4fb6a68c14b91a5fa359c7ec347c911a862fa148e1", hex.EncodeToString(policy.Serialize()))
+	id := policy.ID()
+	assert.Equal(t, "554b457c175dc172e0aa47d60b364bb234a2fd7e227e26f2e314b35674a258da", hex.EncodeToString(id[:]))
+
+	multisig := &WalletPolicy{
+		Name:               "Cold storage",
+		DescriptorTemplate: "wsh(sortedmulti(2,@0/**,@1/**))",
+		Keys:               []string{"[f5acc2fd/48'/0'/0'/2']" + testXPub, "[76223a6e/48'/0'/0'/2']" + testXPub},
+	}
+	assert.Equal(t, "020c436f6c642073746f726167651fb56c3d5542fa09b3956834a9ff6a1df5c36a38e5b02c63c54b41a9a04403b82602078ce3a88fdd19fb345cbe9a8eebe1ac0ca728ce26d3a896e8084c9fc9914aee", hex.EncodeToString(multisig.Serialize()))
+	id = multisig.ID()
+	assert.Equal(t, "1032f06778d05316c48953572456a616b03971b38ec8499e914d26ba3dfbbe5f", hex.EncodeToString(id[:]))
+
+}
+
+func Test_BitcoinWalletAddress(t *testing.T) {
+	ledger, app := newMockBitcoinApp(t)
+	policy, err := NewBIP84WalletPolicy(testFingerprint, BitcoinCoinType, 0, testXPub)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	address, err := ledger.GetWalletAddress(policy, nil, true, 7, false)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	id := policy.ID()
+	assert.Equal(t, fmt.Sprintf("bc1q-%x-1-7", id[:4]), address)
+	assert.Equal(t, BIP84DescriptorTemplate, app.template)
+	assert.Equal(t, policy.Keys, app.keys)
+
+	_, err = ledger.GetWalletAddress(policy, []byte{1}, false, 0, false)
+	assert.EqualError(t, err, "the wallet hmac should contain 32 bytes")
+}
+
+func Test_BitcoinRegisterWallet(t *testing.T) {
+	ledger, app := newMockBitcoinApp(t)
+	policy := &WalletPolicy{
+		Name:               "Swaps",
+		DescriptorTemplate: "wsh(sortedmulti(2,@0/**,@1/**))",
+		Keys:               []string{"[f5acc2fd/48'/0'/0'/2']" + testXPub, "[12345678/48'/0'/0'/2']" + testXPub},
+	}
+
+	_, err := ledger.GetWalletAddress(policy, nil, false, 0, false)
+	assert.EqualError(t, err, "the hmac of the registered wallet policy is required")
+
+	hmac, err := ledger.RegisterWallet(policy)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, app.hmac(policy.ID()), hmac)
+	assert.Equal(t, "Swaps", app.walletName)
+	assert.Equal(t, policy.Keys, app.keys)
+
+	_, err = ledger.GetWalletAddress(policy, hmac, false, 0, false)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	_, err = ledger.GetWalletAddress(policy, make([]byte, 32), false, 0, false)
+	assert.EqualError(t, err, "invalid wallet hmac")
+
+	app.reject = true
+	_, err = ledger.RegisterWallet(policy)
+	assert.EqualError(t, err, "the request was rejected in the device: "+errBTCUserRejected)
+
+	_, err = ledger.RegisterWallet(&WalletPolicy{DescriptorTemplate: BIP84DescriptorTemplate})
+	assert.EqualError(t, err, "the wallet policy needs a name to be registered")
+}
+
+func testPSBT(t *testing.T, pubKey []byte) *PSBT {
+	tx := testBitcoinTx()
+	psbt, err := NewPSBT(tx)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	// Only the first input belongs to the wallet. Its previous transaction does not fit in one answer
+	utxo := append([]byte{0xA0, 0x86, 0x01, 0, 0, 0, 0, 0, 0x16, 0x00, 0x14}, bytes.Repeat([]byte{0x44}, 20)...)
+	prevTx := &BitcoinTx{Version: 2, Inputs: []BitcoinTxIn{{Sequence: 0xFFFFFFFF}}}
+	for i := 0; i < 20; i++ {
+		prevTx.Outputs = append(prevTx.Outputs, BitcoinTxOut{Value: 100000, Script: utxo[9:]})
+	}
+	psbt.Inputs[0].Set([]byte{PSBTInWitnessUTXO}, utxo)
+	psbt.Inputs[0].Set([]byte{PSBTInNonWitnessUTXO}, prevTx.Serialize())
+	psbt.Inputs[0].Set(append([]byte{PSBTInBIP32Derivation}, pubKey...), append(append([]byte{}, testFingerprint...), make([]byte, 20)...))
+	return psbt
+}
+
+func Test_BitcoinSignPSBT(t *testing.T) {
+	ledger, app := newMockBitcoinApp(t)
+	policy, err := NewBIP84WalletPolicy(testFingerprint, BitcoinCoinType, 0, testXPub)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	pubKey := app.key.PubKey().SerializeCompressed()
+
+	psbt := testPSBT(t, pubKey)
+	signatures, err := ledger.SignPSBT(psbt, policy, nil)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, []uint64{150000, 0}, app.amounts)
+	assert.Equal(t, []int{0}, app.signed)
+	require.Len(t, signatures, 1)
+	assert.Equal(t, 0, signatures[0].InputIndex)
+	assert.Equal(t, pubKey, signatures[0].PubKey)
+
+	// The signature is added to the version 0 PSBT
+	assert.Equal(t, uint32(0), psbt.Version())
+	value, ok := psbt.Inputs[0].Get(append([]byte{PSBTInPartialSig}, pubKey...))
+	require.True(t, ok)
+	assert.Equal(t, signatures[0].Signature, value)
+	assert.Equal(t, byte(0x01), value[len(value)-1])
+	signature, err := ecdsa.ParseDERSignature(value[:len(value)-1])
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	utxo, _ := psbt.Inputs[0].Get([]byte{PSBTInWitnessUTXO})
+	hash := sha256.Sum256(append([]byte{0x11}, append(make([]byte, 31), utxo...)...))
+	assert.True(t, signature.Verify(hash[:], app.key.PubKey()))
+	assert.Len(t, psbt.Inputs[1], 0)
+
+	parsed, err := ParsePSBT(psbt.Serialize())
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, psbt.Serialize(), parsed.Serialize())
+}
+
+func Test_BitcoinSignPSBTv2(t *testing.T) {
+	ledger, app := newMockBitcoinApp(t)
+	policy, err := NewBIP84WalletPolicy(testFingerprint, BitcoinCoinType, 0, testXPub)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	pubKey := app.key.PubKey().SerializeCompressed()
+
+	psbt, err := testPSBT(t, pubKey).toV2()
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	_, err = ledger.SignPSBT(psbt, policy, nil)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, uint32(2), psbt.Version())
+	_, ok := psbt.Inputs[0].Get(append([]byte{PSBTInPartialSig}, pubKey...))
+	assert.True(t, ok)
+
+	app.reject = true
+	_, err = ledger.SignPSBT(testPSBT(t, pubKey), policy, nil)
+	assert.EqualError(t, err, "the request was rejected in the device: "+errBTCUserRejected)
+}
+
+func Test_ParseBTCPartialSignature(t *testing.T) {
+	pubKey := bytes.Repeat([]byte{0x02}, 33)
+	yielded := appendVarBytes([]byte{0x01}, pubKey)
+	signature, err := parseBTCPartialSignature(append(yielded, 0x30, 0x01), 2)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, BitcoinPartialSignature{InputIndex: 1, PubKey: pubKey, Signature: []byte{0x30, 0x01}}, *signature)
+
+	_, err = parseBTCPartialSignature(append(yielded, 0x30), 1)
+	assert.EqualError(t, err, "signature for unknown input 1")
+	_, err = parseBTCPartialSignature(yielded, 2)
+	assert.EqualError(t, err, "invalid signature for input 1")
+
+	psbt := &PSBT{Inputs: make([]PSBTMap, 2)}
+	require.Nil(t, psbt.addSignature(BitcoinPartialSignature{InputIndex: 1, PubKey: make([]byte, 32), Signature: []byte{1}}))
+	value, _ := psbt.Inputs[1].Get([]byte{PSBTInTapKeySig})
+	assert.Equal(t, []byte{1}, value)
+	assert.EqualError(t, psbt.addSignature(BitcoinPartialSignature{PubKey: make([]byte, 20)}), "unexpected public key of 20 bytes for input 0")
+}
@@ -0,0 +1,316 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Commands sent by the Bitcoin app while it processes a request
+const (
+	btcClientYield              = 0x10
+	btcClientGetPreimage        = 0x40
+	btcClientGetMerkleLeafProof = 0x41
+	btcClientGetMerkleLeafIndex = 0x42
+	btcClientGetMoreElements    = 0x43
+
+	// btcMaxResponse is the largest answer to a client command
+	btcMaxResponse = 255
+)
+
+// appendCompactSize appends a Bitcoin variable length integer
+func appendCompactSize(b []byte, n uint64) []byte {
+	switch {
+	case n < 0xFD:
+		return append(b, byte(n))
+	case n <= 0xFFFF:
+		return append(append(b, 0xFD), byte(n), byte(n>>8))
+	case n <= 0xFFFFFFFF:
+		b = append(b, 0xFE, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(b[len(b)-4:], uint32(n))
+		return b
+	default:
+		b = append(b, 0xFF, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint64(b[len(b)-8:], n)
+		return b
+	}
+}
+
+// readCompactSize reads a Bitcoin variable length integer and returns the bytes after it
+func readCompactSize(b []byte) (uint64, []byte, error) {
+	if len(b) == 0 {
+		return 0, nil, errors.New("unexpected end of data")
+	}
+	size := map[byte]int{0xFD: 2, 0xFE: 4, 0xFF: 8}[b[0]]
+	if size == 0 {
+		return uint64(b[0]), b[1:], nil
+	}
+	if len(b) < 1+size {
+		return 0, nil, errors.New("unexpected end of data")
+	}
+	var n uint64
+	for i := size; i > 0; i-- {
+		n = n<<8 | uint64(b[i])
+	}
+	return n, b[1+size:], nil
+}
+
+// merkleLeafHash is the hash of an element in the merkle trees of the Bitcoin app
+func merkleLeafHash(element []byte) [32]byte {
+	return sha256.Sum256(append([]byte{0x00}, element...))
+}
+
+func merkleCombine(left [32]byte, right [32]byte) [32]byte {
+	return sha256.Sum256(append(append([]byte{0x01}, left[:]...), right[:]...))
+}
+
+// merkleSplit returns the size of the left subtree: the largest power of 2 smaller than n
+func merkleSplit(n int) int {
+	k := 1
+	for k*2 < n {
+		k *= 2
+	}
+	return k
+}
+
+// merkleRoot returns the root of the tree of leaf hashes. The root of an empty tree is zero
+func merkleRoot(leaves [][32]byte) [32]byte {
+	switch len(leaves) {
+	case 0:
+		return [32]byte{}
+	case 1:
+		return leaves[0]
+	}
+	k := merkleSplit(len(leaves))
+	return merkleCombine(merkleRoot(leaves[:k]), merkleRoot(leaves[k:]))
+}
+
+// merkleProof returns the hashes of the siblings of a leaf, from the leaf up to the root
+func merkleProof(leaves [][32]byte, index int) [][32]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := merkleSplit(len(leaves))
+	if index < k {
+		return append(merkleProof(leaves[:k], index), merkleRoot(leaves[k:]))
+	}
+	return append(merkleProof(leaves[k:], index-k), merkleRoot(leaves[:k]))
+}
+
+// merkleizedMap is a key-value map committed as the merkle roots of its sorted keys and values
+type merkleizedMap struct {
+	keys   [][]byte
+	values [][]byte
+}
+
+func newMerkleizedMap(m PSBTMap) merkleizedMap {
+	entries := append(PSBTMap{}, m...)
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].Key, entries[j].Key) < 0
+	})
+
+	var mm merkleizedMap
+	for _, entry := range entries {
+		mm.keys = append(mm.keys, entry.Key)
+		mm.values = append(mm.values, entry.Value)
+	}
+	return mm
+}
+
+// commitment returns the number of keys followed by the roots of the keys and of the values
+func (m merkleizedMap) commitment() []byte {
+	keysRoot, valuesRoot := merkleRoot(merkleLeaves(m.keys)), merkleRoot(merkleLeaves(m.values))
+	commitment := appendCompactSize(nil, uint64(len(m.keys)))
+	commitment = append(commitment, keysRoot[:]...)
+	return append(commitment, valuesRoot[:]...)
+}
+
+func merkleLeaves(elements [][]byte) [][32]byte {
+	leaves := make([][32]byte, len(elements))
+	for i, element := range elements {
+		leaves[i] = merkleLeafHash(element)
+	}
+	return leaves
+}
+
+// btcClientInterpreter answers the commands of the Bitcoin app with the data known by the client:
+// preimages of hashes and merkle trees of lists
+type btcClientInterpreter struct {
+	preimages map[[32]byte][]byte
+	trees     map[[32]byte][][32]byte
+	// queue holds the elements that did not fit in the last answer, for GET_MORE_ELEMENTS
+	queue   [][]byte
+	yielded [][]byte
+}
+
+func newBTCClientInterpreter() *btcClientInterpreter {
+	return &btcClientInterpreter{preimages: map[[32]byte][]byte{}, trees: map[[32]byte][][32]byte{}}
+}
+
+func (c *btcClientInterpreter) addKnownPreimage(preimage []byte) {
+	c.preimages[sha256.Sum256(preimage)] = preimage
+}
+
+// addKnownList makes the elements of a list and their merkle tree available
+func (c *btcClientInterpreter) addKnownList(elements [][]byte) {
+	for _, element := range elements {
+		c.addKnownPreimage(append([]byte{0x00}, element...))
+	}
+	leaves := merkleLeaves(elements)
+	c.trees[merkleRoot(leaves)] = leaves
+}
+
+func (c *btcClientInterpreter) addKnownMap(m merkleizedMap) {
+	c.addKnownList(m.keys)
+	c.addKnownList(m.values)
+}
+
+// execute answers a command of the app
+func (c *btcClientInterpreter) execute(request []byte) ([]byte, error) {
+	if len(request) == 0 {
+		return nil, errors.New("empty client command")
+	}
+
+	switch request[0] {
+	case btcClientYield:
+		c.yielded = append(c.yielded, append([]byte{}, request[1:]...))
+		return nil, nil
+	case btcClientGetPreimage:
+		return c.getPreimage(request[1:])
+	case btcClientGetMerkleLeafProof:
+		return c.getMerkleLeafProof(request[1:])
+	case btcClientGetMerkleLeafIndex:
+		return c.getMerkleLeafIndex(request[1:])
+	case btcClientGetMoreElements:
+		return c.getMoreElements()
+	default:
+		return nil, fmt.Errorf("unknown client command 0x%02x", request[0])
+	}
+}
+
+func (c *btcClientInterpreter) getPreimage(request []byte) ([]byte, error) {
+	if len(request) != 33 || request[0] != 0 {
+		return nil, errors.New("invalid GET_PREIMAGE command")
+	}
+	var hash [32]byte
+	copy(hash[:], request[1:])
+	preimage, ok := c.preimages[hash]
+	if !ok {
+		return nil, fmt.Errorf("unknown preimage of %x", hash)
+	}
+	if len(c.queue) > 0 {
+		return nil, errors.New("GET_PREIMAGE with pending elements")
+	}
+
+	response := appendCompactSize(nil, uint64(len(preimage)))
+	size := len(preimage)
+	if max := btcMaxResponse - len(response) - 1; size > max {
+		size = max
+	}
+	// The bytes that do not fit are sent one by one with GET_MORE_ELEMENTS
+	for _, b := range preimage[size:] {
+		c.queue = append(c.queue, []byte{b})
+	}
+	response = append(response, byte(size))
+	return append(response, preimage[:size]...), nil
+}
+
+func (c *btcClientInterpreter) getMerkleLeafProof(request []byte) ([]byte, error) {
+	if len(request) < 32 {
+		return nil, errors.New("invalid GET_MERKLE_LEAF_PROOF command")
+	}
+	var root [32]byte
+	copy(root[:], request)
+	size, rest, err := readCompactSize(request[32:])
+	if err != nil {
+		return nil, err
+	}
+	index, _, err := readCompactSize(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	leaves, ok := c.trees[root]
+	if !ok {
+		return nil, fmt.Errorf("unknown merkle tree %x", root)
+	}
+	if size != uint64(len(leaves)) || index >= size {
+		return nil, errors.New("invalid merkle leaf index")
+	}
+	if len(c.queue) > 0 {
+		return nil, errors.New("GET_MERKLE_LEAF_PROOF with pending elements")
+	}
+
+	proof := merkleProof(leaves, int(index))
+	count := len(proof)
+	if max := (btcMaxResponse - 32 - 1 - 1) / 32; count > max {
+		count = max
+	}
+	for _, hash := range proof[count:] {
+		c.queue = append(c.queue, append([]byte{}, hash[:]...))
+	}
+
+	response := append(append([]byte{}, leaves[index][:]...), byte(len(proof)), byte(count))
+	for _, hash := range proof[:count] {
+		response = append(response, hash[:]...)
+	}
+	return response, nil
+}
+
+func (c *btcClientInterpreter) getMerkleLeafIndex(request []byte) ([]byte, error) {
+	if len(request) != 64 {
+		return nil, errors.New("invalid GET_MERKLE_LEAF_INDEX command")
+	}
+	var root, leaf [32]byte
+	copy(root[:], request[:32])
+	copy(leaf[:], request[32:])
+
+	leaves, ok := c.trees[root]
+	if !ok {
+		return nil, fmt.Errorf("unknown merkle tree %x", root)
+	}
+	for i, hash := range leaves {
+		if hash == leaf {
+			return appendCompactSize([]byte{1}, uint64(i)), nil
+		}
+	}
+	return []byte{0, 0}, nil
+}
+
+func (c *btcClientInterpreter) getMoreElements() ([]byte, error) {
+	if len(c.queue) == 0 {
+		return nil, errors.New("GET_MORE_ELEMENTS without pending elements")
+	}
+
+	size := len(c.queue[0])
+	var elements []byte
+	count := 0
+	for len(c.queue) > 0 && len(elements)+size <= btcMaxResponse-2 {
+		if len(c.queue[0]) != size {
+			return nil, errors.New("pending elements of different sizes")
+		}
+		elements = append(elements, c.queue[0]...)
+		c.queue = c.queue[1:]
+		count++
+	}
+	return append([]byte{byte(count), byte(size)}, elements...), nil
+}
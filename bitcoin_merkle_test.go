@@ -0,0 +1,256 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// btcAppClient makes the requests of the Bitcoin app to the client and checks the answers.
+// The first error is kept and the following requests are skipped
+type btcAppClient struct {
+	call func(request []byte) []byte
+	err  error
+}
+
+func (c *btcAppClient) fail(format string, args ...interface{}) {
+	if c.err == nil {
+		c.err = fmt.Errorf(format, args...)
+	}
+}
+
+func (c *btcAppClient) request(request []byte) []byte {
+	if c.err != nil {
+		return nil
+	}
+	return c.call(request)
+}
+
+// moreElements reads count elements of size bytes with GET_MORE_ELEMENTS
+func (c *btcAppClient) moreElements(count int, size int) []byte {
+	var elements []byte
+	for len(elements) < count*size && c.err == nil {
+		response := c.request([]byte{btcClientGetMoreElements})
+		if len(response) < 2 || int(response[1]) != size || len(response) != 2+int(response[0])*size || response[0] == 0 {
+			c.fail("invalid GET_MORE_ELEMENTS answer %x", response)
+			return nil
+		}
+		elements = append(elements, response[2:]...)
+	}
+	return elements
+}
+
+func (c *btcAppClient) preimage(hash [32]byte) []byte {
+	response := c.request(append([]byte{btcClientGetPreimage, 0}, hash[:]...))
+	size, rest, err := readCompactSize(response)
+	if err != nil || len(rest) < 1 || len(rest) != 1+int(rest[0]) {
+		c.fail("invalid GET_PREIMAGE answer %x", response)
+		return nil
+	}
+
+	preimage := append([]byte{}, rest[1:]...)
+	if uint64(len(preimage)) < size {
+		preimage = append(preimage, c.moreElements(int(size)-len(preimage), 1)...)
+	}
+	if sha256.Sum256(preimage) != hash {
+		c.fail("wrong preimage of %x", hash)
+	}
+	return preimage
+}
+
+func (c *btcAppClient) leaf(root [32]byte, size int, index int) [32]byte {
+	request := append([]byte{btcClientGetMerkleLeafProof}, root[:]...)
+	request = appendCompactSize(appendCompactSize(request, uint64(size)), uint64(index))
+	response := c.request(request)
+	if len(response) < 34 || len(response) != 34+32*int(response[33]) {
+		c.fail("invalid GET_MERKLE_LEAF_PROOF answer %x", response)
+		return [32]byte{}
+	}
+
+	var leaf [32]byte
+	copy(leaf[:], response)
+	proofBytes := append([]byte{}, response[34:]...)
+	if count, sent := int(response[32]), int(response[33]); sent < count {
+		proofBytes = append(proofBytes, c.moreElements(count-sent, 32)...)
+	}
+	proof := make([][32]byte, len(proofBytes)/32)
+	for i := range proof {
+		copy(proof[i][:], proofBytes[32*i:])
+	}
+
+	if merkleRootFromProof(leaf, proof, index, size) != root {
+		c.fail("invalid proof of leaf %d of %x", index, root)
+	}
+	return leaf
+}
+
+func (c *btcAppClient) element(root [32]byte, size int, index int) []byte {
+	leaf := c.leaf(root, size, index)
+	preimage := c.preimage(leaf)
+	if len(preimage) == 0 || preimage[0] != 0 {
+		c.fail("invalid leaf preimage %x", preimage)
+		return nil
+	}
+	return preimage[1:]
+}
+
+func (c *btcAppClient) index(root [32]byte, leaf [32]byte) (int, bool) {
+	response := c.request(append(append([]byte{btcClientGetMerkleLeafIndex}, root[:]...), leaf[:]...))
+	if len(response) < 2 {
+		c.fail("invalid GET_MERKLE_LEAF_INDEX answer %x", response)
+		return 0, false
+	}
+	index, _, err := readCompactSize(response[1:])
+	if err != nil {
+		c.fail("invalid GET_MERKLE_LEAF_INDEX answer %x", response)
+	}
+	return int(index), response[0] == 1
+}
+
+// mapValue looks up a key in a merkleized map, checking the key and the value
+func (c *btcAppClient) mapValue(commitment []byte, key []byte) ([]byte, bool) {
+	size, rest, err := readCompactSize(commitment)
+	if err != nil || len(rest) != 64 {
+		c.fail("invalid map commitment %x", commitment)
+		return nil, false
+	}
+	var keysRoot, valuesRoot [32]byte
+	copy(keysRoot[:], rest)
+	copy(valuesRoot[:], rest[32:])
+
+	index, found := c.index(keysRoot, merkleLeafHash(key))
+	if !found {
+		return nil, false
+	}
+	if !bytes.Equal(c.element(keysRoot, int(size), index), key) {
+		c.fail("wrong key at index %d", index)
+	}
+	return c.element(valuesRoot, int(size), index), true
+}
+
+// merkleRootFromProof computes the root of a tree from a leaf and its proof, as the app does
+func merkleRootFromProof(leaf [32]byte, proof [][32]byte, index int, size int) [32]byte {
+	if size <= 1 || len(proof) == 0 {
+		return leaf
+	}
+	k := merkleSplit(size)
+	sibling, proof := proof[len(proof)-1], proof[:len(proof)-1]
+	if index < k {
+		return merkleCombine(merkleRootFromProof(leaf, proof, index, k), sibling)
+	}
+	return merkleCombine(sibling, merkleRootFromProof(leaf, proof, index-k, size-k))
+}
+
+func testElements(count int) [][]byte {
+	elements := make([][]byte, count)
+	for i := range elements {
+		elements[i] = []byte(fmt.Sprintf("element %d", i))
+	}
+	return elements
+}
+
+func Test_MerkleRoot(t *testing.T) {
+	leaves := merkleLeaves(testElements(5))
+
+	assert.Equal(t, [32]byte{}, merkleRoot(nil))
+	assert.Equal(t, leaves[0], merkleRoot(leaves[:1]))
+	assert.Equal(t, merkleCombine(merkleCombine(leaves[0], leaves[1]), leaves[2]), merkleRoot(leaves[:3]))
+
+	left := merkleCombine(merkleCombine(leaves[0], leaves[1]), merkleCombine(leaves[2], leaves[3]))
+	assert.Equal(t, merkleCombine(left, leaves[4]), merkleRoot(leaves))
+}
+
+// The roots of the lists 0x00, 0x01... of each size were computed with Python's hashlib from
+// doc/merkle.md in LedgerHQ/app-bitcoin-new, independently of this package
+func Test_MerkleRoot_Vectors(t *testing.T) {
+	roots := map[int]string{
+		1:  "96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+		2:  "a20bf9a7cc2dc8a08f5f415a71b19f6ac427bab54d24eec868b5d3103449953a",
+		3:  "3b6cccd7e3e023ff393006f030315ee7ad9eb111b022b41fba7e5b7a3973f688",
+		4:  "9bcd51240af4005168f033121ba85be5a6ed4f0e6a5fac262066729b8fbfdecb",
+		5:  "b855b42d6c30f5b087e05266783fbd6e394f7b926013ccaa67700a8b0c5a596f",
+		7:  "3560191803028444b232018ac047fdb561c09c23a7a6876c85e08b5e4d48e9f3",
+		8:  "ef7f49b620f6c7ea9b963a214da34b5021c6ded8ed57734380a311ab726aa907",
+		9:  "162a21c2230e0284ea38cb8739ee4bb75947a1acd5d529c638ec068969fb3c4a",
+		16: "93f2bd0cd60b2e597cf53fb12ae63ed157e0c10efbfe097d23caf8a5f59c6e27",
+		17: "8e31c4ca74a9e3449f253ee8cbe60e07149e3a1398e6e3f0f4a3c312a9fe14f3",
+	}
+	for n, expected := range roots {
+		elements := make([][]byte, n)
+		for i := range elements {
+			elements[i] = []byte{byte(i)}
+		}
+		root := merkleRoot(merkleLeaves(elements))
+		assert.Equal(t, expected, hex.EncodeToString(root[:]), "%d elements", n)
+	}
+}
+
+func Test_MerkleProof(t *testing.T) {
+	for size := 1; size <= 20; size++ {
+		leaves := merkleLeaves(testElements(size))
+		root := merkleRoot(leaves)
+		for index := range leaves {
+			proof := merkleProof(leaves, index)
+			assert.Equal(t, root, merkleRootFromProof(leaves[index], proof, index, size), "size %d index %d", size, index)
+		}
+	}
+}
+
+func Test_BTCClientInterpreter(t *testing.T) {
+	client := newBTCClientInterpreter()
+	app := &btcAppClient{call: func(request []byte) []byte {
+		response, err := client.execute(request)
+		require.Nil(t, err, "Detected error, err: %s\n", err)
+		return response
+	}}
+
+	// The preimage and the proof do not fit in one answer
+	long := bytes.Repeat([]byte{0xAB}, 600)
+	client.addKnownPreimage(long)
+	assert.Equal(t, long, app.preimage(sha256.Sum256(long)))
+
+	elements := testElements(100)
+	client.addKnownList(elements)
+	root := merkleRoot(merkleLeaves(elements))
+	assert.Equal(t, elements[77], app.element(root, 100, 77))
+
+	index, found := app.index(root, merkleLeafHash(elements[42]))
+	assert.True(t, found)
+	assert.Equal(t, 42, index)
+	_, found = app.index(root, merkleLeafHash([]byte("unknown")))
+	assert.False(t, found)
+	require.Nil(t, app.err, "Detected error, err: %s\n", app.err)
+
+	response, err := client.execute([]byte{btcClientYield, 1, 2, 3})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Empty(t, response)
+	assert.Equal(t, [][]byte{{1, 2, 3}}, client.yielded)
+
+	_, err = client.execute(append([]byte{btcClientGetPreimage, 0}, make([]byte, 32)...))
+	assert.Error(t, err)
+	_, err = client.execute([]byte{btcClientGetMoreElements})
+	assert.EqualError(t, err, "GET_MORE_ELEMENTS without pending elements")
+	_, err = client.execute([]byte{0x99})
+	assert.EqualError(t, err, "unknown client command 0x99")
+}
@@ -0,0 +1,94 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+const (
+	// btcWalletPolicyV2 is the version of the serialized wallet policies, where the descriptor template is hashed
+	btcWalletPolicyV2 = 0x02
+
+	// BIP84DescriptorTemplate is the descriptor of single signature native segwit wallets
+	BIP84DescriptorTemplate = "wpkh(@0/**)"
+	// BIP84Purpose is the first element of the paths of BIP84 wallets
+	BIP84Purpose = 84
+)
+
+// WalletPolicy describes the scripts of a wallet to the Bitcoin app: a descriptor template with
+// placeholders @0, @1... for the keys. Each key is an extended public key with its origin,
+// such as [f5acc2fd/84'/0'/0']xpub...
+// Wallets without a name are the standard wallets of the app and do not need to be registered
+type WalletPolicy struct {
+	Name               string
+	DescriptorTemplate string
+	Keys               []string
+}
+
+// NewBIP84WalletPolicy returns the standard native segwit wallet of an account.
+// fingerprint is the master key fingerprint and xpub the extended public key of m/84'/coinType'/account'
+func NewBIP84WalletPolicy(fingerprint []byte, coinType uint32, account uint32, xpub string) (*WalletPolicy, error) {
+	if len(fingerprint) != 4 {
+		return nil, errors.New("the fingerprint should contain 4 bytes")
+	}
+
+	origin := FormatBip32Path([]uint32{BIP84Purpose, coinType, account}, 3)
+	key := fmt.Sprintf("[%s%s]%s", hex.EncodeToString(fingerprint), origin[1:], xpub)
+	return &WalletPolicy{DescriptorTemplate: BIP84DescriptorTemplate, Keys: []string{key}}, nil
+}
+
+// Serialize returns the encoding of the policy, committing to the descriptor template by its hash
+// and to the keys by their merkle root
+func (w *WalletPolicy) Serialize() []byte {
+	data := []byte{btcWalletPolicyV2}
+	data = appendVarBytes(data, []byte(w.Name))
+	data = appendCompactSize(data, uint64(len(w.DescriptorTemplate)))
+	templateHash := sha256.Sum256([]byte(w.DescriptorTemplate))
+	data = append(data, templateHash[:]...)
+	data = appendCompactSize(data, uint64(len(w.Keys)))
+	keysRoot := merkleRoot(merkleLeaves(w.keys()))
+	return append(data, keysRoot[:]...)
+}
+
+// ID returns the identifier of the policy, the sha256 of its serialization
+func (w *WalletPolicy) ID() [32]byte {
+	return sha256.Sum256(w.Serialize())
+}
+
+// IsDefault returns true for the standard wallets, which have no name
+func (w *WalletPolicy) IsDefault() bool {
+	return w.Name == ""
+}
+
+func (w *WalletPolicy) keys() [][]byte {
+	keys := make([][]byte, len(w.Keys))
+	for i, key := range w.Keys {
+		keys[i] = []byte(key)
+	}
+	return keys
+}
+
+// addTo makes the policy, its descriptor template and its keys known to the client interpreter
+func (w *WalletPolicy) addTo(client *btcClientInterpreter) {
+	client.addKnownPreimage(w.Serialize())
+	client.addKnownPreimage([]byte(w.DescriptorTemplate))
+	client.addKnownList(w.keys())
+}
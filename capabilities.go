@@ -0,0 +1,159 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"fmt"
+)
+
+// SignMode is the P2 value given to SignSECP256K1
+type SignMode byte
+
+const (
+	SignModeLegacyAmino SignMode = 0
+	SignModeTextual     SignMode = 1
+)
+
+func (m SignMode) String() string {
+	switch m {
+	case SignModeLegacyAmino:
+		return "amino"
+	case SignModeTextual:
+		return "textual"
+	default:
+		return fmt.Sprintf("SignMode(%d)", byte(m))
+	}
+}
+
+// PayloadFraming is the way a payload is split in APDU chunks
+type PayloadFraming uint8
+
+const (
+	// FramingPacketCount uses P1=packetIndex and P2=packetCount
+	FramingPacketCount PayloadFraming = iota + 1
+	// FramingPayloadDescriptor uses P1=init/add/last and P2=sign mode
+	FramingPayloadDescriptor
+)
+
+// P1 values of the payload descriptor framing
+const (
+	payloadInit = 0
+	payloadAdd  = 1
+	payloadLast = 2
+)
+
+// Capabilities describes what a given version of the user app can do
+type Capabilities struct {
+	Version        VersionInfo
+	SignModes      []SignMode
+	Framing        PayloadFraming
+	MaxPathDepth   int
+	FixedPathDepth bool // the path must contain exactly MaxPathDepth elements
+}
+
+// userAppMinimumVersions contains the minimum supported version of each major release
+var userAppMinimumVersions = map[uint8]VersionInfo{
+	1: {0, 1, 5, 1},
+	2: {0, 2, 0, 0},
+}
+
+// UserAppCapabilities returns the capabilities of a given user app version
+func UserAppCapabilities(ver VersionInfo) (Capabilities, error) {
+	req, ok := userAppMinimumVersions[ver.Major]
+	if !ok {
+		return Capabilities{}, fmt.Errorf("App version %d is not supported", ver.Major)
+	}
+	if err := CheckVersion(ver, req); err != nil {
+		return Capabilities{}, err
+	}
+
+	switch ver.Major {
+	case 1:
+		return Capabilities{
+			Version:      ver,
+			SignModes:    []SignMode{SignModeLegacyAmino},
+			Framing:      FramingPacketCount,
+			MaxPathDepth: 10,
+		}, nil
+	default:
+		return Capabilities{
+			Version:        ver,
+			SignModes:      []SignMode{SignModeLegacyAmino, SignModeTextual},
+			Framing:        FramingPayloadDescriptor,
+			MaxPathDepth:   5,
+			FixedPathDepth: true,
+		}, nil
+	}
+}
+
+// validatorAppMinimumVersions contains the minimum supported version of each major release of the validator app
+var validatorAppMinimumVersions = map[uint8]VersionInfo{
+	0: {0, 0, 5, 0},
+	1: {0, 1, 0, 0},
+}
+
+// ValidatorAppCapabilities returns the capabilities of a given validator app version
+// speaking the given framing. The version does not tell the framing, which is
+// found by LedgerTendermintValidator.Negotiate asking the app
+func ValidatorAppCapabilities(ver VersionInfo, framing PayloadFraming) (Capabilities, error) {
+	req, ok := validatorAppMinimumVersions[ver.Major]
+	if !ok {
+		return Capabilities{}, fmt.Errorf("Validator app version %d is not supported", ver.Major)
+	}
+	if err := CheckVersion(ver, req); err != nil {
+		return Capabilities{}, err
+	}
+
+	switch framing {
+	case FramingPacketCount:
+		return Capabilities{
+			Version:      ver,
+			Framing:      FramingPacketCount,
+			MaxPathDepth: 10,
+		}, nil
+	case FramingPayloadDescriptor:
+		return Capabilities{
+			Version:        ver,
+			Framing:        FramingPayloadDescriptor,
+			MaxPathDepth:   5,
+			FixedPathDepth: true,
+		}, nil
+	default:
+		return Capabilities{}, fmt.Errorf("unknown payload framing %d", framing)
+	}
+}
+
+// SupportsSignMode returns true if the sign mode can be used with this app version
+func (c Capabilities) SupportsSignMode(mode SignMode) bool {
+	for _, m := range c.SignModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckPath verifies that a bip32 path can be encoded for this app version
+func (c Capabilities) CheckPath(bip32Path []uint32) error {
+	if c.FixedPathDepth && len(bip32Path) != c.MaxPathDepth {
+		return fmt.Errorf("path should contain %d elements", c.MaxPathDepth)
+	}
+	if len(bip32Path) > c.MaxPathDepth {
+		return fmt.Errorf("maximum bip32 depth = %d", c.MaxPathDepth)
+	}
+	return nil
+}
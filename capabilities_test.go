@@ -0,0 +1,90 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UserAppCapabilities(t *testing.T) {
+	caps, err := UserAppCapabilities(VersionInfo{0, 1, 5, 3})
+	require.Nil(t, err)
+	assert.Equal(t, FramingPacketCount, caps.Framing)
+	assert.True(t, caps.SupportsSignMode(SignModeLegacyAmino))
+	assert.False(t, caps.SupportsSignMode(SignModeTextual))
+	assert.Nil(t, caps.CheckPath([]uint32{44, 931, 0}))
+	assert.Error(t, caps.CheckPath(make([]uint32, 11)))
+
+	caps, err = UserAppCapabilities(VersionInfo{0, 2, 1, 0})
+	require.Nil(t, err)
+	assert.Equal(t, FramingPayloadDescriptor, caps.Framing)
+	assert.True(t, caps.SupportsSignMode(SignModeTextual))
+	assert.False(t, caps.SupportsSignMode(SignMode(2)))
+	assert.Nil(t, caps.CheckPath([]uint32{44, 931, 0, 0, 0}))
+	assert.Error(t, caps.CheckPath([]uint32{44, 931, 0}))
+}
+
+func Test_UserAppCapabilities_Unsupported(t *testing.T) {
+	_, err := UserAppCapabilities(VersionInfo{0, 1, 5, 0})
+	assert.Equal(t, NewVersionRequiredError(VersionInfo{0, 1, 5, 1}, VersionInfo{0, 1, 5, 0}), err)
+
+	_, err = UserAppCapabilities(VersionInfo{0, 3, 0, 0})
+	assert.EqualError(t, err, "App version 3 is not supported")
+}
+
+func Test_UserCheckVersion_DebugPolicy(t *testing.T) {
+	ledger := &LedgerTHORChain{version: VersionInfo{AppModeDebug, 2, 1, 0}}
+	assert.Nil(t, ledger.CheckVersion(ledger.version))
+
+	assert.Error(t, ledger.SetDebugAppPolicy(RefuseDebugApp))
+	assert.Equal(t, &DebugAppError{ledger.version}, ledger.CheckVersion(ledger.version))
+	assert.Nil(t, ledger.CheckVersion(VersionInfo{0, 2, 1, 0}))
+}
+
+func Test_ValidatorAppCapabilities(t *testing.T) {
+	caps, err := ValidatorAppCapabilities(VersionInfo{0, 0, 9, 0}, FramingPacketCount)
+	require.Nil(t, err)
+	assert.Equal(t, FramingPacketCount, caps.Framing)
+	assert.Nil(t, caps.CheckPath([]uint32{44, 118, 0}))
+
+	caps, err = ValidatorAppCapabilities(VersionInfo{0, 1, 2, 0}, FramingPayloadDescriptor)
+	require.Nil(t, err)
+	assert.Equal(t, FramingPayloadDescriptor, caps.Framing)
+	assert.Error(t, caps.CheckPath([]uint32{44, 118, 0}))
+
+	_, err = ValidatorAppCapabilities(VersionInfo{0, 0, 9, 0}, PayloadFraming(0))
+	assert.Error(t, err)
+
+	_, err = ValidatorAppCapabilities(VersionInfo{0, 2, 0, 0}, FramingPacketCount)
+	assert.EqualError(t, err, "Validator app version 2 is not supported")
+}
+
+func Test_RequiredTendermintValidatorAppVersion(t *testing.T) {
+	assert.Equal(t, VersionInfo{0, 0, 5, 0}, RequiredTendermintValidatorAppVersion())
+
+	_, err := ValidatorAppCapabilities(VersionInfo{0, 0, 5, 0}, FramingPacketCount)
+	assert.Nil(t, err)
+
+	_, err = ValidatorAppCapabilities(VersionInfo{0, 0, 4, 9}, FramingPacketCount)
+	assert.Equal(t, NewVersionRequiredError(VersionInfo{0, 0, 5, 0}, VersionInfo{0, 0, 4, 9}), err)
+
+	_, err = ValidatorAppCapabilities(VersionInfo{0, 0, 0, 5}, FramingPacketCount)
+	assert.Equal(t, NewVersionRequiredError(VersionInfo{0, 0, 5, 0}, VersionInfo{0, 0, 0, 5}), err)
+}
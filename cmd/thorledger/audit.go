@@ -0,0 +1,99 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+type auditVerifyResult struct {
+	File     string           `json:"file"`
+	HeadFile string           `json:"head_file,omitempty"`
+	Head     ledger.AuditHead `json:"head"`
+}
+
+func (c *cli) audit(args []string) error {
+	if len(args) == 0 || args[0] != "verify" {
+		return errors.New("usage: audit verify -file <log> [-head-file <file> | -no-head-file] [-head seq:hash]")
+	}
+
+	fs := c.newFlagSet("audit verify")
+	file := fs.String("file", "", "audit log to verify")
+	headFile := fs.String("head-file", "", "head file written with the log, <log>.head by default")
+	noHeadFile := fs.Bool("no-head-file", false, "only check the chain, without the head file")
+	headFlag := fs.String("head", "", "head recorded earlier, as printed by this command, to detect truncation")
+	if err := parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("-file is required")
+	}
+	if *noHeadFile && *headFile != "" {
+		return errors.New("-head-file and -no-head-file are exclusive")
+	}
+
+	var checkpoints []ledger.AuditHead
+	if !*noHeadFile {
+		if *headFile == "" {
+			*headFile = ledger.AuditHeadFile(*file)
+		}
+		head, err := ledger.ReadAuditHead(*headFile)
+		if err != nil {
+			return err
+		}
+		checkpoints = append(checkpoints, head)
+	}
+	if *headFlag != "" {
+		head, err := parseAuditHead(*headFlag)
+		if err != nil {
+			return err
+		}
+		checkpoints = append(checkpoints, head)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	head, err := ledger.VerifyAuditLog(f, checkpoints...)
+	if err != nil {
+		return err
+	}
+
+	result := auditVerifyResult{File: *file, HeadFile: *headFile, Head: head}
+	return c.output(result, fmt.Sprintf("%d records verified, head %d:%s", head.Seq, head.Seq, head.Hash))
+}
+
+func parseAuditHead(s string) (ledger.AuditHead, error) {
+	seq, hash, ok := strings.Cut(s, ":")
+	if !ok {
+		return ledger.AuditHead{}, fmt.Errorf("invalid head %q: expected seq:hash", s)
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil || n == 0 {
+		return ledger.AuditHead{}, fmt.Errorf("invalid head %q: expected seq:hash", s)
+	}
+	return ledger.AuditHead{Seq: n, Hash: hash}, nil
+}
@@ -0,0 +1,156 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+	"github.com/thorchain/ledger-thorchain-go/airgap"
+)
+
+func (c *cli) bundle(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: bundle create|sign|assemble")
+	}
+
+	switch command, rest := args[0], args[1:]; command {
+	case "create":
+		return c.bundleCreate(rest)
+	case "sign":
+		return c.bundleSign(rest)
+	case "assemble":
+		return c.bundleAssemble(rest)
+	default:
+		return fmt.Errorf("unknown bundle command %q", command)
+	}
+}
+
+type bundleResult struct {
+	File    string `json:"file"`
+	Address string `json:"address"`
+	Signed  bool   `json:"signed"`
+	Summary string `json:"summary"`
+}
+
+// bundleCreate runs online: it does not need the device, the public key is given on the command line
+func (c *cli) bundleCreate(args []string) error {
+	fs := c.newFlagSet("bundle create")
+	pathFlag := fs.String("path", defaultUserPath, "bip32 path of the signer")
+	pubKeyFlag := fs.String("pubkey", "", "hex encoded public key of the signer, as printed by the pubkey command")
+	file := fs.String("file", "-", "file containing the amino JSON sign doc, - for stdin")
+	out := fs.String("out", "", "file the unsigned bundle is written to")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *pubKeyFlag == "" || *out == "" {
+		return errors.New("-pubkey and -out are required")
+	}
+
+	path, err := ledger.ParseBip32Path(*pathFlag, userHardenCount)
+	if err != nil {
+		return err
+	}
+	pubKey, err := hex.DecodeString(*pubKeyFlag)
+	if err != nil {
+		return errors.New("invalid public key: expected hex")
+	}
+
+	signDoc, err := c.readInput(*file)
+	if err != nil {
+		return err
+	}
+
+	b, err := airgap.NewBundle(c.network, path, pubKey, signDoc)
+	if err != nil {
+		return err
+	}
+	if err := b.WriteFile(*out); err != nil {
+		return err
+	}
+
+	result := bundleResult{File: *out, Address: b.Address, Summary: b.Summary}
+	return c.output(result, "unsigned bundle written to "+*out, b.Summary)
+}
+
+// bundleSign runs offline with the device
+func (c *cli) bundleSign(args []string) error {
+	fs := c.newFlagSet("bundle sign")
+	in := fs.String("in", "", "unsigned bundle")
+	out := fs.String("out", "", "file the signed bundle is written to")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *in == "" || *out == "" {
+		return errors.New("-in and -out are required")
+	}
+
+	b, err := airgap.ReadFile(*in)
+	if err != nil {
+		return err
+	}
+
+	app, err := openUserApp()
+	if err != nil {
+		return err
+	}
+	defer app.Close()
+
+	fmt.Fprintf(c.stderr, "Signing as %s:\n%s\n", b.Address, b.Summary)
+	fmt.Fprintln(c.stderr, "Please review and approve the transaction on the device")
+	signed, err := airgap.Sign(app, b)
+	if err != nil {
+		return err
+	}
+	if err := signed.WriteFile(*out); err != nil {
+		return err
+	}
+
+	result := bundleResult{File: *out, Address: signed.Address, Signed: true, Summary: signed.Summary}
+	return c.output(result, "signed bundle written to "+*out)
+}
+
+// bundleAssemble runs online: it verifies a signed bundle and prints the request
+// that broadcasts the transaction through POST /cosmos/tx/v1beta1/txs
+func (c *cli) bundleAssemble(args []string) error {
+	fs := c.newFlagSet("bundle assemble")
+	in := fs.String("in", "", "signed bundle")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *in == "" {
+		return errors.New("-in is required")
+	}
+
+	b, err := airgap.ReadFile(*in)
+	if err != nil {
+		return err
+	}
+	tx, err := airgap.AssembleTx(b)
+	if err != nil {
+		return err
+	}
+
+	request, err := airgap.BroadcastRequest(tx)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(c.stdout, string(request))
+	return err
+}
@@ -0,0 +1,349 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+	"github.com/thorchain/ledger-thorchain-go/preview"
+)
+
+type versionResult struct {
+	App     string `json:"app"`
+	Version string `json:"version"`
+	Debug   bool   `json:"debug"`
+	Model   string `json:"model"`
+}
+
+func (c *cli) version(args []string) error {
+	if err := parseFlags(c.newFlagSet("version"), args); err != nil {
+		return err
+	}
+
+	app, err := openUserApp()
+	if err != nil {
+		return err
+	}
+	defer app.Close()
+
+	version, err := app.GetVersion()
+	if err != nil {
+		return err
+	}
+
+	result := versionResult{
+		App:     ledger.THORChainAppName,
+		Version: version.String(),
+		Debug:   version.IsDebug(),
+		Model:   app.DeviceInfo().Model.String(),
+	}
+	text := fmt.Sprintf("%s app %s on %s", result.App, result.Version, result.Model)
+	if result.Debug {
+		text += " (debug build)"
+	}
+	return c.output(result, text)
+}
+
+type deviceResult struct {
+	Model     string `json:"model"`
+	ProductID string `json:"product_id"`
+	Path      string `json:"path"`
+}
+
+func (c *cli) deviceList(args []string) error {
+	if err := parseFlags(c.newFlagSet("device list"), args); err != nil {
+		return err
+	}
+
+	result := []deviceResult{}
+	var lines []string
+	for i, device := range listDevices() {
+		d := deviceResult{
+			Model:     device.Model.String(),
+			ProductID: fmt.Sprintf("%04x", device.ProductID),
+			Path:      device.Path,
+		}
+		result = append(result, d)
+		lines = append(lines, fmt.Sprintf("%d: %s (product id %s) %s", i, d.Model, d.ProductID, d.Path))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "no Ledger device found")
+	}
+	return c.output(result, lines...)
+}
+
+type pubKeyResult struct {
+	Path   string `json:"path"`
+	PubKey string `json:"pubkey"`
+}
+
+func (c *cli) pubKey(args []string) error {
+	fs := c.newFlagSet("pubkey")
+	pathFlag := fs.String("path", defaultUserPath, "bip32 path")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	path, err := ledger.ParseBip32Path(*pathFlag, userHardenCount)
+	if err != nil {
+		return err
+	}
+
+	app, err := openUserApp()
+	if err != nil {
+		return err
+	}
+	defer app.Close()
+
+	pubKey, err := app.GetPublicKeySECP256K1(path)
+	if err != nil {
+		return err
+	}
+
+	result := pubKeyResult{Path: ledger.FormatBip32Path(path, userHardenCount), PubKey: hex.EncodeToString(pubKey)}
+	return c.output(result, result.PubKey)
+}
+
+type addressResult struct {
+	Path    string `json:"path"`
+	Address string `json:"address"`
+	PubKey  string `json:"pubkey"`
+	Shown   bool   `json:"shown_on_device"`
+}
+
+func (c *cli) address(args []string) error {
+	fs := c.newFlagSet("address")
+	pathFlag := fs.String("path", defaultUserPath, "bip32 path")
+	show := fs.Bool("show", false, "show the address on the device and wait for confirmation")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	path, err := ledger.ParseBip32Path(*pathFlag, userHardenCount)
+	if err != nil {
+		return err
+	}
+
+	app, err := openUserApp()
+	if err != nil {
+		return err
+	}
+	defer app.Close()
+
+	var pubKey []byte
+	var address string
+	if *show {
+		fmt.Fprintln(c.stderr, "Please confirm the address on the device")
+		pubKey, address, err = app.GetAddressPubKeySECP256K1(path, c.network.AccountHRP)
+	} else {
+		pubKey, err = app.GetPublicKeySECP256K1(path)
+		if err == nil {
+			address, err = ledger.AddressFromPubKey(c.network.AccountHRP, pubKey)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	result := addressResult{
+		Path:    ledger.FormatBip32Path(path, userHardenCount),
+		Address: address,
+		PubKey:  hex.EncodeToString(pubKey),
+		Shown:   *show,
+	}
+	return c.output(result, result.Address)
+}
+
+type signResult struct {
+	Path         string `json:"path"`
+	Mode         string `json:"mode"`
+	PubKey       string `json:"pubkey"`
+	Signature    string `json:"signature"`
+	SignatureDER string `json:"signature_der"`
+}
+
+func (c *cli) sign(args []string) error {
+	fs := c.newFlagSet("sign")
+	pathFlag := fs.String("path", defaultUserPath, "bip32 path")
+	modeFlag := fs.String("mode", ledger.SignModeLegacyAmino.String(), "sign mode: amino or textual")
+	file := fs.String("file", "-", "file containing the sign doc, - for stdin")
+	auditFile := fs.String("audit", "", "audit log the request is appended to")
+	auditHeadFile := fs.String("audit-head", "", "head file of the audit log, <audit>.head by default")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	path, err := ledger.ParseBip32Path(*pathFlag, userHardenCount)
+	if err != nil {
+		return err
+	}
+
+	mode, err := parseSignMode(*modeFlag)
+	if err != nil {
+		return err
+	}
+	signDoc, err := c.readInput(*file)
+	if err != nil {
+		return err
+	}
+
+	// The device shows little at a time, so the whole transaction is shown first
+	if p, err := preview.Render(signDoc, mode); err == nil {
+		fmt.Fprint(c.stderr, p.Text())
+	} else {
+		fmt.Fprintln(c.stderr, "The sign doc cannot be previewed:", err)
+	}
+
+	app, err := openUserApp()
+	if err != nil {
+		return err
+	}
+	defer app.Close()
+
+	if *auditFile != "" {
+		if *auditHeadFile == "" {
+			*auditHeadFile = ledger.AuditHeadFile(*auditFile)
+		}
+		log, err := ledger.OpenAuditLogHead(*auditFile, *auditHeadFile)
+		if err != nil {
+			return err
+		}
+		defer log.Close()
+		app.SetAuditLog(log)
+	}
+
+	pubKey, err := app.GetPublicKeySECP256K1(path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.stderr, "Please review and approve the transaction on the device")
+	der, err := app.SignSECP256K1(path, signDoc, byte(mode))
+	if err != nil {
+		return err
+	}
+	compact, err := ledger.SignatureToCompact(der)
+	if err != nil {
+		return err
+	}
+
+	result := signResult{
+		Path:         ledger.FormatBip32Path(path, userHardenCount),
+		Mode:         mode.String(),
+		PubKey:       hex.EncodeToString(pubKey),
+		Signature:    base64.StdEncoding.EncodeToString(compact),
+		SignatureDER: hex.EncodeToString(der),
+	}
+	return c.output(result, result.Signature)
+}
+
+func (c *cli) preview(args []string) error {
+	fs := c.newFlagSet("preview")
+	modeFlag := fs.String("mode", ledger.SignModeLegacyAmino.String(), "sign mode: amino or textual")
+	file := fs.String("file", "-", "file containing the sign doc, - for stdin")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	mode, err := parseSignMode(*modeFlag)
+	if err != nil {
+		return err
+	}
+	signDoc, err := c.readInput(*file)
+	if err != nil {
+		return err
+	}
+
+	p, err := preview.Render(signDoc, mode)
+	if err != nil {
+		return err
+	}
+	return c.output(p, strings.TrimSuffix(p.Text(), "\n"))
+}
+
+func parseSignMode(s string) (ledger.SignMode, error) {
+	switch s {
+	case ledger.SignModeLegacyAmino.String():
+		return ledger.SignModeLegacyAmino, nil
+	case ledger.SignModeTextual.String():
+		return ledger.SignModeTextual, nil
+	default:
+		return 0, fmt.Errorf("unknown sign mode %q: expected amino or textual", s)
+	}
+}
+
+// readInput reads a file, or stdin for -
+func (c *cli) readInput(file string) ([]byte, error) {
+	if file == "-" {
+		return io.ReadAll(c.stdin)
+	}
+	return os.ReadFile(file)
+}
+
+type validatorPubKeyResult struct {
+	Path        string             `json:"path"`
+	Address     string             `json:"address"`
+	ConsAddress string             `json:"consensus_address"`
+	ConsPubKey  string             `json:"consensus_pubkey"`
+	AminoPubKey ledger.AminoPubKey `json:"pub_key"`
+}
+
+func (c *cli) validatorPubKey(args []string) error {
+	fs := c.newFlagSet("validator pubkey")
+	pathFlag := fs.String("path", defaultValidatorPath, "bip32 path, every element must be hardened")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	path, err := ledger.ParseBip32Path(*pathFlag, validatorHardenCount)
+	if err != nil {
+		return err
+	}
+
+	app, err := openValidatorApp()
+	if err != nil {
+		return err
+	}
+	defer app.Close()
+
+	pubKey, err := app.GetConsensusPubKey(path)
+	if err != nil {
+		return err
+	}
+	consAddress, err := pubKey.Bech32AddressWithPrefix(c.network.ConsensusAddressHRP())
+	if err != nil {
+		return err
+	}
+	consPubKey, err := pubKey.Bech32PubKeyWithPrefix(c.network.ConsensusPubKeyHRP())
+	if err != nil {
+		return err
+	}
+
+	result := validatorPubKeyResult{
+		Path:        ledger.FormatBip32Path(path, validatorHardenCount),
+		Address:     fmt.Sprintf("%X", pubKey.Address()),
+		ConsAddress: consAddress,
+		ConsPubKey:  consPubKey,
+		AminoPubKey: pubKey.AminoPubKey(),
+	}
+	return c.output(result,
+		"address:           "+result.Address,
+		"consensus address: "+result.ConsAddress,
+		"consensus pubkey:  "+result.ConsPubKey,
+		"pubkey (base64):   "+result.AminoPubKey.Value)
+}
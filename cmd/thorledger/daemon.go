@@ -0,0 +1,66 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/thorchain/ledger-thorchain-go/privval"
+)
+
+// daemonContext is done when the daemon must stop. It is replaced in tests
+var daemonContext = func() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// daemon runs the remote signer of the chains of a config file until it is interrupted
+func (c *cli) daemon(args []string) error {
+	fs := c.newFlagSet("daemon")
+	configFile := fs.String("config", "", "daemon config file, .toml, .yaml or .yml")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *configFile == "" {
+		return errors.New("-config is required")
+	}
+
+	cfg, err := privval.LoadDaemonConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	app, err := openValidatorApp()
+	if err != nil {
+		return err
+	}
+	defer app.Close()
+
+	daemon, err := privval.NewDaemon(cfg, app, cfg.NewLogger(c.stderr))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := daemonContext()
+	defer cancel()
+	if err := daemon.Run(ctx); !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+// Command thorledger performs everyday operations with the THORChain and
+// Tendermint validator Ledger apps: app version, device list, public keys,
+// addresses, signing, offline signing bundles, multisig signing, validator
+// consensus keys and the validator remote signer.
+// Run thorledger -h for the usage.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+const (
+	defaultUserPath      = "m/44'/931'/0'/0/0"
+	defaultValidatorPath = "m/44'/118'/0'/0'/0'"
+
+	// userHardenCount and validatorHardenCount are the elements hardened by each app
+	userHardenCount      = 3
+	validatorHardenCount = 10
+)
+
+const usage = `Usage: thorledger [-json] [-network mainnet|stagenet|mocknet] <command> [flags]
+
+Commands:
+  version                  version of the THORChain app and device model
+  device list              Ledger devices attached over USB
+  pubkey                   secp256k1 public key
+  address [-show]          account address, -show confirms it on the device
+  sign [-mode] [-file]     sign an amino JSON or textual sign doc, -audit records it in a log
+  preview [-mode] [-file]  show a sign doc as the sign command does, without the device
+  validator pubkey         consensus key of the validator app
+  daemon                   remote signer of the chains of a config file (-config)
+  bundle create            create an unsigned bundle for offline signing (-pubkey, -out)
+  bundle sign              sign a bundle offline with the device (-in, -out)
+  bundle assemble          verify a signed bundle and print the request to broadcast it (-in)
+  audit verify             check an audit log against its head file (-file, -head-file, -head)
+  multisig address         address and amino key of a multisig (-threshold, -pubkeys)
+  multisig sign            sign for a multisig with one member key (-out)
+  multisig combine         verify and combine the partial signatures (-partials)
+
+The key commands accept -path, e.g. -path "m/44'/931'/0'/0/0"
+
+Options:
+`
+
+// userApp is the part of LedgerTHORChain used by the commands
+type userApp interface {
+	Close() error
+	GetVersion() (*ledger.VersionInfo, error)
+	DeviceInfo() ledger.DeviceInfo
+	GetPublicKeySECP256K1(bip32Path []uint32) ([]byte, error)
+	GetAddressPubKeySECP256K1(bip32Path []uint32, hrp string) ([]byte, string, error)
+	SignSECP256K1(bip32Path []uint32, transaction []byte, p2 byte) ([]byte, error)
+	SetAuditLog(recorder ledger.AuditRecorder)
+}
+
+// validatorApp is the part of LedgerTendermintValidator used by the commands
+type validatorApp interface {
+	Close() error
+	GetConsensusPubKey(bip32Path []uint32) (ledger.ConsensusPubKey, error)
+	GetPublicKeyED25519(bip32Path []uint32) ([]byte, error)
+	SignED25519(bip32Path []uint32, message []byte) ([]byte, error)
+}
+
+// The device access is replaced in tests
+var (
+	openUserApp = func() (userApp, error) {
+		return ledger.FindLedgerTHORChainUserApp()
+	}
+	openValidatorApp = func() (validatorApp, error) {
+		return ledger.FindLedgerTendermintValidatorApp()
+	}
+	listDevices = ledger.ListDevices
+)
+
+func main() {
+	err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// cli contains the global options and the streams of the commands. stderr receives the flag usage,
+// the prompts and the previews shown before the device is used
+type cli struct {
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+	json    bool
+	network ledger.Network
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	fs := flag.NewFlagSet("thorledger", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	jsonOutput := fs.Bool("json", false, "print the result as JSON")
+	networkName := fs.String("network", ledger.Mainnet.Name, "network preset: mainnet, stagenet or mocknet")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	network, err := ledger.NetworkByName(*networkName)
+	if err != nil {
+		return err
+	}
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr, json: *jsonOutput, network: network}
+
+	args = fs.Args()
+	if len(args) == 0 {
+		return errors.New("missing command: version, device, pubkey, address, sign, preview, validator, daemon, bundle, audit or multisig")
+	}
+
+	switch command, rest := args[0], args[1:]; command {
+	case "version":
+		return c.version(rest)
+	case "device":
+		if len(rest) == 0 || rest[0] != "list" {
+			return errors.New("usage: device list")
+		}
+		return c.deviceList(rest[1:])
+	case "pubkey":
+		return c.pubKey(rest)
+	case "address":
+		return c.address(rest)
+	case "sign":
+		return c.sign(rest)
+	case "preview":
+		return c.preview(rest)
+	case "validator":
+		if len(rest) == 0 || rest[0] != "pubkey" {
+			return errors.New("usage: validator pubkey")
+		}
+		return c.validatorPubKey(rest[1:])
+	case "daemon":
+		return c.daemon(rest)
+	case "bundle":
+		return c.bundle(rest)
+	case "audit":
+		return c.audit(rest)
+	case "multisig":
+		return c.multisig(rest)
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+// output prints result as JSON, or the text lines
+func (c *cli) output(result interface{}, lines ...string) error {
+	if c.json {
+		encoder := json.NewEncoder(c.stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+	_, err := fmt.Fprintln(c.stdout, strings.Join(lines, "\n"))
+	return err
+}
+
+// newFlagSet returns the flag set of a command
+func (c *cli) newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	return fs
+}
+
+// parseFlags parses the flags of a command, which does not take positional arguments
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	return nil
+}
@@ -0,0 +1,429 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+	"github.com/thorchain/ledger-thorchain-go/preview"
+)
+
+// fakeUserApp emulates the THORChain app with an in-memory key
+type fakeUserApp struct {
+	key       *btcec.PrivateKey
+	paths     [][]uint32
+	signed    []byte
+	signMode  byte
+	confirmed bool
+	audit     ledger.AuditRecorder
+	// onSign is called when the transaction reaches the device
+	onSign func()
+}
+
+func (a *fakeUserApp) Close() error { return nil }
+
+func (a *fakeUserApp) GetVersion() (*ledger.VersionInfo, error) {
+	return &ledger.VersionInfo{Major: 2, Minor: 34, Patch: 1}, nil
+}
+
+func (a *fakeUserApp) DeviceInfo() ledger.DeviceInfo {
+	return ledger.DeviceInfo{Model: ledger.ModelNanoSPlus}
+}
+
+func (a *fakeUserApp) GetPublicKeySECP256K1(bip32Path []uint32) ([]byte, error) {
+	a.paths = append(a.paths, bip32Path)
+	return a.key.PubKey().SerializeCompressed(), nil
+}
+
+func (a *fakeUserApp) GetAddressPubKeySECP256K1(bip32Path []uint32, hrp string) ([]byte, string, error) {
+	a.confirmed = true
+	pubKey, _ := a.GetPublicKeySECP256K1(bip32Path)
+	address, err := ledger.AddressFromPubKey(hrp, pubKey)
+	return pubKey, address, err
+}
+
+func (a *fakeUserApp) SignSECP256K1(bip32Path []uint32, transaction []byte, p2 byte) ([]byte, error) {
+	a.signed, a.signMode = transaction, p2
+	if a.onSign != nil {
+		a.onSign()
+	}
+	hash := sha256.Sum256(transaction)
+	if a.audit != nil {
+		if err := a.audit.Record(ledger.AuditRecord{App: ledger.AuditAppTHORChain, Outcome: ledger.AuditSigned}); err != nil {
+			return nil, err
+		}
+	}
+	return ecdsa.Sign(a.key, hash[:]).Serialize(), nil
+}
+
+func (a *fakeUserApp) SetAuditLog(recorder ledger.AuditRecorder) {
+	a.audit = recorder
+}
+
+type fakeValidatorApp struct {
+	key ed25519.PrivateKey
+}
+
+func (a *fakeValidatorApp) Close() error { return nil }
+
+func (a *fakeValidatorApp) GetConsensusPubKey(bip32Path []uint32) (ledger.ConsensusPubKey, error) {
+	return ledger.NewConsensusPubKey(a.key.Public().(ed25519.PublicKey))
+}
+
+func (a *fakeValidatorApp) GetPublicKeyED25519(bip32Path []uint32) ([]byte, error) {
+	return a.key.Public().(ed25519.PublicKey), nil
+}
+
+func (a *fakeValidatorApp) SignED25519(bip32Path []uint32, message []byte) ([]byte, error) {
+	return ed25519.Sign(a.key, message), nil
+}
+
+func withFakeApps(t *testing.T) (*fakeUserApp, *fakeValidatorApp) {
+	key, err := btcec.NewPrivateKey()
+	require.Nil(t, err)
+	user := &fakeUserApp{key: key}
+	validator := &fakeValidatorApp{key: ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))}
+
+	prevUser, prevValidator, prevList := openUserApp, openValidatorApp, listDevices
+	openUserApp = func() (userApp, error) { return user, nil }
+	openValidatorApp = func() (validatorApp, error) { return validator, nil }
+	listDevices = func() []ledger.DeviceInfo {
+		return []ledger.DeviceInfo{{Model: ledger.ModelNanoX, ProductID: 0x4011, Path: "1-1:1.0"}}
+	}
+	t.Cleanup(func() {
+		openUserApp, openValidatorApp, listDevices = prevUser, prevValidator, prevList
+	})
+	return user, validator
+}
+
+func runCommand(t *testing.T, stdin string, args ...string) string {
+	var stdout bytes.Buffer
+	err := run(args, strings.NewReader(stdin), &stdout, io.Discard)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	return stdout.String()
+}
+
+func Test_Version(t *testing.T) {
+	withFakeApps(t)
+
+	assert.Equal(t, "THORChain app 2.34.1 on Nano S Plus\n", runCommand(t, "", "version"))
+
+	var result versionResult
+	require.Nil(t, json.Unmarshal([]byte(runCommand(t, "", "-json", "version")), &result))
+	assert.Equal(t, versionResult{App: "THORChain", Version: "2.34.1", Model: "Nano S Plus"}, result)
+}
+
+func Test_DeviceList(t *testing.T) {
+	withFakeApps(t)
+
+	var result []deviceResult
+	require.Nil(t, json.Unmarshal([]byte(runCommand(t, "", "-json", "device", "list")), &result))
+	assert.Equal(t, []deviceResult{{Model: "Nano X", ProductID: "4011", Path: "1-1:1.0"}}, result)
+}
+
+func Test_Address(t *testing.T) {
+	user, _ := withFakeApps(t)
+	expected, err := ledger.AddressFromPubKey("sthor", user.key.PubKey().SerializeCompressed())
+	require.Nil(t, err)
+
+	output := runCommand(t, "", "-network", "stagenet", "address", "-path", "m/44'/931'/1'/0/3")
+	assert.Equal(t, expected+"\n", output)
+	assert.False(t, user.confirmed)
+	assert.Equal(t, []uint32{44, 931, 1, 0, 3}, user.paths[0])
+
+	var result addressResult
+	require.Nil(t, json.Unmarshal([]byte(runCommand(t, "", "-json", "-network", "stagenet", "address", "-show")), &result))
+	assert.True(t, user.confirmed)
+	assert.Equal(t, addressResult{
+		Path:    "m/44'/931'/0'/0/0",
+		Address: expected,
+		PubKey:  hex.EncodeToString(user.key.PubKey().SerializeCompressed()),
+		Shown:   true,
+	}, result)
+}
+
+func Test_Sign(t *testing.T) {
+	user, _ := withFakeApps(t)
+	signDoc := `{"account_number":"1","chain_id":"thorchain-1","fee":{},"memo":"","msgs":[],"sequence":"0"}`
+
+	var result signResult
+	require.Nil(t, json.Unmarshal([]byte(runCommand(t, signDoc, "-json", "sign", "-mode", "textual")), &result))
+	assert.Equal(t, []byte(signDoc), user.signed)
+	assert.Equal(t, byte(ledger.SignModeTextual), user.signMode)
+	assert.Equal(t, "textual", result.Mode)
+
+	compact, err := base64.StdEncoding.DecodeString(result.Signature)
+	require.Nil(t, err)
+	der, err := hex.DecodeString(result.SignatureDER)
+	require.Nil(t, err)
+	expected, err := ledger.SignatureToCompact(der)
+	require.Nil(t, err)
+	assert.Equal(t, expected, compact)
+}
+
+// depositSignDoc is a swap of RUNE to BTC
+const depositSignDoc = `{"account_number":"1","chain_id":"thorchain-1","fee":{"amount":[],"gas":"1"},"memo":"","msgs":[` +
+	`{"type":"thorchain/MsgDeposit","value":{"coins":[{"amount":"250000000","asset":"THOR.RUNE"}],"memo":"=:BTC.BTC:bc1qdest","signer":"thor1a"}}],"sequence":"0"}`
+
+func Test_SignPreview(t *testing.T) {
+	user, _ := withFakeApps(t)
+	var stdout, stderr bytes.Buffer
+	var shown string
+	user.onSign = func() { shown = stderr.String() }
+
+	// The preview and the prompt are shown before the transaction reaches the device
+	require.Nil(t, run([]string{"sign"}, strings.NewReader(depositSignDoc), &stdout, &stderr))
+	assert.Contains(t, shown, "Amount:    2.5 RUNE\n")
+	assert.Contains(t, shown, "Intent:    swap to BTC.BTC, destination bc1qdest\n")
+	assert.True(t, strings.HasSuffix(shown, "Please review and approve the transaction on the device\n"), shown)
+	assert.NotContains(t, stdout.String(), "RUNE")
+
+	// Sign docs that cannot be previewed are signed after a notice
+	stderr.Reset()
+	err := run([]string{"sign", "-mode", "textual"}, strings.NewReader(`{"account_number":"1"}`), &stdout, &stderr)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.True(t, strings.HasPrefix(shown, "The sign doc cannot be previewed:"), shown)
+
+	// The usage goes to stderr too
+	stderr.Reset()
+	err = run([]string{"sign", "-h"}, strings.NewReader(""), &stdout, &stderr)
+	assert.ErrorIs(t, err, flag.ErrHelp)
+	assert.Contains(t, stderr.String(), "-path")
+}
+
+func Test_Preview(t *testing.T) {
+	user, _ := withFakeApps(t)
+	signDoc := depositSignDoc
+
+	output := runCommand(t, signDoc, "preview")
+	assert.Contains(t, output, "Amount:    2.5 RUNE\n")
+	assert.Contains(t, output, "Intent:    swap to BTC.BTC, destination bc1qdest\n")
+
+	var result preview.Preview
+	require.Nil(t, json.Unmarshal([]byte(runCommand(t, signDoc, "-json", "preview")), &result))
+	assert.Equal(t, preview.Digest([]byte(signDoc)), result.Digest)
+	assert.Nil(t, user.signed)
+
+	var stdout bytes.Buffer
+	assert.Error(t, run([]string{"preview"}, strings.NewReader("{}"), &stdout, io.Discard))
+}
+
+func Test_Audit(t *testing.T) {
+	user, _ := withFakeApps(t)
+	file := filepath.Join(t.TempDir(), "audit.log")
+	signDoc := `{"account_number":"1","chain_id":"thorchain-1","fee":{"amount":[],"gas":"1"},"memo":"","msgs":[],"sequence":"0"}`
+	runCommand(t, signDoc, "sign", "-audit", file)
+	require.NotNil(t, user.audit)
+
+	var result auditVerifyResult
+	require.Nil(t, json.Unmarshal([]byte(runCommand(t, "", "-json", "audit", "verify", "-file", file)), &result))
+	assert.Equal(t, uint64(1), result.Head.Seq)
+
+	head := fmt.Sprintf("%d:%s", result.Head.Seq, result.Head.Hash)
+	output := runCommand(t, "", "audit", "verify", "-file", file, "-head", head)
+	assert.Equal(t, "1 records verified, head "+head+"\n", output)
+
+	var stdout bytes.Buffer
+	assert.Error(t, run([]string{"audit", "verify", "-file", file, "-head", "2:" + result.Head.Hash}, strings.NewReader(""), &stdout, io.Discard))
+	assert.Error(t, run([]string{"audit", "verify", "-file", file, "-head", "head"}, strings.NewReader(""), &stdout, io.Discard))
+	assert.Error(t, run([]string{"audit", "verify"}, strings.NewReader(""), &stdout, io.Discard))
+
+	// The head file is checked by default, so an emptied log does not verify
+	require.Nil(t, os.WriteFile(file, nil, 0o600))
+	assert.Error(t, run([]string{"audit", "verify", "-file", file}, strings.NewReader(""), &stdout, io.Discard))
+	output = runCommand(t, "", "audit", "verify", "-file", file, "-no-head-file")
+	assert.Equal(t, "0 records verified, head 0:\n", output)
+	assert.Error(t, run([]string{"audit", "verify", "-file", file, "-no-head-file", "-head-file", file + ".head"}, strings.NewReader(""), &stdout, io.Discard))
+}
+
+func Test_ValidatorPubKey(t *testing.T) {
+	_, validator := withFakeApps(t)
+	pubKey := ledger.ConsensusPubKey(validator.key.Public().(ed25519.PublicKey))
+	consAddress, err := pubKey.Bech32Address()
+	require.Nil(t, err)
+
+	var result validatorPubKeyResult
+	require.Nil(t, json.Unmarshal([]byte(runCommand(t, "", "-json", "validator", "pubkey")), &result))
+	assert.Equal(t, "m/44'/118'/0'/0'/0'", result.Path)
+	assert.Equal(t, consAddress, result.ConsAddress)
+	assert.Equal(t, pubKey.AminoPubKey(), result.AminoPubKey)
+}
+
+func Test_Run_Errors(t *testing.T) {
+	withFakeApps(t)
+
+	invalid := [][]string{
+		{},
+		{"unknown"},
+		{"-network", "devnet", "version"},
+		{"device"},
+		{"validator", "address"},
+		{"pubkey", "-path", "m/44/931/0/0/0"},
+		{"validator", "pubkey", "-path", "m/44'/118'/0'/0/0"},
+		{"sign", "-mode", "direct"},
+		{"version", "extra"},
+	}
+	for _, args := range invalid {
+		var stdout bytes.Buffer
+		assert.Error(t, run(args, strings.NewReader(""), &stdout, io.Discard), strings.Join(args, " "))
+	}
+}
+
+func Test_Bundle(t *testing.T) {
+	user, _ := withFakeApps(t)
+	dir := t.TempDir()
+	pubKey := hex.EncodeToString(user.key.PubKey().SerializeCompressed())
+	address, err := ledger.AddressFromPubKey(ledger.Mainnet.AccountHRP, user.key.PubKey().SerializeCompressed())
+	require.Nil(t, err)
+	signDoc := strings.Replace(depositSignDoc, `"signer":"thor1a"`, `"signer":"`+address+`"`, 1)
+
+	unsigned, signed := dir+"/unsigned.json", dir+"/signed.json"
+	runCommand(t, signDoc, "bundle", "create", "-pubkey", pubKey, "-out", unsigned)
+
+	var result bundleResult
+	require.Nil(t, json.Unmarshal([]byte(runCommand(t, "", "-json", "bundle", "sign", "-in", unsigned, "-out", signed)), &result))
+	assert.True(t, result.Signed)
+	assert.Equal(t, []byte(signDoc), user.signed)
+
+	var request struct {
+		TxBytes []byte `json:"tx_bytes"`
+		Mode    string `json:"mode"`
+	}
+	require.Nil(t, json.Unmarshal([]byte(runCommand(t, "", "bundle", "assemble", "-in", signed)), &request))
+	assert.NotEmpty(t, request.TxBytes)
+	assert.Equal(t, "BROADCAST_MODE_SYNC", request.Mode)
+
+	// An unsigned bundle cannot be assembled
+	var stdout bytes.Buffer
+	assert.Error(t, run([]string{"bundle", "assemble", "-in", unsigned}, strings.NewReader(""), &stdout, io.Discard))
+}
+
+func Test_Multisig(t *testing.T) {
+	user, _ := withFakeApps(t)
+	dir := t.TempDir()
+	otherKey, err := btcec.NewPrivateKey()
+	require.Nil(t, err)
+	pubKeys := [][]byte{user.key.PubKey().SerializeCompressed(), otherKey.PubKey().SerializeCompressed()}
+	pubKeysFlag := hex.EncodeToString(pubKeys[0]) + "," + hex.EncodeToString(pubKeys[1])
+	signDoc := `{"account_number":"1","chain_id":"thorchain-1","fee":{"amount":[],"gas":"1"},"memo":"","msgs":[],"sequence":"0"}`
+
+	key, err := ledger.NewMultisigPubKey(2, ledger.SortMultisigPubKeys(pubKeys))
+	require.Nil(t, err)
+	addr, err := key.Bech32Address("thor")
+	require.Nil(t, err)
+
+	var address multisigAddressResult
+	out := runCommand(t, "", "-json", "multisig", "address", "-threshold", "2", "-pubkeys", pubKeysFlag, "-sort")
+	require.Nil(t, json.Unmarshal([]byte(out), &address))
+	assert.Equal(t, addr, address.Address)
+	assert.Equal(t, key, address.PubKey)
+
+	// One partial signature comes from the device, the other from another member
+	partialFile := filepath.Join(dir, "partial.json")
+	runCommand(t, signDoc, "multisig", "sign", "-threshold", "2", "-pubkeys", pubKeysFlag, "-sort", "-out", partialFile)
+	assert.Equal(t, []byte(signDoc), user.signed)
+	assert.Equal(t, byte(ledger.SignModeLegacyAmino), user.signMode)
+
+	other, err := ledger.SignMultisigPartial(&fakeUserApp{key: otherKey}, []uint32{44, 931, 0, 0, 0}, key, []byte(signDoc))
+	require.Nil(t, err)
+	otherData, err := json.Marshal(other)
+	require.Nil(t, err)
+	otherFile := filepath.Join(dir, "other.json")
+	require.Nil(t, os.WriteFile(otherFile, otherData, 0o644))
+
+	var combined multisigCombineResult
+	out = runCommand(t, signDoc, "-json", "multisig", "combine", "-threshold", "2", "-pubkeys", pubKeysFlag, "-sort",
+		"-partials", partialFile+","+otherFile)
+	require.Nil(t, json.Unmarshal([]byte(out), &combined))
+	assert.Equal(t, addr, combined.Address)
+	assert.Equal(t, 2, combined.Signers)
+
+	sigBytes, err := base64.StdEncoding.DecodeString(combined.Signature)
+	require.Nil(t, err)
+	sig, err := ledger.DecodeMultiSignature(sigBytes)
+	require.Nil(t, err)
+	assert.Nil(t, key.Verify([]byte(signDoc), sig))
+
+	// The threshold is not reached with one signature
+	var stdout bytes.Buffer
+	err = run([]string{"multisig", "combine", "-threshold", "2", "-pubkeys", pubKeysFlag, "-sort", "-partials", partialFile},
+		strings.NewReader(signDoc), &stdout, io.Discard)
+	assert.EqualError(t, err, "1 signatures, the threshold is 2")
+}
+
+func Test_Daemon(t *testing.T) {
+	withFakeApps(t)
+	dir := t.TempDir()
+	listener, err := net.Listen("unix", filepath.Join(dir, "node.sock"))
+	require.Nil(t, err)
+	defer listener.Close()
+
+	stateFile := filepath.Join(dir, "thorchain-1.json")
+	configFile := filepath.Join(dir, "daemon.toml")
+	config := fmt.Sprintf(`log_format = "text"
+
+[[chains]]
+chain_id = "thorchain-1"
+address = "unix://%s"
+path = [44, 118, 0, 0, 0]
+state_file = %q
+`, listener.Addr().String(), stateFile)
+	require.Nil(t, os.WriteFile(configFile, []byte(config), 0600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	prevContext := daemonContext
+	daemonContext = func() (context.Context, context.CancelFunc) { return ctx, cancel }
+	t.Cleanup(func() { daemonContext = prevContext })
+
+	var stderr bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- run([]string{"daemon", "-config", configFile}, strings.NewReader(""), io.Discard, &stderr)
+	}()
+
+	// The daemon connects to the node, and stops without error when interrupted
+	conn, err := listener.Accept()
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	conn.Close()
+	cancel()
+	assert.Nil(t, <-done)
+	assert.FileExists(t, stateFile)
+	assert.Contains(t, stderr.String(), "thorchain-1")
+
+	assert.Error(t, run([]string{"daemon"}, strings.NewReader(""), io.Discard, io.Discard))
+	assert.Error(t, run([]string{"daemon", "-config", filepath.Join(dir, "missing.toml")}, strings.NewReader(""), io.Discard, io.Discard))
+}
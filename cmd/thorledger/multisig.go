@@ -0,0 +1,234 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+func (c *cli) multisig(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: multisig address|sign|combine")
+	}
+
+	switch command, rest := args[0], args[1:]; command {
+	case "address":
+		return c.multisigAddress(rest)
+	case "sign":
+		return c.multisigSign(rest)
+	case "combine":
+		return c.multisigCombine(rest)
+	default:
+		return fmt.Errorf("unknown multisig command %q", command)
+	}
+}
+
+// multisigKeyFlags are the flags describing the multisig key, shared by the multisig commands
+type multisigKeyFlags struct {
+	threshold *int
+	pubKeys   *string
+	sort      *bool
+}
+
+func addMultisigKeyFlags(fs *flag.FlagSet) multisigKeyFlags {
+	return multisigKeyFlags{
+		threshold: fs.Int("threshold", 0, "number of signatures required"),
+		pubKeys:   fs.String("pubkeys", "", "comma separated hex encoded public keys of the members"),
+		sort:      fs.Bool("sort", false, "sort the keys by address, like keys add --multisig"),
+	}
+}
+
+func (f multisigKeyFlags) key() (*ledger.MultisigPubKey, error) {
+	if *f.pubKeys == "" {
+		return nil, errors.New("-pubkeys is required")
+	}
+
+	var pubKeys [][]byte
+	for _, s := range strings.Split(*f.pubKeys, ",") {
+		pubKey, err := hex.DecodeString(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %q: expected hex", s)
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+	if *f.sort {
+		pubKeys = ledger.SortMultisigPubKeys(pubKeys)
+	}
+	return ledger.NewMultisigPubKey(*f.threshold, pubKeys)
+}
+
+type multisigAddressResult struct {
+	Address string                 `json:"address"`
+	PubKey  *ledger.MultisigPubKey `json:"pub_key"`
+}
+
+// multisigAddress does not need the device
+func (c *cli) multisigAddress(args []string) error {
+	fs := c.newFlagSet("multisig address")
+	keyFlags := addMultisigKeyFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	key, err := keyFlags.key()
+	if err != nil {
+		return err
+	}
+	addr, err := key.Bech32Address(c.network.AccountHRP)
+	if err != nil {
+		return err
+	}
+	aminoJSON, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+
+	result := multisigAddressResult{Address: addr, PubKey: key}
+	return c.output(result, addr, string(aminoJSON))
+}
+
+type multisigSignResult struct {
+	File    string `json:"file"`
+	Address string `json:"address"`
+	PubKey  string `json:"pubkey"`
+}
+
+// multisigSign signs the sign doc of the multisig with the key of one member
+func (c *cli) multisigSign(args []string) error {
+	fs := c.newFlagSet("multisig sign")
+	keyFlags := addMultisigKeyFlags(fs)
+	pathFlag := fs.String("path", defaultUserPath, "bip32 path of the member key")
+	file := fs.String("file", "-", "file containing the amino JSON sign doc, - for stdin")
+	out := fs.String("out", "", "file the partial signature is written to")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return errors.New("-out is required")
+	}
+
+	key, err := keyFlags.key()
+	if err != nil {
+		return err
+	}
+	path, err := ledger.ParseBip32Path(*pathFlag, userHardenCount)
+	if err != nil {
+		return err
+	}
+	signDoc, err := c.readInput(*file)
+	if err != nil {
+		return err
+	}
+	addr, err := key.Bech32Address(c.network.AccountHRP)
+	if err != nil {
+		return err
+	}
+
+	app, err := openUserApp()
+	if err != nil {
+		return err
+	}
+	defer app.Close()
+
+	fmt.Fprintf(c.stderr, "Signing for the multisig %s\n", addr)
+	fmt.Fprintln(c.stderr, "Please review and approve the transaction on the device")
+	partial, err := ledger.SignMultisigPartial(app, path, key, signDoc)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(partial, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*out, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+
+	result := multisigSignResult{File: *out, Address: addr, PubKey: hex.EncodeToString(partial.PubKey)}
+	return c.output(result, "partial signature written to "+*out)
+}
+
+type multisigCombineResult struct {
+	Address   string `json:"address"`
+	Signers   int    `json:"signers"`
+	Signature string `json:"signature"`
+}
+
+// multisigCombine does not need the device: it checks the partial signatures and assembles them
+func (c *cli) multisigCombine(args []string) error {
+	fs := c.newFlagSet("multisig combine")
+	keyFlags := addMultisigKeyFlags(fs)
+	file := fs.String("file", "-", "file containing the amino JSON sign doc, - for stdin")
+	partialFiles := fs.String("partials", "", "comma separated files written by multisig sign")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *partialFiles == "" {
+		return errors.New("-partials is required")
+	}
+
+	key, err := keyFlags.key()
+	if err != nil {
+		return err
+	}
+	signDoc, err := c.readInput(*file)
+	if err != nil {
+		return err
+	}
+
+	var partials []ledger.PartialSignature
+	for _, name := range strings.Split(*partialFiles, ",") {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		var partial ledger.PartialSignature
+		if err := json.Unmarshal(data, &partial); err != nil {
+			return fmt.Errorf("invalid partial signature %s: %w", name, err)
+		}
+		partials = append(partials, partial)
+	}
+
+	sig, err := key.Combine(signDoc, partials)
+	if err != nil {
+		return err
+	}
+	if err := key.Verify(signDoc, sig); err != nil {
+		return err
+	}
+	addr, err := key.Bech32Address(c.network.AccountHRP)
+	if err != nil {
+		return err
+	}
+
+	result := multisigCombineResult{
+		Address:   addr,
+		Signers:   len(sig.Signatures),
+		Signature: base64.StdEncoding.EncodeToString(sig.Bytes()),
+	}
+	return c.output(result, result.Signature)
+}
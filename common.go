@@ -19,6 +19,8 @@ package ledger_thorchain_go
 import (
 	"encoding/binary"
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 // VersionInfo contains app version information
@@ -29,10 +31,57 @@ type VersionInfo struct {
 	Patch   uint8
 }
 
+// AppModeDebug is the AppMode reported by apps built in debug/testing mode
+const AppModeDebug = 0xFF
+
 func (c VersionInfo) String() string {
 	return fmt.Sprintf("%d.%d.%d", c.Major, c.Minor, c.Patch)
 }
 
+// ParseVersion parses a "major.minor.patch" string, optionally prefixed with "v"
+func ParseVersion(s string) (VersionInfo, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "v"), ".")
+	if len(parts) != 3 {
+		return VersionInfo{}, fmt.Errorf("invalid version %q: expected major.minor.patch", s)
+	}
+
+	var numbers [3]uint8
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 8)
+		if err != nil {
+			return VersionInfo{}, fmt.Errorf("invalid version %q: %w", s, err)
+		}
+		numbers[i] = uint8(n)
+	}
+
+	return VersionInfo{0, numbers[0], numbers[1], numbers[2]}, nil
+}
+
+// Compare returns -1, 0 or 1 if the version is lower, equal or greater than other. AppMode is ignored.
+func (c VersionInfo) Compare(other VersionInfo) int {
+	a := []uint8{c.Major, c.Minor, c.Patch}
+	b := []uint8{other.Major, other.Minor, other.Patch}
+	for i := range a {
+		if a[i] < b[i] {
+			return -1
+		}
+		if a[i] > b[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// AtLeast returns true if the version is equal or greater than other
+func (c VersionInfo) AtLeast(other VersionInfo) bool {
+	return c.Compare(other) >= 0
+}
+
+// IsDebug returns true if the app was built in debug mode
+func (c VersionInfo) IsDebug() bool {
+	return c.AppMode == AppModeDebug
+}
+
 // VersionRequiredError the command is not supported by this app
 type VersionRequiredError struct {
 	Found    VersionInfo
@@ -52,24 +101,37 @@ func NewVersionRequiredError(req VersionInfo, ver VersionInfo) error {
 
 // CheckVersion compares the current version with the required version
 func CheckVersion(ver VersionInfo, req VersionInfo) error {
-	if ver.Major != req.Major {
-		if ver.Major > req.Major {
-			return nil
-		}
-		return NewVersionRequiredError(req, ver)
+	if ver.AtLeast(req) {
+		return nil
 	}
+	return NewVersionRequiredError(req, ver)
+}
 
-	if ver.Minor != req.Minor {
-		if ver.Minor > req.Minor {
-			return nil
-		}
-		return NewVersionRequiredError(req, ver)
-	}
+// DebugAppPolicy decides whether apps built in debug mode are accepted
+type DebugAppPolicy uint8
 
-	if ver.Patch >= req.Patch {
-		return nil
+const (
+	// AllowDebugApp accepts debug builds. This is the default, as required by emulators and tests
+	AllowDebugApp DebugAppPolicy = iota
+	// RefuseDebugApp rejects debug builds. Production code should use it
+	RefuseDebugApp
+)
+
+// DebugAppError a debug build of the app was found and the policy refuses it
+type DebugAppError struct {
+	Found VersionInfo
+}
+
+func (e DebugAppError) Error() string {
+	return fmt.Sprintf("App version %s is a debug build and debug apps are not allowed", e.Found)
+}
+
+// Check applies the policy to a version
+func (p DebugAppPolicy) Check(ver VersionInfo) error {
+	if p == RefuseDebugApp && ver.IsDebug() {
+		return &DebugAppError{ver}
 	}
-	return NewVersionRequiredError(req, ver)
+	return nil
 }
 
 func GetBip32bytesv1(bip32Path []uint32, hardenCount int) ([]byte, error) {
@@ -104,3 +166,45 @@ func GetBip32bytesv2(bip44Path []uint32, hardenCount int) ([]byte, error) {
 	}
 	return message, nil
 }
+
+// ParseBip32Path parses a path such as m/44'/931'/0'/0/0. Hardened elements are marked with ' or h.
+// The first hardenCount elements must be hardened and the others must not, because the apps
+// harden them when the path is encoded. The returned elements do not have the hardened bit set
+func ParseBip32Path(s string, hardenCount int) ([]uint32, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(s), "m"), "/")
+	if s == "" {
+		return nil, fmt.Errorf("empty bip32 path")
+	}
+
+	var path []uint32
+	for index, element := range strings.Split(s, "/") {
+		hardened := strings.HasSuffix(element, "'") || strings.HasSuffix(element, "h") || strings.HasSuffix(element, "H")
+		if hardened {
+			element = element[:len(element)-1]
+		}
+
+		value, err := strconv.ParseUint(element, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bip32 path element %q", element)
+		}
+		if hardened != (index < hardenCount) {
+			return nil, fmt.Errorf("the first %d elements of the path must be hardened, and only them", hardenCount)
+		}
+		path = append(path, uint32(value))
+	}
+	return path, nil
+}
+
+// FormatBip32Path formats a path in the notation accepted by ParseBip32Path
+func FormatBip32Path(bip32Path []uint32, hardenCount int) string {
+	var sb strings.Builder
+	sb.WriteString("m")
+	for index, element := range bip32Path {
+		sb.WriteString("/")
+		sb.WriteString(strconv.FormatUint(uint64(element), 10))
+		if index < hardenCount {
+			sb.WriteString("'")
+		}
+	}
+	return sb.String()
+}
@@ -19,6 +19,7 @@ package ledger_thorchain_go
 import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"testing"
 )
 
@@ -171,3 +172,60 @@ func Test_PathGeneration3v2(t *testing.T) {
 		fmt.Sprintf("%x", pathBytes),
 		"Unexpected PathBytes\n")
 }
+
+func Test_ParseVersion(t *testing.T) {
+	ver, err := ParseVersion("2.1.0")
+	require.Nil(t, err)
+	assert.Equal(t, VersionInfo{0, 2, 1, 0}, ver)
+
+	ver, err = ParseVersion("v1.5.13")
+	require.Nil(t, err)
+	assert.Equal(t, VersionInfo{0, 1, 5, 13}, ver)
+
+	for _, s := range []string{"", "2.1", "2.1.0.4", "2.x.0", "2.1.256"} {
+		_, err = ParseVersion(s)
+		assert.Error(t, err, "version %q should not parse", s)
+	}
+}
+
+func Test_VersionCompare(t *testing.T) {
+	v := VersionInfo{0, 2, 1, 0}
+
+	assert.Equal(t, 0, v.Compare(VersionInfo{AppModeDebug, 2, 1, 0}))
+	assert.Equal(t, 1, v.Compare(VersionInfo{0, 1, 9, 9}))
+	assert.Equal(t, 1, v.Compare(VersionInfo{0, 2, 0, 9}))
+	assert.Equal(t, -1, v.Compare(VersionInfo{0, 2, 1, 1}))
+	assert.Equal(t, -1, v.Compare(VersionInfo{0, 3, 0, 0}))
+
+	assert.True(t, v.AtLeast(VersionInfo{0, 2, 1, 0}))
+	assert.False(t, v.AtLeast(VersionInfo{0, 2, 2, 0}))
+
+	assert.Nil(t, CheckVersion(v, VersionInfo{0, 2, 0, 0}))
+	assert.Equal(t, NewVersionRequiredError(VersionInfo{0, 2, 2, 0}, v), CheckVersion(v, VersionInfo{0, 2, 2, 0}))
+}
+
+func Test_DebugAppPolicy(t *testing.T) {
+	debug := VersionInfo{AppModeDebug, 2, 1, 0}
+	assert.True(t, debug.IsDebug())
+
+	assert.Nil(t, AllowDebugApp.Check(debug))
+	assert.Nil(t, RefuseDebugApp.Check(VersionInfo{0, 2, 1, 0}))
+	assert.Equal(t, &DebugAppError{debug}, RefuseDebugApp.Check(debug))
+}
+
+func Test_ParseBip32Path(t *testing.T) {
+	path, err := ParseBip32Path("m/44'/931'/0'/0/7", 3)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, []uint32{44, 931, 0, 0, 7}, path)
+	assert.Equal(t, "m/44'/931'/0'/0/7", FormatBip32Path(path, 3))
+
+	path, err = ParseBip32Path("44h/118h/0h/0h/0h", 5)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, []uint32{44, 118, 0, 0, 0}, path)
+
+	invalid := []string{"", "m/", "m/44'/931'/0/0/0", "m/44'/931'/0'/0'/0", "m/44'/x'/0'/0/0", "m/44'/2147483648'/0'/0/0"}
+	for _, s := range invalid {
+		_, err := ParseBip32Path(s, 3)
+		assert.Error(t, err, s)
+	}
+}
@@ -0,0 +1,219 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zondax/ledger-go"
+)
+
+const (
+	dashboardCLA = 0xB0
+	openAppCLA   = 0xE0
+
+	dashboardINSGetAppAndVersion = 0x01
+	dashboardINSQuitApp          = 0xA7
+	openAppINS                   = 0xD8
+
+	// DashboardAppName is the name reported when no app is open
+	DashboardAppName = "BOLOS"
+	// THORChainAppName is the name of the THORChain user app
+	THORChainAppName = "THORChain"
+
+	appLaunchTimeout  = 30 * time.Second
+	appLaunchInterval = 500 * time.Millisecond
+)
+
+// AppInfo contains the name and version of the app currently running in the device
+type AppInfo struct {
+	Name    string
+	Version string
+	Flags   []byte
+}
+
+// IsDashboard returns true if no app is open
+func (info AppInfo) IsDashboard() bool {
+	return info.Name == DashboardAppName
+}
+
+// WrongAppError the device is connected but a different app is running
+type WrongAppError struct {
+	Expected string
+	Running  string
+}
+
+func (e WrongAppError) Error() string {
+	if e.Running == DashboardAppName {
+		return fmt.Sprintf("the %s app is not open: the device is in the dashboard", e.Expected)
+	}
+	return fmt.Sprintf("the %s app is not open: %s app is running", e.Expected, e.Running)
+}
+
+// GetAppAndVersion returns the app currently running in the device. It works for any app and the dashboard
+func GetAppAndVersion(device ledger_go.LedgerDevice) (*AppInfo, error) {
+	message := []byte{dashboardCLA, dashboardINSGetAppAndVersion, 0, 0, 0}
+	response, err := device.Exchange(message)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response) < 1 || response[0] != 1 {
+		return nil, errors.New("invalid response: unknown format")
+	}
+
+	fields, err := splitLengthPrefixed(response[1:], 3)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) < 2 {
+		return nil, errors.New("invalid response. Too short")
+	}
+
+	info := &AppInfo{
+		Name:    string(fields[0]),
+		Version: string(fields[1]),
+	}
+	if len(fields) > 2 {
+		info.Flags = fields[2]
+	}
+
+	return info, nil
+}
+
+// splitLengthPrefixed splits up to max fields, each prefixed by a one byte length
+func splitLengthPrefixed(data []byte, max int) ([][]byte, error) {
+	fields := make([][]byte, 0, max)
+	for len(fields) < max && len(data) > 0 {
+		size := int(data[0])
+		if len(data) < 1+size {
+			return nil, errors.New("invalid response. Too short")
+		}
+		fields = append(fields, data[1:1+size])
+		data = data[1+size:]
+	}
+	return fields, nil
+}
+
+// OpenApp asks the dashboard to launch an app by name.
+// this command requires user confirmation in the device
+func OpenApp(device ledger_go.LedgerDevice, name string) error {
+	if len(name) == 0 || len(name) > 255 {
+		return errors.New("app name should contain between 1 and 255 characters")
+	}
+
+	header := []byte{openAppCLA, openAppINS, 0, 0, byte(len(name))}
+	message := append(header, []byte(name)...)
+
+	_, err := device.Exchange(message)
+	if err != nil {
+		switch err.Error() {
+		case "Error code: 6807":
+			return fmt.Errorf("the %s app is not installed", name)
+		case "Error code: 5501":
+			return fmt.Errorf("opening the %s app was rejected in the device", name)
+		}
+		return err
+	}
+	return nil
+}
+
+// QuitApp closes the running app and goes back to the dashboard
+func QuitApp(device ledger_go.LedgerDevice) error {
+	message := []byte{dashboardCLA, dashboardINSQuitApp, 0, 0, 0}
+	_, err := device.Exchange(message)
+	return err
+}
+
+// GetAppInfo returns the app currently running in the device
+func (ledger *LedgerTHORChain) GetAppInfo() (*AppInfo, error) {
+	return GetAppAndVersion(ledger.api)
+}
+
+// wrongAppError explains a failed GetVersion by looking at the app that is running
+func wrongAppError(device ledger_go.LedgerDevice, expected string, err error) error {
+	info, infoErr := GetAppAndVersion(device)
+	if infoErr != nil || info.Name == expected {
+		return err
+	}
+	return &WrongAppError{Expected: expected, Running: info.Name}
+}
+
+// OpenLedgerTHORChainUserApp finds a THORChain user app, launching it from the dashboard if needed.
+// If a different app is running it is closed first.
+// this command requires user confirmation in the device when the app has to be launched
+func OpenLedgerTHORChainUserApp() (*LedgerTHORChain, error) {
+	ledgerAdmin := ledger_go.NewLedgerAdmin()
+	ledgerAPI, err := ledgerAdmin.Connect(0)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := GetAppAndVersion(ledgerAPI)
+	if err != nil {
+		ledgerAPI.Close()
+		return nil, err
+	}
+
+	if info.Name != THORChainAppName {
+		if !info.IsDashboard() {
+			// The device goes back to the dashboard and re-enumerates
+			_ = QuitApp(ledgerAPI)
+			ledgerAPI.Close()
+			ledgerAPI, err = reconnectLedger(ledgerAdmin)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err = OpenApp(ledgerAPI, THORChainAppName)
+		ledgerAPI.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		ledgerAPI.Close()
+	}
+
+	deadline := time.Now().Add(appLaunchTimeout)
+	for {
+		app, err := FindLedgerTHORChainUserApp()
+		if err == nil || time.Now().After(deadline) {
+			return app, err
+		}
+		time.Sleep(appLaunchInterval)
+	}
+}
+
+func reconnectLedger(ledgerAdmin ledger_go.LedgerAdmin) (ledger_go.LedgerDevice, error) {
+	deadline := time.Now().Add(appLaunchTimeout)
+	for {
+		ledgerAPI, err := ledgerAdmin.Connect(0)
+		if err == nil {
+			if _, err = GetAppAndVersion(ledgerAPI); err == nil {
+				return ledgerAPI, nil
+			}
+			ledgerAPI.Close()
+		}
+		if time.Now().After(deadline) {
+			return nil, err
+		}
+		time.Sleep(appLaunchInterval)
+	}
+}
@@ -0,0 +1,84 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func appAndVersionResponse(name string, version string) []byte {
+	response := []byte{1, byte(len(name))}
+	response = append(response, name...)
+	response = append(response, byte(len(version)))
+	response = append(response, version...)
+	return append(response, 1, 0x02)
+}
+
+func Test_GetAppAndVersion(t *testing.T) {
+	device := newMockDevice().reply(dashboardCLA, dashboardINSGetAppAndVersion, appAndVersionResponse("THORChain", "2.1.0"), nil)
+
+	info, err := GetAppAndVersion(device)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, &AppInfo{Name: "THORChain", Version: "2.1.0", Flags: []byte{0x02}}, info)
+	assert.False(t, info.IsDashboard())
+	assert.Equal(t, []byte{0xB0, 0x01, 0, 0, 0}, device.sent[0])
+
+	device.reply(dashboardCLA, dashboardINSGetAppAndVersion, []byte{1, 5, 'B', 'O'}, nil)
+	_, err = GetAppAndVersion(device)
+	assert.EqualError(t, err, "invalid response. Too short")
+
+	device.reply(dashboardCLA, dashboardINSGetAppAndVersion, []byte{2, 0}, nil)
+	_, err = GetAppAndVersion(device)
+	assert.Error(t, err)
+}
+
+func Test_OpenApp(t *testing.T) {
+	device := newMockDevice().reply(openAppCLA, openAppINS, nil, nil)
+	require.Nil(t, OpenApp(device, THORChainAppName))
+	assert.Equal(t, append([]byte{0xE0, 0xD8, 0, 0, 9}, "THORChain"...), device.sent[0])
+
+	device.reply(openAppCLA, openAppINS, nil, errors.New("Error code: 6807"))
+	assert.EqualError(t, OpenApp(device, THORChainAppName), "the THORChain app is not installed")
+
+	assert.Error(t, OpenApp(device, ""))
+}
+
+func Test_QuitApp(t *testing.T) {
+	device := newMockDevice().reply(dashboardCLA, dashboardINSQuitApp, nil, nil)
+	require.Nil(t, QuitApp(device))
+	assert.Equal(t, []byte{0xB0, 0xA7, 0, 0, 0}, device.sent[0])
+}
+
+func Test_WrongAppError(t *testing.T) {
+	versionErr := errors.New("[APDU_CODE_CLA_NOT_SUPPORTED] CLA not supported")
+
+	device := newMockDevice().reply(dashboardCLA, dashboardINSGetAppAndVersion, appAndVersionResponse("Bitcoin", "2.1.3"), nil)
+	err := wrongAppError(device, THORChainAppName, versionErr)
+	assert.Equal(t, &WrongAppError{Expected: "THORChain", Running: "Bitcoin"}, err)
+	assert.EqualError(t, err, "the THORChain app is not open: Bitcoin app is running")
+
+	device.reply(dashboardCLA, dashboardINSGetAppAndVersion, appAndVersionResponse(DashboardAppName, "2.1.0"), nil)
+	err = wrongAppError(device, THORChainAppName, versionErr)
+	assert.EqualError(t, err, "the THORChain app is not open: the device is in the dashboard")
+
+	device.reply(dashboardCLA, dashboardINSGetAppAndVersion, appAndVersionResponse(THORChainAppName, "2.1.0"), nil)
+	assert.Equal(t, versionErr, wrongAppError(device, THORChainAppName, versionErr))
+}
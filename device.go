@@ -0,0 +1,216 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/zondax/hid"
+	"github.com/zondax/ledger-go"
+)
+
+const (
+	dashboardINSGetVersion = 0x01
+)
+
+// Model is the Ledger hardware model
+type Model uint8
+
+const (
+	ModelUnknown Model = iota
+	ModelNanoS
+	ModelNanoX
+	ModelNanoSPlus
+	ModelStax
+	ModelFlex
+)
+
+func (m Model) String() string {
+	switch m {
+	case ModelNanoS:
+		return "Nano S"
+	case ModelNanoX:
+		return "Nano X"
+	case ModelNanoSPlus:
+		return "Nano S Plus"
+	case ModelStax:
+		return "Stax"
+	case ModelFlex:
+		return "Flex"
+	default:
+		return "unknown"
+	}
+}
+
+// HasTouchscreen returns true if confirmations are given by tapping the screen instead of pressing buttons
+func (m Model) HasTouchscreen() bool {
+	return m == ModelStax || m == ModelFlex
+}
+
+// ConfirmationHint returns a short text telling the user how to approve a request in the device
+func (m Model) ConfirmationHint() string {
+	if m.HasTouchscreen() {
+		return fmt.Sprintf("Review the request on your Ledger %s and tap to confirm", m)
+	}
+	if m == ModelUnknown {
+		return "Review the request on your Ledger and confirm it"
+	}
+	return fmt.Sprintf("Review the request on your Ledger %s and press both buttons to confirm", m)
+}
+
+// SignDocLimits returns the sign doc budgets of the user app running on this model.
+// The Nano S has less memory available for the transaction buffer.
+func (m Model) SignDocLimits() SignDocLimits {
+	limits := DefaultSignDocLimits()
+	if m == ModelNanoS {
+		limits.MaxSize = 8192
+	}
+	return limits
+}
+
+// ModelFromProductID returns the model for a USB product id. Both the legacy ids and
+// the ones including an interface mask in the lower byte are recognized
+func ModelFromProductID(productID uint16) Model {
+	id := productID >> 8
+	if productID < 0x100 {
+		id = productID
+	}
+
+	switch id {
+	case 0x01, 0x10:
+		return ModelNanoS
+	case 0x04, 0x40:
+		return ModelNanoX
+	case 0x05, 0x50:
+		return ModelNanoSPlus
+	case 0x06, 0x60:
+		return ModelStax
+	case 0x07, 0x70:
+		return ModelFlex
+	default:
+		return ModelUnknown
+	}
+}
+
+// ModelFromTargetID returns the model for the target id reported by the dashboard
+func ModelFromTargetID(targetID uint32) Model {
+	switch targetID {
+	case 0x31100002, 0x31100003, 0x31100004:
+		return ModelNanoS
+	case 0x33000004:
+		return ModelNanoX
+	case 0x33100004:
+		return ModelNanoSPlus
+	case 0x33200004:
+		return ModelStax
+	case 0x33300004:
+		return ModelFlex
+	default:
+		return ModelUnknown
+	}
+}
+
+// DeviceInfo describes a Ledger device. Firmware fields are only available when
+// the information was read from the dashboard
+type DeviceInfo struct {
+	Model      Model
+	ProductID  uint16
+	Path       string
+	TargetID   uint32
+	SEVersion  string
+	MCUVersion string
+	Flags      []byte
+}
+
+// ListDevices returns the Ledger devices attached over USB HID, in the same order used by Connect
+func ListDevices() []DeviceInfo {
+	var devices []DeviceInfo
+	for _, d := range hid.Enumerate(ledger_go.VendorLedger, 0) {
+		if !isLedgerHID(d) {
+			continue
+		}
+		devices = append(devices, DeviceInfo{
+			Model:     ModelFromProductID(d.ProductID),
+			ProductID: d.ProductID,
+			Path:      d.Path,
+		})
+	}
+	return devices
+}
+
+// isLedgerHID follows the device selection of ledger-go
+func isLedgerHID(d hid.DeviceInfo) bool {
+	if d.UsagePage == ledger_go.UsagePageLedgerNanoS {
+		return true
+	}
+	switch d.ProductID {
+	case 0x4011, 0x1011, 0x1, 0x5011, 0x5:
+		return d.Interface == 0
+	}
+	return false
+}
+
+// GetDeviceVersion reads the target id and the firmware versions. It only works while the device is in the dashboard
+func GetDeviceVersion(device ledger_go.LedgerDevice) (*DeviceInfo, error) {
+	message := []byte{openAppCLA, dashboardINSGetVersion, 0, 0, 0}
+	response, err := device.Exchange(message)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response) < 5 {
+		return nil, errors.New("invalid response. Too short")
+	}
+
+	info := &DeviceInfo{TargetID: binary.BigEndian.Uint32(response[0:4])}
+	info.Model = ModelFromTargetID(info.TargetID)
+
+	fields, err := splitLengthPrefixed(response[4:], 3)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fields) > 0 {
+		info.SEVersion = string(fields[0])
+	}
+	if len(fields) > 1 {
+		info.Flags = fields[1]
+	}
+	if len(fields) > 2 {
+		// The MCU version may be zero terminated
+		info.MCUVersion = strings.TrimRight(string(fields[2]), "\x00")
+	}
+
+	return info, nil
+}
+
+// DeviceInfo returns the model of the connected device
+func (ledger *LedgerTHORChain) DeviceInfo() DeviceInfo {
+	return ledger.device
+}
+
+// detectDevice identifies the device connected at a given index
+func detectDevice(deviceIndex int) DeviceInfo {
+	devices := ListDevices()
+	if deviceIndex < len(devices) {
+		return devices[deviceIndex]
+	}
+	return DeviceInfo{}
+}
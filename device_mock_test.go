@@ -0,0 +1,69 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"errors"
+	"fmt"
+)
+
+// mockReply is the answer of the mock device to a command
+type mockReply struct {
+	response []byte
+	err      error
+}
+
+// mockDevice is a ledger_go.LedgerDevice that answers by CLA/INS and records every command
+type mockDevice struct {
+	replies map[[2]byte]mockReply
+	handler func(command []byte) ([]byte, error)
+	sent    [][]byte
+	closed  bool
+}
+
+func newMockDevice() *mockDevice {
+	return &mockDevice{replies: map[[2]byte]mockReply{}}
+}
+
+func (d *mockDevice) reply(cla byte, ins byte, response []byte, err error) *mockDevice {
+	d.replies[[2]byte{cla, ins}] = mockReply{response, err}
+	return d
+}
+
+func (d *mockDevice) Exchange(command []byte) ([]byte, error) {
+	if len(command) < 5 || int(command[4]) != len(command)-5 {
+		return nil, errors.New("APDU[data length] mismatch")
+	}
+	d.sent = append(d.sent, append([]byte{}, command...))
+
+	if d.handler != nil {
+		return d.handler(command)
+	}
+	r, ok := d.replies[[2]byte{command[0], command[1]}]
+	if !ok {
+		return nil, errors.New("[APDU_CODE_CLA_NOT_SUPPORTED] CLA not supported")
+	}
+	return r.response, r.err
+}
+
+func (d *mockDevice) Close() error {
+	if d.closed {
+		return fmt.Errorf("already closed")
+	}
+	d.closed = true
+	return nil
+}
@@ -0,0 +1,66 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ModelFromProductID(t *testing.T) {
+	assert.Equal(t, ModelNanoS, ModelFromProductID(0x0001))
+	assert.Equal(t, ModelNanoS, ModelFromProductID(0x1011))
+	assert.Equal(t, ModelNanoX, ModelFromProductID(0x4011))
+	assert.Equal(t, ModelNanoSPlus, ModelFromProductID(0x0005))
+	assert.Equal(t, ModelNanoSPlus, ModelFromProductID(0x5015))
+	assert.Equal(t, ModelStax, ModelFromProductID(0x6011))
+	assert.Equal(t, ModelFlex, ModelFromProductID(0x7011))
+	assert.Equal(t, ModelUnknown, ModelFromProductID(0x2011))
+}
+
+func Test_ModelProperties(t *testing.T) {
+	assert.Equal(t, "Nano S Plus", ModelNanoSPlus.String())
+	assert.True(t, ModelFlex.HasTouchscreen())
+	assert.False(t, ModelNanoX.HasTouchscreen())
+	assert.Equal(t, "Review the request on your Ledger Stax and tap to confirm", ModelStax.ConfirmationHint())
+
+	assert.Equal(t, 8192, ModelNanoS.SignDocLimits().MaxSize)
+	assert.Equal(t, DefaultSignDocLimits(), ModelNanoX.SignDocLimits())
+}
+
+func Test_GetDeviceVersion(t *testing.T) {
+	// Nano S Plus dashboard: target id, SE version, flags, MCU version
+	response, _ := hex.DecodeString("3310000405312e312e3104a600000004342e30330000")
+
+	device := newMockDevice().reply(openAppCLA, dashboardINSGetVersion, response, nil)
+	info, err := GetDeviceVersion(device)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	assert.Equal(t, ModelNanoSPlus, info.Model)
+	assert.Equal(t, uint32(0x33100004), info.TargetID)
+	assert.Equal(t, "1.1.1", info.SEVersion)
+	assert.Equal(t, []byte{0xa6, 0, 0, 0}, info.Flags)
+	assert.Equal(t, "4.03", info.MCUVersion)
+	assert.Equal(t, []byte{0xE0, 0x01, 0, 0, 0}, device.sent[0])
+
+	device.reply(openAppCLA, dashboardINSGetVersion, response[:3], nil)
+	_, err = GetDeviceVersion(device)
+	assert.Error(t, err)
+}
@@ -0,0 +1,238 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ED25519Signer signs consensus messages. It is implemented by LedgerTendermintValidator
+type ED25519Signer interface {
+	SignED25519(bip32Path []uint32, message []byte) ([]byte, error)
+}
+
+// Signing steps, in the order they happen within a round
+const (
+	StepNone      int8 = 0
+	StepProposal  int8 = 1
+	StepPrevote   int8 = 2
+	StepPrecommit int8 = 3
+)
+
+// messageStep returns the step of a consensus message
+func messageStep(msgType SignedMsgType) (int8, error) {
+	switch msgType {
+	case ProposalType:
+		return StepProposal, nil
+	case PrevoteType:
+		return StepPrevote, nil
+	case PrecommitType:
+		return StepPrecommit, nil
+	default:
+		return StepNone, fmt.Errorf("unknown message type %d", msgType)
+	}
+}
+
+// SignState is the high-water mark of the last signed message
+type SignState struct {
+	ChainID   string   `json:"chain_id"`
+	Height    int64    `json:"height,string"`
+	Round     int64    `json:"round"`
+	Step      int8     `json:"step"`
+	BlockID   HexBytes `json:"block_id,omitempty"`
+	SignBytes HexBytes `json:"signbytes,omitempty"`
+	Signature []byte   `json:"signature,omitempty"`
+}
+
+// HexBytes is encoded in JSON as an upper case hex string
+type HexBytes []byte
+
+func (b HexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("%X", []byte(b)))
+}
+
+func (b *HexBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := hex.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("invalid hex string %q", s)
+	}
+	*b = decoded
+	return nil
+}
+
+// DoubleSignError a message was refused because it could lead to a double sign
+type DoubleSignError struct {
+	Reason    string
+	Last      SignState
+	Requested SignState
+}
+
+func (e DoubleSignError) Error() string {
+	return fmt.Sprintf("refusing to sign %d/%d/%d: %s (last signed %d/%d/%d)",
+		e.Requested.Height, e.Requested.Round, e.Requested.Step, e.Reason,
+		e.Last.Height, e.Last.Round, e.Last.Step)
+}
+
+// DoubleSignGuard protects a validator key against signing conflicting votes or proposals.
+// Every message is decoded and checked against the last signed height/round/step,
+// which is persisted in a state file before the signature is released
+type DoubleSignGuard struct {
+	signer    ED25519Signer
+	stateFile string
+
+	mtx   sync.Mutex
+	state SignState
+}
+
+// NewDoubleSignGuard wraps a signer. The state file is loaded if it exists, and created otherwise
+func NewDoubleSignGuard(signer ED25519Signer, stateFile string) (*DoubleSignGuard, error) {
+	guard := &DoubleSignGuard{signer: signer, stateFile: stateFile}
+
+	data, err := os.ReadFile(stateFile)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &guard.state); err != nil {
+			return nil, fmt.Errorf("invalid sign state file %s: %w", stateFile, err)
+		}
+	case errors.Is(err, os.ErrNotExist):
+		if err := guard.saveState(guard.state); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	return guard, nil
+}
+
+// State returns the last signed height/round/step
+func (g *DoubleSignGuard) State() SignState {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	return g.state
+}
+
+// SignED25519 signs a vote or proposal if it does not conflict with the high-water mark
+func (g *DoubleSignGuard) SignED25519(bip32Path []uint32, message []byte) ([]byte, error) {
+	msg, err := DecodeSignBytes(message)
+	if err != nil {
+		return nil, err
+	}
+	step, err := messageStep(msg.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	requested := SignState{
+		ChainID:   msg.ChainID,
+		Height:    msg.Height,
+		Round:     msg.Round,
+		Step:      step,
+		BlockID:   msg.BlockID.Hash,
+		SignBytes: message,
+	}
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	if err := g.check(requested); err != nil {
+		return nil, err
+	}
+
+	signature, err := g.signer.SignED25519(bip32Path, message)
+	if err != nil {
+		return nil, err
+	}
+
+	requested.Signature = signature
+	if err := g.saveState(requested); err != nil {
+		return nil, fmt.Errorf("signature withheld, could not persist sign state: %w", err)
+	}
+	g.state = requested
+
+	return signature, nil
+}
+
+func (g *DoubleSignGuard) check(requested SignState) error {
+	last := g.state
+	refuse := func(reason string) error {
+		return &DoubleSignError{Reason: reason, Last: last, Requested: requested}
+	}
+
+	if last.ChainID != "" && last.ChainID != requested.ChainID {
+		return refuse(fmt.Sprintf("chain id %q does not match %q", requested.ChainID, last.ChainID))
+	}
+
+	switch {
+	case requested.Height < last.Height:
+		return refuse("height regression")
+	case requested.Height > last.Height:
+		return nil
+	case requested.Round < last.Round:
+		return refuse("round regression")
+	case requested.Round > last.Round:
+		return nil
+	case requested.Step < last.Step:
+		return refuse("step regression")
+	case requested.Step > last.Step:
+		return nil
+	}
+
+	if !bytes.Equal(requested.SignBytes, last.SignBytes) {
+		return refuse("conflicting payload at the same height/round/step")
+	}
+	return nil
+}
+
+// saveState writes the state to a temporary file and renames it over the state file
+func (g *DoubleSignGuard) saveState(state SignState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(g.stateFile), filepath.Base(g.stateFile)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), g.stateFile)
+}
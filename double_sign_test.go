@@ -0,0 +1,135 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DecodeSignBytes(t *testing.T) {
+	blockID := BlockID{Hash: []byte{1, 2, 3}, PartSetHeader: PartSetHeader{Total: 4, Hash: []byte{5}}}
+	ts := time.Unix(1700000000, 123).UTC()
+
+	msg, err := DecodeSignBytes(VoteSignBytes("thorchain-1", Vote{PrecommitType, 10, 2, blockID, ts}))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, &SignedMessage{PrecommitType, 10, 2, 0, blockID, ts, "thorchain-1"}, msg)
+
+	msg, err = DecodeSignBytes(ProposalSignBytes("thorchain-1", Proposal{10, 1, -1, blockID, ts}))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.True(t, msg.IsProposal())
+	assert.Equal(t, &SignedMessage{ProposalType, 10, 1, -1, blockID, ts, "thorchain-1"}, msg)
+
+	signBytes := VoteSignBytes("thorchain-1", Vote{Type: PrevoteType, Height: 1})
+	_, err = DecodeSignBytes(signBytes[:len(signBytes)-1])
+	assert.Error(t, err)
+	_, err = DecodeSignBytes(VoteSignBytes("thorchain-1", Vote{Height: 1}))
+	assert.Error(t, err)
+}
+
+type countingSigner struct {
+	calls int
+	err   error
+}
+
+func (s *countingSigner) SignED25519(bip32Path []uint32, message []byte) ([]byte, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return []byte{byte(s.calls)}, nil
+}
+
+func Test_DoubleSignGuard(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "priv_validator_state.json")
+	signer := &countingSigner{}
+	path := []uint32{44, 118, 0, 0, 0}
+
+	guard, err := NewDoubleSignGuard(signer, stateFile)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.FileExists(t, stateFile)
+
+	vote := func(msgType SignedMsgType, height int64, round int32, hash byte) []byte {
+		return VoteSignBytes("thorchain-1", Vote{Type: msgType, Height: height, Round: round, BlockID: BlockID{Hash: []byte{hash}}})
+	}
+
+	_, err = guard.SignED25519(path, vote(PrevoteType, 10, 0, 1))
+	require.Nil(t, err)
+	_, err = guard.SignED25519(path, vote(PrecommitType, 10, 0, 1))
+	require.Nil(t, err)
+
+	// Re-signing the identical payload is allowed
+	_, err = guard.SignED25519(path, vote(PrecommitType, 10, 0, 1))
+	require.Nil(t, err)
+
+	var dsErr *DoubleSignError
+	_, err = guard.SignED25519(path, vote(PrecommitType, 10, 0, 2))
+	require.True(t, errors.As(err, &dsErr), "unexpected error %v", err)
+	assert.Equal(t, "conflicting payload at the same height/round/step", dsErr.Reason)
+
+	_, err = guard.SignED25519(path, vote(PrevoteType, 10, 0, 1))
+	require.True(t, errors.As(err, &dsErr))
+	assert.Equal(t, "step regression", dsErr.Reason)
+
+	_, err = guard.SignED25519(path, vote(PrevoteType, 9, 5, 1))
+	require.True(t, errors.As(err, &dsErr))
+	assert.Equal(t, "height regression", dsErr.Reason)
+
+	_, err = guard.SignED25519(path, ProposalSignBytes("thorchain-1", Proposal{Height: 10, Round: 1, POLRound: -1}))
+	require.Nil(t, err)
+
+	_, err = guard.SignED25519(path, vote(PrevoteType, 10, 0, 1))
+	require.True(t, errors.As(err, &dsErr))
+	assert.Equal(t, "round regression", dsErr.Reason)
+
+	_, err = guard.SignED25519(path, VoteSignBytes("other-chain", Vote{Type: PrevoteType, Height: 11}))
+	assert.Error(t, err)
+
+	assert.Equal(t, 4, signer.calls)
+
+	// The watermark survives a restart
+	reloaded, err := NewDoubleSignGuard(signer, stateFile)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, guard.State(), reloaded.State())
+	assert.Equal(t, StepProposal, reloaded.State().Step)
+	assert.Equal(t, []byte{4}, reloaded.State().Signature)
+
+	_, err = reloaded.SignED25519(path, vote(PrecommitType, 10, 0, 1))
+	assert.Error(t, err)
+}
+
+func Test_DoubleSignGuard_SignerError(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	signer := &countingSigner{err: errors.New("device error")}
+
+	guard, err := NewDoubleSignGuard(signer, stateFile)
+	require.Nil(t, err)
+
+	_, err = guard.SignED25519(nil, VoteSignBytes("thorchain-1", Vote{Type: PrevoteType, Height: 5}))
+	assert.EqualError(t, err, "device error")
+	assert.Equal(t, int64(0), guard.State().Height)
+
+	require.Nil(t, os.WriteFile(stateFile, []byte("{"), 0600))
+	_, err = NewDoubleSignGuard(signer, stateFile)
+	assert.Error(t, err)
+}
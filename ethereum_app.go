@@ -0,0 +1,393 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/zondax/ledger-go"
+)
+
+const (
+	ethCLA = 0xE0
+
+	ethINSGetPublicAddress    = 0x02
+	ethINSSignTx              = 0x04
+	ethINSGetAppConfiguration = 0x06
+	ethINSSignPersonalMessage = 0x08
+
+	ethP1First = 0x00
+	ethP1More  = 0x80
+
+	// ethChunkSize is the data sent in each command, as done by the Ledger Live client
+	ethChunkSize = 150
+
+	// EthereumAppName is the name of the Ethereum app
+	EthereumAppName = "Ethereum"
+	// EthereumCoinType is the SLIP-0044 coin type of Ethereum, also used by the EVM chains
+	EthereumCoinType = 60
+
+	// errEthUserRejected is returned by the transport when the request is rejected in the Ethereum app
+	errEthUserRejected = "[APDU_CODE_CONDITIONS_NOT_SATISFIED] Conditions of use not satisfied"
+	// errEthInvalidData is returned when the app refuses a transaction, usually contract data with blind signing disabled
+	errEthInvalidData = "[APDU_CODE_BAD_KEY_HANDLE] The parameters in the data field are incorrect"
+)
+
+// Flags of the Ethereum app configuration
+const (
+	EthFlagArbitraryData = 0x01
+	EthFlagERC20External = 0x02
+)
+
+// LedgerEthereum represents a connection to the Ethereum app, used for the EVM legs of swaps
+type LedgerEthereum struct {
+	api    ledger_go.LedgerDevice
+	config EthereumAppConfig
+	device DeviceInfo
+}
+
+// EthereumAppConfig is the version and settings of the Ethereum app
+type EthereumAppConfig struct {
+	Flags   byte
+	Version VersionInfo
+}
+
+// ArbitraryDataEnabled returns true if blind signing of contract data is enabled in the app settings
+func (c EthereumAppConfig) ArbitraryDataEnabled() bool {
+	return c.Flags&EthFlagArbitraryData != 0
+}
+
+// EthereumAddress is the key of a path
+type EthereumAddress struct {
+	// PubKey is the uncompressed secp256k1 public key
+	PubKey []byte
+	// Address is the EIP-55 checksummed address
+	Address string
+}
+
+// EthereumSignature is a signature returned by the Ethereum app
+type EthereumSignature struct {
+	// V is the value to put in the transaction: the y parity for typed transactions,
+	// chain id * 2 + 35 + parity with EIP-155 and 27 + parity otherwise
+	V *big.Int
+	R []byte
+	S []byte
+	// YParity is the recovery id of the signature
+	YParity byte
+	// Address is the address recovered from the signature
+	Address string
+}
+
+// Bytes returns the 65 byte R || S || V encoding used by personal_sign, with V = 27 + parity
+func (s *EthereumSignature) Bytes() []byte {
+	sig := make([]byte, 0, 65)
+	sig = append(append(sig, s.R...), s.S...)
+	return append(sig, 27+s.YParity)
+}
+
+// FindLedgerEthereumApp finds an Ethereum app running in a ledger device.
+// A WrongAppError is returned if a different app is open
+func FindLedgerEthereumApp() (_ *LedgerEthereum, rerr error) {
+	ledgerAdmin := ledger_go.NewLedgerAdmin()
+	ledgerAPI, err := ledgerAdmin.Connect(0)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if rerr != nil {
+			ledgerAPI.Close()
+		}
+	}()
+
+	app := &LedgerEthereum{api: ledgerAPI, device: detectDevice(0)}
+	if _, err := app.GetAppConfiguration(); err != nil {
+		return nil, wrongAppError(ledgerAPI, EthereumAppName, err)
+	}
+	return app, nil
+}
+
+// Close closes a connection with the Ethereum app
+func (ledger *LedgerEthereum) Close() error {
+	return ledger.api.Close()
+}
+
+// DeviceInfo returns the model of the device
+func (ledger *LedgerEthereum) DeviceInfo() DeviceInfo {
+	return ledger.device
+}
+
+// GetAppConfiguration returns the version and settings of the Ethereum app
+func (ledger *LedgerEthereum) GetAppConfiguration() (*EthereumAppConfig, error) {
+	message := []byte{ethCLA, ethINSGetAppConfiguration, 0, 0, 0}
+	response, err := ledger.api.Exchange(message)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response) < 4 {
+		return nil, errors.New("invalid response")
+	}
+
+	ledger.config = EthereumAppConfig{
+		Flags:   response[0],
+		Version: VersionInfo{Major: response[1], Minor: response[2], Patch: response[3]},
+	}
+	return &ledger.config, nil
+}
+
+// GetVersion returns the current version of the Ethereum app
+func (ledger *LedgerEthereum) GetVersion() (*VersionInfo, error) {
+	config, err := ledger.GetAppConfiguration()
+	if err != nil {
+		return nil, err
+	}
+	return &config.Version, nil
+}
+
+// GetAddress returns the public key and address of a path. The first three elements are hardened.
+// The address is checked against the public key
+// this command requires user confirmation in the device if display is true
+func (ledger *LedgerEthereum) GetAddress(bip32Path []uint32, display bool) (*EthereumAddress, error) {
+	pathBytes, err := bip32bytesBE(bip32Path)
+	if err != nil {
+		return nil, err
+	}
+
+	p1 := byte(0)
+	if display {
+		p1 = 1
+	}
+	header := []byte{ethCLA, ethINSGetPublicAddress, p1, 0, byte(len(pathBytes))}
+	response, err := ledger.api.Exchange(append(header, pathBytes...))
+	if err != nil {
+		return nil, ethError(err)
+	}
+
+	fields, err := splitLengthPrefixed(response, 2)
+	if err != nil || len(fields) < 2 {
+		return nil, errors.New("invalid response")
+	}
+
+	pubKey := append([]byte{}, fields[0]...)
+	address, err := EthAddressFromPubKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(strings.TrimPrefix(address, "0x"), string(fields[1])) {
+		return nil, fmt.Errorf("the address %s does not match the public key", fields[1])
+	}
+	return &EthereumAddress{PubKey: pubKey, Address: address}, nil
+}
+
+// SignTransaction signs an unsigned RLP transaction: legacy, with or without the EIP-155
+// chain id, or an EIP-2930 or EIP-1559 typed transaction (type byte followed by the RLP list).
+// The returned signature contains the address that signed it
+// this command requires user confirmation in the device
+func (ledger *LedgerEthereum) SignTransaction(bip32Path []uint32, rawTx []byte) (*EthereumSignature, error) {
+	info, err := decodeEthTx(rawTx)
+	if err != nil {
+		return nil, err
+	}
+	pathBytes, err := bip32bytesBE(bip32Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var response []byte
+	for offset := 0; offset < len(rawTx); {
+		size := ethChunkSize
+		if offset == 0 {
+			size -= len(pathBytes)
+		}
+		if offset+size > len(rawTx) {
+			size = len(rawTx) - offset
+		}
+		// The app needs the EIP-155 chain id, v and s in the last chunk
+		if info.vrsOffset > 0 && offset+size >= info.vrsOffset {
+			size = len(rawTx) - offset
+		}
+
+		response, err = ledger.exchangeChunk(ethINSSignTx, offset == 0, pathBytes, rawTx[offset:offset+size])
+		if err != nil {
+			return nil, ethError(err)
+		}
+		offset += size
+	}
+
+	parity, err := ethTxParity(response, info)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := newEthereumSignature(response, parity, keccak256(rawTx))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case info.txType != EthTxLegacy:
+		sig.V = big.NewInt(int64(parity))
+	case info.chainID != nil:
+		sig.V = new(big.Int).Add(new(big.Int).Lsh(info.chainID, 1), big.NewInt(35+int64(parity)))
+	default:
+		sig.V = big.NewInt(27 + int64(parity))
+	}
+	return sig, nil
+}
+
+// SignPersonalMessage signs a message with the EIP-191 prefix, as done by personal_sign.
+// The returned signature contains the address that signed it
+// this command requires user confirmation in the device
+func (ledger *LedgerEthereum) SignPersonalMessage(bip32Path []uint32, message []byte) (*EthereumSignature, error) {
+	pathBytes, err := bip32bytesBE(bip32Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The first chunk starts with the length of the whole message
+	data := make([]byte, 4, 4+len(message))
+	binary.BigEndian.PutUint32(data, uint32(len(message)))
+	data = append(data, message...)
+
+	var response []byte
+	for offset := 0; offset < len(data); {
+		size := ethChunkSize
+		if offset == 0 {
+			size -= len(pathBytes)
+		}
+		if offset+size > len(data) {
+			size = len(data) - offset
+		}
+
+		response, err = ledger.exchangeChunk(ethINSSignPersonalMessage, offset == 0, pathBytes, data[offset:offset+size])
+		if err != nil {
+			return nil, ethError(err)
+		}
+		offset += size
+	}
+
+	if len(response) < 65 {
+		return nil, errors.New("invalid response")
+	}
+	parity := response[0]
+	if parity >= 27 {
+		parity -= 27
+	}
+	if parity > 1 {
+		return nil, fmt.Errorf("unexpected v %d in the signature", response[0])
+	}
+
+	sig, err := newEthereumSignature(response, parity, EthPersonalMessageHash(message))
+	if err != nil {
+		return nil, err
+	}
+	sig.V = big.NewInt(27 + int64(parity))
+	return sig, nil
+}
+
+// exchangeChunk sends a chunk of a payload. The first chunk is preceded by the path
+func (ledger *LedgerEthereum) exchangeChunk(ins byte, first bool, pathBytes []byte, chunk []byte) ([]byte, error) {
+	p1 := byte(ethP1More)
+	if first {
+		p1 = ethP1First
+		chunk = append(append([]byte{}, pathBytes...), chunk...)
+	}
+	if len(chunk) > 255 {
+		return nil, errors.New("the chunk does not fit in a command")
+	}
+
+	header := []byte{ethCLA, ins, p1, 0, byte(len(chunk))}
+	return ledger.api.Exchange(append(header, chunk...))
+}
+
+// bip32bytesBE encodes a path as the number of elements followed by big endian elements, the encoding
+// of the Ethereum and Bitcoin apps. The first three elements are hardened
+func bip32bytesBE(bip32Path []uint32) ([]byte, error) {
+	if len(bip32Path) == 0 || len(bip32Path) > 10 {
+		return nil, errors.New("the path should contain between 1 and 10 elements")
+	}
+
+	message := make([]byte, 1+4*len(bip32Path))
+	message[0] = byte(len(bip32Path))
+	for index, element := range bip32Path {
+		if index < 3 {
+			element |= 0x80000000
+		}
+		binary.BigEndian.PutUint32(message[1+index*4:], element)
+	}
+	return message, nil
+}
+
+// ethTxParity extracts the recovery id from the v byte returned by SIGN_TX. The app only returns
+// the low byte of v, which is truncated for EIP-155 chain ids above 109
+func ethTxParity(response []byte, info *ethTxInfo) (byte, error) {
+	if len(response) < 65 {
+		return 0, errors.New("invalid response")
+	}
+
+	v := response[0]
+	var parity byte
+	switch {
+	case info.txType != EthTxLegacy && v <= 1:
+		parity = v
+	case info.chainID != nil:
+		base := new(big.Int).Add(new(big.Int).Lsh(info.chainID, 1), big.NewInt(35))
+		parity = v - byte(base.Uint64())
+	default:
+		parity = v - 27
+	}
+
+	if parity > 1 {
+		return 0, fmt.Errorf("unexpected v %d in the signature", v)
+	}
+	return parity, nil
+}
+
+// newEthereumSignature reads r and s from the response and recovers the address that signed hash
+func newEthereumSignature(response []byte, parity byte, hash []byte) (*EthereumSignature, error) {
+	r := append([]byte{}, response[1:33]...)
+	s := append([]byte{}, response[33:65]...)
+
+	compact := append([]byte{27 + parity}, r...)
+	compact = append(compact, s...)
+	pubKey, _, err := ecdsa.RecoverCompact(compact, hash)
+	if err != nil {
+		return nil, fmt.Errorf("cannot recover the signer: %w", err)
+	}
+	address, err := EthAddressFromPubKey(pubKey.SerializeUncompressed())
+	if err != nil {
+		return nil, err
+	}
+
+	return &EthereumSignature{R: r, S: s, YParity: parity, Address: address}, nil
+}
+
+// ethError explains the errors of the Ethereum app
+func ethError(err error) error {
+	switch err.Error() {
+	case errEthUserRejected:
+		return fmt.Errorf("the request was rejected in the device: %w", err)
+	case errEthInvalidData:
+		return fmt.Errorf("the Ethereum app refused the data, enable blind signing in its settings to sign contract calls: %w", err)
+	}
+	return err
+}
@@ -0,0 +1,320 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rlpEncode encodes []byte as strings and []interface{} as lists
+func rlpEncode(item interface{}) []byte {
+	header := func(offset byte, size int) []byte {
+		if size <= 55 {
+			return []byte{offset + byte(size)}
+		}
+		n := big.NewInt(int64(size)).Bytes()
+		return append([]byte{offset + 55 + byte(len(n))}, n...)
+	}
+
+	switch v := item.(type) {
+	case []byte:
+		if len(v) == 1 && v[0] < 0x80 {
+			return v
+		}
+		return append(header(0x80, len(v)), v...)
+	case []interface{}:
+		var content []byte
+		for _, element := range v {
+			content = append(content, rlpEncode(element)...)
+		}
+		return append(header(0xC0, len(content)), content...)
+	}
+	panic("unsupported rlp item")
+}
+
+func rlpUint(n int64) []byte {
+	return big.NewInt(n).Bytes()
+}
+
+// mockEthereumApp answers like the Ethereum app, signing with key
+type mockEthereumApp struct {
+	key      *btcec.PrivateKey
+	device   *mockDevice
+	payload  []byte
+	ins      byte
+	chainID  int64
+	typed    bool
+	reject   bool
+	chunks   [][]byte
+	pathSize int
+}
+
+func newMockEthereumApp(t *testing.T) (*LedgerEthereum, *mockEthereumApp) {
+	key, err := btcec.NewPrivateKey()
+	require.Nil(t, err)
+	app := &mockEthereumApp{key: key, device: newMockDevice()}
+	app.device.handler = app.handle
+	return &LedgerEthereum{api: app.device}, app
+}
+
+func (a *mockEthereumApp) handle(command []byte) ([]byte, error) {
+	if command[0] != ethCLA {
+		return nil, errors.New("[APDU_CODE_CLA_NOT_SUPPORTED] CLA not supported")
+	}
+	data := command[5:]
+
+	switch command[1] {
+	case ethINSGetAppConfiguration:
+		return []byte{EthFlagArbitraryData, 1, 10, 3}, nil
+	case ethINSGetPublicAddress:
+		pubKey := a.key.PubKey().SerializeUncompressed()
+		address := hex.EncodeToString(keccak256(pubKey[1:])[12:])
+		response := append([]byte{65}, pubKey...)
+		return append(append(response, 40), address...), nil
+	case ethINSSignTx, ethINSSignPersonalMessage:
+		if command[2] == ethP1First {
+			a.ins, a.payload, a.chunks = command[1], nil, nil
+			a.pathSize = 1 + 4*int(data[0])
+			data = data[a.pathSize:]
+		}
+		a.chunks = append(a.chunks, append([]byte{}, data...))
+		a.payload = append(a.payload, data...)
+		if a.reject {
+			return nil, errors.New(errEthUserRejected)
+		}
+		return a.sign(), nil
+	}
+	return nil, errors.New("[APDU_CODE_INS_NOT_SUPPORTED] Instruction code not supported or invalid")
+}
+
+// sign signs the payload received so far. The client only keeps the answer to the last chunk
+func (a *mockEthereumApp) sign() []byte {
+	var hash []byte
+	if a.ins == ethINSSignPersonalMessage {
+		hash = EthPersonalMessageHash(a.payload[4:])
+	} else {
+		hash = keccak256(a.payload)
+	}
+
+	compact, _ := ecdsa.SignCompact(a.key, hash, false)
+	parity := compact[0] - 27
+	switch {
+	case a.ins == ethINSSignPersonalMessage:
+		compact[0] = 27 + parity
+	case a.typed:
+		compact[0] = parity
+	case a.chainID > 0:
+		compact[0] = byte(a.chainID*2 + 35 + int64(parity))
+	}
+	return compact
+}
+
+func (a *mockEthereumApp) address() string {
+	address, _ := EthAddressFromPubKey(a.key.PubKey().SerializeUncompressed())
+	return address
+}
+
+func ethLegacyTx(chainID int64, data []byte) []byte {
+	to := bytes.Repeat([]byte{0x11}, 20)
+	fields := []interface{}{rlpUint(7), rlpUint(20000000000), rlpUint(21000), to, rlpUint(1000000000000000000), data}
+	if chainID > 0 {
+		fields = append(fields, rlpUint(chainID), []byte{}, []byte{})
+	}
+	return rlpEncode(fields)
+}
+
+func ethDynamicFeeTx(chainID int64, data []byte) []byte {
+	to := bytes.Repeat([]byte{0x22}, 20)
+	fields := []interface{}{rlpUint(chainID), rlpUint(3), rlpUint(1000000000), rlpUint(30000000000), rlpUint(90000), to, []byte{}, data, []interface{}{}}
+	return append([]byte{EthTxDynamicFee}, rlpEncode(fields)...)
+}
+
+func Test_EthBip32bytes(t *testing.T) {
+	pathBytes, err := bip32bytesBE([]uint32{44, 60, 0, 0, 1})
+	require.Nil(t, err)
+	assert.Equal(t, "058000002c8000003c800000000000000000000001", hex.EncodeToString(pathBytes))
+
+	_, err = bip32bytesBE(nil)
+	assert.Error(t, err)
+}
+
+func Test_EthChecksumAddress(t *testing.T) {
+	// EIP-55 test vectors
+	for _, expected := range []string{
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+		"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB",
+		"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb",
+	} {
+		addr, err := hex.DecodeString(expected[2:])
+		require.Nil(t, err)
+		assert.Equal(t, expected, EthChecksumAddress(addr))
+	}
+}
+
+func Test_DecodeEthTx(t *testing.T) {
+	info, err := decodeEthTx(ethLegacyTx(0, nil))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Nil(t, info.chainID)
+	assert.Zero(t, info.vrsOffset)
+
+	rawTx := ethLegacyTx(43114, nil)
+	info, err = decodeEthTx(rawTx)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, big.NewInt(43114), info.chainID)
+	assert.Equal(t, []byte{0x82, 0xA8, 0x6A, 0x80, 0x80}, rawTx[info.vrsOffset:])
+
+	info, err = decodeEthTx(ethDynamicFeeTx(8453, nil))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, byte(EthTxDynamicFee), info.txType)
+	assert.Equal(t, big.NewInt(8453), info.chainID)
+
+	_, err = decodeEthTx([]byte{0x03, 0xC0})
+	assert.EqualError(t, err, "unsupported transaction type 0x03")
+	_, err = decodeEthTx(append([]byte{EthTxDynamicFee}, rlpEncode([]interface{}{rlpUint(1)})...))
+	assert.EqualError(t, err, "a type 0x02 transaction has 9 fields, got 1")
+	_, err = decodeEthTx(ethLegacyTx(1, nil)[:20])
+	assert.EqualError(t, err, "rlp: unexpected end of data")
+}
+
+func Test_Ethereum_GetAddress(t *testing.T) {
+	app, mock := newMockEthereumApp(t)
+
+	config, err := app.GetAppConfiguration()
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, "1.10.3", config.Version.String())
+	assert.True(t, config.ArbitraryDataEnabled())
+
+	address, err := app.GetAddress([]uint32{44, 60, 0, 0, 0}, true)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, mock.address(), address.Address)
+	assert.Equal(t, mock.key.PubKey().SerializeUncompressed(), address.PubKey)
+	assert.Equal(t, byte(1), mock.device.sent[1][2])
+}
+
+func Test_Ethereum_SignTransaction(t *testing.T) {
+	path := []uint32{44, 60, 0, 0, 0}
+	data := bytes.Repeat([]byte{0xAB}, 300)
+
+	cases := []struct {
+		name    string
+		rawTx   []byte
+		chainID int64
+		typed   bool
+		v       int64
+	}{
+		{"legacy", ethLegacyTx(0, nil), 0, false, 27},
+		{"eip155 ethereum", ethLegacyTx(1, data), 1, false, 37},
+		{"eip155 bsc", ethLegacyTx(56, nil), 56, false, 147},
+		{"eip155 avalanche", ethLegacyTx(43114, data), 43114, false, 86263},
+		{"eip1559 base", ethDynamicFeeTx(8453, data), 8453, true, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			app, mock := newMockEthereumApp(t)
+			mock.chainID, mock.typed = c.chainID, c.typed
+
+			sig, err := app.SignTransaction(path, c.rawTx)
+			require.Nil(t, err, "Detected error, err: %s\n", err)
+			assert.Equal(t, c.rawTx, mock.payload)
+			assert.Equal(t, mock.address(), sig.Address)
+			assert.Equal(t, c.v+int64(sig.YParity), sig.V.Int64())
+			assert.Len(t, sig.R, 32)
+			assert.Len(t, sig.S, 32)
+
+			for i, chunk := range mock.chunks {
+				size := len(chunk)
+				if i == 0 {
+					size += mock.pathSize
+				}
+				assert.LessOrEqual(t, size, 255)
+			}
+		})
+	}
+}
+
+func Test_Ethereum_SignTransaction_Chunks(t *testing.T) {
+	app, mock := newMockEthereumApp(t)
+	mock.chainID = 1
+
+	// The chain id, v and s start right after the first chunk, so they are sent with it
+	pathSize := 1 + 4*5
+	for size := 0; size < 400; size++ {
+		rawTx := ethLegacyTx(1, bytes.Repeat([]byte{0xCD}, size))
+		info, err := decodeEthTx(rawTx)
+		require.Nil(t, err)
+		if info.vrsOffset != ethChunkSize-pathSize {
+			continue
+		}
+
+		_, err = app.SignTransaction([]uint32{44, 60, 0, 0, 0}, rawTx)
+		require.Nil(t, err, "Detected error, err: %s\n", err)
+		require.Len(t, mock.chunks, 1)
+		assert.Equal(t, rawTx, mock.chunks[0])
+		return
+	}
+	t.Fatal("no transaction with the chain id at the chunk boundary")
+}
+
+func Test_Ethereum_SignPersonalMessage(t *testing.T) {
+	app, mock := newMockEthereumApp(t)
+	message := bytes.Repeat([]byte("THORChain "), 40)
+
+	sig, err := app.SignPersonalMessage([]uint32{44, 60, 0, 0, 0}, message)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, mock.address(), sig.Address)
+	assert.Equal(t, uint32(len(message)), binary.BigEndian.Uint32(mock.payload))
+	assert.Equal(t, message, mock.payload[4:])
+	assert.Greater(t, len(mock.chunks), 1)
+
+	rsv := sig.Bytes()
+	require.Len(t, rsv, 65)
+	assert.Equal(t, 27+sig.YParity, rsv[64])
+	assert.Equal(t, int64(rsv[64]), sig.V.Int64())
+}
+
+func Test_Ethereum_Errors(t *testing.T) {
+	app, mock := newMockEthereumApp(t)
+	path := []uint32{44, 60, 0, 0, 0}
+
+	mock.reject = true
+	_, err := app.SignTransaction(path, ethLegacyTx(1, nil))
+	assert.EqualError(t, err, "the request was rejected in the device: "+errEthUserRejected)
+
+	_, err = app.SignTransaction(path, ethLegacyTx(1, nil)[:10])
+	assert.Error(t, err)
+
+	signed := rlpEncode([]interface{}{rlpUint(1), rlpUint(1), rlpUint(1), []byte{}, rlpUint(1), []byte{}, rlpUint(1), rlpUint(5), rlpUint(6)})
+	_, err = app.SignTransaction(path, signed)
+	assert.EqualError(t, err, "the transaction is already signed: r and s should be empty")
+
+	mock.device.handler = func(command []byte) ([]byte, error) {
+		return nil, errors.New(errEthInvalidData)
+	}
+	_, err = app.SignTransaction(path, ethLegacyTx(1, nil))
+	assert.ErrorContains(t, err, "enable blind signing")
+}
@@ -0,0 +1,197 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Ethereum transaction types
+const (
+	EthTxLegacy     = 0x00
+	EthTxAccessList = 0x01
+	EthTxDynamicFee = 0x02
+)
+
+// ethTxInfo is what the client needs to know about an unsigned transaction
+type ethTxInfo struct {
+	txType byte
+	// chainID is nil for legacy transactions signed without EIP-155
+	chainID *big.Int
+	// vrsOffset is the offset of the EIP-155 chain id, v, r and s of a legacy transaction.
+	// They must be sent in the same chunk
+	vrsOffset int
+}
+
+// rlpItem is an RLP string or list
+type rlpItem struct {
+	list    bool
+	content []byte
+	// offset is the position of the item, header included, in the decoded buffer
+	offset int
+}
+
+// rlpNext reads the item at the start of b and returns it with the bytes after it
+func rlpNext(b []byte) (rlpItem, []byte, error) {
+	if len(b) == 0 {
+		return rlpItem{}, nil, errors.New("rlp: unexpected end of data")
+	}
+
+	var list bool
+	var header, size int
+	switch prefix := b[0]; {
+	case prefix < 0x80:
+		return rlpItem{content: b[:1]}, b[1:], nil
+	case prefix <= 0xB7:
+		header, size = 1, int(prefix-0x80)
+	case prefix <= 0xBF:
+		header = 1 + int(prefix-0xB7)
+	case prefix <= 0xF7:
+		list, header, size = true, 1, int(prefix-0xC0)
+	default:
+		list, header = true, 1+int(prefix-0xF7)
+	}
+
+	if header > 1 {
+		// Lengths over 16 MB are not plausible for a transaction
+		if header > 4 || len(b) < header {
+			return rlpItem{}, nil, errors.New("rlp: invalid length")
+		}
+		for _, c := range b[1:header] {
+			size = size<<8 | int(c)
+		}
+	}
+	if len(b)-header < size {
+		return rlpItem{}, nil, errors.New("rlp: unexpected end of data")
+	}
+	return rlpItem{list: list, content: b[header : header+size]}, b[header+size:], nil
+}
+
+// rlpList decodes b, which must be exactly one list, and returns its items
+func rlpList(b []byte) ([]rlpItem, error) {
+	list, rest, err := rlpNext(b)
+	if err != nil {
+		return nil, err
+	}
+	if !list.list {
+		return nil, errors.New("rlp: expected a list")
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("rlp: trailing bytes after the list")
+	}
+
+	var items []rlpItem
+	data := list.content
+	for len(data) > 0 {
+		offset := len(b) - len(data)
+		item, rest, err := rlpNext(data)
+		if err != nil {
+			return nil, err
+		}
+		item.offset = offset
+		items = append(items, item)
+		data = rest
+	}
+	return items, nil
+}
+
+// decodeEthTx checks the shape of an unsigned transaction: a legacy RLP list, optionally with
+// the EIP-155 chain id, or an EIP-2930 or EIP-1559 typed transaction
+func decodeEthTx(rawTx []byte) (*ethTxInfo, error) {
+	if len(rawTx) == 0 {
+		return nil, errors.New("empty transaction")
+	}
+
+	if rawTx[0] >= 0xC0 {
+		items, err := rlpList(rawTx)
+		if err != nil {
+			return nil, err
+		}
+		switch len(items) {
+		case 6:
+			return &ethTxInfo{txType: EthTxLegacy}, nil
+		case 9:
+			if len(items[7].content) != 0 || len(items[8].content) != 0 {
+				return nil, errors.New("the transaction is already signed: r and s should be empty")
+			}
+			chainID := new(big.Int).SetBytes(items[6].content)
+			return &ethTxInfo{txType: EthTxLegacy, chainID: chainID, vrsOffset: items[6].offset}, nil
+		default:
+			return nil, fmt.Errorf("a legacy transaction has 6 or 9 fields, got %d", len(items))
+		}
+	}
+
+	fields := map[byte]int{EthTxAccessList: 8, EthTxDynamicFee: 9}
+	expected, ok := fields[rawTx[0]]
+	if !ok {
+		return nil, fmt.Errorf("unsupported transaction type 0x%02x", rawTx[0])
+	}
+	items, err := rlpList(rawTx[1:])
+	if err != nil {
+		return nil, err
+	}
+	if len(items) != expected {
+		return nil, fmt.Errorf("a type 0x%02x transaction has %d fields, got %d", rawTx[0], expected, len(items))
+	}
+	return &ethTxInfo{txType: rawTx[0], chainID: new(big.Int).SetBytes(items[0].content)}, nil
+}
+
+// keccak256 is the hash used by Ethereum
+func keccak256(data ...[]byte) []byte {
+	hasher := sha3.NewLegacyKeccak256()
+	for _, b := range data {
+		hasher.Write(b)
+	}
+	return hasher.Sum(nil)
+}
+
+// EthPersonalMessageHash returns the EIP-191 hash signed by SIGN_PERSONAL_MESSAGE
+func EthPersonalMessageHash(message []byte) []byte {
+	prefix := "\x19Ethereum Signed Message:\n" + strconv.Itoa(len(message))
+	return keccak256([]byte(prefix), message)
+}
+
+// EthAddressFromPubKey returns the EIP-55 address of an uncompressed secp256k1 public key
+func EthAddressFromPubKey(pubKey []byte) (string, error) {
+	if len(pubKey) != 65 || pubKey[0] != 0x04 {
+		return "", errors.New("expected a 65 byte uncompressed public key")
+	}
+	return EthChecksumAddress(keccak256(pubKey[1:])[12:]), nil
+}
+
+// EthChecksumAddress formats a 20 byte address with the EIP-55 mixed case checksum
+func EthChecksumAddress(addr []byte) string {
+	lower := hex.EncodeToString(addr)
+	hash := hex.EncodeToString(keccak256([]byte(lower)))
+
+	var sb strings.Builder
+	sb.WriteString("0x")
+	for i, c := range lower {
+		if c >= 'a' && hash[i] >= '8' {
+			c -= 'a' - 'A'
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
@@ -0,0 +1,113 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+// Package evm builds the transactions of the EVM legs of THORChain swaps: calls to the
+// THORChain router and ERC20 approvals, as unsigned EIP-1559 transactions for the Ethereum app.
+package evm
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+// Address is a 20 byte EVM address
+type Address [20]byte
+
+// ZeroAddress is the asset of the native coin (ETH, AVAX, BNB) in router calls
+var ZeroAddress Address
+
+// ParseAddress parses a 0x prefixed hex address. Mixed case addresses must have a valid EIP-55 checksum
+func ParseAddress(s string) (Address, error) {
+	var addr Address
+	if !strings.HasPrefix(s, "0x") || len(s) != 42 {
+		return addr, fmt.Errorf("invalid address %q: expected 0x followed by 40 hex digits", s)
+	}
+	b, err := hex.DecodeString(s[2:])
+	if err != nil {
+		return addr, fmt.Errorf("invalid address %q: expected 0x followed by 40 hex digits", s)
+	}
+	copy(addr[:], b)
+
+	digits := s[2:]
+	if digits != strings.ToLower(digits) && digits != strings.ToUpper(digits) && s != addr.String() {
+		return addr, fmt.Errorf("invalid address %q: wrong EIP-55 checksum", s)
+	}
+	return addr, nil
+}
+
+// String returns the EIP-55 checksummed address
+func (a Address) String() string {
+	return ledger.EthChecksumAddress(a[:])
+}
+
+// IsZero returns true for the zero address
+func (a Address) IsZero() bool {
+	return a == ZeroAddress
+}
+
+// maxUint256 is the largest value of a uint256 argument
+var maxUint256 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// selector returns the first four bytes of the keccak256 of a function signature
+func selector(signature string) []byte {
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write([]byte(signature))
+	return hasher.Sum(nil)[:4]
+}
+
+// abiEncode encodes the arguments of a function call after its selector.
+// The arguments can be Address, *big.Int (uint256) and string
+func abiEncode(signature string, args ...interface{}) ([]byte, error) {
+	head := make([]byte, 0, 32*len(args))
+	var tail []byte
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case Address:
+			head = append(head, make([]byte, 12)...)
+			head = append(head, v[:]...)
+		case *big.Int:
+			if v == nil || v.Sign() < 0 || v.Cmp(maxUint256) > 0 {
+				return nil, fmt.Errorf("argument %d: expected a uint256", i)
+			}
+			head = append(head, abiWord(v)...)
+		case string:
+			// Dynamic arguments are referenced by their offset from the start of the arguments
+			head = append(head, abiWord(big.NewInt(int64(32*len(args)+len(tail))))...)
+			tail = append(tail, abiWord(big.NewInt(int64(len(v))))...)
+			tail = append(tail, v...)
+			if pad := len(v) % 32; pad != 0 {
+				tail = append(tail, make([]byte, 32-pad)...)
+			}
+		default:
+			return nil, errors.New("unsupported argument type")
+		}
+	}
+
+	data := append(selector(signature), head...)
+	return append(data, tail...), nil
+}
+
+// abiWord returns n as a 32 byte big endian word
+func abiWord(n *big.Int) []byte {
+	return n.FillBytes(make([]byte, 32))
+}
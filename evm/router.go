@@ -0,0 +1,124 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package evm
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+// Signatures of the router and ERC20 functions
+const (
+	DepositSignature           = "deposit(address,address,uint256,string)"
+	DepositWithExpirySignature = "depositWithExpiry(address,address,uint256,string,uint256)"
+	TransferOutSignature       = "transferOut(address,address,uint256,string)"
+	ApproveSignature           = "approve(address,uint256)"
+)
+
+// DepositCalldata encodes router.deposit(vault, asset, amount, memo)
+func DepositCalldata(vault Address, asset Address, amount *big.Int, memo string) ([]byte, error) {
+	return abiEncode(DepositSignature, vault, asset, amount, memo)
+}
+
+// DepositWithExpiryCalldata encodes router.depositWithExpiry(vault, asset, amount, memo, expiration).
+// The router refuses the deposit once the block timestamp is past the expiration
+func DepositWithExpiryCalldata(vault Address, asset Address, amount *big.Int, memo string, expiration time.Time) ([]byte, error) {
+	if expiration.Unix() <= 0 {
+		return nil, errors.New("invalid expiration")
+	}
+	return abiEncode(DepositWithExpirySignature, vault, asset, amount, memo, big.NewInt(expiration.Unix()))
+}
+
+// TransferOutCalldata encodes router.transferOut(to, asset, amount, memo), sent by the vaults for outbounds
+func TransferOutCalldata(to Address, asset Address, amount *big.Int, memo string) ([]byte, error) {
+	return abiEncode(TransferOutSignature, to, asset, amount, memo)
+}
+
+// ApproveCalldata encodes ERC20.approve(spender, amount)
+func ApproveCalldata(spender Address, amount *big.Int) ([]byte, error) {
+	return abiEncode(ApproveSignature, spender, amount)
+}
+
+// TxParams are the fields of an EIP-1559 transaction that do not depend on the call
+type TxParams struct {
+	ChainID   *big.Int
+	Nonce     uint64
+	GasTipCap *big.Int
+	GasFeeCap *big.Int
+	Gas       uint64
+}
+
+// Deposit is an inbound of a swap or liquidity operation, sent to the router of the chain
+type Deposit struct {
+	Router Address
+	// Vault is the inbound address of the chain, as returned by /thorchain/inbound_addresses
+	Vault Address
+	// Asset is the ERC20 token, or ZeroAddress for the native coin
+	Asset Address
+	// Amount is in the base unit of the asset: wei for the native coin, the token decimals otherwise
+	Amount *big.Int
+	Memo   string
+	// Expiration is the deadline of the deposit. Zero calls deposit instead of depositWithExpiry
+	Expiration time.Time
+}
+
+// BuildDeposit returns the router call of a deposit. The native coin is sent as the value
+// of the transaction, tokens are pulled by the router and need an approval first, see BuildApprove
+func BuildDeposit(params TxParams, d Deposit) (*DynamicFeeTx, error) {
+	if d.Router.IsZero() || d.Vault.IsZero() {
+		return nil, errors.New("the router and vault addresses are required")
+	}
+	if d.Amount == nil || d.Amount.Sign() <= 0 {
+		return nil, errors.New("the amount should be positive")
+	}
+	if memo := ledger.ParseMemo(d.Memo); !memo.IsKnown() {
+		return nil, fmt.Errorf("unknown memo action %q", memo.Action)
+	}
+
+	var data []byte
+	var err error
+	if d.Expiration.IsZero() {
+		data, err = DepositCalldata(d.Vault, d.Asset, d.Amount, d.Memo)
+	} else {
+		data, err = DepositWithExpiryCalldata(d.Vault, d.Asset, d.Amount, d.Memo, d.Expiration)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	value := new(big.Int)
+	if d.Asset.IsZero() {
+		value.Set(d.Amount)
+	}
+	return newDynamicFeeTx(params, d.Router, value, data)
+}
+
+// BuildApprove returns the ERC20 approval letting the router pull amount of token
+func BuildApprove(params TxParams, token Address, router Address, amount *big.Int) (*DynamicFeeTx, error) {
+	if token.IsZero() {
+		return nil, errors.New("the native coin does not need an approval")
+	}
+	data, err := ApproveCalldata(router, amount)
+	if err != nil {
+		return nil, err
+	}
+	return newDynamicFeeTx(params, token, new(big.Int), data)
+}
@@ -0,0 +1,178 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package evm
+
+import (
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testRouter = mustParseAddress("0xD37BbE5744D730a1d98d8DC97c42F0Ca46aD7146")
+	testVault  = mustParseAddress("0x1111111111111111111111111111111111111111")
+	testToken  = mustParseAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48")
+	testParams = TxParams{ChainID: big.NewInt(1), Nonce: 5, GasTipCap: big.NewInt(1e9), GasFeeCap: big.NewInt(30e9), Gas: 120000}
+)
+
+func mustParseAddress(s string) Address {
+	addr, err := ParseAddress(s)
+	if err != nil {
+		panic(err)
+	}
+	return addr
+}
+
+// words splits calldata after the selector in 32 byte hex words
+func words(data []byte) []string {
+	var result []string
+	for i := 4; i < len(data); i += 32 {
+		result = append(result, hex.EncodeToString(data[i:i+32]))
+	}
+	return result
+}
+
+func Test_ParseAddress(t *testing.T) {
+	addr, err := ParseAddress("0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48")
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, testToken, addr)
+	assert.Equal(t, "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", addr.String())
+
+	_, err = ParseAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eb48")
+	assert.EqualError(t, err, `invalid address "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eb48": wrong EIP-55 checksum`)
+	_, err = ParseAddress("a0b86991c6218b36c1d19d4a2e9eb0ce3606eb48")
+	assert.Error(t, err)
+	_, err = ParseAddress("0x1234")
+	assert.Error(t, err)
+}
+
+func Test_Selectors(t *testing.T) {
+	assert.Equal(t, "1fece7b4", hex.EncodeToString(selector(DepositSignature)))
+	assert.Equal(t, "44bc937b", hex.EncodeToString(selector(DepositWithExpirySignature)))
+	assert.Equal(t, "574da717", hex.EncodeToString(selector(TransferOutSignature)))
+	assert.Equal(t, "095ea7b3", hex.EncodeToString(selector(ApproveSignature)))
+}
+
+func Test_DepositWithExpiryCalldata(t *testing.T) {
+	memo := "=:BTC.BTC:bc1qxy2kgdygjrsqtzq2n0yrf2493p83kkfjhx0wlh:0/1/0:dx:150"
+	data, err := DepositWithExpiryCalldata(testVault, testToken, big.NewInt(1000000), memo, time.Unix(1700000000, 0))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	assert.Equal(t, "44bc937b", hex.EncodeToString(data[:4]))
+	assert.Equal(t, []string{
+		"0000000000000000000000001111111111111111111111111111111111111111",
+		"000000000000000000000000a0b86991c6218b36c1d19d4a2e9eb0ce3606eb48",
+		"00000000000000000000000000000000000000000000000000000000000f4240",
+		"00000000000000000000000000000000000000000000000000000000000000a0",
+		"000000000000000000000000000000000000000000000000000000006553f100",
+		"0000000000000000000000000000000000000000000000000000000000000041",
+		hex.EncodeToString([]byte(memo[:32])),
+		hex.EncodeToString([]byte(memo[32:64])),
+		hex.EncodeToString([]byte(memo[64:])) + strings.Repeat("00", 31),
+	}, words(data))
+}
+
+func Test_DepositAndTransferOutCalldata(t *testing.T) {
+	data, err := DepositCalldata(testVault, ZeroAddress, big.NewInt(1), "+:ETH.ETH")
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, "1fece7b4", hex.EncodeToString(data[:4]))
+	assert.Len(t, words(data), 6)
+	assert.Equal(t, "0000000000000000000000000000000000000000000000000000000000000080", words(data)[3])
+
+	data, err = TransferOutCalldata(testVault, testToken, big.NewInt(1), "OUT:ABCDEF")
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, "574da717", hex.EncodeToString(data[:4]))
+
+	// An empty memo has a length and no data
+	data, err = DepositCalldata(testVault, ZeroAddress, big.NewInt(1), "")
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Len(t, words(data), 5)
+
+	_, err = DepositCalldata(testVault, ZeroAddress, big.NewInt(-1), "")
+	assert.EqualError(t, err, "argument 2: expected a uint256")
+	_, err = ApproveCalldata(testRouter, new(big.Int).Lsh(big.NewInt(1), 256))
+	assert.EqualError(t, err, "argument 1: expected a uint256")
+}
+
+func Test_BuildDeposit(t *testing.T) {
+	amount := big.NewInt(5e16)
+	native, err := BuildDeposit(testParams, Deposit{
+		Router:     testRouter,
+		Vault:      testVault,
+		Asset:      ZeroAddress,
+		Amount:     amount,
+		Memo:       "=:BTC.BTC:bc1qxy2kgdygjrsqtzq2n0yrf2493p83kkfjhx0wlh",
+		Expiration: time.Unix(1700000000, 0),
+	})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, testRouter, native.To)
+	assert.Equal(t, amount, native.Value)
+	assert.Equal(t, "44bc937b", hex.EncodeToString(native.Data[:4]))
+	assert.Equal(t, uint64(5), native.Nonce)
+
+	token, err := BuildDeposit(testParams, Deposit{
+		Router: testRouter,
+		Vault:  testVault,
+		Asset:  testToken,
+		Amount: big.NewInt(1000000),
+		Memo:   "=:BTC.BTC:bc1qxy2kgdygjrsqtzq2n0yrf2493p83kkfjhx0wlh",
+	})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Zero(t, token.Value.Sign())
+	assert.Equal(t, "1fece7b4", hex.EncodeToString(token.Data[:4]))
+
+	approve, err := BuildApprove(testParams, testToken, testRouter, big.NewInt(1000000))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, testToken, approve.To)
+	assert.Equal(t, "095ea7b3", hex.EncodeToString(approve.Data[:4]))
+	assert.Equal(t, []string{
+		"000000000000000000000000d37bbe5744d730a1d98d8dc97c42f0ca46ad7146",
+		"00000000000000000000000000000000000000000000000000000000000f4240",
+	}, words(approve.Data))
+}
+
+func Test_BuildDeposit_Errors(t *testing.T) {
+	deposit := Deposit{Router: testRouter, Vault: testVault, Amount: big.NewInt(1), Memo: "=:BTC.BTC:bc1q"}
+
+	invalid := deposit
+	invalid.Memo = "hello"
+	_, err := BuildDeposit(testParams, invalid)
+	assert.EqualError(t, err, `unknown memo action "HELLO"`)
+
+	invalid = deposit
+	invalid.Amount = big.NewInt(0)
+	_, err = BuildDeposit(testParams, invalid)
+	assert.EqualError(t, err, "the amount should be positive")
+
+	invalid = deposit
+	invalid.Vault = ZeroAddress
+	_, err = BuildDeposit(testParams, invalid)
+	assert.EqualError(t, err, "the router and vault addresses are required")
+
+	params := testParams
+	params.GasTipCap = big.NewInt(40e9)
+	_, err = BuildDeposit(params, deposit)
+	assert.EqualError(t, err, "the tip cap should be between 0 and the fee cap")
+
+	_, err = BuildApprove(testParams, ZeroAddress, testRouter, big.NewInt(1))
+	assert.EqualError(t, err, "the native coin does not need an approval")
+}
@@ -0,0 +1,167 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package evm
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/sha3"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+// dynamicFeeTxType is the EIP-2718 type of EIP-1559 transactions
+const dynamicFeeTxType = 0x02
+
+// DynamicFeeTx is an EIP-1559 transaction without access list
+type DynamicFeeTx struct {
+	ChainID   *big.Int
+	Nonce     uint64
+	GasTipCap *big.Int
+	GasFeeCap *big.Int
+	Gas       uint64
+	To        Address
+	Value     *big.Int
+	Data      []byte
+}
+
+func newDynamicFeeTx(params TxParams, to Address, value *big.Int, data []byte) (*DynamicFeeTx, error) {
+	switch {
+	case params.ChainID == nil || params.ChainID.Sign() <= 0:
+		return nil, errors.New("the chain id is required")
+	case params.GasFeeCap == nil || params.GasTipCap == nil:
+		return nil, errors.New("the fee caps are required")
+	case params.GasTipCap.Sign() < 0 || params.GasTipCap.Cmp(params.GasFeeCap) > 0:
+		return nil, errors.New("the tip cap should be between 0 and the fee cap")
+	case params.Gas == 0:
+		return nil, errors.New("the gas limit is required")
+	}
+
+	return &DynamicFeeTx{
+		ChainID:   new(big.Int).Set(params.ChainID),
+		Nonce:     params.Nonce,
+		GasTipCap: new(big.Int).Set(params.GasTipCap),
+		GasFeeCap: new(big.Int).Set(params.GasFeeCap),
+		Gas:       params.Gas,
+		To:        to,
+		Value:     value,
+		Data:      data,
+	}, nil
+}
+
+// fields returns the RLP encoded fields of the transaction before the signature
+func (tx *DynamicFeeTx) fields() [][]byte {
+	return [][]byte{
+		rlpInt(tx.ChainID),
+		rlpInt(new(big.Int).SetUint64(tx.Nonce)),
+		rlpInt(tx.GasTipCap),
+		rlpInt(tx.GasFeeCap),
+		rlpInt(new(big.Int).SetUint64(tx.Gas)),
+		rlpString(tx.To[:]),
+		rlpInt(tx.Value),
+		rlpString(tx.Data),
+		// Empty access list
+		rlpList(),
+	}
+}
+
+// UnsignedBytes returns 0x02 || rlp([chain_id, nonce, tip_cap, fee_cap, gas, to, value, data, access_list]),
+// the payload signed by LedgerEthereum.SignTransaction
+func (tx *DynamicFeeTx) UnsignedBytes() []byte {
+	return append([]byte{dynamicFeeTxType}, rlpList(tx.fields()...)...)
+}
+
+// TxSigner is the part of LedgerEthereum used to sign transactions
+type TxSigner interface {
+	SignTransaction(bip32Path []uint32, rawTx []byte) (*ledger.EthereumSignature, error)
+}
+
+// SignedTx is a transaction ready to be broadcast with eth_sendRawTransaction
+type SignedTx struct {
+	Raw  []byte
+	Hash string
+	From string
+}
+
+// Sign signs the transaction with the key of a path and returns the signed transaction
+// this command requires user confirmation in the device
+func (tx *DynamicFeeTx) Sign(signer TxSigner, bip32Path []uint32) (*SignedTx, error) {
+	sig, err := signer.SignTransaction(bip32Path, tx.UnsignedBytes())
+	if err != nil {
+		return nil, err
+	}
+	return tx.WithSignature(sig)
+}
+
+// WithSignature appends the y parity, r and s to the transaction
+func (tx *DynamicFeeTx) WithSignature(sig *ledger.EthereumSignature) (*SignedTx, error) {
+	if sig.YParity > 1 || len(sig.R) != 32 || len(sig.S) != 32 {
+		return nil, errors.New("invalid signature")
+	}
+
+	fields := append(tx.fields(),
+		rlpInt(big.NewInt(int64(sig.YParity))),
+		rlpInt(new(big.Int).SetBytes(sig.R)),
+		rlpInt(new(big.Int).SetBytes(sig.S)),
+	)
+	raw := append([]byte{dynamicFeeTxType}, rlpList(fields...)...)
+
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write(raw)
+	return &SignedTx{Raw: raw, Hash: "0x" + hex.EncodeToString(hasher.Sum(nil)), From: sig.Address}, nil
+}
+
+// String returns the raw transaction as 0x prefixed hex
+func (tx *SignedTx) String() string {
+	return fmt.Sprintf("0x%x", tx.Raw)
+}
+
+// rlpString encodes a byte string
+func rlpString(b []byte) []byte {
+	if len(b) == 1 && b[0] < 0x80 {
+		return []byte{b[0]}
+	}
+	return append(rlpHeader(0x80, len(b)), b...)
+}
+
+// rlpInt encodes an integer as a byte string without leading zeros
+func rlpInt(n *big.Int) []byte {
+	if n == nil {
+		return rlpString(nil)
+	}
+	return rlpString(n.Bytes())
+}
+
+// rlpList encodes a list of encoded items
+func rlpList(items ...[]byte) []byte {
+	var content []byte
+	for _, item := range items {
+		content = append(content, item...)
+	}
+	return append(rlpHeader(0xC0, len(content)), content...)
+}
+
+func rlpHeader(offset byte, size int) []byte {
+	if size <= 55 {
+		return []byte{offset + byte(size)}
+	}
+	n := big.NewInt(int64(size)).Bytes()
+	return append([]byte{offset + 55 + byte(len(n))}, n...)
+}
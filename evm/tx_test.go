@@ -0,0 +1,111 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package evm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/sha3"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+// keySigner signs like LedgerEthereum.SignTransaction with an in-memory key
+type keySigner struct {
+	key    *btcec.PrivateKey
+	signed []byte
+}
+
+func (s *keySigner) SignTransaction(bip32Path []uint32, rawTx []byte) (*ledger.EthereumSignature, error) {
+	s.signed = rawTx
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write(rawTx)
+	compact, err := ecdsa.SignCompact(s.key, hasher.Sum(nil), false)
+	if err != nil {
+		return nil, err
+	}
+	address, err := ledger.EthAddressFromPubKey(s.key.PubKey().SerializeUncompressed())
+	if err != nil {
+		return nil, err
+	}
+	parity := compact[0] - 27
+	return &ledger.EthereumSignature{
+		V:       big.NewInt(int64(parity)),
+		R:       compact[1:33],
+		S:       compact[33:],
+		YParity: parity,
+		Address: address,
+	}, nil
+}
+
+func Test_DynamicFeeTx_UnsignedBytes(t *testing.T) {
+	tx := &DynamicFeeTx{
+		ChainID:   big.NewInt(1),
+		GasTipCap: big.NewInt(0),
+		GasFeeCap: big.NewInt(0),
+		Gas:       21000,
+		To:        mustParseAddress("0x1111111111111111111111111111111111111111"),
+		Value:     big.NewInt(0),
+	}
+	expected := "02df0180808082520894" + strings.Repeat("11", 20) + "8080c0"
+	assert.Equal(t, expected, hex.EncodeToString(tx.UnsignedBytes()))
+
+	// Long calldata uses the long list and string headers
+	tx.Data = bytes.Repeat([]byte{0xAA}, 100)
+	unsigned := tx.UnsignedBytes()
+	assert.Equal(t, []byte{0x02, 0xF8, 0x84}, unsigned[:3])
+	assert.Len(t, unsigned, 3+0x84)
+}
+
+func Test_DynamicFeeTx_Sign(t *testing.T) {
+	key, err := btcec.NewPrivateKey()
+	require.Nil(t, err)
+	signer := &keySigner{key: key}
+
+	tx, err := BuildDeposit(testParams, Deposit{
+		Router: testRouter,
+		Vault:  testVault,
+		Amount: big.NewInt(5e16),
+		Memo:   "=:BTC.BTC:bc1qxy2kgdygjrsqtzq2n0yrf2493p83kkfjhx0wlh",
+	})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	signed, err := tx.Sign(signer, []uint32{44, 60, 0, 0, 0})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, tx.UnsignedBytes(), signer.signed)
+
+	address, err := ledger.EthAddressFromPubKey(key.PubKey().SerializeUncompressed())
+	require.Nil(t, err)
+	assert.Equal(t, address, signed.From)
+	assert.True(t, strings.HasPrefix(signed.String(), "0x02f9"))
+	assert.Len(t, signed.Hash, 66)
+
+	// The signed transaction is the unsigned one with three more fields
+	unsignedContent := tx.UnsignedBytes()[4:]
+	assert.True(t, bytes.Contains(signed.Raw, unsignedContent))
+
+	_, err = tx.WithSignature(&ledger.EthereumSignature{YParity: 2})
+	assert.EqualError(t, err, "invalid signature")
+}
@@ -0,0 +1,388 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+// Package grpcsigner serves the THORChain and Tendermint validator apps of a Ledger over gRPC.
+// The service is defined in signer.proto.
+package grpcsigner
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+const defaultStatusInterval = 5 * time.Second
+
+// UserApp is the part of LedgerTHORChain used by the server
+type UserApp interface {
+	GetVersion() (*ledger.VersionInfo, error)
+	GetPublicKeySECP256K1(bip32Path []uint32) ([]byte, error)
+	GetAddressPubKeySECP256K1(bip32Path []uint32, hrp string) ([]byte, string, error)
+	SignSECP256K1(bip32Path []uint32, transaction []byte, p2 byte) ([]byte, error)
+}
+
+// ValidatorApp is the part of LedgerTendermintValidator used by the server
+type ValidatorApp interface {
+	GetVersion() (*ledger.VersionInfo, error)
+	GetPublicKeyED25519(bip32Path []uint32) ([]byte, error)
+	SignED25519(bip32Path []uint32, message []byte) ([]byte, error)
+}
+
+// Config contains the settings of the server
+type Config struct {
+	// User is the THORChain app. Its methods fail with FailedPrecondition if nil
+	User UserApp
+	// Validator is the validator app. Its methods fail with FailedPrecondition if nil
+	Validator ValidatorApp
+	// SignStateFile keeps the double sign watermark of the validator app. It is required with Validator
+	SignStateFile string
+	// Token must be sent by the clients in the authorization metadata as "Bearer <token>".
+	// See TokenCredentials
+	Token string
+	// Network selects the address prefix. Mainnet is used if empty
+	Network ledger.Network
+	// StatusInterval is the delay between two status checks of WatchDeviceStatus
+	StatusInterval time.Duration
+}
+
+// Server implements SignerServer. Device operations are serialized, and every call
+// must be authenticated with the configured token
+type Server struct {
+	UnimplementedSignerServer
+
+	cfg   Config
+	guard *ledger.DoubleSignGuard
+	// device is held while a call uses the device. It is a channel so that waiting
+	// for it stops when the call is cancelled
+	device chan struct{}
+}
+
+// NewServer creates a server for the configured apps
+func NewServer(cfg Config) (*Server, error) {
+	if cfg.User == nil && cfg.Validator == nil {
+		return nil, errors.New("at least one app is required")
+	}
+	if cfg.Token == "" {
+		return nil, errors.New("an authentication token is required")
+	}
+	if cfg.Network.Name == "" {
+		cfg.Network = ledger.Mainnet
+	}
+	if cfg.StatusInterval == 0 {
+		cfg.StatusInterval = defaultStatusInterval
+	}
+
+	s := &Server{cfg: cfg, device: make(chan struct{}, 1)}
+	if cfg.Validator != nil {
+		if cfg.SignStateFile == "" {
+			return nil, errors.New("a sign state file is required to serve the validator app")
+		}
+		guard, err := ledger.NewDoubleSignGuard(cfg.Validator, cfg.SignStateFile)
+		if err != nil {
+			return nil, err
+		}
+		s.guard = guard
+	}
+	return s, nil
+}
+
+func (s *Server) GetVersion(ctx context.Context, req *GetVersionRequest) (*GetVersionResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	version, err := s.version(ctx, req.App)
+	if err != nil {
+		return nil, err
+	}
+	return &GetVersionResponse{
+		AppMode: uint32(version.AppMode),
+		Major:   uint32(version.Major),
+		Minor:   uint32(version.Minor),
+		Patch:   uint32(version.Patch),
+	}, nil
+}
+
+func (s *Server) GetPublicKey(ctx context.Context, req *GetPublicKeyRequest) (*GetPublicKeyResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	var getPublicKey func([]uint32) ([]byte, error)
+	switch req.App {
+	case App_APP_THORCHAIN:
+		if s.cfg.User == nil {
+			return nil, errNotConfigured(req.App)
+		}
+		getPublicKey = s.cfg.User.GetPublicKeySECP256K1
+	case App_APP_VALIDATOR:
+		if s.cfg.Validator == nil {
+			return nil, errNotConfigured(req.App)
+		}
+		getPublicKey = s.cfg.Validator.GetPublicKeyED25519
+	default:
+		return nil, errUnsupportedApp(req.App)
+	}
+
+	if err := s.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.unlock()
+
+	pubKey, err := getPublicKey(req.Path)
+	if err != nil {
+		return nil, deviceError(err)
+	}
+	return &GetPublicKeyResponse{PublicKey: pubKey}, nil
+}
+
+func (s *Server) GetAddress(ctx context.Context, req *GetAddressRequest) (*GetAddressResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if s.cfg.User == nil {
+		return nil, errNotConfigured(App_APP_THORCHAIN)
+	}
+
+	if err := s.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.unlock()
+
+	var pubKey []byte
+	var address string
+	var err error
+	if req.Show {
+		pubKey, address, err = s.cfg.User.GetAddressPubKeySECP256K1(req.Path, s.cfg.Network.AccountHRP)
+	} else {
+		pubKey, err = s.cfg.User.GetPublicKeySECP256K1(req.Path)
+		if err == nil {
+			address, err = ledger.AddressFromPubKey(s.cfg.Network.AccountHRP, pubKey)
+		}
+	}
+	if err != nil {
+		return nil, deviceError(err)
+	}
+	return &GetAddressResponse{Address: address, PublicKey: pubKey}, nil
+}
+
+func (s *Server) Sign(ctx context.Context, req *SignRequest) (*SignResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if s.cfg.User == nil {
+		return nil, errNotConfigured(App_APP_THORCHAIN)
+	}
+
+	var mode ledger.SignMode
+	switch req.SignMode {
+	case SignMode_SIGN_MODE_AMINO:
+		mode = ledger.SignModeLegacyAmino
+	case SignMode_SIGN_MODE_TEXTUAL:
+		mode = ledger.SignModeTextual
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown sign mode %d", req.SignMode)
+	}
+	if len(req.SignDoc) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "sign doc is empty")
+	}
+
+	if err := s.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.unlock()
+
+	pubKey, err := s.cfg.User.GetPublicKeySECP256K1(req.Path)
+	if err != nil {
+		return nil, deviceError(err)
+	}
+	der, err := s.cfg.User.SignSECP256K1(req.Path, req.SignDoc, byte(mode))
+	if err != nil {
+		return nil, deviceError(err)
+	}
+	compact, err := ledger.SignatureToCompact(der)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &SignResponse{Signature: compact, SignatureDer: der, PublicKey: pubKey}, nil
+}
+
+// SignED25519 signs a vote or proposal. The message goes through a DoubleSignGuard,
+// which refuses anything else and any conflict with the last signed message
+func (s *Server) SignED25519(ctx context.Context, req *SignED25519Request) (*SignED25519Response, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if s.cfg.Validator == nil {
+		return nil, errNotConfigured(App_APP_VALIDATOR)
+	}
+	if _, err := ledger.DecodeSignBytes(req.Message); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := s.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.unlock()
+
+	signature, err := s.guard.SignED25519(req.Path, req.Message)
+	var doubleSign *ledger.DoubleSignError
+	if errors.As(err, &doubleSign) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return nil, deviceError(err)
+	}
+	return &SignED25519Response{Signature: signature}, nil
+}
+
+// WatchDeviceStatus polls the configured apps and sends their status when it changes
+func (s *Server) WatchDeviceStatus(req *WatchDeviceStatusRequest, stream Signer_WatchDeviceStatusServer) error {
+	ctx := stream.Context()
+	if err := s.authorize(ctx); err != nil {
+		return err
+	}
+
+	var apps []App
+	if s.cfg.User != nil {
+		apps = append(apps, App_APP_THORCHAIN)
+	}
+	if s.cfg.Validator != nil {
+		apps = append(apps, App_APP_VALIDATOR)
+	}
+
+	last := map[App]*DeviceStatusEvent{}
+	ticker := time.NewTicker(s.cfg.StatusInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, app := range apps {
+			event, err := s.status(ctx, app)
+			if err != nil {
+				return nil
+			}
+			if prev, ok := last[app]; ok && proto.Equal(prev, event) {
+				continue
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+			last[app] = event
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// status returns the status of an app. An error is only returned if ctx is done
+func (s *Server) status(ctx context.Context, app App) (*DeviceStatusEvent, error) {
+	event := &DeviceStatusEvent{App: app}
+	version, err := s.version(ctx, app)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		event.Status = DeviceStatus_DEVICE_STATUS_DISCONNECTED
+		event.Error = status.Convert(err).Message()
+		return event, nil
+	}
+	event.Status = DeviceStatus_DEVICE_STATUS_CONNECTED
+	event.Version = version.String()
+	return event, nil
+}
+
+func (s *Server) version(ctx context.Context, app App) (*ledger.VersionInfo, error) {
+	var getVersion func() (*ledger.VersionInfo, error)
+	switch app {
+	case App_APP_THORCHAIN:
+		if s.cfg.User == nil {
+			return nil, errNotConfigured(app)
+		}
+		getVersion = s.cfg.User.GetVersion
+	case App_APP_VALIDATOR:
+		if s.cfg.Validator == nil {
+			return nil, errNotConfigured(app)
+		}
+		getVersion = s.cfg.Validator.GetVersion
+	default:
+		return nil, errUnsupportedApp(app)
+	}
+
+	if err := s.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.unlock()
+
+	version, err := getVersion()
+	if err != nil {
+		return nil, deviceError(err)
+	}
+	return version, nil
+}
+
+// lock waits for the device, unless ctx is done first
+func (s *Server) lock(ctx context.Context) error {
+	select {
+	case s.device <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+func (s *Server) unlock() {
+	<-s.device
+}
+
+// authorize checks the bearer token of the authorization metadata
+func (s *Server) authorize(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get(authorizationKey) {
+		if !strings.HasPrefix(value, bearerPrefix) {
+			continue
+		}
+		token := strings.TrimPrefix(value, bearerPrefix)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.Token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+}
+
+func errNotConfigured(app App) error {
+	return status.Errorf(codes.FailedPrecondition, "the %s app is not served", app)
+}
+
+func errUnsupportedApp(app App) error {
+	return status.Errorf(codes.InvalidArgument, "unsupported app %s", app)
+}
+
+// deviceError reports an error returned by the device, which includes rejections by the user
+func deviceError(err error) error {
+	return status.Error(codes.Aborted, err.Error())
+}
@@ -0,0 +1,351 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package grpcsigner
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+var (
+	userPath      = []uint32{44, 931, 0, 0, 0}
+	validatorPath = []uint32{44, 118, 0, 0, 0}
+)
+
+const testToken = "secret"
+
+// emulatedDevice runs both apps with in-memory keys. Every call fails while disconnected is set
+type emulatedDevice struct {
+	secp256k1 *btcec.PrivateKey
+	ed25519   ed25519.PrivateKey
+
+	mtx          sync.Mutex
+	disconnected bool
+	lastMode     byte
+}
+
+func newEmulatedDevice(t *testing.T) *emulatedDevice {
+	secp, err := btcec.NewPrivateKey()
+	require.Nil(t, err)
+	_, ed, err := ed25519.GenerateKey(nil)
+	require.Nil(t, err)
+	return &emulatedDevice{secp256k1: secp, ed25519: ed}
+}
+
+func (d *emulatedDevice) setDisconnected(disconnected bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.disconnected = disconnected
+}
+
+func (d *emulatedDevice) check() error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.disconnected {
+		return errors.New("LedgerHID device (idx 0) not found")
+	}
+	return nil
+}
+
+// emulatedUser is the THORChain app of an emulatedDevice
+type emulatedUser struct {
+	*emulatedDevice
+}
+
+func (u emulatedUser) GetVersion() (*ledger.VersionInfo, error) {
+	if err := u.check(); err != nil {
+		return nil, err
+	}
+	return &ledger.VersionInfo{Major: 2, Minor: 34, Patch: 7}, nil
+}
+
+func (u emulatedUser) GetPublicKeySECP256K1(bip32Path []uint32) ([]byte, error) {
+	if err := u.check(); err != nil {
+		return nil, err
+	}
+	if len(bip32Path) != 5 {
+		return nil, errors.New("path should contain 5 elements")
+	}
+	return u.secp256k1.PubKey().SerializeCompressed(), nil
+}
+
+func (u emulatedUser) GetAddressPubKeySECP256K1(bip32Path []uint32, hrp string) ([]byte, string, error) {
+	pubKey, err := u.GetPublicKeySECP256K1(bip32Path)
+	if err != nil {
+		return nil, "", err
+	}
+	address, err := ledger.AddressFromPubKey(hrp, pubKey)
+	return pubKey, address, err
+}
+
+func (u emulatedUser) SignSECP256K1(bip32Path []uint32, transaction []byte, p2 byte) ([]byte, error) {
+	if err := u.check(); err != nil {
+		return nil, err
+	}
+	u.mtx.Lock()
+	u.lastMode = p2
+	u.mtx.Unlock()
+	hash := sha256.Sum256(transaction)
+	return ecdsa.Sign(u.secp256k1, hash[:]).Serialize(), nil
+}
+
+// emulatedValidator is the validator app of an emulatedDevice
+type emulatedValidator struct {
+	*emulatedDevice
+}
+
+func (v emulatedValidator) GetVersion() (*ledger.VersionInfo, error) {
+	if err := v.check(); err != nil {
+		return nil, err
+	}
+	return &ledger.VersionInfo{Major: 1, Minor: 0, Patch: 1}, nil
+}
+
+func (v emulatedValidator) GetPublicKeyED25519(bip32Path []uint32) ([]byte, error) {
+	if err := v.check(); err != nil {
+		return nil, err
+	}
+	return v.ed25519.Public().(ed25519.PublicKey), nil
+}
+
+func (v emulatedValidator) SignED25519(bip32Path []uint32, message []byte) ([]byte, error) {
+	if err := v.check(); err != nil {
+		return nil, err
+	}
+	return ed25519.Sign(v.ed25519, message), nil
+}
+
+func newTestClient(t *testing.T, cfg Config) SignerClient {
+	return newTestClientToken(t, cfg, testToken)
+}
+
+// newTestClientToken serves cfg and returns a client that sends token with every call
+func newTestClientToken(t *testing.T, cfg Config, token string) SignerClient {
+	cfg.Token = testToken
+	if cfg.Validator != nil {
+		cfg.SignStateFile = filepath.Join(t.TempDir(), "sign_state.json")
+	}
+	server, err := NewServer(cfg)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+	RegisterSignerServer(grpcServer, server)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(TokenCredentials{Token: token, AllowInsecure: true}))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	t.Cleanup(func() { conn.Close() })
+
+	return NewSignerClient(conn)
+}
+
+func Test_Server(t *testing.T) {
+	device := newEmulatedDevice(t)
+	client := newTestClient(t, Config{User: emulatedUser{device}, Validator: emulatedValidator{device}})
+	ctx := context.Background()
+
+	version, err := client.GetVersion(ctx, &GetVersionRequest{App: App_APP_THORCHAIN})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.True(t, proto.Equal(&GetVersionResponse{Major: 2, Minor: 34, Patch: 7}, version))
+
+	version, err = client.GetVersion(ctx, &GetVersionRequest{App: App_APP_VALIDATOR})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, uint32(1), version.Major)
+
+	pubKey := device.secp256k1.PubKey().SerializeCompressed()
+	pk, err := client.GetPublicKey(ctx, &GetPublicKeyRequest{App: App_APP_THORCHAIN, Path: userPath})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, pubKey, pk.PublicKey)
+
+	pk, err = client.GetPublicKey(ctx, &GetPublicKeyRequest{App: App_APP_VALIDATOR, Path: validatorPath})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, []byte(device.ed25519.Public().(ed25519.PublicKey)), pk.PublicKey)
+
+	expected, err := ledger.AddressFromPubKey("thor", pubKey)
+	require.Nil(t, err)
+	addr, err := client.GetAddress(ctx, &GetAddressRequest{Path: userPath, Show: true})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, expected, addr.Address)
+
+	signDoc := []byte(`{"account_number":"1","chain_id":"thorchain-1"}`)
+	sig, err := client.Sign(ctx, &SignRequest{Path: userPath, SignDoc: signDoc, SignMode: SignMode_SIGN_MODE_TEXTUAL})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Len(t, sig.Signature, 64)
+	assert.Equal(t, pubKey, sig.PublicKey)
+	assert.Equal(t, byte(ledger.SignModeTextual), device.lastMode)
+	parsed, err := ecdsa.ParseDERSignature(sig.SignatureDer)
+	require.Nil(t, err)
+	hash := sha256.Sum256(signDoc)
+	assert.True(t, parsed.Verify(hash[:], device.secp256k1.PubKey()))
+
+	vote := ledger.Vote{Type: ledger.PrevoteType, Height: 10, Timestamp: time.Unix(1700000000, 0)}
+	message := ledger.VoteSignBytes("thorchain-1", vote)
+	edSig, err := client.SignED25519(ctx, &SignED25519Request{Path: validatorPath, Message: message})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.True(t, ed25519.Verify(device.ed25519.Public().(ed25519.PublicKey), message, edSig.Signature))
+}
+
+func Test_Server_SignED25519_DoubleSign(t *testing.T) {
+	device := newEmulatedDevice(t)
+	client := newTestClient(t, Config{Validator: emulatedValidator{device}})
+	ctx := context.Background()
+
+	// Arbitrary bytes are never signed with the consensus key
+	_, err := client.SignED25519(ctx, &SignED25519Request{Path: validatorPath, Message: []byte("consensus message")})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	vote := ledger.Vote{Type: ledger.PrecommitType, Height: 10, Timestamp: time.Unix(1700000000, 0)}
+	_, err = client.SignED25519(ctx, &SignED25519Request{Path: validatorPath, Message: ledger.VoteSignBytes("thorchain-1", vote)})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	vote.BlockID = ledger.BlockID{Hash: []byte{1}}
+	_, err = client.SignED25519(ctx, &SignED25519Request{Path: validatorPath, Message: ledger.VoteSignBytes("thorchain-1", vote)})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	vote.Height = 9
+	_, err = client.SignED25519(ctx, &SignED25519Request{Path: validatorPath, Message: ledger.VoteSignBytes("thorchain-1", vote)})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func Test_Server_Authentication(t *testing.T) {
+	device := newEmulatedDevice(t)
+	ctx := context.Background()
+
+	client := newTestClientToken(t, Config{User: emulatedUser{device}}, "wrong")
+	_, err := client.GetVersion(ctx, &GetVersionRequest{App: App_APP_THORCHAIN})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	stream, err := client.WatchDeviceStatus(ctx, &WatchDeviceStatusRequest{})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// The token must come with the Bearer scheme
+	server, err := NewServer(Config{User: emulatedUser{device}, Token: testToken})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	bare := metadata.NewIncomingContext(ctx, metadata.Pairs(authorizationKey, testToken))
+	_, err = server.GetVersion(bare, &GetVersionRequest{App: App_APP_THORCHAIN})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	bearer := metadata.NewIncomingContext(ctx, metadata.Pairs(authorizationKey, "Bearer "+testToken))
+	_, err = server.GetVersion(bearer, &GetVersionRequest{App: App_APP_THORCHAIN})
+	assert.Nil(t, err)
+
+	_, err = NewServer(Config{User: emulatedUser{device}})
+	assert.Error(t, err)
+	_, err = NewServer(Config{Validator: emulatedValidator{device}, Token: testToken})
+	assert.Error(t, err)
+}
+
+func Test_Server_Cancel(t *testing.T) {
+	device := newEmulatedDevice(t)
+	server, err := NewServer(Config{User: emulatedUser{device}, Token: testToken})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(authorizationKey, "Bearer "+testToken))
+
+	// A call waiting for the device returns when it is cancelled
+	require.Nil(t, server.lock(ctx))
+	defer server.unlock()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = server.GetPublicKey(ctx, &GetPublicKeyRequest{App: App_APP_THORCHAIN, Path: userPath})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
+
+func Test_Server_Errors(t *testing.T) {
+	device := newEmulatedDevice(t)
+	client := newTestClient(t, Config{User: emulatedUser{device}})
+	ctx := context.Background()
+
+	_, err := client.SignED25519(ctx, &SignED25519Request{Path: validatorPath, Message: []byte{1}})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = client.GetVersion(ctx, &GetVersionRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Sign(ctx, &SignRequest{Path: userPath, SignDoc: []byte("{}"), SignMode: 7})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.GetPublicKey(ctx, &GetPublicKeyRequest{App: App_APP_THORCHAIN, Path: []uint32{44}})
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "5 elements")
+
+	_, err = NewServer(Config{Token: testToken})
+	assert.Error(t, err)
+}
+
+func Test_WatchDeviceStatus(t *testing.T) {
+	device := newEmulatedDevice(t)
+	client := newTestClient(t, Config{
+		User:           emulatedUser{device},
+		Validator:      emulatedValidator{device},
+		StatusInterval: 10 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.WatchDeviceStatus(ctx, &WatchDeviceStatusRequest{})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	event, err := stream.Recv()
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.True(t, proto.Equal(&DeviceStatusEvent{App: App_APP_THORCHAIN, Status: DeviceStatus_DEVICE_STATUS_CONNECTED, Version: "2.34.7"}, event))
+	event, err = stream.Recv()
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.True(t, proto.Equal(&DeviceStatusEvent{App: App_APP_VALIDATOR, Status: DeviceStatus_DEVICE_STATUS_CONNECTED, Version: "1.0.1"}, event))
+
+	device.setDisconnected(true)
+	for _, app := range []App{App_APP_THORCHAIN, App_APP_VALIDATOR} {
+		event, err = stream.Recv()
+		require.Nil(t, err, "Detected error, err: %s\n", err)
+		assert.Equal(t, app, event.App)
+		assert.Equal(t, DeviceStatus_DEVICE_STATUS_DISCONNECTED, event.Status)
+		assert.Contains(t, event.Error, "not found")
+	}
+
+	device.setDisconnected(false)
+	event, err = stream.Recv()
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, DeviceStatus_DEVICE_STATUS_CONNECTED, event.Status)
+}
@@ -0,0 +1,339 @@
+// Signer exposes a Ledger device running the THORChain and Tendermint validator apps.
+// signer.pb.go and signer_grpc.pb.go are generated from this file, see generate.go.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: signer.proto
+
+package grpcsigner
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Signer_GetVersion_FullMethodName        = "/thorchain.ledger.v1.Signer/GetVersion"
+	Signer_GetPublicKey_FullMethodName      = "/thorchain.ledger.v1.Signer/GetPublicKey"
+	Signer_GetAddress_FullMethodName        = "/thorchain.ledger.v1.Signer/GetAddress"
+	Signer_Sign_FullMethodName              = "/thorchain.ledger.v1.Signer/Sign"
+	Signer_SignED25519_FullMethodName       = "/thorchain.ledger.v1.Signer/SignED25519"
+	Signer_WatchDeviceStatus_FullMethodName = "/thorchain.ledger.v1.Signer/WatchDeviceStatus"
+)
+
+// SignerClient is the client API for Signer service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SignerClient interface {
+	// GetVersion returns the version of an app
+	GetVersion(ctx context.Context, in *GetVersionRequest, opts ...grpc.CallOption) (*GetVersionResponse, error)
+	// GetPublicKey returns the secp256k1 key of the THORChain app or the ed25519 key of the validator app
+	GetPublicKey(ctx context.Context, in *GetPublicKeyRequest, opts ...grpc.CallOption) (*GetPublicKeyResponse, error)
+	// GetAddress returns the bech32 account address of a THORChain app path
+	GetAddress(ctx context.Context, in *GetAddressRequest, opts ...grpc.CallOption) (*GetAddressResponse, error)
+	// Sign signs a sign doc with the THORChain app
+	Sign(ctx context.Context, in *SignRequest, opts ...grpc.CallOption) (*SignResponse, error)
+	// SignED25519 signs the sign bytes of a vote or proposal with the validator app,
+	// after checking them against the double sign watermark
+	SignED25519(ctx context.Context, in *SignED25519Request, opts ...grpc.CallOption) (*SignED25519Response, error)
+	// WatchDeviceStatus sends the status of every configured app, then every change
+	WatchDeviceStatus(ctx context.Context, in *WatchDeviceStatusRequest, opts ...grpc.CallOption) (Signer_WatchDeviceStatusClient, error)
+}
+
+type signerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSignerClient(cc grpc.ClientConnInterface) SignerClient {
+	return &signerClient{cc}
+}
+
+func (c *signerClient) GetVersion(ctx context.Context, in *GetVersionRequest, opts ...grpc.CallOption) (*GetVersionResponse, error) {
+	out := new(GetVersionResponse)
+	err := c.cc.Invoke(ctx, Signer_GetVersion_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signerClient) GetPublicKey(ctx context.Context, in *GetPublicKeyRequest, opts ...grpc.CallOption) (*GetPublicKeyResponse, error) {
+	out := new(GetPublicKeyResponse)
+	err := c.cc.Invoke(ctx, Signer_GetPublicKey_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signerClient) GetAddress(ctx context.Context, in *GetAddressRequest, opts ...grpc.CallOption) (*GetAddressResponse, error) {
+	out := new(GetAddressResponse)
+	err := c.cc.Invoke(ctx, Signer_GetAddress_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signerClient) Sign(ctx context.Context, in *SignRequest, opts ...grpc.CallOption) (*SignResponse, error) {
+	out := new(SignResponse)
+	err := c.cc.Invoke(ctx, Signer_Sign_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signerClient) SignED25519(ctx context.Context, in *SignED25519Request, opts ...grpc.CallOption) (*SignED25519Response, error) {
+	out := new(SignED25519Response)
+	err := c.cc.Invoke(ctx, Signer_SignED25519_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signerClient) WatchDeviceStatus(ctx context.Context, in *WatchDeviceStatusRequest, opts ...grpc.CallOption) (Signer_WatchDeviceStatusClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Signer_ServiceDesc.Streams[0], Signer_WatchDeviceStatus_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &signerWatchDeviceStatusClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Signer_WatchDeviceStatusClient interface {
+	Recv() (*DeviceStatusEvent, error)
+	grpc.ClientStream
+}
+
+type signerWatchDeviceStatusClient struct {
+	grpc.ClientStream
+}
+
+func (x *signerWatchDeviceStatusClient) Recv() (*DeviceStatusEvent, error) {
+	m := new(DeviceStatusEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SignerServer is the server API for Signer service.
+// All implementations must embed UnimplementedSignerServer
+// for forward compatibility
+type SignerServer interface {
+	// GetVersion returns the version of an app
+	GetVersion(context.Context, *GetVersionRequest) (*GetVersionResponse, error)
+	// GetPublicKey returns the secp256k1 key of the THORChain app or the ed25519 key of the validator app
+	GetPublicKey(context.Context, *GetPublicKeyRequest) (*GetPublicKeyResponse, error)
+	// GetAddress returns the bech32 account address of a THORChain app path
+	GetAddress(context.Context, *GetAddressRequest) (*GetAddressResponse, error)
+	// Sign signs a sign doc with the THORChain app
+	Sign(context.Context, *SignRequest) (*SignResponse, error)
+	// SignED25519 signs the sign bytes of a vote or proposal with the validator app,
+	// after checking them against the double sign watermark
+	SignED25519(context.Context, *SignED25519Request) (*SignED25519Response, error)
+	// WatchDeviceStatus sends the status of every configured app, then every change
+	WatchDeviceStatus(*WatchDeviceStatusRequest, Signer_WatchDeviceStatusServer) error
+	mustEmbedUnimplementedSignerServer()
+}
+
+// UnimplementedSignerServer must be embedded to have forward compatible implementations.
+type UnimplementedSignerServer struct {
+}
+
+func (UnimplementedSignerServer) GetVersion(context.Context, *GetVersionRequest) (*GetVersionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetVersion not implemented")
+}
+func (UnimplementedSignerServer) GetPublicKey(context.Context, *GetPublicKeyRequest) (*GetPublicKeyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPublicKey not implemented")
+}
+func (UnimplementedSignerServer) GetAddress(context.Context, *GetAddressRequest) (*GetAddressResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAddress not implemented")
+}
+func (UnimplementedSignerServer) Sign(context.Context, *SignRequest) (*SignResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Sign not implemented")
+}
+func (UnimplementedSignerServer) SignED25519(context.Context, *SignED25519Request) (*SignED25519Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SignED25519 not implemented")
+}
+func (UnimplementedSignerServer) WatchDeviceStatus(*WatchDeviceStatusRequest, Signer_WatchDeviceStatusServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchDeviceStatus not implemented")
+}
+func (UnimplementedSignerServer) mustEmbedUnimplementedSignerServer() {}
+
+// UnsafeSignerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SignerServer will
+// result in compilation errors.
+type UnsafeSignerServer interface {
+	mustEmbedUnimplementedSignerServer()
+}
+
+func RegisterSignerServer(s grpc.ServiceRegistrar, srv SignerServer) {
+	s.RegisterService(&Signer_ServiceDesc, srv)
+}
+
+func _Signer_GetVersion_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetVersionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SignerServer).GetVersion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Signer_GetVersion_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SignerServer).GetVersion(ctx, req.(*GetVersionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Signer_GetPublicKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPublicKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SignerServer).GetPublicKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Signer_GetPublicKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SignerServer).GetPublicKey(ctx, req.(*GetPublicKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Signer_GetAddress_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAddressRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SignerServer).GetAddress(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Signer_GetAddress_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SignerServer).GetAddress(ctx, req.(*GetAddressRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Signer_Sign_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SignRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SignerServer).Sign(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Signer_Sign_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SignerServer).Sign(ctx, req.(*SignRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Signer_SignED25519_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SignED25519Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SignerServer).SignED25519(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Signer_SignED25519_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SignerServer).SignED25519(ctx, req.(*SignED25519Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Signer_WatchDeviceStatus_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchDeviceStatusRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SignerServer).WatchDeviceStatus(m, &signerWatchDeviceStatusServer{stream})
+}
+
+type Signer_WatchDeviceStatusServer interface {
+	Send(*DeviceStatusEvent) error
+	grpc.ServerStream
+}
+
+type signerWatchDeviceStatusServer struct {
+	grpc.ServerStream
+}
+
+func (x *signerWatchDeviceStatusServer) Send(m *DeviceStatusEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Signer_ServiceDesc is the grpc.ServiceDesc for Signer service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Signer_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "thorchain.ledger.v1.Signer",
+	HandlerType: (*SignerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetVersion",
+			Handler:    _Signer_GetVersion_Handler,
+		},
+		{
+			MethodName: "GetPublicKey",
+			Handler:    _Signer_GetPublicKey_Handler,
+		},
+		{
+			MethodName: "GetAddress",
+			Handler:    _Signer_GetAddress_Handler,
+		},
+		{
+			MethodName: "Sign",
+			Handler:    _Signer_Sign_Handler,
+		},
+		{
+			MethodName: "SignED25519",
+			Handler:    _Signer_SignED25519_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchDeviceStatus",
+			Handler:       _Signer_WatchDeviceStatus_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "signer.proto",
+}
@@ -0,0 +1,444 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+// Package httpsigner exposes a THORChain app over HTTP/JSON so that several services
+// can share one Ledger attached to a signing host. Requests are authenticated with
+// bearer tokens, checked by an optional policy and queued for the device one at a time.
+package httpsigner
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+const (
+	defaultTimeout   = 2 * time.Minute
+	defaultQueueSize = 16
+
+	// maxRequestSize is the largest request body accepted, well above the size of a sign doc
+	maxRequestSize = 64 * 1024
+
+	userHardenCount = 3
+
+	bearerPrefix = "Bearer "
+)
+
+// Backend is the part of LedgerTHORChain used by the server
+type Backend interface {
+	GetVersion() (*ledger.VersionInfo, error)
+	GetPublicKeySECP256K1(bip32Path []uint32) ([]byte, error)
+	GetAddressPubKeySECP256K1(bip32Path []uint32, hrp string) ([]byte, string, error)
+	SignSECP256K1(bip32Path []uint32, transaction []byte, p2 byte) ([]byte, error)
+}
+
+// PolicyFunc decides whether a sign doc may be sent to the device. It receives the decoded
+// sign doc, i.e. the JSON of amino mode or the bytes of textual mode. A returned error rejects it
+type PolicyFunc func(ctx context.Context, path []uint32, signDoc []byte, mode ledger.SignMode) error
+
+// Config contains the settings of the server
+type Config struct {
+	// Tokens are the accepted bearer tokens. At least one is required
+	Tokens []string
+	// Network selects the address prefix. Mainnet is used if empty
+	Network ledger.Network
+	// Timeout bounds the time a request waits in the queue and for the device
+	Timeout time.Duration
+	// QueueSize is the number of requests that can wait for the device
+	QueueSize int
+	// Policy is called for every sign request before it is queued
+	Policy PolicyFunc
+}
+
+// The request and response bodies are generated from openapi.yaml in client.gen.go
+
+// httpError is an error with the status code returned to the client
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e httpError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(err error) error {
+	return &httpError{http.StatusBadRequest, err}
+}
+
+// job is a device operation waiting in the queue
+type job struct {
+	ctx  context.Context
+	run  func() (interface{}, error)
+	done chan jobResult
+}
+
+type jobResult struct {
+	value interface{}
+	err   error
+}
+
+// errClosed is returned to the requests still queued when the server is closed
+var errClosed = errors.New("server closed")
+
+// Server is an http.Handler serving the signing API
+type Server struct {
+	backend Backend
+	cfg     Config
+	mux     *http.ServeMux
+
+	// mtx guards closed, so that no job is queued once the worker has stopped
+	mtx    sync.Mutex
+	closed bool
+	jobs   chan *job
+	quit   chan struct{}
+}
+
+// NewServer creates a server and starts the device worker. Close stops it
+func NewServer(backend Backend, cfg Config) (*Server, error) {
+	if len(cfg.Tokens) == 0 {
+		return nil, errors.New("at least one token is required")
+	}
+	for _, token := range cfg.Tokens {
+		if token == "" {
+			return nil, errors.New("empty token")
+		}
+	}
+	if cfg.Network.Name == "" {
+		cfg.Network = ledger.Mainnet
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+
+	s := &Server{
+		backend: backend,
+		cfg:     cfg,
+		mux:     http.NewServeMux(),
+		jobs:    make(chan *job, cfg.QueueSize),
+		quit:    make(chan struct{}),
+	}
+	s.mux.HandleFunc("/v1/version", s.handle(http.MethodGet, s.version))
+	s.mux.HandleFunc("/v1/pubkey", s.handle(http.MethodPost, s.pubKey))
+	s.mux.HandleFunc("/v1/address", s.handle(http.MethodPost, s.address))
+	s.mux.HandleFunc("/v1/sign", s.handle(http.MethodPost, s.sign))
+
+	go s.worker()
+	return s, nil
+}
+
+// Close stops the device worker. The queued requests fail with 503 at once,
+// an operation already sent to the device completes
+func (s *Server) Close() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.quit)
+
+	for {
+		select {
+		case j := <-s.jobs:
+			j.done <- jobResult{err: errClosed}
+		default:
+			return
+		}
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// worker runs the queued operations one at a time, skipping those whose request is gone
+func (s *Server) worker() {
+	for {
+		select {
+		case <-s.quit:
+			return
+		case j := <-s.jobs:
+			select {
+			case <-s.quit:
+				j.done <- jobResult{err: errClosed}
+				return
+			default:
+			}
+			if err := j.ctx.Err(); err != nil {
+				j.done <- jobResult{err: err}
+				continue
+			}
+			value, err := j.run()
+			j.done <- jobResult{value, err}
+		}
+	}
+}
+
+// enqueue waits for a device operation, bounded by the request timeout
+func (s *Server) enqueue(ctx context.Context, run func() (interface{}, error)) (interface{}, error) {
+	j := &job{ctx: ctx, run: run, done: make(chan jobResult, 1)}
+	if err := s.push(j); err != nil {
+		return nil, err
+	}
+
+	select {
+	case res := <-j.done:
+		if res.err != nil && ctx.Err() == nil {
+			return nil, deviceError(res.err)
+		}
+		if res.err != nil {
+			return nil, &httpError{http.StatusGatewayTimeout, errors.New("request timed out")}
+		}
+		return res.value, nil
+	case <-ctx.Done():
+		return nil, &httpError{http.StatusGatewayTimeout, errors.New("request timed out")}
+	}
+}
+
+// push queues a job unless the queue is full or the server is closed
+func (s *Server) push(j *job) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return &httpError{http.StatusServiceUnavailable, errClosed}
+	}
+	select {
+	case s.jobs <- j:
+		return nil
+	default:
+		return &httpError{http.StatusServiceUnavailable, errors.New("too many pending requests")}
+	}
+}
+
+// clientStatusWords are the answers of the app to an invalid request
+var clientStatusWords = map[uint16]bool{
+	0x6700: true, // wrong length
+	0x6984: true, // invalid data, e.g. a sign doc that the app cannot parse
+	0x6A80: true, // invalid parameters
+	0x6B00: true, // invalid P1/P2
+}
+
+// deviceError returns the status of an operation that failed: 400 when the app refused the
+// request, 403 when the user rejected it on the device, and 502 when the device failed
+func deviceError(err error) error {
+	switch sw := ledger.StatusWord(err); {
+	case errors.Is(err, errClosed):
+		return &httpError{http.StatusServiceUnavailable, err}
+	case clientStatusWords[sw]:
+		return badRequest(err)
+	case sw == 0x6986:
+		return &httpError{http.StatusForbidden, err}
+	default:
+		return &httpError{http.StatusBadGateway, err}
+	}
+}
+
+// handle authenticates a request, applies the timeout and writes the JSON response
+func (s *Server) handle(method string, h func(ctx context.Context, r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method not allowed"})
+			return
+		}
+		if !s.authorized(r) {
+			writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "invalid or missing token"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), s.cfg.Timeout)
+		defer cancel()
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+		result, err := h(ctx, r)
+		if err != nil {
+			status := http.StatusInternalServerError
+			var he *httpError
+			if errors.As(err, &he) {
+				status = he.status
+			}
+			writeJSON(w, status, ErrorResponse{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// authorized checks the bearer token of the Authorization header
+func (s *Server) authorized(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, bearerPrefix) {
+		return false
+	}
+	token := strings.TrimPrefix(header, bearerPrefix)
+	valid := 0
+	for _, t := range s.cfg.Tokens {
+		valid |= subtle.ConstantTimeCompare([]byte(token), []byte(t))
+	}
+	return valid == 1
+}
+
+func (s *Server) version(ctx context.Context, r *http.Request) (interface{}, error) {
+	return s.enqueue(ctx, func() (interface{}, error) {
+		version, err := s.backend.GetVersion()
+		if err != nil {
+			return nil, err
+		}
+		return &VersionResponse{Version: version.String(), Debug: version.IsDebug()}, nil
+	})
+}
+
+func (s *Server) pubKey(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req PubKeyRequest
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+	path, err := parsePath(req.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.enqueue(ctx, func() (interface{}, error) {
+		pubKey, err := s.backend.GetPublicKeySECP256K1(path)
+		if err != nil {
+			return nil, err
+		}
+		return &PubKeyResponse{Path: ledger.FormatBip32Path(path, userHardenCount), Pubkey: hex.EncodeToString(pubKey)}, nil
+	})
+}
+
+func (s *Server) address(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req AddressRequest
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+	path, err := parsePath(req.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.enqueue(ctx, func() (interface{}, error) {
+		var pubKey []byte
+		var address string
+		var err error
+		if req.Show != nil && *req.Show {
+			pubKey, address, err = s.backend.GetAddressPubKeySECP256K1(path, s.cfg.Network.AccountHRP)
+		} else {
+			pubKey, err = s.backend.GetPublicKeySECP256K1(path)
+			if err == nil {
+				address, err = ledger.AddressFromPubKey(s.cfg.Network.AccountHRP, pubKey)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &AddressResponse{
+			Path:    ledger.FormatBip32Path(path, userHardenCount),
+			Address: address,
+			Pubkey:  hex.EncodeToString(pubKey),
+		}, nil
+	})
+}
+
+func (s *Server) sign(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req SignRequest
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+	path, err := parsePath(req.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := ledger.SignModeLegacyAmino
+	signDoc := []byte(req.SignDoc)
+	var reqMode SignMode
+	if req.Mode != nil {
+		reqMode = *req.Mode
+	}
+	switch reqMode {
+	case "", SignModeAmino:
+	case SignModeTextual:
+		mode = ledger.SignModeTextual
+		if signDoc, err = base64.StdEncoding.DecodeString(req.SignDoc); err != nil {
+			return nil, badRequest(errors.New("textual sign docs must be base64 encoded"))
+		}
+	default:
+		return nil, badRequest(fmt.Errorf("unknown sign mode %q", reqMode))
+	}
+
+	if s.cfg.Policy != nil {
+		if err := s.cfg.Policy(ctx, path, signDoc, mode); err != nil {
+			return nil, &httpError{http.StatusForbidden, fmt.Errorf("rejected by policy: %w", err)}
+		}
+	}
+
+	return s.enqueue(ctx, func() (interface{}, error) {
+		pubKey, err := s.backend.GetPublicKeySECP256K1(path)
+		if err != nil {
+			return nil, err
+		}
+		der, err := s.backend.SignSECP256K1(path, signDoc, byte(mode))
+		if err != nil {
+			return nil, err
+		}
+		compact, err := ledger.SignatureToCompact(der)
+		if err != nil {
+			return nil, err
+		}
+		return &SignResponse{
+			Pubkey:       hex.EncodeToString(pubKey),
+			Signature:    base64.StdEncoding.EncodeToString(compact),
+			SignatureDer: hex.EncodeToString(der),
+		}, nil
+	})
+}
+
+func parsePath(s string) ([]uint32, error) {
+	path, err := ledger.ParseBip32Path(s, userHardenCount)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	return path, nil
+}
+
+func decodeBody(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return badRequest(fmt.Errorf("invalid request body: %w", err))
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,308 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package httpsigner
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+const (
+	testToken = "secret-token"
+	testPath  = "m/44'/931'/0'/0/0"
+	testDoc   = `{"account_number":"12","chain_id":"thorchain-1","fee":{"amount":[],"gas":"4000000"},"memo":"","msgs":[],"sequence":"3"}`
+)
+
+// keyBackend signs with an in-memory key like the THORChain app. Calls are reported to
+// waiting and block while hold is set, and fail with err if it is set
+type keyBackend struct {
+	key      *btcec.PrivateKey
+	waiting  chan struct{}
+	hold     chan struct{}
+	err      error
+	lastMode byte
+	shown    bool
+}
+
+func (b *keyBackend) wait() error {
+	if b.waiting != nil {
+		b.waiting <- struct{}{}
+	}
+	if b.hold != nil {
+		<-b.hold
+	}
+	return b.err
+}
+
+func (b *keyBackend) GetVersion() (*ledger.VersionInfo, error) {
+	if err := b.wait(); err != nil {
+		return nil, err
+	}
+	return &ledger.VersionInfo{AppMode: 0, Major: 2, Minor: 34, Patch: 7}, nil
+}
+
+func (b *keyBackend) GetPublicKeySECP256K1(bip32Path []uint32) ([]byte, error) {
+	if err := b.wait(); err != nil {
+		return nil, err
+	}
+	return b.key.PubKey().SerializeCompressed(), nil
+}
+
+func (b *keyBackend) GetAddressPubKeySECP256K1(bip32Path []uint32, hrp string) ([]byte, string, error) {
+	if err := b.wait(); err != nil {
+		return nil, "", err
+	}
+	b.shown = true
+	pubKey := b.key.PubKey().SerializeCompressed()
+	address, err := ledger.AddressFromPubKey(hrp, pubKey)
+	return pubKey, address, err
+}
+
+func (b *keyBackend) SignSECP256K1(bip32Path []uint32, transaction []byte, p2 byte) ([]byte, error) {
+	if err := b.wait(); err != nil {
+		return nil, err
+	}
+	b.lastMode = p2
+	hash := sha256.Sum256(transaction)
+	return ecdsa.Sign(b.key, hash[:]).Serialize(), nil
+}
+
+// testServer is a server on a test HTTP server, with a generated client
+type testServer struct {
+	*Server
+	backend *keyBackend
+	url     string
+	client  *ClientWithResponses
+}
+
+func newTestServer(t *testing.T, cfg Config) *testServer {
+	key, err := btcec.NewPrivateKey()
+	require.Nil(t, err)
+	backend := &keyBackend{key: key}
+
+	if cfg.Tokens == nil {
+		cfg.Tokens = []string{testToken}
+	}
+	server, err := NewServer(backend, cfg)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	t.Cleanup(server.Close)
+
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+
+	client, err := NewClientWithResponses(httpServer.URL, WithHTTPClient(httpServer.Client()), WithToken(testToken))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	return &testServer{server, backend, httpServer.URL, client}
+}
+
+// statusCoder is a response of the generated client
+type statusCoder interface {
+	StatusCode() int
+}
+
+func requireStatus(t *testing.T, resp statusCoder, err error, status int) {
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	require.Equal(t, status, resp.StatusCode())
+}
+
+func Test_Server(t *testing.T) {
+	s := newTestServer(t, Config{Network: ledger.Stagenet})
+	ctx := context.Background()
+	pubKey := s.backend.key.PubKey().SerializeCompressed()
+
+	version, err := s.client.GetVersionWithResponse(ctx)
+	requireStatus(t, version, err, http.StatusOK)
+	assert.Equal(t, "2.34.7", version.JSON200.Version)
+
+	pk, err := s.client.GetPubKeyWithResponse(ctx, PubKeyRequest{Path: testPath})
+	requireStatus(t, pk, err, http.StatusOK)
+	assert.Equal(t, hex.EncodeToString(pubKey), pk.JSON200.Pubkey)
+	assert.Equal(t, testPath, pk.JSON200.Path)
+
+	expected, err := ledger.AddressFromPubKey("sthor", pubKey)
+	require.Nil(t, err)
+	addr, err := s.client.GetAddressWithResponse(ctx, AddressRequest{Path: testPath})
+	requireStatus(t, addr, err, http.StatusOK)
+	assert.Equal(t, expected, addr.JSON200.Address)
+	assert.False(t, s.backend.shown)
+
+	show := true
+	addr, err = s.client.GetAddressWithResponse(ctx, AddressRequest{Path: testPath, Show: &show})
+	requireStatus(t, addr, err, http.StatusOK)
+	assert.Equal(t, expected, addr.JSON200.Address)
+	assert.True(t, s.backend.shown)
+
+	sig, err := s.client.SignDocWithResponse(ctx, SignRequest{Path: testPath, SignDoc: testDoc})
+	requireStatus(t, sig, err, http.StatusOK)
+	compact, err := base64.StdEncoding.DecodeString(sig.JSON200.Signature)
+	require.Nil(t, err)
+	require.Len(t, compact, 64)
+	der, err := hex.DecodeString(sig.JSON200.SignatureDer)
+	require.Nil(t, err)
+	parsed, err := ecdsa.ParseDERSignature(der)
+	require.Nil(t, err)
+	hash := sha256.Sum256([]byte(testDoc))
+	assert.True(t, parsed.Verify(hash[:], s.backend.key.PubKey()))
+
+	textual := base64.StdEncoding.EncodeToString([]byte{0xa1, 0x00})
+	mode := SignModeTextual
+	sig, err = s.client.SignDocWithResponse(ctx, SignRequest{Path: testPath, SignDoc: textual, Mode: &mode})
+	requireStatus(t, sig, err, http.StatusOK)
+	assert.Equal(t, byte(ledger.SignModeTextual), s.backend.lastMode)
+}
+
+func Test_Server_Errors(t *testing.T) {
+	s := newTestServer(t, Config{})
+	ctx := context.Background()
+
+	pk, err := s.client.GetPubKeyWithResponse(ctx, PubKeyRequest{Path: "m/44'/931'/0'/0'/0"})
+	requireStatus(t, pk, err, http.StatusBadRequest)
+
+	mode := SignMode("direct")
+	sig, err := s.client.SignDocWithResponse(ctx, SignRequest{Path: testPath, SignDoc: testDoc, Mode: &mode})
+	requireStatus(t, sig, err, http.StatusBadRequest)
+	assert.Contains(t, sig.JSONDefault.Error, "unknown sign mode")
+
+	// The errors of the app are told apart from the failures of the device
+	for sw, status := range map[uint16]int{
+		0x6984: http.StatusBadRequest,
+		0x6986: http.StatusForbidden,
+		0x6F00: http.StatusBadGateway,
+	} {
+		s.backend.err = fmt.Errorf("Error code: %04x", sw)
+		sig, err = s.client.SignDocWithResponse(ctx, SignRequest{Path: testPath, SignDoc: testDoc})
+		requireStatus(t, sig, err, status)
+	}
+	s.backend.err = errors.New("LedgerHID device (idx 0) not found")
+	version, err := s.client.GetVersionWithResponse(ctx)
+	requireStatus(t, version, err, http.StatusBadGateway)
+
+	_, err = NewServer(&keyBackend{}, Config{})
+	assert.Error(t, err)
+}
+
+func Test_Server_Authorization(t *testing.T) {
+	s := newTestServer(t, Config{})
+	ctx := context.Background()
+
+	unauthorized, err := NewClientWithResponses(s.url, WithToken("wrong"))
+	require.Nil(t, err)
+	version, err := unauthorized.GetVersionWithResponse(ctx)
+	requireStatus(t, version, err, http.StatusUnauthorized)
+
+	// The token must come with the Bearer scheme
+	bare, err := NewClientWithResponses(s.url, WithRequestEditorFn(func(ctx context.Context, req *http.Request) error {
+		req.Header.Set("Authorization", testToken)
+		return nil
+	}))
+	require.Nil(t, err)
+	version, err = bare.GetVersionWithResponse(ctx)
+	requireStatus(t, version, err, http.StatusUnauthorized)
+}
+
+func Test_Server_Policy(t *testing.T) {
+	var seenDoc []byte
+	var seenMode ledger.SignMode
+	s := newTestServer(t, Config{
+		Policy: func(ctx context.Context, path []uint32, signDoc []byte, mode ledger.SignMode) error {
+			seenDoc, seenMode = signDoc, mode
+			return errors.New("memo is not allowed")
+		},
+	})
+	ctx := context.Background()
+
+	sig, err := s.client.SignDocWithResponse(ctx, SignRequest{Path: testPath, SignDoc: testDoc})
+	requireStatus(t, sig, err, http.StatusForbidden)
+	assert.Contains(t, sig.JSONDefault.Error, "memo is not allowed")
+	assert.Equal(t, []byte(testDoc), seenDoc)
+	assert.Equal(t, ledger.SignModeLegacyAmino, seenMode)
+
+	// Textual sign docs are decoded before the policy sees them
+	mode := SignModeTextual
+	textual := base64.StdEncoding.EncodeToString([]byte{0xa1, 0x00})
+	sig, err = s.client.SignDocWithResponse(ctx, SignRequest{Path: testPath, SignDoc: textual, Mode: &mode})
+	requireStatus(t, sig, err, http.StatusForbidden)
+	assert.Equal(t, []byte{0xa1, 0x00}, seenDoc)
+	assert.Equal(t, ledger.SignModeTextual, seenMode)
+}
+
+func Test_Server_QueueAndTimeout(t *testing.T) {
+	s := newTestServer(t, Config{QueueSize: 1, Timeout: 200 * time.Millisecond})
+	s.backend.hold = make(chan struct{})
+	ctx := context.Background()
+
+	// The first request keeps the device busy and the second one fills the queue
+	first := make(chan *GetVersionResponse, 1)
+	go func() {
+		resp, _ := s.client.GetVersionWithResponse(ctx)
+		first <- resp
+	}()
+	require.Eventually(t, func() bool {
+		resp, err := s.client.GetVersionWithResponse(ctx)
+		return err == nil && resp.StatusCode() == http.StatusServiceUnavailable
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, http.StatusGatewayTimeout, (<-first).StatusCode())
+	close(s.backend.hold)
+
+	version, err := s.client.GetVersionWithResponse(ctx)
+	requireStatus(t, version, err, http.StatusOK)
+}
+
+func Test_Server_Close(t *testing.T) {
+	s := newTestServer(t, Config{QueueSize: 2, Timeout: time.Minute})
+	s.backend.waiting = make(chan struct{}, 1)
+	s.backend.hold = make(chan struct{})
+	ctx := context.Background()
+
+	// The first request keeps the device busy, the second one waits in the queue
+	results := make(chan int, 2)
+	request := func() {
+		resp, err := s.client.GetVersionWithResponse(ctx)
+		if assert.Nil(t, err) {
+			results <- resp.StatusCode()
+		}
+	}
+	go request()
+	<-s.backend.waiting
+	go request()
+	require.Eventually(t, func() bool { return len(s.jobs) == 1 }, time.Second, time.Millisecond)
+
+	// Closing fails the queued request at once, the running one completes
+	s.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, <-results)
+	close(s.backend.hold)
+	assert.Equal(t, http.StatusOK, <-results)
+
+	version, err := s.client.GetVersionWithResponse(ctx)
+	requireStatus(t, version, err, http.StatusServiceUnavailable)
+}
@@ -0,0 +1,154 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Actions of THORChain memos
+const (
+	MemoSwap      = "SWAP"
+	MemoAdd       = "ADD"
+	MemoWithdraw  = "WITHDRAW"
+	MemoDonate    = "DONATE"
+	MemoBond      = "BOND"
+	MemoUnbond    = "UNBOND"
+	MemoLeave     = "LEAVE"
+	MemoReserve   = "RESERVE"
+	MemoLoanOpen  = "LOAN+"
+	MemoLoanRepay = "LOAN-"
+)
+
+// memoActions maps the lower case names and shorthands of memo actions to the action
+var memoActions = map[string]string{
+	"swap": MemoSwap, "s": MemoSwap, "=": MemoSwap,
+	"add": MemoAdd, "a": MemoAdd, "+": MemoAdd,
+	"withdraw": MemoWithdraw, "wd": MemoWithdraw, "-": MemoWithdraw,
+	"donate": MemoDonate, "d": MemoDonate,
+	"bond":    MemoBond,
+	"unbond":  MemoUnbond,
+	"leave":   MemoLeave,
+	"reserve": MemoReserve,
+	"loan+":   MemoLoanOpen, "$+": MemoLoanOpen,
+	"loan-": MemoLoanRepay, "$-": MemoLoanRepay,
+}
+
+// memoShorthands are the shortest forms of the actions
+var memoShorthands = map[string]string{
+	MemoSwap: "=", MemoAdd: "+", MemoWithdraw: "-", MemoDonate: "d", MemoLoanOpen: "$+", MemoLoanRepay: "$-",
+}
+
+// assetShortcodes are the single letter forms of the native assets accepted in memos
+var assetShortcodes = map[string]string{
+	"THOR.RUNE": "r", "BTC.BTC": "b", "ETH.ETH": "e", "GAIA.ATOM": "g",
+	"DOGE.DOGE": "d", "LTC.LTC": "l", "BCH.BCH": "c", "AVAX.AVAX": "a",
+}
+
+// Memo is a THORChain memo split in its fields
+type Memo struct {
+	// Action is one of the Memo constants, or the first field in upper case if it is not known
+	Action string
+	Fields []string
+	Raw    string
+}
+
+// ParseMemo splits a memo such as "=:BTC.BTC:bc1q...:1000" and resolves the action shorthand
+func ParseMemo(memo string) Memo {
+	fields := strings.Split(memo, ":")
+	action, ok := memoActions[strings.ToLower(strings.TrimSpace(fields[0]))]
+	if !ok {
+		action = strings.ToUpper(strings.TrimSpace(fields[0]))
+	}
+	return Memo{Action: action, Fields: fields, Raw: memo}
+}
+
+// IsKnown returns true if the action of the memo is one of the Memo constants
+func (m Memo) IsKnown() bool {
+	_, ok := memoActions[strings.ToLower(m.Action)]
+	return ok
+}
+
+// Field returns the field at index i, or an empty string
+func (m Memo) Field(i int) string {
+	if i < len(m.Fields) {
+		return m.Fields[i]
+	}
+	return ""
+}
+
+// Asset returns the asset or pool of swap, liquidity and loan memos
+func (m Memo) Asset() string {
+	switch m.Action {
+	case MemoSwap, MemoAdd, MemoWithdraw, MemoDonate, MemoLoanOpen, MemoLoanRepay:
+		return m.Field(1)
+	}
+	return ""
+}
+
+// Destination returns the address that receives the output of swap and loan memos
+func (m Memo) Destination() string {
+	switch m.Action {
+	case MemoSwap, MemoLoanOpen:
+		return m.Field(2)
+	}
+	return ""
+}
+
+// AbbreviateMemo returns the shortest equivalent form of a memo: the action shorthand, the asset
+// shortcode and the swap limit in scientific notation. Unknown memos are returned unchanged
+func AbbreviateMemo(memo string) string {
+	m := ParseMemo(memo)
+	if !m.IsKnown() {
+		return memo
+	}
+
+	fields := append([]string{}, m.Fields...)
+	if short, ok := memoShorthands[m.Action]; ok {
+		fields[0] = short
+	}
+	if code, ok := assetShortcodes[strings.ToUpper(m.Asset())]; ok {
+		fields[1] = code
+	}
+	if m.Action == MemoSwap && len(fields) > 3 {
+		// The limit may be followed by the streaming interval and quantity
+		parts := strings.Split(fields[3], "/")
+		parts[0] = abbreviateAmount(parts[0])
+		fields[3] = strings.Join(parts, "/")
+	}
+	return strings.Join(fields, ":")
+}
+
+// abbreviateAmount writes the trailing zeros of an integer as an exponent, such as 15e9, when shorter
+func abbreviateAmount(amount string) string {
+	for _, c := range amount {
+		if c < '0' || c > '9' {
+			return amount
+		}
+	}
+
+	digits := strings.TrimRight(amount, "0")
+	if digits == "" {
+		return amount
+	}
+	short := fmt.Sprintf("%se%d", digits, len(amount)-len(digits))
+	if len(short) < len(amount) {
+		return short
+	}
+	return amount
+}
@@ -0,0 +1,74 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseMemo(t *testing.T) {
+	tests := []struct {
+		memo        string
+		action      string
+		asset       string
+		destination string
+	}{
+		{"SWAP:BTC.BTC:bc1qdest:1000", MemoSwap, "BTC.BTC", "bc1qdest"},
+		{"=:ETH.ETH:0xdest", MemoSwap, "ETH.ETH", "0xdest"},
+		{"s:BTC.BTC", MemoSwap, "BTC.BTC", ""},
+		{"+:BTC.BTC:bc1qpaired", MemoAdd, "BTC.BTC", ""},
+		{"wd:BTC.BTC:10000", MemoWithdraw, "BTC.BTC", ""},
+		{"$+:BTC.BTC:bc1qdest", MemoLoanOpen, "BTC.BTC", "bc1qdest"},
+		{"BOND:thor1node", MemoBond, "", ""},
+		{"custom:thing", "CUSTOM", "", ""},
+	}
+
+	for _, tc := range tests {
+		memo := ParseMemo(tc.memo)
+		assert.Equal(t, tc.action, memo.Action, tc.memo)
+		assert.Equal(t, tc.asset, memo.Asset(), tc.memo)
+		assert.Equal(t, tc.destination, memo.Destination(), tc.memo)
+		assert.Equal(t, tc.memo, memo.Raw)
+	}
+
+	assert.True(t, ParseMemo("=:BTC.BTC").IsKnown())
+	assert.False(t, ParseMemo("custom").IsKnown())
+	assert.Equal(t, "", ParseMemo("").Action)
+}
+
+func Test_AbbreviateMemo(t *testing.T) {
+	tests := []struct {
+		memo     string
+		expected string
+	}{
+		{"SWAP:BTC.BTC:bc1qdest:1500000000", "=:b:bc1qdest:15e8"},
+		{"swap:eth.eth:0xdest:123456/3/0:t:15", "=:e:0xdest:123456/3/0:t:15"},
+		{"=:ETH.USDC-0XA0B86991C6218B36C1D19D4A2E9EB0CE3606EB48:0xdest:1000", "=:ETH.USDC-0XA0B86991C6218B36C1D19D4A2E9EB0CE3606EB48:0xdest:1e3"},
+		{"ADD:BTC.BTC:thor1paired", "+:b:thor1paired"},
+		{"WITHDRAW:DOGE.DOGE:10000", "-:d:10000"},
+		{"LOAN+:BTC.BTC:thor1dest:100000", "$+:b:thor1dest:100000"},
+		{"=:GAIA.ATOM:cosmos1dest:100", "=:g:cosmos1dest:100"},
+		{"BOND:thor1node", "BOND:thor1node"},
+		{"custom:BTC.BTC", "custom:BTC.BTC"},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.expected, AbbreviateMemo(tc.memo), tc.memo)
+	}
+}
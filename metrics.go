@@ -0,0 +1,116 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zondax/ledger-go"
+)
+
+const (
+	// StatusWordOK is the status word of a successful command
+	StatusWordOK = 0x9000
+	// StatusWordNone is reported when the device did not answer, e.g. it was unplugged
+	StatusWordNone = 0
+	// StatusWordRejected is the status word of a request rejected in the device
+	StatusWordRejected = 0x6986
+)
+
+// Metrics receives measurements of the device operations. The app label is
+// AuditAppTHORChain or AuditAppValidator. Implementations must be safe for concurrent use.
+// The prommetrics package exports them to Prometheus
+type Metrics interface {
+	// APDUExchanged counts a command sent to the device by instruction and status word
+	APDUExchanged(cla byte, ins byte, statusWord uint16)
+	// ConfirmationWaited observes the time spent waiting for the user to approve or reject a signature
+	ConfirmationWaited(elapsed time.Duration)
+	// SignRejected counts sign requests rejected in the device
+	SignRejected(app string)
+	// SignPayloadSize observes the size of the payloads sent to be signed
+	SignPayloadSize(app string, size int)
+	// Reconnected counts the connections opened again to the device
+	Reconnected()
+}
+
+// knownStatusWords are the status words that the transport reports by name
+var knownStatusWords = []uint16{
+	0x6400, 0x6700, 0x6982, 0x6983, 0x6984, 0x6985, 0x6986,
+	0x6A80, 0x6B00, 0x6D00, 0x6E00, 0x6E01, 0x6F00, 0x6F01,
+}
+
+// statusWordByMessage maps the error messages of the transport back to the status words
+var statusWordByMessage = func() map[string]uint16 {
+	messages := make(map[string]uint16, len(knownStatusWords))
+	for _, sw := range knownStatusWords {
+		messages[ledger_go.ErrorMessage(sw)] = sw
+	}
+	return messages
+}()
+
+// StatusWord returns the status word of the answer that produced err.
+// StatusWordNone is returned for errors that are not a status word
+func StatusWord(err error) uint16 {
+	if err == nil {
+		return StatusWordOK
+	}
+	if sw, ok := statusWordByMessage[err.Error()]; ok {
+		return sw
+	}
+	var sw uint16
+	if _, scanErr := fmt.Sscanf(err.Error(), "Error code: %04x", &sw); scanErr == nil {
+		return sw
+	}
+	return StatusWordNone
+}
+
+// meteredDevice counts the commands exchanged with the device
+type meteredDevice struct {
+	ledger_go.LedgerDevice
+	metrics Metrics
+}
+
+func (d *meteredDevice) Exchange(command []byte) ([]byte, error) {
+	response, err := d.LedgerDevice.Exchange(command)
+	if len(command) >= 2 {
+		d.metrics.APDUExchanged(command[0], command[1], StatusWord(err))
+	}
+	return response, err
+}
+
+// meterDevice wraps device so its commands are counted. A nil metrics removes the wrapper
+func meterDevice(device ledger_go.LedgerDevice, metrics Metrics) ledger_go.LedgerDevice {
+	if metered, ok := device.(*meteredDevice); ok {
+		device = metered.LedgerDevice
+	}
+	if metrics == nil || device == nil {
+		return device
+	}
+	return &meteredDevice{LedgerDevice: device, metrics: metrics}
+}
+
+// observeSign reports the size of a sign request and whether it was rejected in the device
+func observeSign(metrics Metrics, app string, payload []byte, signErr error) {
+	if metrics == nil {
+		return
+	}
+	metrics.SignPayloadSize(app, len(payload))
+	if signErr != nil && StatusWord(signErr) == StatusWordRejected {
+		metrics.SignRejected(app)
+	}
+}
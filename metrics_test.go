@@ -0,0 +1,150 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// errUserRejected is the message of the transport for a request rejected in the device
+const errUserRejected = "[APDU_CODE_COMMAND_NOT_ALLOWED] Command not allowed / User Rejected (no current EF)"
+
+// recordingMetrics keeps every measurement it receives
+type recordingMetrics struct {
+	mtx          sync.Mutex
+	exchanges    map[[2]uint16]int
+	waits        []time.Duration
+	rejections   map[string]int
+	payloadSizes map[string][]int
+	reconnects   int
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{
+		exchanges:    map[[2]uint16]int{},
+		rejections:   map[string]int{},
+		payloadSizes: map[string][]int{},
+	}
+}
+
+func (m *recordingMetrics) APDUExchanged(cla byte, ins byte, statusWord uint16) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.exchanges[[2]uint16{uint16(cla)<<8 | uint16(ins), statusWord}]++
+}
+
+func (m *recordingMetrics) ConfirmationWaited(elapsed time.Duration) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.waits = append(m.waits, elapsed)
+}
+
+func (m *recordingMetrics) SignRejected(app string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.rejections[app]++
+}
+
+func (m *recordingMetrics) SignPayloadSize(app string, size int) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.payloadSizes[app] = append(m.payloadSizes[app], size)
+}
+
+func (m *recordingMetrics) Reconnected() {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.reconnects++
+}
+
+func Test_StatusWord(t *testing.T) {
+	assert.Equal(t, uint16(StatusWordOK), StatusWord(nil))
+	assert.Equal(t, uint16(0x6986), StatusWord(errors.New(errUserRejected)))
+	assert.Equal(t, uint16(0x6E01), StatusWord(errors.New("[APDU_CODE_APP_NOT_OPEN] Ledger Connected but Chain Specific App Not Open")))
+	assert.Equal(t, uint16(0x6807), StatusWord(errors.New("Error code: 6807")))
+	assert.Equal(t, uint16(StatusWordNone), StatusWord(errors.New("hidapi: device disconnected")))
+}
+
+func Test_Metrics_User(t *testing.T) {
+	device := newMockDevice()
+	rejected := false
+	device.handler = func(command []byte) ([]byte, error) {
+		if command[1] != userINSSignSECP256K1 {
+			return nil, errors.New("[APDU_CODE_INS_NOT_SUPPORTED] Instruction code not supported or invalid")
+		}
+		if command[2] == payloadLast {
+			time.Sleep(10 * time.Millisecond)
+			if rejected {
+				return nil, errors.New(errUserRejected)
+			}
+			return []byte{0x30, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01, 0x01}, nil
+		}
+		return nil, nil
+	}
+	userApp := &LedgerTHORChain{api: device, version: VersionInfo{0, 2, 34, 0}}
+	metrics := newRecordingMetrics()
+	userApp.SetMetrics(metrics)
+
+	path := []uint32{44, 931, 0, 0, 0}
+	signDoc := []byte(`{"account_number":"1","chain_id":"thorchain-1","fee":{},"memo":"","msgs":[],"sequence":"0"}`)
+	_, err := userApp.SignSECP256K1(path, signDoc, 0)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	rejected = true
+	_, err = userApp.SignSECP256K1(path, signDoc, 0)
+	assert.EqualError(t, err, errUserRejected)
+
+	_, err = userApp.GetPublicKeySECP256K1(path)
+	assert.Error(t, err)
+
+	sign := uint16(userCLA)<<8 | userINSSignSECP256K1
+	getAddr := uint16(userCLA)<<8 | userINSGetAddrSecp256k1
+	assert.Equal(t, map[[2]uint16]int{
+		{sign, StatusWordOK}: 3,
+		{sign, 0x6986}:       1,
+		{getAddr, 0x6D00}:    1,
+	}, metrics.exchanges)
+	require.Len(t, metrics.waits, 2)
+	assert.GreaterOrEqual(t, metrics.waits[0], 10*time.Millisecond)
+	assert.Equal(t, map[string]int{AuditAppTHORChain: 1}, metrics.rejections)
+	assert.Equal(t, map[string][]int{AuditAppTHORChain: {len(signDoc), len(signDoc)}}, metrics.payloadSizes)
+
+	// Disabling the metrics removes the wrapper of the device
+	userApp.SetMetrics(nil)
+	assert.Same(t, device, userApp.api)
+}
+
+func Test_Metrics_Validator(t *testing.T) {
+	device, _ := newMockValidatorVersion(1, VersionInfo{0, 1, 0, 0}, FramingPayloadDescriptor)
+	validatorApp := newNegotiatedValidator(t, device)
+	metrics := newRecordingMetrics()
+	validatorApp.SetMetrics(metrics)
+
+	_, err := validatorApp.SignED25519([]uint32{44, 118, 0, 0, 0}, []byte("vote"))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	assert.Equal(t, map[string][]int{AuditAppValidator: {4}}, metrics.payloadSizes)
+	assert.Empty(t, metrics.rejections)
+	assert.Empty(t, metrics.waits)
+	assert.NotEmpty(t, metrics.exchanges)
+}
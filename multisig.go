@@ -0,0 +1,464 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"golang.org/x/crypto/ripemd160" //nolint:staticcheck // RIPEMD-160 is part of the address format
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Amino types of the account keys
+const (
+	AminoPubKeySecp256k1Type = "tendermint/PubKeySecp256k1"
+	AminoPubKeyMultisigType  = "tendermint/PubKeyMultisigThreshold"
+
+	multisigAddressSize = 20
+)
+
+var (
+	// aminoPubKeySecp256k1Prefix is the amino type prefix of PubKeySecp256k1 followed by the length of the key
+	aminoPubKeySecp256k1Prefix = []byte{0xEB, 0x5A, 0xE9, 0x87, 0x21}
+	// aminoPubKeyMultisigPrefix is the amino type prefix of PubKeyMultisigThreshold
+	aminoPubKeyMultisigPrefix = []byte{0x22, 0xC1, 0xF7, 0xE2}
+)
+
+// MultisigPubKey is a legacy amino threshold multisig key (LegacyAminoPubKey).
+// The order of the keys is part of the address
+type MultisigPubKey struct {
+	Threshold int
+	// PubKeys are compressed secp256k1 keys
+	PubKeys [][]byte
+}
+
+// NewMultisigPubKey checks the threshold and the keys of a multisig.
+// The keys keep the given order, use SortMultisigPubKeys to get the order of `keys add --multisig`
+func NewMultisigPubKey(threshold int, pubKeys [][]byte) (*MultisigPubKey, error) {
+	if len(pubKeys) == 0 {
+		return nil, errors.New("a multisig needs at least one key")
+	}
+	if threshold < 1 || threshold > len(pubKeys) {
+		return nil, fmt.Errorf("threshold should be between 1 and %d", len(pubKeys))
+	}
+
+	keys := make([][]byte, len(pubKeys))
+	for i, pubKey := range pubKeys {
+		if len(pubKey) != 33 {
+			return nil, fmt.Errorf("key %d: expected a 33 byte compressed public key", i)
+		}
+		if _, err := btcec.ParsePubKey(pubKey); err != nil {
+			return nil, fmt.Errorf("key %d: %w", i, err)
+		}
+		for j := 0; j < i; j++ {
+			if bytes.Equal(keys[j], pubKey) {
+				return nil, fmt.Errorf("key %d is a duplicate of key %d", i, j)
+			}
+		}
+		keys[i] = append([]byte{}, pubKey...)
+	}
+	return &MultisigPubKey{Threshold: threshold, PubKeys: keys}, nil
+}
+
+// SortMultisigPubKeys returns the keys sorted by address, as done by `keys add --multisig`
+func SortMultisigPubKeys(pubKeys [][]byte) [][]byte {
+	sorted := append([][]byte{}, pubKeys...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return bytes.Compare(pubKeyAddress(sorted[i]), pubKeyAddress(sorted[j])) < 0
+	})
+	return sorted
+}
+
+// pubKeyAddress returns the RIPEMD-160 of the SHA-256 of a secp256k1 key
+func pubKeyAddress(pubKey []byte) []byte {
+	sha := sha256.Sum256(pubKey)
+	hasher := ripemd160.New()
+	hasher.Write(sha[:])
+	return hasher.Sum(nil)
+}
+
+// Bytes returns the amino binary encoding of the key
+func (k *MultisigPubKey) Bytes() []byte {
+	bz := append([]byte{}, aminoPubKeyMultisigPrefix...)
+	bz = appendAminoUvarint(bz, 1, uint64(k.Threshold))
+	for _, pubKey := range k.PubKeys {
+		bz = appendAminoBytes(bz, 2, append(append([]byte{}, aminoPubKeySecp256k1Prefix...), pubKey...))
+	}
+	return bz
+}
+
+// Address returns the account address: the first 20 bytes of the SHA-256 of the amino encoded key
+func (k *MultisigPubKey) Address() []byte {
+	hash := sha256.Sum256(k.Bytes())
+	return hash[:multisigAddressSize]
+}
+
+// Bech32Address returns the account address with the given prefix, e.g. thor
+func (k *MultisigPubKey) Bech32Address(hrp string) (string, error) {
+	return Bech32Encode(hrp, k.Address())
+}
+
+// Index returns the position of a member key, or -1 if it is not part of the multisig
+func (k *MultisigPubKey) Index(pubKey []byte) int {
+	for i, member := range k.PubKeys {
+		if bytes.Equal(member, pubKey) {
+			return i
+		}
+	}
+	return -1
+}
+
+// aminoMultisigPubKey is the amino JSON representation of a multisig key
+type aminoMultisigPubKey struct {
+	Type  string `json:"type"`
+	Value struct {
+		Threshold string        `json:"threshold"`
+		PubKeys   []AminoPubKey `json:"pubkeys"`
+	} `json:"value"`
+}
+
+// MarshalJSON returns the amino JSON representation used in StdTx:
+// {"type":"tendermint/PubKeyMultisigThreshold","value":{"threshold":"2","pubkeys":[...]}}
+func (k *MultisigPubKey) MarshalJSON() ([]byte, error) {
+	var aminoKey aminoMultisigPubKey
+	aminoKey.Type = AminoPubKeyMultisigType
+	aminoKey.Value.Threshold = strconv.Itoa(k.Threshold)
+	aminoKey.Value.PubKeys = make([]AminoPubKey, len(k.PubKeys))
+	for i, pubKey := range k.PubKeys {
+		aminoKey.Value.PubKeys[i] = AminoPubKey{
+			Type:  AminoPubKeySecp256k1Type,
+			Value: base64.StdEncoding.EncodeToString(pubKey),
+		}
+	}
+	return json.Marshal(aminoKey)
+}
+
+// UnmarshalJSON reads the amino JSON representation and checks the key
+func (k *MultisigPubKey) UnmarshalJSON(data []byte) error {
+	var aminoKey aminoMultisigPubKey
+	if err := json.Unmarshal(data, &aminoKey); err != nil {
+		return err
+	}
+	if aminoKey.Type != AminoPubKeyMultisigType {
+		return fmt.Errorf("expected a %s key, got %q", AminoPubKeyMultisigType, aminoKey.Type)
+	}
+	threshold, err := strconv.Atoi(aminoKey.Value.Threshold)
+	if err != nil {
+		return fmt.Errorf("invalid threshold %q", aminoKey.Value.Threshold)
+	}
+
+	pubKeys := make([][]byte, len(aminoKey.Value.PubKeys))
+	for i, pubKey := range aminoKey.Value.PubKeys {
+		if pubKey.Type != AminoPubKeySecp256k1Type {
+			return fmt.Errorf("key %d: expected a %s key, got %q", i, AminoPubKeySecp256k1Type, pubKey.Type)
+		}
+		if pubKeys[i], err = base64.StdEncoding.DecodeString(pubKey.Value); err != nil {
+			return fmt.Errorf("key %d: %w", i, err)
+		}
+	}
+
+	parsed, err := NewMultisigPubKey(threshold, pubKeys)
+	if err != nil {
+		return err
+	}
+	*k = *parsed
+	return nil
+}
+
+// PartialSignature is the signature of a sign doc by one member of a multisig
+type PartialSignature struct {
+	PubKey []byte `json:"pub_key"`
+	// Signature is the 64 byte R || S signature of the sign doc
+	Signature []byte `json:"signature"`
+}
+
+// SignMultisigPartial signs an amino JSON sign doc with the key of a multisig member.
+// Every member signs the same sign doc, which carries the account number and sequence of the multisig
+// this command requires user confirmation in the device
+func SignMultisigPartial(device SECP256K1Device, bip32Path []uint32, multisig *MultisigPubKey, signDoc []byte) (*PartialSignature, error) {
+	signer, err := NewSigner(device, bip32Path, SignatureCompact)
+	if err != nil {
+		return nil, err
+	}
+	pubKey := signer.Public().(*btcec.PublicKey).SerializeCompressed()
+	if multisig.Index(pubKey) < 0 {
+		return nil, fmt.Errorf("the key of path %s is not a member of the multisig", FormatBip32Path(bip32Path, 3))
+	}
+
+	signature, err := signer.Sign(nil, nil, &SignerOpts{Message: signDoc, SignMode: SignModeLegacyAmino})
+	if err != nil {
+		return nil, err
+	}
+	return &PartialSignature{PubKey: pubKey, Signature: signature}, nil
+}
+
+// CompactBitArray marks the members that signed a multisig signature. Bit i is stored
+// in the most significant bits first of Elems[i/8]
+type CompactBitArray struct {
+	// ExtraBitsStored is the number of bits used in the last byte, 0 if it is full
+	ExtraBitsStored uint8
+	Elems           []byte
+}
+
+// NewCompactBitArray returns an array of size bits, all false
+func NewCompactBitArray(size int) *CompactBitArray {
+	return &CompactBitArray{ExtraBitsStored: uint8(size % 8), Elems: make([]byte, (size+7)/8)}
+}
+
+// Size returns the number of bits of the array
+func (b *CompactBitArray) Size() int {
+	if b.ExtraBitsStored == 0 {
+		return len(b.Elems) * 8
+	}
+	return (len(b.Elems)-1)*8 + int(b.ExtraBitsStored)
+}
+
+// GetIndex returns bit i. It is false if i is out of range
+func (b *CompactBitArray) GetIndex(i int) bool {
+	if i < 0 || i >= b.Size() {
+		return false
+	}
+	return b.Elems[i>>3]&(1<<uint8(7-i%8)) > 0
+}
+
+// SetIndex sets bit i. It returns false if i is out of range
+func (b *CompactBitArray) SetIndex(i int, v bool) bool {
+	if i < 0 || i >= b.Size() {
+		return false
+	}
+	if v {
+		b.Elems[i>>3] |= 1 << uint8(7-i%8)
+	} else {
+		b.Elems[i>>3] &^= 1 << uint8(7-i%8)
+	}
+	return true
+}
+
+// Count returns the number of bits set
+func (b *CompactBitArray) Count() int {
+	count := 0
+	for i := 0; i < b.Size(); i++ {
+		if b.GetIndex(i) {
+			count++
+		}
+	}
+	return count
+}
+
+// MultiSignature is the signature of a multisig: the members that signed and
+// their signatures, in the order of the keys
+type MultiSignature struct {
+	BitArray   *CompactBitArray
+	Signatures [][]byte
+}
+
+// Bytes returns the amino encoding used as the signature of a StdTx (AminoMultisignature)
+func (s *MultiSignature) Bytes() []byte {
+	var bz []byte
+	if s.BitArray != nil {
+		var bitArray []byte
+		if s.BitArray.ExtraBitsStored != 0 {
+			bitArray = appendAminoUvarint(bitArray, 1, uint64(s.BitArray.ExtraBitsStored))
+		}
+		if len(s.BitArray.Elems) > 0 {
+			bitArray = appendAminoBytes(bitArray, 2, s.BitArray.Elems)
+		}
+		bz = appendAminoBytes(bz, 1, bitArray)
+	}
+	for _, sig := range s.Signatures {
+		bz = appendAminoBytes(bz, 2, sig)
+	}
+	return bz
+}
+
+// DecodeMultiSignature reads the amino encoding returned by MultiSignature.Bytes
+func DecodeMultiSignature(bz []byte) (*MultiSignature, error) {
+	sig := &MultiSignature{}
+	err := readAminoFields(bz, func(field int, value []byte, _ uint64) error {
+		switch field {
+		case 1:
+			sig.BitArray = &CompactBitArray{}
+			return readAminoFields(value, func(field int, value []byte, n uint64) error {
+				switch field {
+				case 1:
+					if n > 7 {
+						return errors.New("invalid bit array: extra bits should be less than 8")
+					}
+					sig.BitArray.ExtraBitsStored = uint8(n)
+				case 2:
+					sig.BitArray.Elems = append([]byte{}, value...)
+				}
+				return nil
+			})
+		case 2:
+			sig.Signatures = append(sig.Signatures, append([]byte{}, value...))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid multisig signature: %w", err)
+	}
+	if sig.BitArray == nil {
+		return nil, errors.New("invalid multisig signature: missing bit array")
+	}
+	return sig, nil
+}
+
+// Combine checks the partial signatures of the members against the sign doc and assembles
+// the multisig signature. Signatures beyond the threshold are kept
+func (k *MultisigPubKey) Combine(signDoc []byte, partials []PartialSignature) (*MultiSignature, error) {
+	byIndex := make(map[int][]byte, len(partials))
+	for _, partial := range partials {
+		index := k.Index(partial.PubKey)
+		if index < 0 {
+			return nil, fmt.Errorf("key %X is not a member of the multisig", partial.PubKey)
+		}
+		if _, ok := byIndex[index]; ok {
+			return nil, fmt.Errorf("key %X signed twice", partial.PubKey)
+		}
+		if err := verifyCompactSignature(partial.PubKey, signDoc, partial.Signature); err != nil {
+			return nil, fmt.Errorf("key %X: %w", partial.PubKey, err)
+		}
+		byIndex[index] = partial.Signature
+	}
+	if len(byIndex) < k.Threshold {
+		return nil, fmt.Errorf("%d signatures, the threshold is %d", len(byIndex), k.Threshold)
+	}
+
+	sig := &MultiSignature{BitArray: NewCompactBitArray(len(k.PubKeys))}
+	for i := range k.PubKeys {
+		if signature, ok := byIndex[i]; ok {
+			sig.BitArray.SetIndex(i, true)
+			sig.Signatures = append(sig.Signatures, append([]byte{}, signature...))
+		}
+	}
+	return sig, nil
+}
+
+// Verify checks a multisig signature of the sign doc like the Cosmos SDK does
+func (k *MultisigPubKey) Verify(signDoc []byte, sig *MultiSignature) error {
+	if sig.BitArray == nil || sig.BitArray.Size() != len(k.PubKeys) {
+		return fmt.Errorf("the bit array should contain %d bits", len(k.PubKeys))
+	}
+	if sig.BitArray.Count() != len(sig.Signatures) {
+		return fmt.Errorf("the bit array marks %d signers but there are %d signatures", sig.BitArray.Count(), len(sig.Signatures))
+	}
+	if len(sig.Signatures) < k.Threshold {
+		return fmt.Errorf("%d signatures, the threshold is %d", len(sig.Signatures), k.Threshold)
+	}
+
+	next := 0
+	for i, pubKey := range k.PubKeys {
+		if !sig.BitArray.GetIndex(i) {
+			continue
+		}
+		if err := verifyCompactSignature(pubKey, signDoc, sig.Signatures[next]); err != nil {
+			return fmt.Errorf("key %d: %w", i, err)
+		}
+		next++
+	}
+	return nil
+}
+
+// verifyCompactSignature checks a 64 byte R || S signature of the SHA-256 of message.
+// Like the Cosmos SDK, S must be in the lower half of the curve order
+func verifyCompactSignature(pubKey []byte, message []byte, signature []byte) error {
+	if len(signature) != 64 {
+		return errors.New("expected a 64 byte signature")
+	}
+	key, err := btcec.ParsePubKey(pubKey)
+	if err != nil {
+		return err
+	}
+
+	var r, s btcec.ModNScalar
+	if overflow := r.SetByteSlice(signature[:32]); overflow || r.IsZero() {
+		return errors.New("invalid signature: R should be in [1, N-1]")
+	}
+	if overflow := s.SetByteSlice(signature[32:]); overflow || s.IsZero() {
+		return errors.New("invalid signature: S should be in [1, N-1]")
+	}
+	if s.IsOverHalfOrder() {
+		return errors.New("invalid signature: S should be in the lower half of the curve order")
+	}
+
+	hash := sha256.Sum256(message)
+	if !ecdsa.NewSignature(&r, &s).Verify(hash[:], key) {
+		return errors.New("signature verification failed")
+	}
+	return nil
+}
+
+// appendAminoUvarint appends a varint field. Amino omits zero values
+func appendAminoUvarint(bz []byte, field int, value uint64) []byte {
+	if value == 0 {
+		return bz
+	}
+	bz = protowire.AppendTag(bz, protowire.Number(field), protowire.VarintType)
+	return protowire.AppendVarint(bz, value)
+}
+
+// appendAminoBytes appends a length delimited field
+func appendAminoBytes(bz []byte, field int, value []byte) []byte {
+	bz = protowire.AppendTag(bz, protowire.Number(field), protowire.BytesType)
+	return protowire.AppendBytes(bz, value)
+}
+
+// readAminoFields calls fn for each varint or length delimited field of bz
+func readAminoFields(bz []byte, fn func(field int, value []byte, n uint64) error) error {
+	for len(bz) > 0 {
+		num, typ, size := protowire.ConsumeTag(bz)
+		if size < 0 {
+			return errors.New("invalid field key")
+		}
+		bz = bz[size:]
+
+		var err error
+		switch typ {
+		case protowire.VarintType:
+			var n uint64
+			n, size = protowire.ConsumeVarint(bz)
+			if size < 0 {
+				return fmt.Errorf("field %d: invalid varint", num)
+			}
+			err = fn(int(num), nil, n)
+		case protowire.BytesType:
+			var value []byte
+			value, size = protowire.ConsumeBytes(bz)
+			if size < 0 {
+				return fmt.Errorf("field %d: invalid length", num)
+			}
+			err = fn(int(num), value, 0)
+		default:
+			return fmt.Errorf("field %d: unexpected wire type %d", num, typ)
+		}
+		if err != nil {
+			return err
+		}
+		bz = bz[size:]
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var multisigSignDoc = []byte(`{"account_number":"7","chain_id":"thorchain-1","fee":{"amount":[],"gas":"4000000"},"memo":"","msgs":[],"sequence":"3"}`)
+
+// newMultisigMembers returns devices holding the keys of a multisig and the multisig key
+func newMultisigMembers(t *testing.T, threshold int, count int) ([]*keyDevice, *MultisigPubKey) {
+	devices := make([]*keyDevice, count)
+	pubKeys := make([][]byte, count)
+	for i := range devices {
+		key, err := btcec.NewPrivateKey()
+		require.Nil(t, err)
+		devices[i] = &keyDevice{key: key}
+		pubKeys[i] = key.PubKey().SerializeCompressed()
+	}
+	multisig, err := NewMultisigPubKey(threshold, pubKeys)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	return devices, multisig
+}
+
+func Test_MultisigPubKey_Encoding(t *testing.T) {
+	pubKeys := make([][]byte, 2)
+	for i := range pubKeys {
+		key, _ := btcec.PrivKeyFromBytes(bytes.Repeat([]byte{byte(i + 1)}, 32))
+		pubKeys[i] = key.PubKey().SerializeCompressed()
+	}
+	multisig, err := NewMultisigPubKey(2, pubKeys)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	expected := []byte{0x22, 0xC1, 0xF7, 0xE2, 0x08, 0x02}
+	for _, pubKey := range pubKeys {
+		expected = append(expected, 0x12, 0x26, 0xEB, 0x5A, 0xE9, 0x87, 0x21)
+		expected = append(expected, pubKey...)
+	}
+	assert.Equal(t, expected, multisig.Bytes())
+
+	hash := sha256.Sum256(expected)
+	assert.Equal(t, hash[:20], multisig.Address())
+	addr, err := multisig.Bech32Address(Mainnet.AccountHRP)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	hrp, data, err := Bech32Decode(addr)
+	require.Nil(t, err)
+	assert.Equal(t, "thor", hrp)
+	assert.Equal(t, hash[:20], data)
+
+	aminoJSON, err := json.Marshal(multisig)
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(aminoJSON), `{"type":"tendermint/PubKeyMultisigThreshold","value":{"threshold":"2","pubkeys":[{"type":"tendermint/PubKeySecp256k1","value":"`))
+
+	var decoded MultisigPubKey
+	require.Nil(t, json.Unmarshal(aminoJSON, &decoded))
+	assert.Equal(t, multisig, &decoded)
+}
+
+// The vector was computed with Cosmos SDK v0.50.10: the keys sorted by address as `keys add --multisig`
+// does, kmultisig.NewLegacyAminoPubKey(2, keys) and the StdTx signature of legacytx.SignatureDataToAminoSignature
+// for the partial signatures of the keys 0x03.. and 0x01.. on multisigSignDoc
+func Test_Multisig_CosmosSDKVector(t *testing.T) {
+	devices := make([]*keyDevice, 3)
+	pubKeys := make([][]byte, 3)
+	for i := range devices {
+		key, _ := btcec.PrivKeyFromBytes(bytes.Repeat([]byte{byte(i + 1)}, 32))
+		devices[i] = &keyDevice{key: key}
+		pubKeys[i] = key.PubKey().SerializeCompressed()
+	}
+	multisig, err := NewMultisigPubKey(2, SortMultisigPubKeys(pubKeys))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	addr, err := multisig.Bech32Address(Mainnet.AccountHRP)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, "thor10522r7w8h7s4pwu0sp7lxthcmjatdxcnumpvz4", addr)
+	assert.Equal(t, "22c1f7e208021226eb5ae9872102531fe6068134503d2723133227c867ac8fa6c83c537e9a44c3c5bdbdcb1fe3371226eb5ae98721031b84c5567b126440995d3ed5aaba0565d71e1834604819ff9c17f5e9d5dd078f1226eb5ae98721024d4b6cd1361032ca9bd2aeb9d900aa4d45d9ead80ac9423374c451a7254d0766", hex.EncodeToString(multisig.Bytes()))
+	aminoJSON, err := json.Marshal(multisig)
+	require.Nil(t, err)
+	assert.Equal(t, `{"type":"tendermint/PubKeyMultisigThreshold","value":{"threshold":"2","pubkeys":[{"type":"tendermint/PubKeySecp256k1","value":"AlMf5gaBNFA9JyMTMifIZ6yPpsg8U36aRMPFvb3LH+M3"},{"type":"tendermint/PubKeySecp256k1","value":"AxuExVZ7EmRAmV0+1aq6BWXXHhg0YEgZ/5wX9enV3QeP"},{"type":"tendermint/PubKeySecp256k1","value":"Ak1LbNE2EDLKm9KuudkAqk1F2erYCslCM3TEUaclTQdm"}]}}`, string(aminoJSON))
+
+	var partials []PartialSignature
+	for _, device := range []*keyDevice{devices[2], devices[0]} {
+		partial, err := SignMultisigPartial(device, []uint32{44, 931, 0, 0, 0}, multisig, multisigSignDoc)
+		require.Nil(t, err, "Detected error, err: %s\n", err)
+		partials = append(partials, *partial)
+	}
+	sig, err := multisig.Combine(multisigSignDoc, partials)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, "0a0508031201c01240b1feee6a7fcdf3e32c16a7a32b09c9b81ad791abc077199ea4950f47fb7f0dac17e55072d0ece2dd50fcd76f1af16a2015a941076fbe720c8e1f5ff85c72e1c81240dd3013a07ad5e822572abcea00cde1cb58e6dfff20153f2dd162d26cebf9dd02327d71d00ddb06d496d862eb1bfbe0d193f6023342c4309aec7b28eebee5dfe4", hex.EncodeToString(sig.Bytes()))
+}
+
+func Test_NewMultisigPubKey_Errors(t *testing.T) {
+	_, multisig := newMultisigMembers(t, 1, 2)
+	pubKey := multisig.PubKeys[0]
+
+	_, err := NewMultisigPubKey(1, nil)
+	assert.EqualError(t, err, "a multisig needs at least one key")
+	_, err = NewMultisigPubKey(3, multisig.PubKeys)
+	assert.EqualError(t, err, "threshold should be between 1 and 2")
+	_, err = NewMultisigPubKey(0, multisig.PubKeys)
+	assert.EqualError(t, err, "threshold should be between 1 and 2")
+	_, err = NewMultisigPubKey(1, [][]byte{pubKey, pubKey})
+	assert.EqualError(t, err, "key 1 is a duplicate of key 0")
+	_, err = NewMultisigPubKey(1, [][]byte{pubKey[:32]})
+	assert.EqualError(t, err, "key 0: expected a 33 byte compressed public key")
+
+	var decoded MultisigPubKey
+	err = json.Unmarshal([]byte(`{"type":"tendermint/PubKeySecp256k1","value":{}}`), &decoded)
+	assert.EqualError(t, err, `expected a tendermint/PubKeyMultisigThreshold key, got "tendermint/PubKeySecp256k1"`)
+}
+
+func Test_SortMultisigPubKeys(t *testing.T) {
+	_, multisig := newMultisigMembers(t, 1, 5)
+	sorted := SortMultisigPubKeys(multisig.PubKeys)
+	require.Len(t, sorted, 5)
+	for i := 1; i < len(sorted); i++ {
+		assert.Negative(t, bytes.Compare(pubKeyAddress(sorted[i-1]), pubKeyAddress(sorted[i])))
+	}
+	assert.ElementsMatch(t, multisig.PubKeys, sorted)
+}
+
+func Test_CompactBitArray(t *testing.T) {
+	bitArray := NewCompactBitArray(10)
+	assert.Equal(t, 10, bitArray.Size())
+	assert.Equal(t, uint8(2), bitArray.ExtraBitsStored)
+	assert.True(t, bitArray.SetIndex(0, true))
+	assert.True(t, bitArray.SetIndex(9, true))
+	assert.False(t, bitArray.SetIndex(10, true))
+	assert.Equal(t, []byte{0x80, 0x40}, bitArray.Elems)
+	assert.True(t, bitArray.GetIndex(9))
+	assert.False(t, bitArray.GetIndex(1))
+	assert.Equal(t, 2, bitArray.Count())
+
+	bitArray.SetIndex(0, false)
+	assert.Equal(t, 1, bitArray.Count())
+	assert.Equal(t, 16, NewCompactBitArray(16).Size())
+}
+
+func Test_Multisig_SignAndCombine(t *testing.T) {
+	devices, multisig := newMultisigMembers(t, 2, 3)
+	path := []uint32{44, 931, 0, 0, 0}
+
+	var partials []PartialSignature
+	for _, device := range []*keyDevice{devices[2], devices[0]} {
+		partial, err := SignMultisigPartial(device, path, multisig, multisigSignDoc)
+		require.Nil(t, err, "Detected error, err: %s\n", err)
+		assert.Equal(t, byte(SignModeLegacyAmino), device.lastMode)
+		assert.Len(t, partial.Signature, 64)
+		partials = append(partials, *partial)
+	}
+
+	sig, err := multisig.Combine(multisigSignDoc, partials)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, []byte{0xA0}, sig.BitArray.Elems)
+	assert.Equal(t, [][]byte{partials[1].Signature, partials[0].Signature}, sig.Signatures)
+	require.Nil(t, multisig.Verify(multisigSignDoc, sig))
+
+	decoded, err := DecodeMultiSignature(sig.Bytes())
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, sig, decoded)
+	require.Nil(t, multisig.Verify(multisigSignDoc, decoded))
+
+	// The signatures do not cover another sign doc
+	err = multisig.Verify([]byte(`{"account_number":"8"}`), sig)
+	assert.EqualError(t, err, "key 0: signature verification failed")
+}
+
+func Test_Multisig_Errors(t *testing.T) {
+	devices, multisig := newMultisigMembers(t, 2, 3)
+	path := []uint32{44, 931, 0, 0, 0}
+
+	outsider, err := btcec.NewPrivateKey()
+	require.Nil(t, err)
+	_, err = SignMultisigPartial(&keyDevice{key: outsider}, path, multisig, multisigSignDoc)
+	assert.EqualError(t, err, "the key of path m/44'/931'/0'/0/0 is not a member of the multisig")
+
+	partial, err := SignMultisigPartial(devices[1], path, multisig, multisigSignDoc)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	_, err = multisig.Combine(multisigSignDoc, []PartialSignature{*partial})
+	assert.EqualError(t, err, "1 signatures, the threshold is 2")
+	_, err = multisig.Combine(multisigSignDoc, []PartialSignature{*partial, *partial})
+	assert.ErrorContains(t, err, "signed twice")
+
+	forged := PartialSignature{PubKey: multisig.PubKeys[0], Signature: partial.Signature}
+	_, err = multisig.Combine(multisigSignDoc, []PartialSignature{*partial, forged})
+	assert.ErrorContains(t, err, "signature verification failed")
+
+	other, err := SignMultisigPartial(devices[0], path, multisig, multisigSignDoc)
+	require.Nil(t, err)
+	sig, err := multisig.Combine(multisigSignDoc, []PartialSignature{*partial, *other})
+	require.Nil(t, err)
+
+	sig.Signatures = sig.Signatures[:1]
+	assert.EqualError(t, multisig.Verify(multisigSignDoc, sig), "the bit array marks 2 signers but there are 1 signatures")
+	sig.BitArray = NewCompactBitArray(2)
+	assert.EqualError(t, multisig.Verify(multisigSignDoc, sig), "the bit array should contain 3 bits")
+
+	// Signatures with a high S are malleable and refused
+	highS := append([]byte{}, other.Signature...)
+	n := btcec.S256().Params().N
+	new(big.Int).Sub(n, new(big.Int).SetBytes(highS[32:])).FillBytes(highS[32:])
+	err = verifyCompactSignature(other.PubKey, multisigSignDoc, highS)
+	assert.EqualError(t, err, "invalid signature: S should be in the lower half of the curve order")
+
+	_, err = DecodeMultiSignature([]byte{0x12, 0x05, 0x01})
+	assert.ErrorContains(t, err, "invalid multisig signature")
+}
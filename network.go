@@ -0,0 +1,82 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ripemd160" //nolint:staticcheck // RIPEMD-160 is part of the address format
+)
+
+// THORChainCoinType is the SLIP-0044 coin type of THORChain
+const THORChainCoinType = 931
+
+// Network contains the chain id and bech32 prefixes of a THORChain network
+type Network struct {
+	Name       string
+	ChainID    string
+	AccountHRP string
+}
+
+// Network presets
+var (
+	Mainnet  = Network{Name: "mainnet", ChainID: "thorchain-1", AccountHRP: "thor"}
+	Stagenet = Network{Name: "stagenet", ChainID: "thorchain-stagenet-v2", AccountHRP: "sthor"}
+	Mocknet  = Network{Name: "mocknet", ChainID: "thorchain", AccountHRP: "tthor"}
+)
+
+// Networks lists the network presets
+var Networks = []Network{Mainnet, Stagenet, Mocknet}
+
+// NetworkByName returns the preset with the given name
+func NetworkByName(name string) (Network, error) {
+	for _, n := range Networks {
+		if n.Name == name {
+			return n, nil
+		}
+	}
+	return Network{}, fmt.Errorf("unknown network %q", name)
+}
+
+// ConsensusAddressHRP returns the prefix of validator consensus addresses, e.g. thorvalcons
+func (n Network) ConsensusAddressHRP() string {
+	return n.AccountHRP + "valcons"
+}
+
+// ConsensusPubKeyHRP returns the prefix of validator consensus public keys, e.g. thorcpub
+func (n Network) ConsensusPubKeyHRP() string {
+	return n.AccountHRP + "cpub"
+}
+
+// DefaultPath returns the path m/44'/931'/account'/0/index
+func (n Network) DefaultPath(account uint32, index uint32) []uint32 {
+	return []uint32{44, THORChainCoinType, account, 0, index}
+}
+
+// AddressFromPubKey returns the bech32 account address of a compressed secp256k1 public key
+func AddressFromPubKey(hrp string, pubKey []byte) (string, error) {
+	if len(pubKey) != 33 {
+		return "", errors.New("expected a 33 byte compressed public key")
+	}
+
+	sha := sha256.Sum256(pubKey)
+	hasher := ripemd160.New()
+	hasher.Write(sha[:])
+	return Bech32Encode(hrp, hasher.Sum(nil))
+}
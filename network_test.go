@@ -0,0 +1,64 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NetworkByName(t *testing.T) {
+	network, err := NetworkByName("stagenet")
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, Stagenet, network)
+	assert.Equal(t, "sthorvalcons", network.ConsensusAddressHRP())
+	assert.Equal(t, "sthorcpub", network.ConsensusPubKeyHRP())
+	assert.Equal(t, []uint32{44, 931, 1, 0, 2}, network.DefaultPath(1, 2))
+
+	assert.Equal(t, ConsensusAddressPrefix, Mainnet.ConsensusAddressHRP())
+	assert.Equal(t, ConsensusPubKeyPrefix, Mainnet.ConsensusPubKeyHRP())
+
+	_, err = NetworkByName("devnet")
+	assert.Error(t, err)
+}
+
+func Test_AddressFromPubKey(t *testing.T) {
+	// The public key of the private key 1
+	pubKey, _ := hex.DecodeString("0279BE667EF9DCBBAC55A06295CE870B07029BFCDB2DCE28D959F2815B16F81798")
+
+	address, err := AddressFromPubKey("thor", pubKey)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, "thor1w508d6qejxtdg4y5r3zarvary0c5xw7ku6wp68", address)
+
+	address, err = AddressFromPubKey(Stagenet.AccountHRP, pubKey)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, "sthor1w508d6qejxtdg4y5r3zarvary0c5xw7kgrjhve", address)
+
+	_, err = AddressFromPubKey("thor", pubKey[1:])
+	assert.Error(t, err)
+}
+
+func Test_ConsensusPubKey_Network(t *testing.T) {
+	pubKey := ConsensusPubKey(testConsensusPubKey())
+
+	address, err := pubKey.Bech32AddressWithPrefix(Stagenet.ConsensusAddressHRP())
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, "sthorvalcons14cskcth4y3ar0qkpxhh6y7drunxuvyy5hy675m", address)
+}
@@ -0,0 +1,55 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package policy
+
+import (
+	"errors"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+// UserApp is the part of LedgerTHORChain wrapped by a Guard
+type UserApp interface {
+	GetVersion() (*ledger.VersionInfo, error)
+	GetPublicKeySECP256K1(bip32Path []uint32) ([]byte, error)
+	GetAddressPubKeySECP256K1(bip32Path []uint32, hrp string) ([]byte, string, error)
+	SignSECP256K1(bip32Path []uint32, transaction []byte, p2 byte) ([]byte, error)
+}
+
+// Guard wraps a THORChain app so that sign docs violating the policy never reach the device.
+// It can be used wherever a LedgerTHORChain is expected through an interface
+type Guard struct {
+	UserApp
+	policy *Policy
+}
+
+// NewGuard wraps app with a policy
+func NewGuard(app UserApp, policy *Policy) *Guard {
+	return &Guard{UserApp: app, policy: policy}
+}
+
+// SignSECP256K1 checks the sign doc before sending it to the device. Textual sign docs
+// cannot be evaluated and are refused
+func (g *Guard) SignSECP256K1(bip32Path []uint32, transaction []byte, p2 byte) ([]byte, error) {
+	if ledger.SignMode(p2) != ledger.SignModeLegacyAmino {
+		return nil, errors.New("only amino JSON sign docs can be checked by the signing policy")
+	}
+	if err := g.policy.Check(transaction); err != nil {
+		return nil, err
+	}
+	return g.UserApp.SignSECP256K1(bip32Path, transaction, p2)
+}
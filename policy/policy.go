@@ -0,0 +1,316 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+// Package policy checks amino sign docs against treasury rules before they reach the device.
+package policy
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+// Names of the rules, as reported in violations
+const (
+	RuleChainID        = "chain_ids"
+	RuleMaxFee         = "max_fee"
+	RuleMsgType        = "msg_types"
+	RuleDepositActions = "deposit_actions"
+	RuleRecipient      = "recipients"
+	RuleMaxAmount      = "max_amount"
+)
+
+// Rules is a policy file, in TOML or YAML. Empty rules are not enforced. Amounts are in base units:
+//
+//	chain_ids = ["thorchain-1"]
+//	msg_types = ["thorchain/MsgSend", "thorchain/MsgDeposit"]
+//	deposit_actions = ["SWAP", "ADD"]
+//	recipients = ["thor1...", "bc1q..."]
+//
+//	[max_fee]
+//	rune = "2000000"
+//
+//	[max_amount]
+//	rune = "100000000000"
+type Rules struct {
+	// ChainIDs are the chains that can be signed for
+	ChainIDs []string `toml:"chain_ids" yaml:"chain_ids"`
+	// MaxFee is the maximum fee per denom. Fees in other denoms are refused
+	MaxFee map[string]string `toml:"max_fee" yaml:"max_fee"`
+	// MsgTypes are the amino types of the messages that can be signed
+	MsgTypes []string `toml:"msg_types" yaml:"msg_types"`
+	// DepositActions are the memo actions allowed in MsgDeposit, such as SWAP or ADD. Shorthands are resolved
+	DepositActions []string `toml:"deposit_actions" yaml:"deposit_actions"`
+	// Recipients are the allowed MsgSend recipients and swap and loan destinations
+	Recipients []string `toml:"recipients" yaml:"recipients"`
+	// MaxAmount is the maximum amount of a denom sent or deposited by one sign doc. Other denoms are not limited
+	MaxAmount map[string]string `toml:"max_amount" yaml:"max_amount"`
+}
+
+// Violation is a rule broken by a sign doc
+type Violation struct {
+	Rule string `json:"rule"`
+	// Msg is the 1-based index of the message, or 0 for the sign doc itself
+	Msg    int    `json:"msg"`
+	Detail string `json:"detail"`
+}
+
+func (v Violation) String() string {
+	if v.Msg == 0 {
+		return fmt.Sprintf("%s: %s", v.Rule, v.Detail)
+	}
+	return fmt.Sprintf("%s: message %d: %s", v.Rule, v.Msg, v.Detail)
+}
+
+// ViolationError a sign doc was refused by the policy
+type ViolationError struct {
+	Violations []Violation
+}
+
+func (e ViolationError) Error() string {
+	details := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		details[i] = v.String()
+	}
+	return "sign doc violates the signing policy: " + strings.Join(details, "; ")
+}
+
+// Policy evaluates sign docs against a set of rules
+type Policy struct {
+	rules          Rules
+	chainIDs       map[string]bool
+	msgTypes       map[string]bool
+	depositActions map[string]bool
+	recipients     map[string]bool
+	maxFee         map[string]*big.Int
+	maxAmount      map[string]*big.Int
+}
+
+// New validates the rules and returns the policy enforcing them
+func New(rules Rules) (*Policy, error) {
+	p := &Policy{
+		rules:          rules,
+		chainIDs:       toSet(rules.ChainIDs),
+		msgTypes:       toSet(rules.MsgTypes),
+		depositActions: map[string]bool{},
+		recipients:     toSet(rules.Recipients),
+	}
+
+	for _, action := range rules.DepositActions {
+		memo := ledger.ParseMemo(action)
+		if !memo.IsKnown() || len(memo.Fields) != 1 {
+			return nil, fmt.Errorf("unknown deposit action %q", action)
+		}
+		p.depositActions[memo.Action] = true
+	}
+
+	var err error
+	if p.maxFee, err = parseAmounts(RuleMaxFee, rules.MaxFee); err != nil {
+		return nil, err
+	}
+	if p.maxAmount, err = parseAmounts(RuleMaxAmount, rules.MaxAmount); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// LoadFile reads a policy file. The format is chosen by the extension: .toml, .yaml or .yml
+func LoadFile(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules Rules
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".toml":
+		err = toml.Unmarshal(data, &rules)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &rules)
+	default:
+		return nil, fmt.Errorf("unknown policy format %q: expected .toml, .yaml or .yml", filepath.Ext(file))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %w", file, err)
+	}
+
+	p, err := New(rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %w", file, err)
+	}
+	return p, nil
+}
+
+// Rules returns the rules enforced by the policy
+func (p *Policy) Rules() Rules {
+	return p.rules
+}
+
+// Check returns a *ViolationError if the sign doc breaks a rule, or the error of an invalid sign doc
+func (p *Policy) Check(signDoc []byte) error {
+	violations, err := p.Evaluate(signDoc)
+	if err != nil {
+		return err
+	}
+	if len(violations) > 0 {
+		return &ViolationError{violations}
+	}
+	return nil
+}
+
+// Evaluate returns every rule broken by an amino JSON sign doc
+func (p *Policy) Evaluate(signDoc []byte) ([]Violation, error) {
+	doc, err := ledger.ParseAminoSignDoc(signDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	var violations []Violation
+	add := func(rule string, msg int, format string, args ...interface{}) {
+		violations = append(violations, Violation{Rule: rule, Msg: msg, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	if len(p.chainIDs) > 0 && !p.chainIDs[doc.ChainID] {
+		add(RuleChainID, 0, "chain id %q is not allowed", doc.ChainID)
+	}
+
+	if p.maxFee != nil {
+		for _, coin := range doc.Fee.Amount {
+			amount, err := coin.Int()
+			if err != nil {
+				return nil, fmt.Errorf("invalid fee: %w", err)
+			}
+			max, ok := p.maxFee[coin.Denom]
+			switch {
+			case !ok:
+				add(RuleMaxFee, 0, "fee denom %q is not allowed", coin.Denom)
+			case amount.Cmp(max) > 0:
+				add(RuleMaxFee, 0, "fee of %s%s exceeds the maximum of %s%s", amount, coin.Denom, max, coin.Denom)
+			}
+		}
+	}
+
+	// Amounts are added up over the whole sign doc, so that a transfer cannot be split in several messages
+	totals := map[string]*big.Int{}
+	addAmounts := func(coins []ledger.Coin) error {
+		for _, coin := range coins {
+			amount, err := coin.Int()
+			if err != nil {
+				return err
+			}
+			if totals[coin.Denom] == nil {
+				totals[coin.Denom] = new(big.Int)
+			}
+			totals[coin.Denom].Add(totals[coin.Denom], amount)
+		}
+		return nil
+	}
+	checkRecipient := func(index int, address string) {
+		if len(p.recipients) > 0 && !p.recipients[address] {
+			add(RuleRecipient, index, "recipient %q is not allowed", address)
+		}
+	}
+
+	for i, aminoMsg := range doc.Msgs {
+		index := i + 1
+		if len(p.msgTypes) > 0 && !p.msgTypes[aminoMsg.Type] {
+			add(RuleMsgType, index, "message type %q is not allowed", aminoMsg.Type)
+		}
+
+		msg, err := aminoMsg.Decode()
+		if err != nil {
+			return nil, err
+		}
+
+		switch msg := msg.(type) {
+		case *ledger.MsgSend:
+			checkRecipient(index, msg.ToAddress)
+			if err := addAmounts(msg.Amount); err != nil {
+				return nil, fmt.Errorf("message %d: %w", index, err)
+			}
+
+		case *ledger.MsgDeposit:
+			memo := ledger.ParseMemo(msg.Memo)
+			if len(p.depositActions) > 0 && !p.depositActions[memo.Action] {
+				add(RuleDepositActions, index, "deposit memo %q is not allowed", msg.Memo)
+			}
+			if destination := memo.Destination(); destination != "" {
+				checkRecipient(index, destination)
+			}
+			coins := make([]ledger.Coin, len(msg.Coins))
+			for j, coin := range msg.Coins {
+				coins[j] = coin.Coin()
+			}
+			if err := addAmounts(coins); err != nil {
+				return nil, fmt.Errorf("message %d: %w", index, err)
+			}
+
+		default:
+			// Funds moved by other messages cannot be checked, so they are refused when funds are limited
+			if len(p.recipients) > 0 || len(p.maxAmount) > 0 {
+				add(RuleMsgType, index, "message type %q cannot be checked by the policy", aminoMsg.Type)
+			}
+		}
+	}
+
+	denoms := make([]string, 0, len(totals))
+	for denom := range totals {
+		denoms = append(denoms, denom)
+	}
+	sort.Strings(denoms)
+	for _, denom := range denoms {
+		if max, ok := p.maxAmount[denom]; ok && totals[denom].Cmp(max) > 0 {
+			add(RuleMaxAmount, 0, "total of %s%s exceeds the maximum of %s%s", totals[denom], denom, max, denom)
+		}
+	}
+
+	return violations, nil
+}
+
+func toSet(values []string) map[string]bool {
+	set := map[string]bool{}
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// parseAmounts returns nil for an empty table, which is not enforced like the other empty rules
+func parseAmounts(rule string, amounts map[string]string) (map[string]*big.Int, error) {
+	if len(amounts) == 0 {
+		return nil, nil
+	}
+	parsed := map[string]*big.Int{}
+	for denom, s := range amounts {
+		if denom == "" {
+			return nil, fmt.Errorf("%s: empty denom", rule)
+		}
+		amount, err := ledger.ParseAmount(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", rule, err)
+		}
+		parsed[denom] = amount
+	}
+	return parsed, nil
+}
@@ -0,0 +1,201 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+const testPolicyTOML = `
+chain_ids = ["thorchain-1"]
+msg_types = ["thorchain/MsgSend", "thorchain/MsgDeposit"]
+deposit_actions = ["SWAP", "ADD"]
+recipients = ["thor1treasury", "bc1qtreasury"]
+
+[max_fee]
+rune = "2000000"
+
+[max_amount]
+rune = "100000000000"
+`
+
+const testPolicyYAML = `
+chain_ids: [thorchain-1]
+msg_types: [thorchain/MsgSend, thorchain/MsgDeposit]
+deposit_actions: [SWAP, ADD]
+recipients: [thor1treasury, bc1qtreasury]
+max_fee:
+  rune: "2000000"
+max_amount:
+  rune: "100000000000"
+`
+
+func signDoc(chainID string, fee string, msgs string) []byte {
+	return []byte(`{"account_number":"12","chain_id":"` + chainID + `","fee":{"amount":[` + fee + `],"gas":"4000000"},"memo":"","msgs":[` + msgs + `],"sequence":"3"}`)
+}
+
+func send(to string, amount string) string {
+	return `{"type":"thorchain/MsgSend","value":{"amount":[{"amount":"` + amount + `","denom":"rune"}],"from_address":"thor1a","to_address":"` + to + `"}}`
+}
+
+func deposit(memo string, amount string) string {
+	return `{"type":"thorchain/MsgDeposit","value":{"coins":[{"amount":"` + amount + `","asset":"THOR.RUNE"}],"memo":"` + memo + `","signer":"thor1a"}}`
+}
+
+const runeFee = `{"amount":"2000000","denom":"rune"}`
+
+func loadTestPolicy(t *testing.T, name string, content string) *Policy {
+	file := filepath.Join(t.TempDir(), name)
+	require.Nil(t, os.WriteFile(file, []byte(content), 0o600))
+	p, err := LoadFile(file)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	return p
+}
+
+func Test_LoadFile(t *testing.T) {
+	fromTOML := loadTestPolicy(t, "policy.toml", testPolicyTOML)
+	fromYAML := loadTestPolicy(t, "policy.yaml", testPolicyYAML)
+	assert.Equal(t, fromTOML.Rules(), fromYAML.Rules())
+	assert.Equal(t, []string{"SWAP", "ADD"}, fromTOML.Rules().DepositActions)
+
+	_, err := LoadFile(filepath.Join(t.TempDir(), "policy.json"))
+	assert.Error(t, err)
+
+	_, err = New(Rules{DepositActions: []string{"STEAL"}})
+	assert.Error(t, err)
+	_, err = New(Rules{MaxFee: map[string]string{"rune": "-1"}})
+	assert.Error(t, err)
+}
+
+func Test_EmptyMaxFee(t *testing.T) {
+	// An empty table is not enforced, instead of refusing every fee
+	for name, content := range map[string]string{
+		"policy.toml": "chain_ids = [\"thorchain-1\"]\n\n[max_fee]\n",
+		"policy.yaml": "chain_ids: [thorchain-1]\nmax_fee: {}\n",
+	} {
+		p := loadTestPolicy(t, name, content)
+		assert.NotNil(t, p.Rules().MaxFee, name)
+		violations, err := p.Evaluate(signDoc("thorchain-1", runeFee, send("thor1treasury", "1")))
+		require.Nil(t, err, "Detected error, err: %s\n", err)
+		assert.Empty(t, violations, name)
+	}
+}
+
+func Test_Evaluate(t *testing.T) {
+	p := loadTestPolicy(t, "policy.toml", testPolicyTOML)
+
+	tests := []struct {
+		name     string
+		doc      []byte
+		expected []Violation
+	}{
+		{
+			name: "allowed",
+			doc:  signDoc("thorchain-1", runeFee, send("thor1treasury", "100000000")+","+deposit("=:BTC.BTC:bc1qtreasury", "1000")+","+deposit("+:BTC.BTC", "1000")),
+		},
+		{
+			name:     "chain id",
+			doc:      signDoc("thorchain-stagenet-v2", runeFee, send("thor1treasury", "1")),
+			expected: []Violation{{Rule: RuleChainID, Detail: `chain id "thorchain-stagenet-v2" is not allowed`}},
+		},
+		{
+			name: "fee",
+			doc:  signDoc("thorchain-1", `{"amount":"2000001","denom":"rune"},{"amount":"1","denom":"tcy"}`, send("thor1treasury", "1")),
+			expected: []Violation{
+				{Rule: RuleMaxFee, Detail: "fee of 2000001rune exceeds the maximum of 2000000rune"},
+				{Rule: RuleMaxFee, Detail: `fee denom "tcy" is not allowed`},
+			},
+		},
+		{
+			name:     "deposit action",
+			doc:      signDoc("thorchain-1", runeFee, send("thor1treasury", "1")+","+deposit("WITHDRAW:BTC.BTC:10000", "0")),
+			expected: []Violation{{Rule: RuleDepositActions, Msg: 2, Detail: `deposit memo "WITHDRAW:BTC.BTC:10000" is not allowed`}},
+		},
+		{
+			name: "recipients",
+			doc:  signDoc("thorchain-1", runeFee, send("thor1attacker", "1")+","+deposit("SWAP:BTC.BTC:bc1qattacker", "1")),
+			expected: []Violation{
+				{Rule: RuleRecipient, Msg: 1, Detail: `recipient "thor1attacker" is not allowed`},
+				{Rule: RuleRecipient, Msg: 2, Detail: `recipient "bc1qattacker" is not allowed`},
+			},
+		},
+		{
+			name:     "amount split over messages",
+			doc:      signDoc("thorchain-1", runeFee, send("thor1treasury", "60000000000")+","+send("thor1treasury", "40000000001")),
+			expected: []Violation{{Rule: RuleMaxAmount, Detail: "total of 100000000001rune exceeds the maximum of 100000000000rune"}},
+		},
+		{
+			name: "unchecked message type",
+			doc:  signDoc("thorchain-1", runeFee, `{"type":"cosmos-sdk/MsgMultiSend","value":{}}`),
+			expected: []Violation{
+				{Rule: RuleMsgType, Msg: 1, Detail: `message type "cosmos-sdk/MsgMultiSend" is not allowed`},
+				{Rule: RuleMsgType, Msg: 1, Detail: `message type "cosmos-sdk/MsgMultiSend" cannot be checked by the policy`},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			violations, err := p.Evaluate(tc.doc)
+			require.Nil(t, err, "Detected error, err: %s\n", err)
+			assert.Equal(t, tc.expected, violations)
+		})
+	}
+
+	_, err := p.Evaluate([]byte(`{"chain_id":"thorchain-1"`))
+	assert.Error(t, err)
+}
+
+// recordingApp counts the sign requests that reach the device
+type recordingApp struct {
+	UserApp
+	signed int
+}
+
+func (a *recordingApp) SignSECP256K1(bip32Path []uint32, transaction []byte, p2 byte) ([]byte, error) {
+	a.signed++
+	return []byte{0x30}, nil
+}
+
+func Test_Guard(t *testing.T) {
+	p := loadTestPolicy(t, "policy.toml", testPolicyTOML)
+	app := &recordingApp{}
+	guard := NewGuard(app, p)
+	path := []uint32{44, 931, 0, 0, 0}
+
+	_, err := guard.SignSECP256K1(path, signDoc("thorchain-1", runeFee, send("thor1treasury", "1")), 0)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, 1, app.signed)
+
+	_, err = guard.SignSECP256K1(path, signDoc("thorchain-1", runeFee, send("thor1attacker", "1")), 0)
+	var violationErr *ViolationError
+	require.True(t, errors.As(err, &violationErr))
+	assert.Equal(t, RuleRecipient, violationErr.Violations[0].Rule)
+	assert.Contains(t, err.Error(), `recipient "thor1attacker" is not allowed`)
+
+	_, err = guard.SignSECP256K1(path, []byte{0xa1}, byte(ledger.SignModeTextual))
+	assert.Error(t, err)
+	assert.Equal(t, 1, app.signed)
+}
@@ -0,0 +1,303 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+// Package preview renders the payload given to SignSECP256K1 so that it can be reviewed
+// on the host before it is confirmed on the device.
+package preview
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+// Decimals of the THORChain native denoms
+const Decimals = 8
+
+// Preview is the structured summary of a sign doc
+type Preview struct {
+	Mode string `json:"mode"`
+	// Digest is the hex SHA-256 of the payload, which is also the hash signed by the device
+	Digest string `json:"digest"`
+	Size   int    `json:"size"`
+
+	ChainID       string    `json:"chain_id,omitempty"`
+	AccountNumber string    `json:"account_number,omitempty"`
+	Sequence      string    `json:"sequence,omitempty"`
+	Fee           []Amount  `json:"fee,omitempty"`
+	Gas           string    `json:"gas,omitempty"`
+	Memo          *Intent   `json:"memo,omitempty"`
+	Messages      []Message `json:"messages,omitempty"`
+	Screens       []Screen  `json:"screens,omitempty"`
+}
+
+// Amount is an amount in base units and its display value
+type Amount struct {
+	Denom   string `json:"denom"`
+	Amount  string `json:"amount"`
+	Display string `json:"display"`
+}
+
+// Message is a message of an amino sign doc. Only the type is known for messages other than sends and deposits
+type Message struct {
+	Type    string   `json:"type"`
+	From    string   `json:"from,omitempty"`
+	To      string   `json:"to,omitempty"`
+	Amounts []Amount `json:"amounts,omitempty"`
+	Memo    *Intent  `json:"memo,omitempty"`
+}
+
+// Intent is a decoded THORChain memo
+type Intent struct {
+	Raw         string `json:"raw"`
+	Action      string `json:"action"`
+	Description string `json:"description"`
+}
+
+// Screen is a screen of a textual sign doc
+type Screen struct {
+	Title   string `json:"title,omitempty"`
+	Content string `json:"content,omitempty"`
+	Indent  int    `json:"indent,omitempty"`
+	Expert  bool   `json:"expert,omitempty"`
+}
+
+// Digest returns the hex SHA-256 of a payload
+func Digest(payload []byte) string {
+	hash := sha256.Sum256(payload)
+	return hex.EncodeToString(hash[:])
+}
+
+// Render decodes an amino JSON or textual payload
+func Render(payload []byte, mode ledger.SignMode) (*Preview, error) {
+	p := &Preview{Mode: mode.String(), Digest: Digest(payload), Size: len(payload)}
+
+	switch mode {
+	case ledger.SignModeLegacyAmino:
+		if err := p.renderAmino(payload); err != nil {
+			return nil, err
+		}
+	case ledger.SignModeTextual:
+		screens, err := decodeTextual(payload)
+		if err != nil {
+			return nil, err
+		}
+		p.Screens = screens
+		for _, screen := range screens {
+			if screen.Title == "Chain id" && screen.Indent == 0 {
+				p.ChainID = screen.Content
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown sign mode %d", mode)
+	}
+	return p, nil
+}
+
+// Matches returns true if payload is the one the preview was rendered from
+func (p *Preview) Matches(payload []byte) bool {
+	return p.Digest == Digest(payload)
+}
+
+func (p *Preview) renderAmino(payload []byte) error {
+	doc, err := ledger.ParseAminoSignDoc(payload)
+	if err != nil {
+		return err
+	}
+
+	p.ChainID = doc.ChainID
+	p.AccountNumber = doc.AccountNumber
+	p.Sequence = doc.Sequence
+	p.Gas = doc.Fee.Gas
+	if p.Fee, err = amounts(doc.Fee.Amount); err != nil {
+		return fmt.Errorf("invalid fee: %w", err)
+	}
+	if doc.Memo != "" {
+		p.Memo = intent(doc.Memo)
+	}
+
+	for i, aminoMsg := range doc.Msgs {
+		msg, err := aminoMsg.Decode()
+		if err != nil {
+			return err
+		}
+
+		m := Message{Type: aminoMsg.Type}
+		switch msg := msg.(type) {
+		case *ledger.MsgSend:
+			m.From, m.To = msg.FromAddress, msg.ToAddress
+			m.Amounts, err = amounts(msg.Amount)
+		case *ledger.MsgDeposit:
+			coins := make([]ledger.Coin, len(msg.Coins))
+			for j, coin := range msg.Coins {
+				coins[j] = coin.Coin()
+			}
+			m.From = msg.Signer
+			m.Amounts, err = amounts(coins)
+			m.Memo = intent(msg.Memo)
+		}
+		if err != nil {
+			return fmt.Errorf("message %d: %w", i+1, err)
+		}
+		p.Messages = append(p.Messages, m)
+	}
+	return nil
+}
+
+// Text returns the preview formatted for a terminal
+func (p *Preview) Text() string {
+	var b strings.Builder
+	line := func(indent int, label string, value string) {
+		fmt.Fprintf(&b, "%s%-*s %s\n", strings.Repeat("  ", indent), 12-2*indent, label+":", value)
+	}
+
+	if p.Screens != nil {
+		line(0, "Sign mode", p.Mode)
+		for _, screen := range p.Screens {
+			title := screen.Title
+			if screen.Expert {
+				title += " (expert)"
+			}
+			if title == "" {
+				fmt.Fprintf(&b, "%s%s\n", strings.Repeat("  ", screen.Indent), screen.Content)
+				continue
+			}
+			line(screen.Indent, title, screen.Content)
+		}
+		line(0, "Digest", p.Digest)
+		return b.String()
+	}
+
+	line(0, "Chain", p.ChainID)
+	line(0, "Account", fmt.Sprintf("%s, sequence %s", p.AccountNumber, p.Sequence))
+	line(0, "Fee", fmt.Sprintf("%s (gas %s)", displayAmounts(p.Fee), p.Gas))
+	if p.Memo != nil {
+		line(0, "Memo", p.Memo.Raw)
+		line(0, "Intent", p.Memo.Description)
+	}
+	for i, m := range p.Messages {
+		line(0, fmt.Sprintf("Message %d", i+1), m.Type)
+		if m.From != "" {
+			line(1, "From", m.From)
+		}
+		if m.To != "" {
+			line(1, "To", m.To)
+		}
+		if m.Amounts != nil {
+			line(1, "Amount", displayAmounts(m.Amounts))
+		}
+		if m.Memo != nil {
+			line(1, "Memo", m.Memo.Raw)
+			line(1, "Intent", m.Memo.Description)
+		}
+	}
+	line(0, "Digest", p.Digest)
+	return b.String()
+}
+
+// FormatAmount formats an amount in base units with the given number of decimals, without trailing zeros
+func FormatAmount(amount *big.Int, decimals int) string {
+	s := amount.String()
+	if decimals <= 0 {
+		return s
+	}
+	if len(s) <= decimals {
+		s = strings.Repeat("0", decimals-len(s)+1) + s
+	}
+	integer, fraction := s[:len(s)-decimals], strings.TrimRight(s[len(s)-decimals:], "0")
+	if fraction == "" {
+		return integer
+	}
+	return integer + "." + fraction
+}
+
+func amounts(coins []ledger.Coin) ([]Amount, error) {
+	result := []Amount{}
+	for _, coin := range coins {
+		amount, err := coin.Int()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, Amount{
+			Denom:   coin.Denom,
+			Amount:  coin.Amount,
+			Display: FormatAmount(amount, Decimals) + " " + strings.ToUpper(coin.Denom),
+		})
+	}
+	return result, nil
+}
+
+func displayAmounts(amounts []Amount) string {
+	if len(amounts) == 0 {
+		return "none"
+	}
+	display := make([]string, len(amounts))
+	for i, a := range amounts {
+		display[i] = a.Display
+	}
+	return strings.Join(display, ", ")
+}
+
+// intent describes what THORChain does with a memo
+func intent(raw string) *Intent {
+	memo := ledger.ParseMemo(raw)
+	i := &Intent{Raw: raw, Action: memo.Action}
+
+	var d bytes.Buffer
+	switch memo.Action {
+	case ledger.MemoSwap:
+		fmt.Fprintf(&d, "swap to %s", memo.Asset())
+		if dest := memo.Destination(); dest != "" {
+			fmt.Fprintf(&d, ", destination %s", dest)
+		}
+		if limit := memo.Field(3); limit != "" {
+			fmt.Fprintf(&d, ", limit %s", limit)
+		}
+	case ledger.MemoAdd:
+		fmt.Fprintf(&d, "add liquidity to %s", memo.Asset())
+		if paired := memo.Field(2); paired != "" {
+			fmt.Fprintf(&d, ", paired address %s", paired)
+		}
+	case ledger.MemoWithdraw:
+		fmt.Fprintf(&d, "withdraw liquidity from %s", memo.Asset())
+		if bps, ok := new(big.Int).SetString(memo.Field(2), 10); ok {
+			fmt.Fprintf(&d, ", %s%%", FormatAmount(bps, 2))
+		}
+	case ledger.MemoDonate:
+		fmt.Fprintf(&d, "donate to %s", memo.Asset())
+	case ledger.MemoBond:
+		fmt.Fprintf(&d, "bond to node %s", memo.Field(1))
+	case ledger.MemoUnbond:
+		fmt.Fprintf(&d, "unbond %s from node %s", memo.Field(2), memo.Field(1))
+	case ledger.MemoLeave:
+		fmt.Fprintf(&d, "node %s leaves", memo.Field(1))
+	case ledger.MemoReserve:
+		d.WriteString("add to the protocol reserve")
+	case ledger.MemoLoanOpen:
+		fmt.Fprintf(&d, "open loan, receive %s at %s", memo.Asset(), memo.Destination())
+	case ledger.MemoLoanRepay:
+		fmt.Fprintf(&d, "repay loan of %s collateral", memo.Asset())
+	default:
+		d.WriteString("unknown memo")
+	}
+	i.Description = d.String()
+	return i
+}
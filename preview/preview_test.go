@@ -0,0 +1,146 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package preview
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+const testSignDoc = `{"account_number":"12","chain_id":"thorchain-1","fee":{"amount":[{"amount":"2000000","denom":"rune"}],"gas":"4000000"},"memo":"","msgs":[` +
+	`{"type":"thorchain/MsgSend","value":{"amount":[{"amount":"150000000","denom":"rune"}],"from_address":"thor1a","to_address":"thor1b"}},` +
+	`{"type":"thorchain/MsgDeposit","value":{"coins":[{"amount":"50000000","asset":"THOR.RUNE"}],"memo":"=:BTC.BTC:bc1qdest:1000","signer":"thor1a"}}],"sequence":"3"}`
+
+const testText = `Chain:       thorchain-1
+Account:     12, sequence 3
+Fee:         0.02 RUNE (gas 4000000)
+Message 1:   thorchain/MsgSend
+  From:      thor1a
+  To:        thor1b
+  Amount:    1.5 RUNE
+Message 2:   thorchain/MsgDeposit
+  From:      thor1a
+  Amount:    0.5 RUNE
+  Memo:      =:BTC.BTC:bc1qdest:1000
+  Intent:    swap to BTC.BTC, destination bc1qdest, limit 1000
+Digest:      `
+
+func Test_RenderAmino(t *testing.T) {
+	p, err := Render([]byte(testSignDoc), ledger.SignModeLegacyAmino)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	assert.Equal(t, "amino", p.Mode)
+	assert.Equal(t, "thorchain-1", p.ChainID)
+	assert.Equal(t, []Amount{{Denom: "rune", Amount: "2000000", Display: "0.02 RUNE"}}, p.Fee)
+	require.Len(t, p.Messages, 2)
+	assert.Equal(t, "thor1b", p.Messages[0].To)
+	assert.Equal(t, ledger.MemoSwap, p.Messages[1].Memo.Action)
+	assert.Equal(t, testText+p.Digest+"\n", p.Text())
+
+	assert.Equal(t, Digest([]byte(testSignDoc)), p.Digest)
+	assert.True(t, p.Matches([]byte(testSignDoc)))
+	assert.False(t, p.Matches([]byte(testSignDoc+" ")))
+
+	_, err = Render([]byte(`{"chain_id":"thorchain-1"}`), ledger.SignModeLegacyAmino)
+	assert.Error(t, err)
+}
+
+// cborHead and encodeText encode the few CBOR items of a textual sign doc
+func cborHead(major byte, n int) []byte {
+	if n < 24 {
+		return []byte{major<<5 | byte(n)}
+	}
+	return []byte{major<<5 | 24, byte(n)}
+}
+
+func encodeText(s string) []byte {
+	return append(cborHead(cborText, len(s)), s...)
+}
+
+func textualPayload(screens ...[]byte) []byte {
+	payload := append(cborHead(cborMap, 1), cborHead(cborUint, textualScreensKey)...)
+	payload = append(payload, cborHead(cborArray, len(screens))...)
+	for _, screen := range screens {
+		payload = append(payload, screen...)
+	}
+	return payload
+}
+
+func Test_RenderTextual(t *testing.T) {
+	chain := append(cborHead(cborMap, 2), cborHead(cborUint, screenTitleKey)...)
+	chain = append(chain, encodeText("Chain id")...)
+	chain = append(chain, cborHead(cborUint, screenContentKey)...)
+	chain = append(chain, encodeText("thorchain-1")...)
+
+	hash := append(cborHead(cborMap, 4), cborHead(cborUint, screenTitleKey)...)
+	hash = append(hash, encodeText("Hash of raw bytes")...)
+	hash = append(hash, cborHead(cborUint, screenContentKey)...)
+	hash = append(hash, encodeText("abcd")...)
+	hash = append(hash, cborHead(cborUint, screenIndentKey)...)
+	hash = append(hash, cborHead(cborUint, 1)...)
+	hash = append(hash, cborHead(cborUint, screenExpertKey)...)
+	hash = append(hash, cborSimple<<5|cborTrue)
+
+	payload := textualPayload(chain, hash)
+	p, err := Render(payload, ledger.SignModeTextual)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, "thorchain-1", p.ChainID)
+	assert.Equal(t, []Screen{
+		{Title: "Chain id", Content: "thorchain-1"},
+		{Title: "Hash of raw bytes", Content: "abcd", Indent: 1, Expert: true},
+	}, p.Screens)
+	assert.Contains(t, p.Text(), "  Hash of raw bytes (expert): abcd\n")
+
+	_, err = Render(payload[:len(payload)-1], ledger.SignModeTextual)
+	assert.Error(t, err)
+	_, err = Render(append(payload, 0), ledger.SignModeTextual)
+	assert.Error(t, err)
+	_, err = Render([]byte(testSignDoc), ledger.SignModeTextual)
+	assert.Error(t, err)
+}
+
+func Test_FormatAmount(t *testing.T) {
+	tests := map[int64]string{
+		0:          "0",
+		1:          "0.00000001",
+		100000000:  "1",
+		150000000:  "1.5",
+		2000000:    "0.02",
+		1234567890: "12.3456789",
+	}
+	for amount, expected := range tests {
+		assert.Equal(t, expected, FormatAmount(big.NewInt(amount), Decimals))
+	}
+}
+
+func Test_Intent(t *testing.T) {
+	tests := map[string]string{
+		"+:BTC.BTC:bc1qpaired":  "add liquidity to BTC.BTC, paired address bc1qpaired",
+		"WITHDRAW:BTC.BTC:5000": "withdraw liquidity from BTC.BTC, 50%",
+		"BOND:thor1node":        "bond to node thor1node",
+		"$+:ETH.ETH:0xdest:1":   "open loan, receive ETH.ETH at 0xdest",
+		"hello":                 "unknown memo",
+	}
+	for memo, expected := range tests {
+		assert.Equal(t, expected, intent(memo).Description, memo)
+	}
+}
@@ -0,0 +1,198 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package preview
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// CBOR major types used by textual sign docs
+const (
+	cborUint   = 0
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborSimple = 7
+
+	cborFalse = 20
+	cborTrue  = 21
+
+	maxScreens = 1024
+)
+
+// Keys of the textual sign doc and screen maps (ADR-050)
+const (
+	textualScreensKey = 1
+
+	screenTitleKey   = 1
+	screenContentKey = 2
+	screenIndentKey  = 3
+	screenExpertKey  = 4
+)
+
+// decodeTextual decodes a SIGN_MODE_TEXTUAL payload: a CBOR map with the array of screens at key 1
+func decodeTextual(payload []byte) ([]Screen, error) {
+	r := &cborReader{data: payload}
+	n, err := r.header(cborMap)
+	if err != nil {
+		return nil, err
+	}
+
+	var screens []Screen
+	for i := uint64(0); i < n; i++ {
+		key, err := r.header(cborUint)
+		if err != nil {
+			return nil, err
+		}
+		if key != textualScreensKey {
+			return nil, fmt.Errorf("invalid textual sign doc: unknown key %d", key)
+		}
+		if screens, err = r.screens(); err != nil {
+			return nil, err
+		}
+	}
+	if len(r.data) > 0 {
+		return nil, errors.New("invalid textual sign doc: trailing bytes")
+	}
+	if screens == nil {
+		return nil, errors.New("invalid textual sign doc: no screens")
+	}
+	return screens, nil
+}
+
+type cborReader struct {
+	data []byte
+}
+
+func (r *cborReader) screens() ([]Screen, error) {
+	n, err := r.header(cborArray)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxScreens {
+		return nil, fmt.Errorf("invalid textual sign doc: %d screens", n)
+	}
+
+	screens := make([]Screen, 0, n)
+	for i := uint64(0); i < n; i++ {
+		fields, err := r.header(cborMap)
+		if err != nil {
+			return nil, err
+		}
+
+		var screen Screen
+		for j := uint64(0); j < fields; j++ {
+			key, err := r.header(cborUint)
+			if err != nil {
+				return nil, err
+			}
+			switch key {
+			case screenTitleKey:
+				screen.Title, err = r.text()
+			case screenContentKey:
+				screen.Content, err = r.text()
+			case screenIndentKey:
+				var indent uint64
+				indent, err = r.header(cborUint)
+				if indent > 16 {
+					err = fmt.Errorf("invalid textual sign doc: indent %d", indent)
+				}
+				screen.Indent = int(indent)
+			case screenExpertKey:
+				screen.Expert, err = r.bool()
+			default:
+				err = fmt.Errorf("invalid textual sign doc: unknown screen key %d", key)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		screens = append(screens, screen)
+	}
+	return screens, nil
+}
+
+// header reads the head of an item of the expected major type and returns its argument
+func (r *cborReader) header(majorType byte) (uint64, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("invalid textual sign doc: unexpected end")
+	}
+	major, info := r.data[0]>>5, r.data[0]&0x1f
+	if major != majorType {
+		return 0, fmt.Errorf("invalid textual sign doc: expected major type %d, found %d", majorType, major)
+	}
+	r.data = r.data[1:]
+
+	var size int
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, errors.New("invalid textual sign doc: indefinite lengths are not allowed")
+	}
+	if len(r.data) < size {
+		return 0, errors.New("invalid textual sign doc: unexpected end")
+	}
+
+	var buf [8]byte
+	copy(buf[8-size:], r.data[:size])
+	r.data = r.data[size:]
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+func (r *cborReader) text() (string, error) {
+	n, err := r.header(cborText)
+	if err != nil {
+		return "", err
+	}
+	if uint64(len(r.data)) < n {
+		return "", errors.New("invalid textual sign doc: unexpected end")
+	}
+	s := r.data[:n]
+	r.data = r.data[n:]
+	if !utf8.Valid(s) {
+		return "", errors.New("invalid textual sign doc: invalid UTF-8")
+	}
+	return string(s), nil
+}
+
+func (r *cborReader) bool() (bool, error) {
+	if len(r.data) == 0 {
+		return false, errors.New("invalid textual sign doc: unexpected end")
+	}
+	switch r.data[0] {
+	case cborSimple<<5 | cborTrue:
+		r.data = r.data[1:]
+		return true, nil
+	case cborSimple<<5 | cborFalse:
+		r.data = r.data[1:]
+		return false, nil
+	default:
+		return false, errors.New("invalid textual sign doc: expected a boolean")
+	}
+}
@@ -0,0 +1,152 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package privval
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// DaemonConfig is the configuration file of the signing daemon, in TOML or YAML.
+// Only the chains listed in the file are served:
+//
+//	log_format = "json"
+//
+//	[[chains]]
+//	chain_id = "thorchain-1"
+//	address = "tcp://10.0.0.1:26659"
+//	path = [44, 118, 0, 0, 0]
+//	state_file = "/var/lib/thorledger/thorchain-1.json"
+type DaemonConfig struct {
+	// LogFormat is json (default) or text
+	LogFormat string        `toml:"log_format" yaml:"log_format"`
+	Chains    []ChainConfig `toml:"chains" yaml:"chains"`
+}
+
+// ChainConfig describes one chain served by the daemon
+type ChainConfig struct {
+	ChainID string `toml:"chain_id" yaml:"chain_id"`
+	// Address of the node privval listener: tcp://host:port, tcp://<node id>@host:port or unix:///path
+	Address string   `toml:"address" yaml:"address"`
+	Path    []uint32 `toml:"path" yaml:"path"`
+	// StateFile keeps the double sign watermark of the chain
+	StateFile     string   `toml:"state_file" yaml:"state_file"`
+	RetryInterval Duration `toml:"retry_interval" yaml:"retry_interval"`
+}
+
+// Duration is a time.Duration written as a string such as "500ms"
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// LoadDaemonConfig reads a config file. The format is chosen by the extension: .toml, .yaml or .yml
+func LoadDaemonConfig(file string) (*DaemonConfig, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &DaemonConfig{}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".toml":
+		err = toml.Unmarshal(data, cfg)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	default:
+		return nil, fmt.Errorf("unknown config format %q: expected .toml, .yaml or .yml", filepath.Ext(file))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", file, err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", file, err)
+	}
+	return cfg, nil
+}
+
+// Validate checks that every chain is complete and that chains do not share a chain id or state file
+func (cfg *DaemonConfig) Validate() error {
+	switch cfg.LogFormat {
+	case "", "json", "text":
+	default:
+		return fmt.Errorf("unknown log format %q", cfg.LogFormat)
+	}
+
+	if len(cfg.Chains) == 0 {
+		return errors.New("no chain configured")
+	}
+
+	chainIDs := map[string]bool{}
+	stateFiles := map[string]bool{}
+	for i, chain := range cfg.Chains {
+		switch {
+		case chain.ChainID == "":
+			return fmt.Errorf("chain %d: chain_id is required", i)
+		case len(chain.Path) == 0:
+			return fmt.Errorf("chain %s: path is required", chain.ChainID)
+		case chain.StateFile == "":
+			return fmt.Errorf("chain %s: state_file is required", chain.ChainID)
+		case chain.RetryInterval < 0:
+			return fmt.Errorf("chain %s: retry_interval should not be negative", chain.ChainID)
+		}
+
+		if _, _, _, err := parseAddress(chain.Address); err != nil {
+			return fmt.Errorf("chain %s: %w", chain.ChainID, err)
+		}
+
+		if chainIDs[chain.ChainID] {
+			return fmt.Errorf("chain %s is configured twice", chain.ChainID)
+		}
+		chainIDs[chain.ChainID] = true
+
+		// A shared state file would mix the watermarks of two chains
+		stateFile := filepath.Clean(chain.StateFile)
+		if stateFiles[stateFile] {
+			return fmt.Errorf("chain %s: state file %s is used by another chain", chain.ChainID, chain.StateFile)
+		}
+		stateFiles[stateFile] = true
+	}
+	return nil
+}
+
+// NewLogger returns a logger in the configured format
+func (cfg *DaemonConfig) NewLogger(w io.Writer) Logger {
+	if cfg.LogFormat == "text" {
+		return NewTextLogger(w)
+	}
+	return NewJSONLogger(w)
+}
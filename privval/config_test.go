@@ -0,0 +1,124 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package privval
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTOMLConfig = `
+log_format = "text"
+
+[[chains]]
+chain_id = "thorchain-1"
+address = "tcp://127.0.0.1:26659"
+path = [44, 118, 0, 0, 0]
+state_file = "/var/lib/thorledger/thorchain-1.json"
+retry_interval = "500ms"
+
+[[chains]]
+chain_id = "thorchain-stagenet-2"
+address = "unix:///run/stagenet/privval.sock"
+path = [44, 118, 1, 0, 0]
+state_file = "/var/lib/thorledger/stagenet.json"
+`
+
+const testYAMLConfig = `
+log_format: text
+chains:
+  - chain_id: thorchain-1
+    address: tcp://127.0.0.1:26659
+    path: [44, 118, 0, 0, 0]
+    state_file: /var/lib/thorledger/thorchain-1.json
+    retry_interval: 500ms
+  - chain_id: thorchain-stagenet-2
+    address: unix:///run/stagenet/privval.sock
+    path: [44, 118, 1, 0, 0]
+    state_file: /var/lib/thorledger/stagenet.json
+`
+
+func writeTestConfig(t *testing.T, name string, content string) string {
+	file := filepath.Join(t.TempDir(), name)
+	require.Nil(t, os.WriteFile(file, []byte(content), 0o600))
+	return file
+}
+
+func Test_LoadDaemonConfig(t *testing.T) {
+	expected := &DaemonConfig{
+		LogFormat: "text",
+		Chains: []ChainConfig{
+			{
+				ChainID:       "thorchain-1",
+				Address:       "tcp://127.0.0.1:26659",
+				Path:          []uint32{44, 118, 0, 0, 0},
+				StateFile:     "/var/lib/thorledger/thorchain-1.json",
+				RetryInterval: Duration(500 * time.Millisecond),
+			},
+			{
+				ChainID:   "thorchain-stagenet-2",
+				Address:   "unix:///run/stagenet/privval.sock",
+				Path:      []uint32{44, 118, 1, 0, 0},
+				StateFile: "/var/lib/thorledger/stagenet.json",
+			},
+		},
+	}
+
+	cfg, err := LoadDaemonConfig(writeTestConfig(t, "signer.toml", testTOMLConfig))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, expected, cfg)
+
+	cfg, err = LoadDaemonConfig(writeTestConfig(t, "signer.yaml", testYAMLConfig))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, expected, cfg)
+
+	_, err = LoadDaemonConfig(writeTestConfig(t, "signer.json", "{}"))
+	assert.Error(t, err)
+}
+
+func Test_DaemonConfig_Validate(t *testing.T) {
+	valid := func() *DaemonConfig {
+		return &DaemonConfig{Chains: []ChainConfig{
+			{ChainID: "a", Address: "tcp://0123456789abcdef0123456789abcdef01234567@127.0.0.1:1", Path: []uint32{44}, StateFile: "a.json"},
+			{ChainID: "b", Address: "unix:///tmp/b.sock", Path: []uint32{44}, StateFile: "b.json"},
+		}}
+	}
+	assert.Nil(t, valid().Validate())
+
+	invalid := map[string]func(cfg *DaemonConfig){
+		"no chains":          func(cfg *DaemonConfig) { cfg.Chains = nil },
+		"log format":         func(cfg *DaemonConfig) { cfg.LogFormat = "xml" },
+		"missing chain id":   func(cfg *DaemonConfig) { cfg.Chains[0].ChainID = "" },
+		"duplicate chain id": func(cfg *DaemonConfig) { cfg.Chains[1].ChainID = "a" },
+		"address":            func(cfg *DaemonConfig) { cfg.Chains[0].Address = "127.0.0.1:1" },
+		"node id":            func(cfg *DaemonConfig) { cfg.Chains[0].Address = "tcp://0123@127.0.0.1:1" },
+		"missing path":       func(cfg *DaemonConfig) { cfg.Chains[0].Path = nil },
+		"missing state file": func(cfg *DaemonConfig) { cfg.Chains[0].StateFile = "" },
+		"shared state file":  func(cfg *DaemonConfig) { cfg.Chains[1].StateFile = "./a.json" },
+		"retry interval":     func(cfg *DaemonConfig) { cfg.Chains[0].RetryInterval = -1 },
+	}
+	for name, change := range invalid {
+		cfg := valid()
+		change(cfg)
+		assert.Error(t, cfg.Validate(), name)
+	}
+}
@@ -0,0 +1,145 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package privval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+// ValidatorApp is the part of LedgerTendermintValidator used by the daemon
+type ValidatorApp interface {
+	ledger.ED25519Signer
+	GetPublicKeyED25519(bip32Path []uint32) ([]byte, error)
+}
+
+// Daemon serves several chains with a single validator app.
+// Each chain has its own node connection and double sign watermark
+type Daemon struct {
+	servers []*Server
+	guards  map[string]*ledger.DoubleSignGuard
+	log     Logger
+}
+
+// NewDaemon creates one server per configured chain. The state files are loaded or created
+func NewDaemon(cfg *DaemonConfig, validator ValidatorApp, logger Logger) (*Daemon, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if logger == nil {
+		logger = NopLogger()
+	}
+
+	// The device handles one command at a time
+	device := &lockedValidator{app: validator}
+
+	d := &Daemon{guards: map[string]*ledger.DoubleSignGuard{}, log: logger}
+	for _, chain := range cfg.Chains {
+		pubKey, err := device.GetPublicKeyED25519(chain.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		guard, err := ledger.NewDoubleSignGuard(device, chain.StateFile)
+		if err != nil {
+			return nil, err
+		}
+		state := guard.State()
+		logger.Info("loaded sign state", "chain_id", chain.ChainID, "state_file", chain.StateFile,
+			"height", state.Height, "round", state.Round, "step", state.Step)
+
+		server, err := NewServer(Config{
+			Address:       chain.Address,
+			ChainID:       chain.ChainID,
+			Path:          chain.Path,
+			RetryInterval: time.Duration(chain.RetryInterval),
+			Logger:        logger,
+		}, guard, pubKey)
+		if err != nil {
+			return nil, err
+		}
+
+		d.servers = append(d.servers, server)
+		d.guards[chain.ChainID] = guard
+	}
+	return d, nil
+}
+
+// Run serves all chains until ctx is done. A server that stops with an error,
+// e.g. because its node asks for another chain, stops the other servers and
+// its error is returned
+func (d *Daemon) Run(ctx context.Context) error {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make([]error, len(d.servers))
+	var wg sync.WaitGroup
+	for i, server := range d.servers {
+		wg.Add(1)
+		go func(i int, server *Server) {
+			defer wg.Done()
+			if err := server.Run(runCtx); err != nil && !errors.Is(err, runCtx.Err()) {
+				server.log.Error("server stopped", "err", err)
+				errs[i] = fmt.Errorf("chain %s: %w", server.cfg.ChainID, err)
+				cancel()
+			}
+		}(i, server)
+	}
+
+	d.log.Info("daemon started", "chains", len(d.servers))
+	wg.Wait()
+	d.log.Info("daemon stopped")
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// Watermarks returns the last signed height/round/step of each chain
+func (d *Daemon) Watermarks() map[string]ledger.SignState {
+	watermarks := make(map[string]ledger.SignState, len(d.guards))
+	for chainID, guard := range d.guards {
+		watermarks[chainID] = guard.State()
+	}
+	return watermarks
+}
+
+// lockedValidator serializes the commands sent to the device by the chain servers
+type lockedValidator struct {
+	mtx sync.Mutex
+	app ValidatorApp
+}
+
+func (v *lockedValidator) SignED25519(bip32Path []uint32, message []byte) ([]byte, error) {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	return v.app.SignED25519(bip32Path, message)
+}
+
+func (v *lockedValidator) GetPublicKeyED25519(bip32Path []uint32) ([]byte, error) {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	return v.app.GetPublicKeyED25519(bip32Path)
+}
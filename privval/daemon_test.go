@@ -0,0 +1,160 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package privval
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+// keyValidator emulates the validator app with one in-memory key
+type keyValidator struct {
+	keySigner
+}
+
+func (v *keyValidator) GetPublicKeyED25519(bip32Path []uint32) ([]byte, error) {
+	if len(bip32Path) == 0 {
+		return nil, errors.New("empty path")
+	}
+	return v.key.Public().(ed25519.PublicKey), nil
+}
+
+// safeBuffer is a bytes.Buffer that can be written by several goroutines
+type safeBuffer struct {
+	lockedWriter
+	buf bytes.Buffer
+}
+
+func newSafeBuffer() *safeBuffer {
+	b := &safeBuffer{}
+	b.w = &b.buf
+	return b
+}
+
+func (b *safeBuffer) String() string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.String()
+}
+
+func Test_Daemon(t *testing.T) {
+	dir := t.TempDir()
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	pubKey := key.Public().(ed25519.PublicKey)
+
+	chainIDs := []string{"thorchain-1", "thorchain-stagenet-2"}
+	cfg := &DaemonConfig{}
+	listeners := map[string]net.Listener{}
+	for _, chainID := range chainIDs {
+		listener, err := net.Listen("unix", filepath.Join(dir, chainID+".sock"))
+		require.Nil(t, err)
+		defer listener.Close()
+		listeners[chainID] = listener
+
+		cfg.Chains = append(cfg.Chains, ChainConfig{
+			ChainID:       chainID,
+			Address:       "unix://" + listener.Addr().String(),
+			Path:          []uint32{44, 118, 0, 0, 0},
+			StateFile:     filepath.Join(dir, chainID+".json"),
+			RetryInterval: Duration(10 * time.Millisecond),
+		})
+	}
+
+	logs := newSafeBuffer()
+	daemon, err := NewDaemon(cfg, &keyValidator{keySigner{key}}, NewJSONLogger(logs))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	daemonDone := make(chan error, 1)
+	go func() {
+		daemonDone <- daemon.Run(ctx)
+	}()
+
+	nodes := map[string]*mockNode{}
+	for chainID, listener := range listeners {
+		conn, err := listener.Accept()
+		require.Nil(t, err, "Detected error, err: %s\n", err)
+		defer conn.Close()
+		nodes[chainID] = &mockNode{t, conn}
+	}
+
+	// The watermarks are independent: chain 2 can sign a lower height than chain 1
+	heights := map[string]int64{"thorchain-1": 100, "thorchain-stagenet-2": 5}
+	for chainID, height := range heights {
+		vote := ledger.Vote{Type: ledger.PrevoteType, Height: height, Timestamp: time.Unix(1700000000, 0).UTC()}
+		num, resp := nodes[chainID].request(msgSignVoteRequest, encodeTestVote(vote), chainID)
+		assert.Equal(t, protowire.Number(msgSignedVoteResponse), num)
+		assert.Nil(t, resp.bytes(2), "unexpected remote signer error")
+		signed, err := decodeFields(resp.bytes(1))
+		require.Nil(t, err)
+		assert.True(t, ed25519.Verify(pubKey, ledger.VoteSignBytes(chainID, vote), signed.bytes(voteSignatureField)))
+	}
+
+	watermarks := daemon.Watermarks()
+	assert.Equal(t, int64(100), watermarks["thorchain-1"].Height)
+	assert.Equal(t, int64(5), watermarks["thorchain-stagenet-2"].Height)
+	assert.Equal(t, "thorchain-stagenet-2", watermarks["thorchain-stagenet-2"].ChainID)
+
+	// Each connection only answers for its own chain. A node asking for
+	// another chain is misconfigured, so the daemon stops with an error
+	vote := ledger.Vote{Type: ledger.PrecommitType, Height: 200, Timestamp: time.Unix(1700000000, 0).UTC()}
+	_, resp := nodes["thorchain-1"].request(msgSignVoteRequest, encodeTestVote(vote), "thorchain-stagenet-2")
+	assert.NotNil(t, resp.bytes(2), "expected a remote signer error")
+	err = <-daemonDone
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `chain thorchain-1: chain id "thorchain-stagenet-2" is not served`)
+	cancel()
+
+	// Every log line is a JSON object with a message and a level
+	var signed int
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]interface{}
+		require.Nil(t, json.Unmarshal([]byte(line), &entry), line)
+		assert.NotEmpty(t, entry["msg"])
+		assert.NotEmpty(t, entry["level"])
+		if entry["msg"] == "signed" {
+			signed++
+			assert.Contains(t, chainIDs, entry["chain_id"])
+		}
+	}
+	assert.Equal(t, 2, signed)
+}
+
+func Test_TextLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewTextLogger(&buf).With("chain_id", "thorchain-1")
+	logger.Error("refused to sign", "height", 10, "err", errors.New("height regression"))
+
+	line := buf.String()
+	assert.True(t, strings.HasPrefix(line, "ts="))
+	assert.Contains(t, line, ` level=error msg="refused to sign" chain_id=thorchain-1 height=10 err="height regression"`)
+}
@@ -0,0 +1,163 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package privval
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logger writes structured log lines: a message followed by key/value pairs
+type Logger interface {
+	Info(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+	// With returns a logger that adds keyvals to every line
+	With(keyvals ...interface{}) Logger
+}
+
+// NewJSONLogger returns a logger writing one JSON object per line
+func NewJSONLogger(w io.Writer) Logger {
+	return &writerLogger{out: &lockedWriter{w: w}, format: formatJSON}
+}
+
+// NewTextLogger returns a logger writing logfmt lines (key=value)
+func NewTextLogger(w io.Writer) Logger {
+	return &writerLogger{out: &lockedWriter{w: w}, format: formatText}
+}
+
+// NopLogger returns a logger that discards everything
+func NopLogger() Logger {
+	return nopLogger{}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+func (l nopLogger) With(...interface{}) Logger { return l }
+
+type lockedWriter struct {
+	mtx sync.Mutex
+	w   io.Writer
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mtx.Lock()
+	defer lw.mtx.Unlock()
+	return lw.w.Write(p)
+}
+
+type logFormat int
+
+const (
+	formatJSON logFormat = iota
+	formatText
+)
+
+type writerLogger struct {
+	out     io.Writer
+	format  logFormat
+	keyvals []interface{}
+}
+
+func (l *writerLogger) Info(msg string, keyvals ...interface{}) {
+	l.log("info", msg, keyvals)
+}
+
+func (l *writerLogger) Error(msg string, keyvals ...interface{}) {
+	l.log("error", msg, keyvals)
+}
+
+func (l *writerLogger) With(keyvals ...interface{}) Logger {
+	return &writerLogger{
+		out:     l.out,
+		format:  l.format,
+		keyvals: append(append([]interface{}{}, l.keyvals...), keyvals...),
+	}
+}
+
+func (l *writerLogger) log(level string, msg string, keyvals []interface{}) {
+	all := []interface{}{"ts", time.Now().UTC().Format(time.RFC3339Nano), "level", level, "msg", msg}
+	all = append(append(all, l.keyvals...), keyvals...)
+	if len(all)%2 != 0 {
+		all = append(all, "MISSING")
+	}
+
+	var line bytes.Buffer
+	if l.format == formatJSON {
+		line.WriteByte('{')
+	}
+	for i := 0; i < len(all); i += 2 {
+		key, value := fmt.Sprint(all[i]), logValue(all[i+1])
+		if l.format == formatJSON {
+			if i > 0 {
+				line.WriteByte(',')
+			}
+			writeJSON(&line, key)
+			line.WriteByte(':')
+			writeJSON(&line, value)
+		} else {
+			if i > 0 {
+				line.WriteByte(' ')
+			}
+			line.WriteString(key)
+			line.WriteByte('=')
+			line.WriteString(logfmtValue(value))
+		}
+	}
+	if l.format == formatJSON {
+		line.WriteByte('}')
+	}
+	line.WriteByte('\n')
+
+	_, _ = l.out.Write(line.Bytes())
+}
+
+// logValue converts errors and byte slices to strings, other values are kept as they are
+func logValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case []byte:
+		return fmt.Sprintf("%X", v)
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return v
+	}
+}
+
+func writeJSON(buf *bytes.Buffer, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(data)
+}
+
+func logfmtValue(value interface{}) string {
+	s := fmt.Sprint(value)
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
@@ -0,0 +1,324 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package privval
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+// Field numbers of the tendermint.privval.Message oneof
+const (
+	msgPubKeyRequest          = 1
+	msgPubKeyResponse         = 2
+	msgSignVoteRequest        = 3
+	msgSignedVoteResponse     = 4
+	msgSignProposalRequest    = 5
+	msgSignedProposalResponse = 6
+	msgPingRequest            = 7
+	msgPingResponse           = 8
+
+	voteSignatureField     = 8
+	proposalSignatureField = 7
+
+	maxMessageSize = 1024 * 1024
+)
+
+// Request is a decoded privval request
+type Request struct {
+	Type    int
+	ChainID string
+
+	// Raw contains the encoded vote or proposal
+	Raw      []byte
+	Vote     ledger.Vote
+	Proposal ledger.Proposal
+}
+
+// SignBytes returns the bytes the validator has to sign for a vote or proposal request
+func (r *Request) SignBytes() []byte {
+	if r.Type == msgSignProposalRequest {
+		return ledger.ProposalSignBytes(r.ChainID, r.Proposal)
+	}
+	return ledger.VoteSignBytes(r.ChainID, r.Vote)
+}
+
+// DecodeRequest decodes a tendermint.privval.Message sent by the node
+func DecodeRequest(data []byte) (*Request, error) {
+	fields, err := decodeFields(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != 1 {
+		return nil, errors.New("privval message should contain exactly one request")
+	}
+
+	for msgType, f := range fields {
+		inner, err := decodeFields(f.bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		req := &Request{Type: int(msgType)}
+		switch msgType {
+		case msgPubKeyRequest:
+			req.ChainID = string(inner.bytes(1))
+		case msgSignVoteRequest:
+			req.ChainID = string(inner.bytes(2))
+			req.Raw = inner.bytes(1)
+			if req.Vote, err = decodeVote(req.Raw); err != nil {
+				return nil, err
+			}
+		case msgSignProposalRequest:
+			req.ChainID = string(inner.bytes(2))
+			req.Raw = inner.bytes(1)
+			if req.Proposal, err = decodeProposal(req.Raw); err != nil {
+				return nil, err
+			}
+		case msgPingRequest:
+		default:
+			return nil, fmt.Errorf("unsupported privval message %d", msgType)
+		}
+		return req, nil
+	}
+	return nil, errors.New("empty privval message")
+}
+
+// encodePubKeyResponse encodes a PubKeyResponse, or a RemoteSignerError if err is set
+func encodePubKeyResponse(pubKey []byte, err error) []byte {
+	var inner []byte
+	if err != nil {
+		inner = appendMessage(inner, 1, nil)
+		inner = appendMessage(inner, 2, encodeRemoteSignerError(err))
+	} else {
+		inner = appendMessage(inner, 1, encodePublicKey(pubKey))
+	}
+	return appendMessage(nil, msgPubKeyResponse, inner)
+}
+
+// encodeSignedResponse returns the vote or proposal of the request with the signature set
+func encodeSignedResponse(req *Request, signature []byte, err error) []byte {
+	responseType, signatureField := msgSignedVoteResponse, voteSignatureField
+	if req.Type == msgSignProposalRequest {
+		responseType, signatureField = msgSignedProposalResponse, proposalSignatureField
+	}
+
+	var inner []byte
+	if err != nil {
+		inner = appendMessage(inner, 1, nil)
+		inner = appendMessage(inner, 2, encodeRemoteSignerError(err))
+	} else {
+		// The last occurrence of a field wins, so the signature can be appended to the original encoding
+		signed := append(append([]byte{}, req.Raw...), protowire.AppendTag(nil, protowire.Number(signatureField), protowire.BytesType)...)
+		signed = protowire.AppendBytes(signed, signature)
+		inner = appendMessage(inner, 1, signed)
+	}
+	return appendMessage(nil, protowire.Number(responseType), inner)
+}
+
+func encodePingResponse() []byte {
+	return appendMessage(nil, msgPingResponse, nil)
+}
+
+func encodeRemoteSignerError(err error) []byte {
+	msg := protowire.AppendTag(nil, 1, protowire.VarintType)
+	msg = protowire.AppendVarint(msg, 1)
+	msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+	return protowire.AppendString(msg, err.Error())
+}
+
+// encodePublicKey encodes a tendermint.crypto.PublicKey with an ed25519 key
+func encodePublicKey(pubKey []byte) []byte {
+	return appendMessage(nil, 1, pubKey)
+}
+
+func decodePublicKey(data []byte) (ed25519.PublicKey, error) {
+	fields, err := decodeFields(data)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := fields[1]; !ok {
+		return nil, errors.New("only ed25519 keys are supported")
+	}
+	return fields.bytes(1), nil
+}
+
+// decodeVote decodes a tendermint.types.Vote
+func decodeVote(data []byte) (ledger.Vote, error) {
+	fields, err := decodeFields(data)
+	if err != nil {
+		return ledger.Vote{}, err
+	}
+	blockID, err := decodeBlockID(fields.bytes(4))
+	if err != nil {
+		return ledger.Vote{}, err
+	}
+	timestamp, err := decodeTimestamp(fields.bytes(5))
+	if err != nil {
+		return ledger.Vote{}, err
+	}
+
+	return ledger.Vote{
+		Type:      ledger.SignedMsgType(fields.varint(1)),
+		Height:    int64(fields.varint(2)),
+		Round:     int32(fields.varint(3)),
+		BlockID:   blockID,
+		Timestamp: timestamp,
+	}, nil
+}
+
+// decodeProposal decodes a tendermint.types.Proposal
+func decodeProposal(data []byte) (ledger.Proposal, error) {
+	fields, err := decodeFields(data)
+	if err != nil {
+		return ledger.Proposal{}, err
+	}
+	if ledger.SignedMsgType(fields.varint(1)) != ledger.ProposalType {
+		return ledger.Proposal{}, errors.New("invalid proposal type")
+	}
+	blockID, err := decodeBlockID(fields.bytes(5))
+	if err != nil {
+		return ledger.Proposal{}, err
+	}
+	timestamp, err := decodeTimestamp(fields.bytes(6))
+	if err != nil {
+		return ledger.Proposal{}, err
+	}
+
+	return ledger.Proposal{
+		Height:    int64(fields.varint(2)),
+		Round:     int32(fields.varint(3)),
+		POLRound:  int32(fields.varint(4)),
+		BlockID:   blockID,
+		Timestamp: timestamp,
+	}, nil
+}
+
+func decodeBlockID(data []byte) (ledger.BlockID, error) {
+	fields, err := decodeFields(data)
+	if err != nil {
+		return ledger.BlockID{}, err
+	}
+	psh, err := decodeFields(fields.bytes(2))
+	if err != nil {
+		return ledger.BlockID{}, err
+	}
+	return ledger.BlockID{
+		Hash: fields.bytes(1),
+		PartSetHeader: ledger.PartSetHeader{
+			Total: uint32(psh.varint(1)),
+			Hash:  psh.bytes(2),
+		},
+	}, nil
+}
+
+func decodeTimestamp(data []byte) (time.Time, error) {
+	fields, err := decodeFields(data)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(fields.varint(1)), int64(int32(fields.varint(2)))).UTC(), nil
+}
+
+// protoFields contains the last value of each field of a message
+type protoFields map[protowire.Number]protoField
+
+type protoField struct {
+	varint uint64
+	bytes  []byte
+}
+
+func (f protoFields) varint(num protowire.Number) uint64 {
+	return f[num].varint
+}
+
+func (f protoFields) bytes(num protowire.Number) []byte {
+	return f[num].bytes
+}
+
+func decodeFields(data []byte) (protoFields, error) {
+	fields := protoFields{}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var f protoField
+		switch typ {
+		case protowire.VarintType:
+			f.varint, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			f.varint, n = protowire.ConsumeFixed64(data)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+		fields[num] = f
+	}
+	return fields, nil
+}
+
+func appendMessage(buf []byte, num protowire.Number, msg []byte) []byte {
+	buf = protowire.AppendTag(buf, num, protowire.BytesType)
+	return protowire.AppendBytes(buf, msg)
+}
+
+// writeDelimited writes a uvarint length-prefixed message
+func writeDelimited(w io.Writer, msg []byte) error {
+	_, err := w.Write(protowire.AppendBytes(nil, msg))
+	return err
+}
+
+// readDelimited reads a uvarint length-prefixed message
+func readDelimited(r io.Reader, maxSize int) ([]byte, error) {
+	// The length is read byte by byte so that nothing after the message is consumed
+	var size uint64
+	var b [1]byte
+	for shift := 0; ; shift += 7 {
+		if shift >= 64 {
+			return nil, errors.New("invalid message length")
+		}
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		size |= uint64(b[0]&0x7f) << shift
+		if b[0] < 0x80 {
+			break
+		}
+	}
+	if size > uint64(maxSize) {
+		return nil, fmt.Errorf("message of %d bytes exceeds the maximum of %d", size, maxSize)
+	}
+
+	msg := make([]byte, size)
+	_, err := io.ReadFull(r, msg)
+	return msg, err
+}
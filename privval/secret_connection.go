@@ -0,0 +1,291 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package privval
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/gtank/merlin"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// SecretConnection framing and transcript labels, as defined by CometBFT p2p/conn
+const (
+	dataLenSize      = 4
+	dataMaxSize      = 1024
+	totalFrameSize   = dataMaxSize + dataLenSize
+	aeadSizeOverhead = 16
+	aeadKeySize      = chacha20poly1305.KeySize
+	aeadNonceSize    = chacha20poly1305.NonceSize
+
+	transcriptName               = "TENDERMINT_SECRET_CONNECTION_TRANSCRIPT_HASH"
+	labelEphemeralLowerPublicKey = "EPHEMERAL_LOWER_PUBLIC_KEY"
+	labelEphemeralUpperPublicKey = "EPHEMERAL_UPPER_PUBLIC_KEY"
+	labelDHSecret                = "DH_SECRET"
+	labelSecretConnectionMac     = "SECRET_CONNECTION_MAC"
+
+	maxHandshakeMessageSize = 1024 * 1024
+)
+
+var secretConnKeyAndChallengeGen = []byte("TENDERMINT_SECRET_CONNECTION_KEY_AND_CHALLENGE_GEN")
+
+// SecretConnection is an authenticated and encrypted connection compatible with CometBFT
+type SecretConnection struct {
+	conn      io.ReadWriter
+	remPubKey ed25519.PublicKey
+
+	recvAead cipher.AEAD
+	sendAead cipher.AEAD
+
+	recvMtx    sync.Mutex
+	recvBuffer []byte
+	recvNonce  [aeadNonceSize]byte
+
+	sendMtx   sync.Mutex
+	sendNonce [aeadNonceSize]byte
+}
+
+// MakeSecretConnection performs the handshake over conn, authenticating with locPrivKey
+func MakeSecretConnection(conn io.ReadWriter, locPrivKey ed25519.PrivateKey) (*SecretConnection, error) {
+	locEphPriv := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(locEphPriv); err != nil {
+		return nil, err
+	}
+	return makeSecretConnection(conn, locPrivKey, locEphPriv)
+}
+
+// makeSecretConnection performs the handshake with the given ephemeral key
+func makeSecretConnection(conn io.ReadWriter, locPrivKey ed25519.PrivateKey, locEphPriv []byte) (*SecretConnection, error) {
+	locEphPub, err := curve25519.X25519(locEphPriv, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	remote, err := shareHandshakeMessage(conn, encodeBytesValue(locEphPub))
+	if err != nil {
+		return nil, fmt.Errorf("exchanging ephemeral keys: %w", err)
+	}
+	remEphPub, err := decodeBytesValue(remote)
+	if err != nil {
+		return nil, err
+	}
+	if len(remEphPub) != curve25519.PointSize {
+		return nil, errors.New("invalid remote ephemeral key")
+	}
+
+	loEphPub, hiEphPub := locEphPub, remEphPub
+	if bytes.Compare(loEphPub, hiEphPub) > 0 {
+		loEphPub, hiEphPub = hiEphPub, loEphPub
+	}
+
+	// X25519 returns an error for low order points
+	dhSecret, err := curve25519.X25519(locEphPriv, remEphPub)
+	if err != nil {
+		return nil, err
+	}
+
+	recvSecret, sendSecret, err := deriveSecrets(dhSecret, bytes.Equal(locEphPub, loEphPub))
+	if err != nil {
+		return nil, err
+	}
+	challenge := handshakeChallenge(loEphPub, hiEphPub, dhSecret)
+
+	sc := &SecretConnection{conn: conn}
+	if sc.sendAead, err = chacha20poly1305.New(sendSecret); err != nil {
+		return nil, err
+	}
+	if sc.recvAead, err = chacha20poly1305.New(recvSecret); err != nil {
+		return nil, err
+	}
+
+	locPubKey := locPrivKey.Public().(ed25519.PublicKey)
+	locSignature := ed25519.Sign(locPrivKey, challenge)
+
+	remote, err = shareHandshakeMessage(sc, encodeAuthSigMessage(locPubKey, locSignature))
+	if err != nil {
+		return nil, fmt.Errorf("exchanging auth signatures: %w", err)
+	}
+	remPubKey, remSignature, err := decodeAuthSigMessage(remote)
+	if err != nil {
+		return nil, err
+	}
+	if len(remPubKey) != ed25519.PublicKeySize || !ed25519.Verify(remPubKey, challenge, remSignature) {
+		return nil, errors.New("challenge verification failed")
+	}
+	sc.remPubKey = remPubKey
+
+	return sc, nil
+}
+
+// RemotePubKey returns the authenticated key of the other end
+func (sc *SecretConnection) RemotePubKey() ed25519.PublicKey {
+	return sc.remPubKey
+}
+
+// Write encrypts data in frames of up to dataMaxSize bytes
+func (sc *SecretConnection) Write(data []byte) (int, error) {
+	sc.sendMtx.Lock()
+	defer sc.sendMtx.Unlock()
+
+	n := 0
+	frame := make([]byte, totalFrameSize)
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > dataMaxSize {
+			chunk = data[:dataMaxSize]
+		}
+		data = data[len(chunk):]
+
+		for i := range frame {
+			frame[i] = 0
+		}
+		binary.LittleEndian.PutUint32(frame, uint32(len(chunk)))
+		copy(frame[dataLenSize:], chunk)
+
+		sealed := sc.sendAead.Seal(nil, sc.sendNonce[:], frame, nil)
+		incrNonce(&sc.sendNonce)
+
+		if _, err := sc.conn.Write(sealed); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+	}
+	return n, nil
+}
+
+// Read decrypts the next frame, buffering what does not fit in data
+func (sc *SecretConnection) Read(data []byte) (int, error) {
+	sc.recvMtx.Lock()
+	defer sc.recvMtx.Unlock()
+
+	if len(sc.recvBuffer) > 0 {
+		n := copy(data, sc.recvBuffer)
+		sc.recvBuffer = sc.recvBuffer[n:]
+		return n, nil
+	}
+
+	sealed := make([]byte, aeadSizeOverhead+totalFrameSize)
+	if _, err := io.ReadFull(sc.conn, sealed); err != nil {
+		return 0, err
+	}
+
+	frame, err := sc.recvAead.Open(nil, sc.recvNonce[:], sealed, nil)
+	if err != nil {
+		return 0, errors.New("failed to decrypt SecretConnection frame")
+	}
+	incrNonce(&sc.recvNonce)
+
+	chunkLength := binary.LittleEndian.Uint32(frame)
+	if chunkLength > dataMaxSize {
+		return 0, errors.New("chunk length is greater than dataMaxSize")
+	}
+	chunk := frame[dataLenSize : dataLenSize+chunkLength]
+
+	n := copy(data, chunk)
+	sc.recvBuffer = chunk[n:]
+	return n, nil
+}
+
+// handshakeChallenge is the value signed by both ends, taken from the transcript of the key exchange
+func handshakeChallenge(loEphPub []byte, hiEphPub []byte, dhSecret []byte) []byte {
+	transcript := merlin.NewTranscript(transcriptName)
+	transcript.AppendMessage([]byte(labelEphemeralLowerPublicKey), loEphPub)
+	transcript.AppendMessage([]byte(labelEphemeralUpperPublicKey), hiEphPub)
+	transcript.AppendMessage([]byte(labelDHSecret), dhSecret)
+	return transcript.ExtractBytes([]byte(labelSecretConnectionMac), 32)
+}
+
+func deriveSecrets(dhSecret []byte, locIsLeast bool) (recvSecret []byte, sendSecret []byte, err error) {
+	res := make([]byte, 2*aeadKeySize+32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, dhSecret, nil, secretConnKeyAndChallengeGen), res); err != nil {
+		return nil, nil, err
+	}
+
+	if locIsLeast {
+		return res[0:aeadKeySize], res[aeadKeySize : 2*aeadKeySize], nil
+	}
+	return res[aeadKeySize : 2*aeadKeySize], res[0:aeadKeySize], nil
+}
+
+// incrNonce increments the counter in the last 8 bytes of the nonce
+func incrNonce(nonce *[aeadNonceSize]byte) {
+	counter := binary.LittleEndian.Uint64(nonce[4:])
+	if counter == math.MaxUint64 {
+		panic("can't increase nonce without overflow")
+	}
+	binary.LittleEndian.PutUint64(nonce[4:], counter+1)
+}
+
+// shareHandshakeMessage writes a length-delimited message while reading the one sent by the other end
+func shareHandshakeMessage(rw io.ReadWriter, msg []byte) ([]byte, error) {
+	writeErr := make(chan error, 1)
+	go func() {
+		writeErr <- writeDelimited(rw, msg)
+	}()
+
+	remote, err := readDelimited(rw, maxHandshakeMessageSize)
+	if wErr := <-writeErr; err == nil {
+		err = wErr
+	}
+	return remote, err
+}
+
+// encodeBytesValue encodes a google.protobuf.BytesValue
+func encodeBytesValue(value []byte) []byte {
+	return protowire.AppendBytes(protowire.AppendTag(nil, 1, protowire.BytesType), value)
+}
+
+func decodeBytesValue(data []byte) ([]byte, error) {
+	fields, err := decodeFields(data)
+	if err != nil {
+		return nil, err
+	}
+	return fields.bytes(1), nil
+}
+
+// encodeAuthSigMessage encodes a tendermint.p2p.AuthSigMessage with an ed25519 key
+func encodeAuthSigMessage(pubKey ed25519.PublicKey, signature []byte) []byte {
+	msg := protowire.AppendTag(nil, 1, protowire.BytesType)
+	msg = protowire.AppendBytes(msg, encodePublicKey(pubKey))
+	msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+	return protowire.AppendBytes(msg, signature)
+}
+
+func decodeAuthSigMessage(data []byte) (pubKey ed25519.PublicKey, signature []byte, err error) {
+	fields, err := decodeFields(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubKey, err = decodePublicKey(fields.bytes(1))
+	if err != nil {
+		return nil, nil, err
+	}
+	return pubKey, fields.bytes(2), nil
+}
@@ -0,0 +1,219 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package privval
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/curve25519"
+)
+
+func makeSecretConnectionPair(t *testing.T) (*SecretConnection, *SecretConnection, ed25519.PublicKey, ed25519.PublicKey) {
+	pubA, keyA, _ := ed25519.GenerateKey(rand.Reader)
+	pubB, keyB, _ := ed25519.GenerateKey(rand.Reader)
+	connA, connB := net.Pipe()
+	t.Cleanup(func() {
+		connA.Close()
+		connB.Close()
+	})
+
+	type result struct {
+		sc  *SecretConnection
+		err error
+	}
+	resultB := make(chan result, 1)
+	go func() {
+		sc, err := MakeSecretConnection(connB, keyB)
+		resultB <- result{sc, err}
+	}()
+
+	scA, err := MakeSecretConnection(connA, keyA)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	b := <-resultB
+	require.Nil(t, b.err, "Detected error, err: %s\n", b.err)
+
+	return scA, b.sc, pubA, pubB
+}
+
+func Test_SecretConnection_Handshake(t *testing.T) {
+	scA, scB, pubA, pubB := makeSecretConnectionPair(t)
+
+	assert.Equal(t, pubB, scA.RemotePubKey())
+	assert.Equal(t, pubA, scB.RemotePubKey())
+}
+
+func Test_SecretConnection_ReadWrite(t *testing.T) {
+	scA, scB, _, _ := makeSecretConnectionPair(t)
+
+	// Spans several frames and is read with a small buffer
+	message := bytes.Repeat([]byte("thorchain"), 500)
+	go func() {
+		_, _ = scA.Write(message)
+	}()
+
+	received := make([]byte, len(message))
+	_, err := io.ReadFull(scB, received)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, message, received)
+
+	go func() {
+		_ = writeDelimited(scB, []byte("pong"))
+	}()
+	msg, err := readDelimited(scA, maxMessageSize)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("pong"), msg)
+}
+
+func Test_IncrNonce(t *testing.T) {
+	var nonce [aeadNonceSize]byte
+	incrNonce(&nonce)
+	assert.Equal(t, [aeadNonceSize]byte{0, 0, 0, 0, 1}, nonce)
+
+	nonce = [aeadNonceSize]byte{0, 0, 0, 0, 0xff}
+	incrNonce(&nonce)
+	assert.Equal(t, [aeadNonceSize]byte{0, 0, 0, 0, 0, 1}, nonce)
+}
+
+// readGolden returns the comma or space separated fields of the lines of a testdata file
+func readGolden(t *testing.T, name string, sep string) [][]string {
+	f, err := os.Open(filepath.Join("testdata", name))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	defer f.Close()
+
+	var lines [][]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, strings.Split(scanner.Text(), sep))
+	}
+	require.Nil(t, scanner.Err())
+	return lines
+}
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	return b
+}
+
+// The vectors are the golden file of CometBFT v0.38.12 p2p/conn. Its last column is
+// the challenge of a former HKDF-based handshake, which CometBFT no longer checks either
+func Test_DeriveSecrets_Golden(t *testing.T) {
+	lines := readGolden(t, "TestDeriveSecretsAndChallengeGolden.golden", ",")
+	require.Len(t, lines, 32)
+
+	for _, params := range lines {
+		locIsLeast, err := strconv.ParseBool(params[1])
+		require.Nil(t, err)
+
+		recvSecret, sendSecret, err := deriveSecrets(decodeHex(t, params[0]), locIsLeast)
+		require.Nil(t, err, "Detected error, err: %s\n", err)
+		assert.Equal(t, params[2], hex.EncodeToString(recvSecret))
+		assert.Equal(t, params[3], hex.EncodeToString(sendSecret))
+	}
+}
+
+// replayConn answers with the recorded messages of the other end and collects what is written
+type replayConn struct {
+	in io.Reader
+
+	mtx sync.Mutex
+	out bytes.Buffer
+}
+
+func (c *replayConn) Read(data []byte) (int, error) {
+	return c.in.Read(data)
+}
+
+func (c *replayConn) Write(data []byte) (int, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.out.Write(data)
+}
+
+func (c *replayConn) written() []byte {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	written := append([]byte{}, c.out.Bytes()...)
+	c.out.Reset()
+	return written
+}
+
+// The handshake was recorded between two CometBFT v0.38.12 p2p/conn SecretConnections,
+// A with the ed25519 seed 0x11.. and the ephemeral key 0x01.., B with 0x22.. and 0x02...
+// A sent "thorchain" after the handshake and B answered "pong".
+// Replaying B's messages, A must write the same bytes as CometBFT did
+func Test_SecretConnection_Golden(t *testing.T) {
+	sent := map[string][][]byte{}
+	for _, line := range readGolden(t, "secret_connection_handshake.golden", " ") {
+		sent[line[0]] = append(sent[line[0]], decodeHex(t, line[1]))
+	}
+	require.Len(t, sent["A"], 3)
+	require.Len(t, sent["B"], 3)
+
+	keyA := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{0x11}, 32))
+	keyB := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{0x22}, 32))
+	ephA := bytes.Repeat([]byte{0x01}, curve25519.ScalarSize)
+
+	conn := &replayConn{in: bytes.NewReader(bytes.Join(sent["B"], nil))}
+	sc, err := makeSecretConnection(conn, keyA, ephA)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, keyB.Public(), sc.RemotePubKey())
+
+	// The ephemeral key and the encrypted AuthSigMessage
+	assert.Equal(t, hex.EncodeToString(bytes.Join(sent["A"][:2], nil)), hex.EncodeToString(conn.written()))
+
+	// The challenge signed by both ends, which B's signature was verified with
+	ephPubA, err := decodeBytesValue(sent["A"][0][1:])
+	require.Nil(t, err)
+	ephPubB, err := decodeBytesValue(sent["B"][0][1:])
+	require.Nil(t, err)
+	dhSecret, err := curve25519.X25519(ephA, ephPubB)
+	require.Nil(t, err)
+	loEphPub, hiEphPub := ephPubA, ephPubB
+	if bytes.Compare(loEphPub, hiEphPub) > 0 {
+		loEphPub, hiEphPub = hiEphPub, loEphPub
+	}
+	assert.Equal(t, "4206c8c11bdca5a661d2a4939fd3566e52fbf4ea7501df0d089bcd055291f25d", hex.EncodeToString(handshakeChallenge(loEphPub, hiEphPub, dhSecret)))
+
+	// CometBFT pads frames with what its buffer pool held before while they are zeroed here,
+	// so only the stream cipher output up to the end of the data is the same
+	message := []byte("thorchain")
+	_, err = sc.Write(message)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	frame := conn.written()
+	require.Len(t, frame, aeadSizeOverhead+totalFrameSize)
+	assert.Equal(t, hex.EncodeToString(sent["A"][2][:dataLenSize+len(message)]), hex.EncodeToString(frame[:dataLenSize+len(message)]))
+
+	received := make([]byte, 4)
+	_, err = io.ReadFull(sc, received)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, []byte("pong"), received)
+}
@@ -0,0 +1,299 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+// Package privval implements a CometBFT remote signer (privval) backed by the
+// Ledger Tendermint validator app. The server dials the privval listener of
+// the node, like tmkms does, and answers its requests.
+package privval
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+const defaultRetryInterval = time.Second
+
+// nodeIDSize is the size of a node id, the truncated sha256 of the node key
+const nodeIDSize = 20
+
+// Config contains the settings of a remote signer
+type Config struct {
+	// Address of the node privval listener: tcp://host:port or unix:///path.
+	// A TCP address can require the key of the node: tcp://<node id>@host:port
+	Address string
+	// ChainID is the only chain the signer answers for
+	ChainID string
+	// Path is the bip32 path of the validator key
+	Path []uint32
+	// ConnKey authenticates the SecretConnection. A random key is used if nil
+	ConnKey ed25519.PrivateKey
+	// RetryInterval is the delay between connection attempts
+	RetryInterval time.Duration
+	// Logger receives connection and signing events. Nothing is logged if nil
+	Logger Logger
+}
+
+// Server answers privval requests from a CometBFT node
+type Server struct {
+	cfg    Config
+	signer ledger.ED25519Signer
+	pubKey []byte
+	log    Logger
+}
+
+// NewServer creates a remote signer that signs with signer and reports pubKey to the node
+func NewServer(cfg Config, signer ledger.ED25519Signer, pubKey []byte) (*Server, error) {
+	if cfg.ChainID == "" {
+		return nil, errors.New("chain id is required")
+	}
+	if len(pubKey) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid validator public key")
+	}
+	if cfg.ConnKey == nil {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ConnKey = key
+	}
+	if cfg.RetryInterval == 0 {
+		cfg.RetryInterval = defaultRetryInterval
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = NopLogger()
+	}
+
+	return &Server{cfg: cfg, signer: signer, pubKey: pubKey, log: logger.With("chain_id", cfg.ChainID)}, nil
+}
+
+// NewLedgerServer creates a remote signer backed by the validator app. Every message
+// goes through a DoubleSignGuard that keeps its watermark in stateFile
+func NewLedgerServer(cfg Config, validator *ledger.LedgerTendermintValidator, stateFile string) (*Server, error) {
+	pubKey, err := validator.GetPublicKeyED25519(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	guard, err := ledger.NewDoubleSignGuard(validator, stateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewServer(cfg, guard, pubKey)
+}
+
+// Run connects to the node and serves requests until ctx is done, reconnecting after errors.
+// It stops with an error when reconnecting cannot help: the address is invalid, the node
+// key is not the one in the address or the node asks for another chain
+func (s *Server) Run(ctx context.Context) error {
+	for {
+		conn, err := s.dial(ctx)
+		if err == nil {
+			s.log.Info("connected", "address", s.cfg.Address)
+			done := make(chan struct{})
+			go func() {
+				select {
+				case <-ctx.Done():
+					conn.Close()
+				case <-done:
+				}
+			}()
+			err = s.Serve(conn)
+			close(done)
+			conn.Close()
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var fatal *fatalError
+		if errors.As(err, &fatal) {
+			return fatal.err
+		}
+		s.log.Error("connection failed", "address", s.cfg.Address, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.cfg.RetryInterval):
+		}
+	}
+}
+
+// fatalError is an error that reconnecting to the node does not fix
+type fatalError struct {
+	err error
+}
+
+func (e *fatalError) Error() string {
+	return e.err.Error()
+}
+
+// NodeID returns the id of a node key, as written in tcp://<node id>@host:port addresses
+func NodeID(pubKey ed25519.PublicKey) string {
+	hash := sha256.Sum256(pubKey)
+	return hex.EncodeToString(hash[:nodeIDSize])
+}
+
+// parseAddress returns the network and address to dial, and the node id required by the address
+func parseAddress(address string) (network string, addr string, nodeID string, err error) {
+	switch {
+	case strings.HasPrefix(address, "unix://"):
+		return "unix", strings.TrimPrefix(address, "unix://"), "", nil
+	case strings.HasPrefix(address, "tcp://"):
+		addr = strings.TrimPrefix(address, "tcp://")
+		if i := strings.Index(addr, "@"); i >= 0 {
+			nodeID, addr = strings.ToLower(addr[:i]), addr[i+1:]
+			if id, err := hex.DecodeString(nodeID); err != nil || len(id) != nodeIDSize {
+				return "", "", "", fmt.Errorf("invalid node id in address %q", address)
+			}
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return "", "", "", fmt.Errorf("invalid address %q: %w", address, err)
+		}
+		return "tcp", addr, nodeID, nil
+	default:
+		return "", "", "", fmt.Errorf("invalid address %q: expected tcp:// or unix://", address)
+	}
+}
+
+// dial opens a connection to the node. TCP connections are secured with a SecretConnection
+func (s *Server) dial(ctx context.Context) (net.Conn, error) {
+	network, addr, nodeID, err := parseAddress(s.cfg.Address)
+	if err != nil {
+		return nil, &fatalError{err}
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil || network == "unix" {
+		return conn, err
+	}
+
+	sc, err := MakeSecretConnection(conn, s.cfg.ConnKey)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if nodeID != "" && NodeID(sc.RemotePubKey()) != nodeID {
+		conn.Close()
+		return nil, &fatalError{fmt.Errorf("node key %s is not authorized: expected %s", NodeID(sc.RemotePubKey()), nodeID)}
+	}
+	return &secretNetConn{conn, sc}, nil
+}
+
+// Serve handles requests on an established connection until it fails
+func (s *Server) Serve(conn io.ReadWriter) error {
+	for {
+		msg, err := readDelimited(conn, maxMessageSize)
+		if err != nil {
+			return err
+		}
+
+		req, err := DecodeRequest(msg)
+		if err != nil {
+			return err
+		}
+
+		resp, handleErr := s.Handle(req)
+		if resp != nil {
+			if err := writeDelimited(conn, resp); err != nil {
+				return err
+			}
+		}
+		if handleErr != nil {
+			return &fatalError{handleErr}
+		}
+	}
+}
+
+// Handle returns the encoded response to a request. An error is returned when the
+// connection should be closed: the node asked for another chain, after the error
+// response, or the request type is unknown, without a response
+func (s *Server) Handle(req *Request) ([]byte, error) {
+	switch req.Type {
+	case msgPubKeyRequest:
+		if err := s.checkChainID(req.ChainID); err != nil {
+			return encodePubKeyResponse(nil, err), err
+		}
+		return encodePubKeyResponse(s.pubKey, nil), nil
+
+	case msgSignVoteRequest, msgSignProposalRequest:
+		if err := s.checkChainID(req.ChainID); err != nil {
+			return encodeSignedResponse(req, nil, err), err
+		}
+		height, round, msgType := req.position()
+		signature, err := s.signer.SignED25519(s.cfg.Path, req.SignBytes())
+		if err != nil {
+			s.log.Error("refused to sign", "type", msgType, "height", height, "round", round, "err", err)
+			return encodeSignedResponse(req, nil, err), nil
+		}
+		s.log.Info("signed", "type", msgType, "height", height, "round", round)
+		return encodeSignedResponse(req, signature, nil), nil
+
+	case msgPingRequest:
+		return encodePingResponse(), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported privval message %d", req.Type)
+	}
+}
+
+func (s *Server) checkChainID(chainID string) error {
+	if chainID != s.cfg.ChainID {
+		s.log.Error("request for a chain that is not served", "requested_chain_id", chainID)
+		return fmt.Errorf("chain id %q is not served by this signer", chainID)
+	}
+	return nil
+}
+
+// position returns the height, round and type of a sign request, for logging
+func (r *Request) position() (int64, int32, string) {
+	if r.Type == msgSignProposalRequest {
+		return r.Proposal.Height, r.Proposal.Round, "proposal"
+	}
+	if r.Vote.Type == ledger.PrecommitType {
+		return r.Vote.Height, r.Vote.Round, "precommit"
+	}
+	return r.Vote.Height, r.Vote.Round, "prevote"
+}
+
+// secretNetConn reads and writes through a SecretConnection while keeping the net.Conn methods
+type secretNetConn struct {
+	net.Conn
+	sc *SecretConnection
+}
+
+func (c *secretNetConn) Read(b []byte) (int, error) {
+	return c.sc.Read(b)
+}
+
+func (c *secretNetConn) Write(b []byte) (int, error) {
+	return c.sc.Write(b)
+}
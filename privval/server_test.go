@@ -0,0 +1,289 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package privval
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+// keySigner signs with an in-memory ed25519 key instead of the validator app
+type keySigner struct {
+	key ed25519.PrivateKey
+}
+
+func (s *keySigner) SignED25519(bip32Path []uint32, message []byte) ([]byte, error) {
+	return ed25519.Sign(s.key, message), nil
+}
+
+// Node side encoding of tendermint.types messages
+
+func encodeTestBlockID(blockID ledger.BlockID) []byte {
+	psh := protowire.AppendTag(nil, 1, protowire.VarintType)
+	psh = protowire.AppendVarint(psh, uint64(blockID.PartSetHeader.Total))
+	psh = appendMessage(psh, 2, blockID.PartSetHeader.Hash)
+	return appendMessage(appendMessage(nil, 1, blockID.Hash), 2, psh)
+}
+
+func encodeTestTimestamp(ts time.Time) []byte {
+	msg := protowire.AppendTag(nil, 1, protowire.VarintType)
+	msg = protowire.AppendVarint(msg, uint64(ts.Unix()))
+	msg = protowire.AppendTag(msg, 2, protowire.VarintType)
+	return protowire.AppendVarint(msg, uint64(ts.Nanosecond()))
+}
+
+func encodeTestVote(vote ledger.Vote) []byte {
+	msg := protowire.AppendTag(nil, 1, protowire.VarintType)
+	msg = protowire.AppendVarint(msg, uint64(vote.Type))
+	msg = protowire.AppendTag(msg, 2, protowire.VarintType)
+	msg = protowire.AppendVarint(msg, uint64(vote.Height))
+	msg = protowire.AppendTag(msg, 3, protowire.VarintType)
+	msg = protowire.AppendVarint(msg, uint64(int64(vote.Round)))
+	msg = appendMessage(msg, 4, encodeTestBlockID(vote.BlockID))
+	msg = appendMessage(msg, 5, encodeTestTimestamp(vote.Timestamp))
+	return appendMessage(msg, 6, []byte("validator-address"))
+}
+
+func encodeTestProposal(proposal ledger.Proposal) []byte {
+	msg := protowire.AppendTag(nil, 1, protowire.VarintType)
+	msg = protowire.AppendVarint(msg, uint64(ledger.ProposalType))
+	msg = protowire.AppendTag(msg, 2, protowire.VarintType)
+	msg = protowire.AppendVarint(msg, uint64(proposal.Height))
+	msg = protowire.AppendTag(msg, 4, protowire.VarintType)
+	msg = protowire.AppendVarint(msg, uint64(int64(proposal.POLRound)))
+	msg = appendMessage(msg, 5, encodeTestBlockID(proposal.BlockID))
+	return appendMessage(msg, 6, encodeTestTimestamp(proposal.Timestamp))
+}
+
+func encodeTestRequest(msgType protowire.Number, payload []byte, chainID string) []byte {
+	var inner []byte
+	switch msgType {
+	case msgPubKeyRequest:
+		inner = appendMessage(nil, 1, []byte(chainID))
+	case msgSignVoteRequest, msgSignProposalRequest:
+		inner = appendMessage(appendMessage(nil, 1, payload), 2, []byte(chainID))
+	}
+	return appendMessage(nil, msgType, inner)
+}
+
+// mockNode plays the node side of the privval protocol over a connection
+type mockNode struct {
+	t    *testing.T
+	conn io.ReadWriter
+}
+
+func (n *mockNode) request(msgType protowire.Number, payload []byte, chainID string) (protowire.Number, protoFields) {
+	require.Nil(n.t, writeDelimited(n.conn, encodeTestRequest(msgType, payload, chainID)))
+
+	msg, err := readDelimited(n.conn, maxMessageSize)
+	require.Nil(n.t, err, "Detected error, err: %s\n", err)
+
+	fields, err := decodeFields(msg)
+	require.Nil(n.t, err)
+	require.Len(n.t, fields, 1)
+	for num, f := range fields {
+		inner, err := decodeFields(f.bytes)
+		require.Nil(n.t, err)
+		return num, inner
+	}
+	return 0, nil
+}
+
+func (n *mockNode) run(pubKey ed25519.PublicKey) {
+	t := n.t
+
+	num, resp := n.request(msgPingRequest, nil, "")
+	assert.Equal(t, protowire.Number(msgPingResponse), num)
+
+	num, resp = n.request(msgPubKeyRequest, nil, "thorchain-1")
+	assert.Equal(t, protowire.Number(msgPubKeyResponse), num)
+	key, err := decodePublicKey(resp.bytes(1))
+	require.Nil(t, err)
+	assert.Equal(t, pubKey, key)
+
+	vote := ledger.Vote{
+		Type:      ledger.PrevoteType,
+		Height:    100,
+		Round:     0,
+		BlockID:   ledger.BlockID{Hash: bytes.Repeat([]byte{1}, 32), PartSetHeader: ledger.PartSetHeader{Total: 1, Hash: bytes.Repeat([]byte{2}, 32)}},
+		Timestamp: time.Unix(1700000000, 42).UTC(),
+	}
+	num, resp = n.request(msgSignVoteRequest, encodeTestVote(vote), "thorchain-1")
+	assert.Equal(t, protowire.Number(msgSignedVoteResponse), num)
+	assert.Nil(t, resp.bytes(2), "unexpected remote signer error")
+	signed, err := decodeFields(resp.bytes(1))
+	require.Nil(t, err)
+	assert.True(t, ed25519.Verify(pubKey, ledger.VoteSignBytes("thorchain-1", vote), signed.bytes(voteSignatureField)))
+	assert.Equal(t, []byte("validator-address"), signed.bytes(6))
+
+	// A conflicting vote at the same height/round/step is refused
+	conflicting := vote
+	conflicting.BlockID = ledger.BlockID{}
+	num, resp = n.request(msgSignVoteRequest, encodeTestVote(conflicting), "thorchain-1")
+	assert.Equal(t, protowire.Number(msgSignedVoteResponse), num)
+	assert.NotNil(t, resp.bytes(2), "expected a remote signer error")
+
+	proposal := ledger.Proposal{Height: 101, POLRound: -1, BlockID: vote.BlockID, Timestamp: vote.Timestamp}
+	num, resp = n.request(msgSignProposalRequest, encodeTestProposal(proposal), "thorchain-1")
+	assert.Equal(t, protowire.Number(msgSignedProposalResponse), num)
+	signed, err = decodeFields(resp.bytes(1))
+	require.Nil(t, err)
+	assert.True(t, ed25519.Verify(pubKey, ledger.ProposalSignBytes("thorchain-1", proposal), signed.bytes(proposalSignatureField)))
+}
+
+func newTestServer(t *testing.T, address string) (*Server, ed25519.PublicKey) {
+	pubKey, key, _ := ed25519.GenerateKey(rand.Reader)
+	guard, err := ledger.NewDoubleSignGuard(&keySigner{key}, filepath.Join(t.TempDir(), "state.json"))
+	require.Nil(t, err)
+
+	server, err := NewServer(Config{Address: address, ChainID: "thorchain-1", RetryInterval: 10 * time.Millisecond}, guard, pubKey)
+	require.Nil(t, err)
+	return server, pubKey
+}
+
+func runTestNode(t *testing.T, listener net.Listener, secret bool) {
+	server, pubKey := newTestServer(t, listener.Addr().Network()+"://"+listener.Addr().String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serverDone := make(chan error, 1)
+	go func() {
+		serverDone <- server.Run(ctx)
+	}()
+
+	conn, err := listener.Accept()
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	defer conn.Close()
+
+	var rw io.ReadWriter = conn
+	if secret {
+		_, nodeKey, _ := ed25519.GenerateKey(rand.Reader)
+		sc, err := MakeSecretConnection(conn, nodeKey)
+		require.Nil(t, err, "Detected error, err: %s\n", err)
+		rw = sc
+	}
+
+	node := &mockNode{t, rw}
+	node.run(pubKey)
+
+	// A request for another chain is answered with an error, then the server stops
+	_, resp := node.request(msgPubKeyRequest, nil, "other-chain")
+	assert.NotNil(t, resp.bytes(2), "expected a remote signer error")
+	err = <-serverDone
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `chain id "other-chain" is not served`)
+	cancel()
+}
+
+func Test_Server_TCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+
+	runTestNode(t, listener, true)
+}
+
+func Test_Server_Unix(t *testing.T) {
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "privval.sock"))
+	require.Nil(t, err)
+	defer listener.Close()
+
+	runTestNode(t, listener, false)
+}
+
+func Test_Server_HandleUnknown(t *testing.T) {
+	server, _ := newTestServer(t, "unix:///tmp/unused.sock")
+
+	resp, err := server.Handle(&Request{Type: msgPingRequest})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, encodePingResponse(), resp)
+
+	// An unknown request is not acknowledged
+	resp, err = server.Handle(&Request{Type: msgPingResponse})
+	assert.Error(t, err)
+	assert.Nil(t, resp)
+}
+
+func Test_NewServer_Invalid(t *testing.T) {
+	pubKey, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	_, err := NewServer(Config{Address: "tcp://127.0.0.1:1"}, &keySigner{}, pubKey)
+	assert.Error(t, err)
+
+	_, err = NewServer(Config{Address: "tcp://127.0.0.1:1", ChainID: "thorchain-1"}, &keySigner{}, pubKey[:31])
+	assert.Error(t, err)
+
+	// An invalid address stops Run instead of being retried
+	for _, address := range []string{"http://127.0.0.1:1", "tcp://127.0.0.1", "tcp://abcd@127.0.0.1:1"} {
+		server, err := NewServer(Config{Address: address, ChainID: "thorchain-1", RetryInterval: time.Millisecond}, &keySigner{}, pubKey)
+		require.Nil(t, err)
+		err = server.Run(context.Background())
+		assert.Error(t, err, address)
+	}
+}
+
+func Test_Server_NodeID(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+
+	nodePubKey, nodeKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherPubKey, _, _ := ed25519.GenerateKey(rand.Reader)
+	assert.Len(t, NodeID(nodePubKey), 40)
+
+	for _, expected := range []ed25519.PublicKey{nodePubKey, otherPubKey} {
+		server, _ := newTestServer(t, "tcp://"+strings.ToUpper(NodeID(expected))+"@"+listener.Addr().String())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		serverDone := make(chan error, 1)
+		go func() {
+			serverDone <- server.Run(ctx)
+		}()
+
+		conn, err := listener.Accept()
+		require.Nil(t, err, "Detected error, err: %s\n", err)
+		sc, err := MakeSecretConnection(conn, nodeKey)
+		require.Nil(t, err, "Detected error, err: %s\n", err)
+
+		node := &mockNode{t, sc}
+		if bytes.Equal(expected, nodePubKey) {
+			num, _ := node.request(msgPingRequest, nil, "")
+			assert.Equal(t, protowire.Number(msgPingResponse), num)
+			cancel()
+			assert.Equal(t, context.Canceled, <-serverDone)
+		} else {
+			err = <-serverDone
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "node key "+NodeID(nodePubKey)+" is not authorized")
+			cancel()
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,127 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+// Package prommetrics exports the device metrics of the ledger package to Prometheus.
+// It lives in its own module so that the main package does not depend on the Prometheus client.
+package prommetrics
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+// DefaultNamespace prefixes the metric names when New is given an empty namespace
+const DefaultNamespace = "thorledger"
+
+// Metrics implements ledger.Metrics and prometheus.Collector. Register it once:
+//
+//	metrics := prommetrics.New("")
+//	prometheus.MustRegister(metrics)
+//	app.SetMetrics(metrics)
+type Metrics struct {
+	apduExchanges    *prometheus.CounterVec
+	confirmationWait prometheus.Histogram
+	signRejections   *prometheus.CounterVec
+	signPayloadSize  *prometheus.HistogramVec
+	reconnects       prometheus.Counter
+}
+
+var _ ledger.Metrics = (*Metrics)(nil)
+var _ prometheus.Collector = (*Metrics)(nil)
+
+// New creates the metrics under namespace
+func New(namespace string) *Metrics {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+
+	return &Metrics{
+		apduExchanges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "apdu_exchanges_total",
+			Help:      "Commands sent to the device by CLA, INS and status word. Status 0000 means the device did not answer.",
+		}, []string{"cla", "ins", "status"}),
+		confirmationWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "confirmation_wait_seconds",
+			Help:      "Time spent waiting for the user to approve or reject a signature in the device.",
+			Buckets:   []float64{1, 2, 5, 10, 20, 30, 60, 120, 300},
+		}),
+		signRejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "sign_rejections_total",
+			Help:      "Sign requests rejected in the device by app.",
+		}, []string{"app"}),
+		signPayloadSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "sign_payload_bytes",
+			Help:      "Size of the payloads sent to be signed by app.",
+			Buckets:   prometheus.ExponentialBuckets(64, 2, 9),
+		}, []string{"app"}),
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reconnects_total",
+			Help:      "Connections opened again to the device.",
+		}),
+	}
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.apduExchanges, m.confirmationWait, m.signRejections, m.signPayloadSize, m.reconnects}
+}
+
+// Describe implements prometheus.Collector
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// APDUExchanged implements ledger.Metrics
+func (m *Metrics) APDUExchanged(cla byte, ins byte, statusWord uint16) {
+	m.apduExchanges.WithLabelValues(fmt.Sprintf("%02x", cla), fmt.Sprintf("%02x", ins), fmt.Sprintf("%04x", statusWord)).Inc()
+}
+
+// ConfirmationWaited implements ledger.Metrics
+func (m *Metrics) ConfirmationWaited(elapsed time.Duration) {
+	m.confirmationWait.Observe(elapsed.Seconds())
+}
+
+// SignRejected implements ledger.Metrics
+func (m *Metrics) SignRejected(app string) {
+	m.signRejections.WithLabelValues(app).Inc()
+}
+
+// SignPayloadSize implements ledger.Metrics
+func (m *Metrics) SignPayloadSize(app string, size int) {
+	m.signPayloadSize.WithLabelValues(app).Observe(float64(size))
+}
+
+// Reconnected implements ledger.Metrics
+func (m *Metrics) Reconnected() {
+	m.reconnects.Inc()
+}
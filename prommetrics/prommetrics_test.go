@@ -0,0 +1,84 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package prommetrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+func Test_Metrics(t *testing.T) {
+	metrics := New("")
+	registry := prometheus.NewRegistry()
+	require.Nil(t, registry.Register(metrics))
+
+	metrics.APDUExchanged(0x55, 0x02, ledger.StatusWordOK)
+	metrics.APDUExchanged(0x55, 0x02, ledger.StatusWordOK)
+	metrics.APDUExchanged(0x55, 0x02, 0x6986)
+	metrics.ConfirmationWaited(3 * time.Second)
+	metrics.SignRejected(ledger.AuditAppTHORChain)
+	metrics.SignPayloadSize(ledger.AuditAppTHORChain, 300)
+	metrics.SignPayloadSize(ledger.AuditAppValidator, 100)
+	metrics.Reconnected()
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.apduExchanges.WithLabelValues("55", "02", "9000")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.apduExchanges.WithLabelValues("55", "02", "6986")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.signRejections.WithLabelValues(ledger.AuditAppTHORChain)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.reconnects))
+
+	expected := `
+# HELP thorledger_confirmation_wait_seconds Time spent waiting for the user to approve or reject a signature in the device.
+# TYPE thorledger_confirmation_wait_seconds histogram
+thorledger_confirmation_wait_seconds_bucket{le="1"} 0
+thorledger_confirmation_wait_seconds_bucket{le="2"} 0
+thorledger_confirmation_wait_seconds_bucket{le="5"} 1
+thorledger_confirmation_wait_seconds_bucket{le="10"} 1
+thorledger_confirmation_wait_seconds_bucket{le="20"} 1
+thorledger_confirmation_wait_seconds_bucket{le="30"} 1
+thorledger_confirmation_wait_seconds_bucket{le="60"} 1
+thorledger_confirmation_wait_seconds_bucket{le="120"} 1
+thorledger_confirmation_wait_seconds_bucket{le="300"} 1
+thorledger_confirmation_wait_seconds_bucket{le="+Inf"} 1
+thorledger_confirmation_wait_seconds_sum 3
+thorledger_confirmation_wait_seconds_count 1
+`
+	err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "thorledger_confirmation_wait_seconds")
+	assert.Nil(t, err, "Detected error, err: %s\n", err)
+
+	count, err := testutil.GatherAndCount(registry, "thorledger_sign_payload_bytes")
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, 2, count)
+}
+
+func Test_Metrics_Namespace(t *testing.T) {
+	metrics := New("signer")
+	metrics.Reconnected()
+
+	registry := prometheus.NewRegistry()
+	require.Nil(t, registry.Register(metrics))
+	count, err := testutil.GatherAndCount(registry, "signer_reconnects_total")
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,479 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// psbtMagic starts every serialized PSBT
+var psbtMagic = []byte{'p', 's', 'b', 't', 0xFF}
+
+// Key types of the PSBT maps (BIP-174 and BIP-370)
+const (
+	PSBTGlobalUnsignedTx       = 0x00
+	PSBTGlobalXPub             = 0x01
+	PSBTGlobalTxVersion        = 0x02
+	PSBTGlobalFallbackLocktime = 0x03
+	PSBTGlobalInputCount       = 0x04
+	PSBTGlobalOutputCount      = 0x05
+	PSBTGlobalVersion          = 0xFB
+
+	PSBTInNonWitnessUTXO   = 0x00
+	PSBTInWitnessUTXO      = 0x01
+	PSBTInPartialSig       = 0x02
+	PSBTInSighashType      = 0x03
+	PSBTInBIP32Derivation  = 0x06
+	PSBTInPreviousTxID     = 0x0E
+	PSBTInOutputIndex      = 0x0F
+	PSBTInSequence         = 0x10
+	PSBTInTapKeySig        = 0x13
+	PSBTInTapScriptSig     = 0x14
+	PSBTOutRedeemScript    = 0x00
+	PSBTOutWitnessScript   = 0x01
+	PSBTOutBIP32Derivation = 0x02
+	PSBTOutAmount          = 0x03
+	PSBTOutScript          = 0x04
+)
+
+// PSBTEntry is a key-value pair of a PSBT map. The key starts with the key type
+type PSBTEntry struct {
+	Key   []byte
+	Value []byte
+}
+
+// PSBTMap is a PSBT map, in the order of the serialization
+type PSBTMap []PSBTEntry
+
+// Get returns the value of a key
+func (m PSBTMap) Get(key []byte) ([]byte, bool) {
+	for _, entry := range m {
+		if bytes.Equal(entry.Key, key) {
+			return entry.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Set replaces the value of a key, or adds the key at the end of the map
+func (m *PSBTMap) Set(key []byte, value []byte) {
+	for i, entry := range *m {
+		if bytes.Equal(entry.Key, key) {
+			(*m)[i].Value = value
+			return
+		}
+	}
+	*m = append(*m, PSBTEntry{Key: key, Value: value})
+}
+
+// Delete removes a key
+func (m *PSBTMap) Delete(key []byte) {
+	for i, entry := range *m {
+		if bytes.Equal(entry.Key, key) {
+			*m = append((*m)[:i:i], (*m)[i+1:]...)
+			return
+		}
+	}
+}
+
+// PSBT is a partially signed Bitcoin transaction, version 0 or 2
+type PSBT struct {
+	Global  PSBTMap
+	Inputs  []PSBTMap
+	Outputs []PSBTMap
+}
+
+// NewPSBT creates a version 0 PSBT for an unsigned transaction
+func NewPSBT(tx *BitcoinTx) (*PSBT, error) {
+	for _, input := range tx.Inputs {
+		if len(input.ScriptSig) > 0 {
+			return nil, errors.New("the transaction inputs must not be signed")
+		}
+	}
+
+	return &PSBT{
+		Global:  PSBTMap{{Key: []byte{PSBTGlobalUnsignedTx}, Value: tx.Serialize()}},
+		Inputs:  make([]PSBTMap, len(tx.Inputs)),
+		Outputs: make([]PSBTMap, len(tx.Outputs)),
+	}, nil
+}
+
+// ParsePSBTBase64 parses a base64 encoded PSBT
+func ParsePSBTBase64(s string) (*PSBT, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 PSBT: %w", err)
+	}
+	return ParsePSBT(data)
+}
+
+// ParsePSBT parses a serialized PSBT
+func ParsePSBT(data []byte) (*PSBT, error) {
+	if !bytes.HasPrefix(data, psbtMagic) {
+		return nil, errors.New("invalid PSBT magic")
+	}
+	data = data[len(psbtMagic):]
+
+	psbt := &PSBT{}
+	var err error
+	if psbt.Global, data, err = readPSBTMap(data); err != nil {
+		return nil, fmt.Errorf("invalid PSBT global map: %w", err)
+	}
+
+	inputCount, outputCount, err := psbt.counts()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < inputCount; i++ {
+		var input PSBTMap
+		if input, data, err = readPSBTMap(data); err != nil {
+			return nil, fmt.Errorf("invalid PSBT input %d: %w", i, err)
+		}
+		psbt.Inputs = append(psbt.Inputs, input)
+	}
+	for i := uint64(0); i < outputCount; i++ {
+		var output PSBTMap
+		if output, data, err = readPSBTMap(data); err != nil {
+			return nil, fmt.Errorf("invalid PSBT output %d: %w", i, err)
+		}
+		psbt.Outputs = append(psbt.Outputs, output)
+	}
+
+	if len(data) > 0 {
+		return nil, errors.New("unexpected data after the PSBT")
+	}
+	return psbt, nil
+}
+
+// counts returns the number of inputs and outputs declared by the global map
+func (p *PSBT) counts() (uint64, uint64, error) {
+	version := p.Version()
+	switch version {
+	case 0:
+		tx, err := p.UnsignedTx()
+		if err != nil {
+			return 0, 0, err
+		}
+		return uint64(len(tx.Inputs)), uint64(len(tx.Outputs)), nil
+	case 2:
+		if _, ok := p.Global.Get([]byte{PSBTGlobalUnsignedTx}); ok {
+			return 0, 0, errors.New("a version 2 PSBT must not contain an unsigned transaction")
+		}
+		inputCount, err := psbtCompactSize(p.Global, PSBTGlobalInputCount)
+		if err != nil {
+			return 0, 0, err
+		}
+		outputCount, err := psbtCompactSize(p.Global, PSBTGlobalOutputCount)
+		if err != nil {
+			return 0, 0, err
+		}
+		return inputCount, outputCount, nil
+	}
+	return 0, 0, fmt.Errorf("unsupported PSBT version %d", version)
+}
+
+// Version returns the PSBT version: 0 or 2
+func (p *PSBT) Version() uint32 {
+	value, ok := p.Global.Get([]byte{PSBTGlobalVersion})
+	if !ok || len(value) != 4 {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(value)
+}
+
+// UnsignedTx returns the transaction of a version 0 PSBT
+func (p *PSBT) UnsignedTx() (*BitcoinTx, error) {
+	value, ok := p.Global.Get([]byte{PSBTGlobalUnsignedTx})
+	if !ok {
+		return nil, errors.New("the PSBT does not contain the unsigned transaction")
+	}
+	return ParseBitcoinTx(value)
+}
+
+// Serialize returns the binary encoding of the PSBT
+func (p *PSBT) Serialize() []byte {
+	data := append([]byte{}, psbtMagic...)
+	data = appendPSBTMap(data, p.Global)
+	for _, input := range p.Inputs {
+		data = appendPSBTMap(data, input)
+	}
+	for _, output := range p.Outputs {
+		data = appendPSBTMap(data, output)
+	}
+	return data
+}
+
+// Base64 returns the base64 encoding of the PSBT
+func (p *PSBT) Base64() string {
+	return base64.StdEncoding.EncodeToString(p.Serialize())
+}
+
+// toV2 returns a copy of the PSBT in version 2, where the fields of the transaction are in the maps.
+// This is the only version understood by the Bitcoin app
+func (p *PSBT) toV2() (*PSBT, error) {
+	v2 := &PSBT{Global: append(PSBTMap{}, p.Global...)}
+	for _, input := range p.Inputs {
+		v2.Inputs = append(v2.Inputs, append(PSBTMap{}, input...))
+	}
+	for _, output := range p.Outputs {
+		v2.Outputs = append(v2.Outputs, append(PSBTMap{}, output...))
+	}
+	if p.Version() == 2 {
+		return v2, nil
+	}
+
+	tx, err := p.UnsignedTx()
+	if err != nil {
+		return nil, err
+	}
+	if len(tx.Inputs) != len(p.Inputs) || len(tx.Outputs) != len(p.Outputs) {
+		return nil, errors.New("the PSBT maps do not match the unsigned transaction")
+	}
+
+	v2.Global.Delete([]byte{PSBTGlobalUnsignedTx})
+	v2.Global.Set([]byte{PSBTGlobalTxVersion}, psbtUint32(uint32(tx.Version)))
+	v2.Global.Set([]byte{PSBTGlobalFallbackLocktime}, psbtUint32(tx.LockTime))
+	v2.Global.Set([]byte{PSBTGlobalInputCount}, appendCompactSize(nil, uint64(len(tx.Inputs))))
+	v2.Global.Set([]byte{PSBTGlobalOutputCount}, appendCompactSize(nil, uint64(len(tx.Outputs))))
+	v2.Global.Set([]byte{PSBTGlobalVersion}, psbtUint32(2))
+
+	for i, input := range tx.Inputs {
+		v2.Inputs[i].Set([]byte{PSBTInPreviousTxID}, append([]byte{}, input.PrevTxHash[:]...))
+		v2.Inputs[i].Set([]byte{PSBTInOutputIndex}, psbtUint32(input.PrevIndex))
+		v2.Inputs[i].Set([]byte{PSBTInSequence}, psbtUint32(input.Sequence))
+	}
+	for i, output := range tx.Outputs {
+		amount := make([]byte, 8)
+		binary.LittleEndian.PutUint64(amount, uint64(output.Value))
+		v2.Outputs[i].Set([]byte{PSBTOutAmount}, amount)
+		v2.Outputs[i].Set([]byte{PSBTOutScript}, output.Script)
+	}
+	return v2, nil
+}
+
+func psbtUint32(n uint32) []byte {
+	value := make([]byte, 4)
+	binary.LittleEndian.PutUint32(value, n)
+	return value
+}
+
+func psbtCompactSize(m PSBTMap, keyType byte) (uint64, error) {
+	value, ok := m.Get([]byte{keyType})
+	if !ok {
+		return 0, fmt.Errorf("missing PSBT key 0x%02x", keyType)
+	}
+	n, rest, err := readCompactSize(value)
+	if err != nil || len(rest) > 0 {
+		return 0, fmt.Errorf("invalid PSBT key 0x%02x", keyType)
+	}
+	return n, nil
+}
+
+// readPSBTMap reads the entries of a map up to its separator
+func readPSBTMap(data []byte) (PSBTMap, []byte, error) {
+	m := PSBTMap{}
+	for {
+		key, rest, err := readVarBytes(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(key) == 0 {
+			return m, rest, nil
+		}
+		value, rest, err := readVarBytes(rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := m.Get(key); ok {
+			return nil, nil, fmt.Errorf("duplicated key %x", key)
+		}
+		m = append(m, PSBTEntry{Key: key, Value: value})
+		data = rest
+	}
+}
+
+func appendPSBTMap(data []byte, m PSBTMap) []byte {
+	for _, entry := range m {
+		data = appendVarBytes(data, entry.Key)
+		data = appendVarBytes(data, entry.Value)
+	}
+	return append(data, 0x00)
+}
+
+func readVarBytes(data []byte) ([]byte, []byte, error) {
+	size, rest, err := readCompactSize(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if uint64(len(rest)) < size {
+		return nil, nil, errors.New("unexpected end of data")
+	}
+	return rest[:size], rest[size:], nil
+}
+
+func appendVarBytes(data []byte, value []byte) []byte {
+	return append(appendCompactSize(data, uint64(len(value))), value...)
+}
+
+// BitcoinTxIn is an input of a Bitcoin transaction
+type BitcoinTxIn struct {
+	// PrevTxHash is the hash of the previous transaction, in the internal byte order
+	PrevTxHash [32]byte
+	PrevIndex  uint32
+	ScriptSig  []byte
+	Sequence   uint32
+}
+
+// BitcoinTxOut is an output of a Bitcoin transaction
+type BitcoinTxOut struct {
+	Value  int64
+	Script []byte
+}
+
+// BitcoinTx is a Bitcoin transaction. Witnesses are not kept
+type BitcoinTx struct {
+	Version  int32
+	Inputs   []BitcoinTxIn
+	Outputs  []BitcoinTxOut
+	LockTime uint32
+}
+
+// ParseBitcoinTx parses a transaction, with or without witnesses
+func ParseBitcoinTx(data []byte) (*BitcoinTx, error) {
+	r := &btcReader{data: data}
+	tx := &BitcoinTx{Version: int32(r.uint32())}
+
+	inputCount := r.compactSize()
+	segwit := inputCount == 0 && len(r.data) > 0 && r.data[0] == 0x01
+	if segwit {
+		r.bytes(1)
+		inputCount = r.compactSize()
+	}
+	for i := uint64(0); i < inputCount && r.err == nil; i++ {
+		var input BitcoinTxIn
+		copy(input.PrevTxHash[:], r.bytes(32))
+		input.PrevIndex = r.uint32()
+		input.ScriptSig = r.varBytes()
+		input.Sequence = r.uint32()
+		tx.Inputs = append(tx.Inputs, input)
+	}
+
+	outputCount := r.compactSize()
+	for i := uint64(0); i < outputCount && r.err == nil; i++ {
+		var output BitcoinTxOut
+		output.Value = int64(r.uint64())
+		output.Script = r.varBytes()
+		tx.Outputs = append(tx.Outputs, output)
+	}
+
+	if segwit {
+		for i := uint64(0); i < inputCount && r.err == nil; i++ {
+			items := r.compactSize()
+			for j := uint64(0); j < items && r.err == nil; j++ {
+				r.varBytes()
+			}
+		}
+	}
+	tx.LockTime = r.uint32()
+
+	if r.err == nil && len(r.data) > 0 {
+		r.err = errors.New("unexpected data after the transaction")
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid transaction: %w", r.err)
+	}
+	return tx, nil
+}
+
+// Serialize returns the encoding of the transaction without witnesses
+func (tx *BitcoinTx) Serialize() []byte {
+	data := psbtUint32(uint32(tx.Version))
+	data = appendCompactSize(data, uint64(len(tx.Inputs)))
+	for _, input := range tx.Inputs {
+		data = append(data, input.PrevTxHash[:]...)
+		data = append(data, psbtUint32(input.PrevIndex)...)
+		data = appendVarBytes(data, input.ScriptSig)
+		data = append(data, psbtUint32(input.Sequence)...)
+	}
+	data = appendCompactSize(data, uint64(len(tx.Outputs)))
+	for _, output := range tx.Outputs {
+		value := make([]byte, 8)
+		binary.LittleEndian.PutUint64(value, uint64(output.Value))
+		data = append(data, value...)
+		data = appendVarBytes(data, output.Script)
+	}
+	return append(data, psbtUint32(tx.LockTime)...)
+}
+
+// Hash returns the double sha256 of the transaction without witnesses, in the internal byte order
+func (tx *BitcoinTx) Hash() [32]byte {
+	first := sha256.Sum256(tx.Serialize())
+	return sha256.Sum256(first[:])
+}
+
+// btcReader reads little endian fields, remembering the first error
+type btcReader struct {
+	data []byte
+	err  error
+}
+
+func (r *btcReader) bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if uint64(len(r.data)) < n {
+		r.err = errors.New("unexpected end of data")
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *btcReader) uint32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *btcReader) uint64() uint64 {
+	b := r.bytes(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (r *btcReader) compactSize() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	var n uint64
+	n, r.data, r.err = readCompactSize(r.data)
+	return n
+}
+
+func (r *btcReader) varBytes() []byte {
+	return r.bytes(r.compactSize())
+}
@@ -0,0 +1,132 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testBitcoinTx() *BitcoinTx {
+	return &BitcoinTx{
+		Version: 2,
+		Inputs: []BitcoinTxIn{
+			{PrevTxHash: [32]byte{0x11}, PrevIndex: 1, ScriptSig: []byte{}, Sequence: 0xFFFFFFFD},
+			{PrevTxHash: [32]byte{0x22}, PrevIndex: 0, ScriptSig: []byte{}, Sequence: 0xFFFFFFFD},
+		},
+		Outputs: []BitcoinTxOut{
+			{Value: 150000, Script: append([]byte{0x00, 0x14}, bytes.Repeat([]byte{0x33}, 20)...)},
+			{Value: 0, Script: append([]byte{0x6A, 0x05}, "=:r:x"...)},
+		},
+		LockTime: 800000,
+	}
+}
+
+func Test_BitcoinTx(t *testing.T) {
+	tx := testBitcoinTx()
+	parsed, err := ParseBitcoinTx(tx.Serialize())
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, tx, parsed)
+
+	// The same transaction with a witness for each input
+	serialized := tx.Serialize()
+	segwit := append(append([]byte{}, serialized[:4]...), 0x00, 0x01)
+	segwit = append(segwit, serialized[4:len(serialized)-4]...)
+	segwit = append(segwit, 0x02, 0x01, 0xAA, 0x01, 0xBB, 0x00)
+	segwit = append(segwit, serialized[len(serialized)-4:]...)
+	parsed, err = ParseBitcoinTx(segwit)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, tx, parsed)
+	assert.Equal(t, tx.Hash(), parsed.Hash())
+
+	_, err = ParseBitcoinTx(serialized[:len(serialized)-1])
+	assert.EqualError(t, err, "invalid transaction: unexpected end of data")
+	_, err = ParseBitcoinTx(append(serialized, 0))
+	assert.EqualError(t, err, "invalid transaction: unexpected data after the transaction")
+}
+
+func Test_PSBT(t *testing.T) {
+	psbt, err := NewPSBT(testBitcoinTx())
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	psbt.Inputs[0].Set([]byte{PSBTInWitnessUTXO}, []byte{1, 2, 3})
+	psbt.Inputs[0].Set([]byte{PSBTInWitnessUTXO}, []byte{4, 5, 6})
+	assert.Len(t, psbt.Inputs[0], 1)
+	assert.Equal(t, uint32(0), psbt.Version())
+	assert.Equal(t, "cHNidP8B", psbt.Base64()[:8])
+
+	parsed, err := ParsePSBTBase64(psbt.Base64())
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, psbt.Serialize(), parsed.Serialize())
+	value, ok := parsed.Inputs[0].Get([]byte{PSBTInWitnessUTXO})
+	assert.True(t, ok)
+	assert.Equal(t, []byte{4, 5, 6}, value)
+	assert.Empty(t, parsed.Outputs[1])
+
+	_, err = ParsePSBT(psbt.Serialize()[1:])
+	assert.EqualError(t, err, "invalid PSBT magic")
+	_, err = ParsePSBT(append(psbt.Serialize(), 0))
+	assert.EqualError(t, err, "unexpected data after the PSBT")
+	_, err = ParsePSBT(psbt.Serialize()[:len(psbt.Serialize())-1])
+	assert.EqualError(t, err, "invalid PSBT output 1: unexpected end of data")
+
+	signed := testBitcoinTx()
+	signed.Inputs[0].ScriptSig = []byte{0x00}
+	_, err = NewPSBT(signed)
+	assert.EqualError(t, err, "the transaction inputs must not be signed")
+}
+
+func Test_PSBTToV2(t *testing.T) {
+	tx := testBitcoinTx()
+	psbt, err := NewPSBT(tx)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	psbt.Global.Set([]byte{PSBTGlobalXPub, 0x01}, []byte{0x02})
+
+	v2, err := psbt.toV2()
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, uint32(2), v2.Version())
+	assert.Equal(t, uint32(0), psbt.Version())
+
+	_, ok := v2.Global.Get([]byte{PSBTGlobalUnsignedTx})
+	assert.False(t, ok)
+	value, _ := v2.Global.Get([]byte{PSBTGlobalXPub, 0x01})
+	assert.Equal(t, []byte{0x02}, value)
+	value, _ = v2.Global.Get([]byte{PSBTGlobalFallbackLocktime})
+	assert.Equal(t, uint32(800000), binary.LittleEndian.Uint32(value))
+
+	value, _ = v2.Inputs[1].Get([]byte{PSBTInPreviousTxID})
+	assert.Equal(t, tx.Inputs[1].PrevTxHash[:], value)
+	value, _ = v2.Inputs[0].Get([]byte{PSBTInOutputIndex})
+	assert.Equal(t, []byte{1, 0, 0, 0}, value)
+	value, _ = v2.Outputs[0].Get([]byte{PSBTOutAmount})
+	assert.Equal(t, uint64(150000), binary.LittleEndian.Uint64(value))
+	value, _ = v2.Outputs[1].Get([]byte{PSBTOutScript})
+	assert.Equal(t, tx.Outputs[1].Script, value)
+	assert.Empty(t, psbt.Outputs[1])
+
+	parsed, err := ParsePSBT(v2.Serialize())
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Len(t, parsed.Inputs, 2)
+	assert.Len(t, parsed.Outputs, 2)
+
+	again, err := parsed.toV2()
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, v2.Serialize(), again.Serialize())
+}
@@ -0,0 +1,78 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+// Package sdkledger connects the Cosmos SDK keyring to the THORChain Ledger app.
+// It lives in its own module so that the main package does not depend on the SDK.
+// Until the main module is tagged, go.mod replaces it with the parent directory.
+//
+// Call Register before the keyring is used, e.g. in the init of thornode's CLI,
+// and `thornode keys add --ledger` will talk to the device through this library.
+package sdkledger
+
+import (
+	"github.com/cosmos/cosmos-sdk/crypto/keys/secp256k1"
+	cosmosledger "github.com/cosmos/cosmos-sdk/crypto/ledger"
+	cryptotypes "github.com/cosmos/cosmos-sdk/crypto/types"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+// Register makes the SDK ledger support use the THORChain app
+func Register() {
+	cosmosledger.SetAppName(ledger.THORChainAppName)
+	cosmosledger.SetDiscoverLedger(Discover)
+	cosmosledger.SetCreatePubkey(CreatePubKey)
+	// Device.SignSECP256K1 already returns compact signatures
+	cosmosledger.SetSkipDERConversion()
+}
+
+// Discover finds the THORChain app. It is the SDK ledger discovery function
+func Discover() (cosmosledger.SECP256K1, error) {
+	app, err := ledger.FindLedgerTHORChainUserApp()
+	if err != nil {
+		return nil, err
+	}
+	return &Device{app}, nil
+}
+
+// CreatePubKey returns the SDK key type for a compressed public key returned by the app
+func CreatePubKey(key []byte) cryptotypes.PubKey {
+	return &secp256k1.PubKey{Key: key}
+}
+
+// App is the part of LedgerTHORChain used by Device
+type App interface {
+	Close() error
+	GetPublicKeySECP256K1(bip32Path []uint32) ([]byte, error)
+	GetAddressPubKeySECP256K1(bip32Path []uint32, hrp string) ([]byte, string, error)
+	SignSECP256K1(bip32Path []uint32, transaction []byte, p2 byte) ([]byte, error)
+}
+
+// Device implements the SDK SECP256K1 interface with the THORChain app
+type Device struct {
+	App
+}
+
+var _ cosmosledger.SECP256K1 = (*Device)(nil)
+
+// SignSECP256K1 signs with the app and converts the DER signature to the 64 byte compact form
+func (d *Device) SignSECP256K1(bip32Path []uint32, message []byte, p2 byte) ([]byte, error) {
+	der, err := d.App.SignSECP256K1(bip32Path, message, p2)
+	if err != nil {
+		return nil, err
+	}
+	return ledger.SignatureToCompact(der)
+}
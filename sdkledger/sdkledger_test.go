@@ -0,0 +1,116 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package sdkledger
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/cosmos/cosmos-sdk/crypto/hd"
+	"github.com/cosmos/cosmos-sdk/crypto/keys/secp256k1"
+	cosmosledger "github.com/cosmos/cosmos-sdk/crypto/ledger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeApp signs like the THORChain app with an in-memory key
+type fakeApp struct {
+	key      *btcec.PrivateKey
+	lastPath []uint32
+	lastMode byte
+	err      error
+}
+
+func newFakeApp(t *testing.T) *fakeApp {
+	key, err := btcec.NewPrivateKey()
+	require.Nil(t, err)
+	return &fakeApp{key: key}
+}
+
+func (a *fakeApp) Close() error {
+	return nil
+}
+
+func (a *fakeApp) GetPublicKeySECP256K1(bip32Path []uint32) ([]byte, error) {
+	a.lastPath = bip32Path
+	return a.key.PubKey().SerializeCompressed(), a.err
+}
+
+func (a *fakeApp) GetAddressPubKeySECP256K1(bip32Path []uint32, hrp string) ([]byte, string, error) {
+	return nil, "", errors.New("not implemented")
+}
+
+func (a *fakeApp) SignSECP256K1(bip32Path []uint32, transaction []byte, p2 byte) ([]byte, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	a.lastPath, a.lastMode = bip32Path, p2
+	hash := sha256.Sum256(transaction)
+	return ecdsa.Sign(a.key, hash[:]).Serialize(), nil
+}
+
+func Test_Register(t *testing.T) {
+	Register()
+	app := newFakeApp(t)
+	cosmosledger.SetDiscoverLedger(func() (cosmosledger.SECP256K1, error) {
+		return &Device{app}, nil
+	})
+
+	path := *hd.NewFundraiserParams(0, 931, 3)
+	priv, err := cosmosledger.NewPrivKeySecp256k1Unsafe(path)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, app.key.PubKey().SerializeCompressed(), priv.PubKey().Bytes())
+	assert.Equal(t, []uint32{44, 931, 0, 0, 3}, app.lastPath)
+
+	// The keyring verifies the compact signature returned by Device
+	message := []byte(`{"account_number":"1","chain_id":"thorchain-1"}`)
+	signature, err := priv.Sign(message)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Len(t, signature, 64)
+	assert.True(t, priv.PubKey().VerifySignature(message, signature))
+	assert.Equal(t, byte(1), app.lastMode, "Sign uses SIGN_MODE_TEXTUAL")
+
+	signature, err = priv.SignLedgerAminoJSON(message)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.True(t, priv.PubKey().VerifySignature(message, signature))
+	assert.Equal(t, byte(0), app.lastMode)
+}
+
+func Test_Device_SignSECP256K1(t *testing.T) {
+	app := newFakeApp(t)
+	device := &Device{app}
+
+	message := []byte("message")
+	signature, err := device.SignSECP256K1([]uint32{44, 931, 0, 0, 0}, message, 1)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.True(t, CreatePubKey(app.key.PubKey().SerializeCompressed()).VerifySignature(message, signature))
+	assert.Equal(t, byte(1), app.lastMode)
+
+	app.err = errors.New("[APDU_CODE_COMMAND_NOT_ALLOWED] Command not allowed / User Rejected (no current EF)")
+	_, err = device.SignSECP256K1([]uint32{44, 931, 0, 0, 0}, message, 1)
+	assert.Equal(t, app.err, err)
+}
+
+func Test_CreatePubKey(t *testing.T) {
+	key := make([]byte, 33)
+	key[0] = 2
+	pubKey := CreatePubKey(key)
+	assert.Equal(t, &secp256k1.PubKey{Key: key}, pubKey)
+}
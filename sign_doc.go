@@ -0,0 +1,166 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// SignDocLimits contains the parser budgets of the user app. A zero field disables that check.
+type SignDocLimits struct {
+	MaxSize   int // bytes
+	MaxTokens int // JSMN tokens (objects, arrays, keys and values)
+	MaxDepth  int // nesting of objects and arrays
+}
+
+// DefaultSignDocLimits returns the budgets enforced by current releases of the user app
+func DefaultSignDocLimits() SignDocLimits {
+	return SignDocLimits{
+		MaxSize:   16384,
+		MaxTokens: 768,
+		MaxDepth:  16,
+	}
+}
+
+// requiredSignDocKeys are the root fields the app expects in a StdSignDoc
+var requiredSignDocKeys = []string{"account_number", "chain_id", "fee", "memo", "msgs", "sequence"}
+
+// SignDocLimitError a sign doc exceeds one of the app budgets
+type SignDocLimitError struct {
+	Limit string
+	Found int
+	Max   int
+}
+
+func (e SignDocLimitError) Error() string {
+	return fmt.Sprintf("sign doc %s %d exceeds the app limit of %d", e.Limit, e.Found, e.Max)
+}
+
+// NonCanonicalSignDocError a sign doc is valid JSON but not in the compact, key-sorted form the app requires
+type NonCanonicalSignDocError struct {
+	Offset int
+}
+
+func (e NonCanonicalSignDocError) Error() string {
+	return fmt.Sprintf("sign doc is not canonical (first difference at byte %d): keys must be sorted and whitespace removed", e.Offset)
+}
+
+// CanonicalizeAminoJSON returns the compact, key-sorted encoding of a JSON document.
+// Numbers are kept verbatim so that amounts are not altered.
+func CanonicalizeAminoJSON(doc []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, signDocSyntaxError(err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("Unexpected characters after the JSON document at byte %d", decoder.InputOffset())
+	}
+
+	// encoding/json sorts map keys, which is what the app and the SDK expect
+	return json.Marshal(value)
+}
+
+// PreflightSignDoc checks an amino JSON sign doc against the requirements of the user app
+// so that it can be rejected without prompting the user in the device
+func PreflightSignDoc(doc []byte, limits SignDocLimits) error {
+	if limits.MaxSize > 0 && len(doc) > limits.MaxSize {
+		return &SignDocLimitError{"size", len(doc), limits.MaxSize}
+	}
+
+	canonical, err := CanonicalizeAminoJSON(doc)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(canonical, doc) {
+		offset := 0
+		for offset < len(doc) && offset < len(canonical) && doc[offset] == canonical[offset] {
+			offset++
+		}
+		return &NonCanonicalSignDocError{offset}
+	}
+
+	tokens, depth, err := countSignDocTokens(doc)
+	if err != nil {
+		return err
+	}
+	if limits.MaxTokens > 0 && tokens > limits.MaxTokens {
+		return &SignDocLimitError{"token count", tokens, limits.MaxTokens}
+	}
+	if limits.MaxDepth > 0 && depth > limits.MaxDepth {
+		return &SignDocLimitError{"nesting depth", depth, limits.MaxDepth}
+	}
+
+	var root map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &root); err != nil {
+		return errors.New("sign doc root must be a JSON object")
+	}
+	for _, key := range requiredSignDocKeys {
+		if _, ok := root[key]; !ok {
+			return fmt.Errorf("sign doc is missing the required field %q", key)
+		}
+	}
+
+	return nil
+}
+
+// countSignDocTokens counts tokens the way the app JSON parser does and returns the maximum nesting depth
+func countSignDocTokens(doc []byte) (tokens int, maxDepth int, err error) {
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
+
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return tokens, maxDepth, nil
+		}
+		if err != nil {
+			return 0, 0, signDocSyntaxError(err)
+		}
+
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			tokens++
+			depth++
+			if depth > maxDepth {
+				maxDepth = depth
+			}
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		default:
+			tokens++
+		}
+	}
+}
+
+func signDocSyntaxError(err error) error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return fmt.Errorf("Invalid character in JSON string at byte %d: %s", syntaxErr.Offset, syntaxErr.Error())
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errors.New("The JSON string is not a complete.")
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CanonicalizeAminoJSON(t *testing.T) {
+	input := `{
+		"sequence": "3",
+		"msgs": [{"value": {"memo": "=:BTC.BTC:bc1q", "coins": [{"asset": "THOR.RUNE", "amount": "100"}]}, "type": "thorchain/MsgDeposit"}],
+		"memo": "",
+		"fee": {"gas": "5", "amount": []},
+		"chain_id": "thorchain-1",
+		"account_number": 1.50
+	}`
+
+	canonical, err := CanonicalizeAminoJSON([]byte(input))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	assert.Equal(
+		t,
+		`{"account_number":1.50,"chain_id":"thorchain-1","fee":{"amount":[],"gas":"5"},"memo":"",`+
+			`"msgs":[{"type":"thorchain/MsgDeposit","value":{"coins":[{"amount":"100","asset":"THOR.RUNE"}],"memo":"=:BTC.BTC:bc1q"}}],"sequence":"3"}`,
+		string(canonical))
+
+	assert.Nil(t, PreflightSignDoc(canonical, DefaultSignDocLimits()))
+}
+
+func Test_CanonicalizeAminoJSON_Invalid(t *testing.T) {
+	_, err := CanonicalizeAminoJSON([]byte(`A{"memo":""}`))
+	require.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "Invalid character in JSON string at byte 1"), err.Error())
+
+	_, err = CanonicalizeAminoJSON([]byte(`{"memo":""`))
+	require.Error(t, err)
+	assert.Equal(t, "The JSON string is not a complete.", err.Error())
+
+	_, err = CanonicalizeAminoJSON([]byte(`{"memo":""}{}`))
+	require.Error(t, err)
+}
+
+func Test_PreflightSignDoc_NotCanonical(t *testing.T) {
+	err := PreflightSignDoc(getDummyTx()[:0], DefaultSignDocLimits())
+	require.Error(t, err)
+
+	doc := []byte(`{"chain_id":"thorchain-1","account_number":"1","fee":{"amount":[],"gas":"5"},"memo":"","msgs":[],"sequence":"3"}`)
+	err = PreflightSignDoc(doc, DefaultSignDocLimits())
+	require.Error(t, err)
+	assert.Equal(t, &NonCanonicalSignDocError{2}, err)
+
+	err = PreflightSignDoc([]byte(`{"account_number":"1", "chain_id":"x"}`), SignDocLimits{})
+	require.Error(t, err)
+	assert.Equal(t, &NonCanonicalSignDocError{22}, err)
+}
+
+func Test_PreflightSignDoc_Limits(t *testing.T) {
+	doc := getDummyTx()
+
+	err := PreflightSignDoc(doc, SignDocLimits{MaxSize: len(doc) - 1})
+	assert.Equal(t, &SignDocLimitError{"size", len(doc), len(doc) - 1}, err)
+
+	// root, 6 root keys, 4 scalar values, fee{amount:[{amount,denom}],gas} and msgs:["SOMETHING"]
+	err = PreflightSignDoc(doc, SignDocLimits{MaxTokens: 10})
+	assert.Equal(t, &SignDocLimitError{"token count", 23, 10}, err)
+
+	err = PreflightSignDoc(doc, SignDocLimits{MaxDepth: 3})
+	assert.Equal(t, &SignDocLimitError{"nesting depth", 4, 3}, err)
+
+	assert.Nil(t, PreflightSignDoc(doc, SignDocLimits{MaxSize: len(doc), MaxTokens: 23, MaxDepth: 4}))
+}
+
+func Test_PreflightSignDoc_MissingField(t *testing.T) {
+	err := PreflightSignDoc([]byte(`{"account_number":"1","chain_id":"x"}`), DefaultSignDocLimits())
+	require.Error(t, err)
+	assert.Equal(t, `sign doc is missing the required field "fee"`, err.Error())
+
+	err = PreflightSignDoc([]byte(`["account_number"]`), DefaultSignDocLimits())
+	require.Error(t, err)
+	assert.Equal(t, "sign doc root must be a JSON object", err.Error())
+}
@@ -0,0 +1,69 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"crypto/sha256"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SignatureToCompact(t *testing.T) {
+	priv, err := btcec.NewPrivateKey()
+	require.Nil(t, err)
+	hash := sha256.Sum256([]byte("message"))
+
+	der := ecdsa.Sign(priv, hash[:]).Serialize()
+	recoverable, err := ecdsa.SignCompact(priv, hash[:], true)
+	require.Nil(t, err)
+
+	compact, err := SignatureToCompact(der)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, recoverable[1:], compact)
+
+	// A high S is normalized
+	var sig ecdsaSignature
+	_, err = asn1.Unmarshal(der, &sig)
+	require.Nil(t, err)
+	highS, err := asn1.Marshal(ecdsaSignature{sig.R, new(big.Int).Sub(btcec.S256().Params().N, sig.S)})
+	require.Nil(t, err)
+
+	compact, err = SignatureToCompact(highS)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, recoverable[1:], compact)
+}
+
+func Test_SignatureToCompact_Invalid(t *testing.T) {
+	_, err := SignatureToCompact([]byte{0x30, 0x01})
+	assert.Error(t, err)
+
+	zero, err := asn1.Marshal(ecdsaSignature{big.NewInt(0), big.NewInt(1)})
+	require.Nil(t, err)
+	_, err = SignatureToCompact(zero)
+	assert.Error(t, err)
+
+	valid, err := asn1.Marshal(ecdsaSignature{big.NewInt(1), big.NewInt(1)})
+	require.Nil(t, err)
+	_, err = SignatureToCompact(append(valid, 0))
+	assert.Error(t, err)
+}
@@ -0,0 +1,133 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+)
+
+// SECP256K1Device is the part of LedgerTHORChain used by Signer
+type SECP256K1Device interface {
+	GetPublicKeySECP256K1(bip32Path []uint32) ([]byte, error)
+	SignSECP256K1(bip32Path []uint32, transaction []byte, p2 byte) ([]byte, error)
+}
+
+// SignatureEncoding is the encoding of the signatures returned by Signer
+type SignatureEncoding int
+
+const (
+	// SignatureDER is the ASN.1 DER encoding returned by the app
+	SignatureDER SignatureEncoding = iota
+	// SignatureCompact is the 64 byte R || S encoding used by the Cosmos SDK
+	SignatureCompact
+)
+
+// SignerOpts carries the message to sign. The app hashes and displays the message
+// itself, so it cannot sign a digest computed by the caller
+type SignerOpts struct {
+	Message  []byte
+	SignMode SignMode
+}
+
+// HashFunc returns 0: the digest given to Sign is not what the device signs
+func (o *SignerOpts) HashFunc() crypto.Hash {
+	return 0
+}
+
+// Signer implements crypto.Signer with the key of a bip32 path
+type Signer struct {
+	device   SECP256K1Device
+	path     []uint32
+	encoding SignatureEncoding
+	pubKey   *btcec.PublicKey
+}
+
+var _ crypto.Signer = (*Signer)(nil)
+
+// NewSigner creates a signer for a path. The public key is read from the device once
+func NewSigner(device SECP256K1Device, bip32Path []uint32, encoding SignatureEncoding) (*Signer, error) {
+	if encoding != SignatureDER && encoding != SignatureCompact {
+		return nil, fmt.Errorf("unknown signature encoding %d", encoding)
+	}
+
+	pubKeyBytes, err := device.GetPublicKeySECP256K1(bip32Path)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := btcec.ParsePubKey(pubKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	path := append([]uint32{}, bip32Path...)
+	return &Signer{device: device, path: path, encoding: encoding, pubKey: pubKey}, nil
+}
+
+// Path returns the bip32 path of the key
+func (s *Signer) Path() []uint32 {
+	return append([]uint32{}, s.path...)
+}
+
+// Public returns the *btcec.PublicKey of the path
+func (s *Signer) Public() crypto.PublicKey {
+	return s.pubKey
+}
+
+// Sign signs the message given in opts, which must be a *SignerOpts. If digest is not
+// empty it must be the SHA-256 of the message. The signature is checked against the
+// public key before it is returned
+// this command requires user confirmation in the device
+func (s *Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	signerOpts, ok := opts.(*SignerOpts)
+	if !ok || signerOpts == nil {
+		return nil, errors.New("the message must be passed in a *SignerOpts")
+	}
+	if len(signerOpts.Message) == 0 {
+		return nil, errors.New("empty message")
+	}
+
+	hash := sha256.Sum256(signerOpts.Message)
+	if len(digest) > 0 && !bytes.Equal(digest, hash[:]) {
+		return nil, errors.New("digest does not match the SHA-256 of the message")
+	}
+
+	der, err := s.device.SignSECP256K1(s.path, signerOpts.Message, byte(signerOpts.SignMode))
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := ecdsa.ParseDERSignature(der)
+	if err != nil {
+		return nil, err
+	}
+	if !sig.Verify(hash[:], s.pubKey) {
+		return nil, errors.New("signature verification failed")
+	}
+
+	if s.encoding == SignatureCompact {
+		return SignatureToCompact(der)
+	}
+	return der, nil
+}
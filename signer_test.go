@@ -0,0 +1,111 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// keyDevice signs with an in-memory key like the user app, returning DER signatures
+type keyDevice struct {
+	key      *btcec.PrivateKey
+	lastMode byte
+}
+
+func (d *keyDevice) GetPublicKeySECP256K1(bip32Path []uint32) ([]byte, error) {
+	if len(bip32Path) != 5 {
+		return nil, errors.New("path should contain 5 elements")
+	}
+	return d.key.PubKey().SerializeCompressed(), nil
+}
+
+func (d *keyDevice) SignSECP256K1(bip32Path []uint32, transaction []byte, p2 byte) ([]byte, error) {
+	d.lastMode = p2
+	hash := sha256.Sum256(transaction)
+	return ecdsa.Sign(d.key, hash[:]).Serialize(), nil
+}
+
+func Test_Signer(t *testing.T) {
+	key, err := btcec.NewPrivateKey()
+	require.Nil(t, err)
+	device := &keyDevice{key: key}
+	path := []uint32{44, 931, 0, 0, 0}
+	message := []byte(`{"account_number":"1"}`)
+	hash := sha256.Sum256(message)
+
+	signer, err := NewSigner(device, path, SignatureDER)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.True(t, key.PubKey().IsEqual(signer.Public().(*btcec.PublicKey)))
+	assert.Equal(t, path, signer.Path())
+
+	der, err := signer.Sign(nil, hash[:], &SignerOpts{Message: message})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	sig, err := ecdsa.ParseDERSignature(der)
+	require.Nil(t, err)
+	assert.True(t, sig.Verify(hash[:], key.PubKey()))
+
+	signer, err = NewSigner(device, path, SignatureCompact)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	compact, err := signer.Sign(nil, nil, &SignerOpts{Message: message, SignMode: SignModeTextual})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Len(t, compact, 64)
+	assert.Equal(t, byte(SignModeTextual), device.lastMode)
+
+	expected, err := SignatureToCompact(der)
+	require.Nil(t, err)
+	assert.Equal(t, expected, compact)
+}
+
+func Test_Signer_Errors(t *testing.T) {
+	key, err := btcec.NewPrivateKey()
+	require.Nil(t, err)
+	device := &keyDevice{key: key}
+	path := []uint32{44, 931, 0, 0, 0}
+
+	_, err = NewSigner(device, []uint32{44}, SignatureDER)
+	assert.Error(t, err)
+	_, err = NewSigner(device, path, SignatureEncoding(5))
+	assert.Error(t, err)
+
+	signer, err := NewSigner(device, path, SignatureDER)
+	require.Nil(t, err)
+
+	hash := sha256.Sum256([]byte("message"))
+	_, err = signer.Sign(nil, hash[:], crypto.SHA256)
+	assert.Error(t, err, "the message must be passed in the options")
+
+	_, err = signer.Sign(nil, hash[:], &SignerOpts{Message: []byte("other message")})
+	assert.Error(t, err, "digest mismatch")
+
+	_, err = signer.Sign(nil, nil, &SignerOpts{})
+	assert.Error(t, err)
+
+	// A signature made with another key is rejected
+	other, err := btcec.NewPrivateKey()
+	require.Nil(t, err)
+	device.key = other
+	_, err = signer.Sign(nil, hash[:], &SignerOpts{Message: []byte("message")})
+	assert.EqualError(t, err, "signature verification failed")
+}
@@ -18,9 +18,12 @@
 package ledger_thorchain_go
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"math"
+	"sync"
+	"time"
 
 	ledger_go "github.com/cosmos/ledger-go"
 )
@@ -37,11 +40,21 @@ const (
 
 // LedgerCosmos represents a connection to the Cosmos app in a Ledger Nano S device
 type LedgerTHORChain struct {
-	api     ledger_go.LedgerDevice
-	version VersionInfo
+	api           ledger_go.LedgerDevice
+	version       VersionInfo
+	signDocLimits SignDocLimits
+	debugPolicy   DebugAppPolicy
+	device        DeviceInfo
+	audit         AuditRecorder
+	metrics       Metrics
+
+	// pubKeys caches the public keys reported in audit records, by path
+	pubKeysMtx sync.Mutex
+	pubKeys    map[string][]byte
 }
 
-// FindLedgerCosmosUserApp finds a Cosmos user app running in a ledger device
+// FindLedgerTHORChainUserApp finds a THORChain user app running in a ledger device.
+// A WrongAppError is returned if a different app is open
 func FindLedgerTHORChainUserApp() (_ *LedgerTHORChain, rerr error) {
 	ledgerAdmin := ledger_go.NewLedgerAdmin()
 	ledgerAPI, err := ledgerAdmin.Connect(0)
@@ -55,13 +68,11 @@ func FindLedgerTHORChainUserApp() (_ *LedgerTHORChain, rerr error) {
 		}
 	}()
 
-	app := &LedgerTHORChain{ledgerAPI, VersionInfo{}}
+	device := detectDevice(0)
+	app := &LedgerTHORChain{api: ledgerAPI, signDocLimits: device.Model.SignDocLimits(), device: device}
 	appVersion, err := app.GetVersion()
 	if err != nil {
-		if err.Error() == "[APDU_CODE_CLA_NOT_SUPPORTED] Class not supported" {
-			err = errors.New("are you sure the Cosmos app is open?")
-		}
-		return nil, err
+		return nil, wrongAppError(ledgerAPI, THORChainAppName, err)
 	}
 
 	if err := app.CheckVersion(*appVersion); err != nil {
@@ -76,21 +87,26 @@ func (ledger *LedgerTHORChain) Close() error {
 	return ledger.api.Close()
 }
 
-// VersionIsSupported returns true if the App version is supported by this library
+// CheckVersion returns an error if the App version is not supported by this library
+// or if it is a debug build and the debug app policy refuses it
 func (ledger *LedgerTHORChain) CheckVersion(ver VersionInfo) error {
-	version, err := ledger.GetVersion()
-	if err != nil {
+	if err := ledger.debugPolicy.Check(ver); err != nil {
 		return err
 	}
+	_, err := UserAppCapabilities(ver)
+	return err
+}
 
-	switch major := version.Major; major {
-	case 1:
-		return CheckVersion(ver, VersionInfo{0, 1, 5, 1})
-	case 2:
-		return CheckVersion(ver, VersionInfo{0, 2, 0, 0})
-	default:
-		return fmt.Errorf("App version %d is not supported", major)
-	}
+// SetDebugAppPolicy changes the policy applied to debug builds of the app and
+// checks it against the version of the connected app
+func (ledger *LedgerTHORChain) SetDebugAppPolicy(policy DebugAppPolicy) error {
+	ledger.debugPolicy = policy
+	return policy.Check(ledger.version)
+}
+
+// Capabilities returns the capabilities of the connected app
+func (ledger *LedgerTHORChain) Capabilities() (Capabilities, error) {
+	return UserAppCapabilities(ledger.version)
 }
 
 // GetVersion returns the current version of the THORChain user app
@@ -116,17 +132,109 @@ func (ledger *LedgerTHORChain) GetVersion() (*VersionInfo, error) {
 	return &ledger.version, nil
 }
 
+// SetSignDocLimits changes the budgets used to preflight amino JSON sign docs
+func (ledger *LedgerTHORChain) SetSignDocLimits(limits SignDocLimits) {
+	ledger.signDocLimits = limits
+}
+
+// SetAuditLog records every following sign request in recorder. Nil disables the audit
+func (ledger *LedgerTHORChain) SetAuditLog(recorder AuditRecorder) {
+	ledger.audit = recorder
+}
+
+// SetMetrics reports the following device operations to metrics. Nil disables the metrics
+func (ledger *LedgerTHORChain) SetMetrics(metrics Metrics) {
+	ledger.metrics = metrics
+	ledger.api = meterDevice(ledger.api, metrics)
+}
+
+// Reconnect closes the connection and connects again to the THORChain user app,
+// keeping the settings of this instance. Use it after the device was unplugged or restarted
+func (ledger *LedgerTHORChain) Reconnect() error {
+	_ = ledger.api.Close()
+
+	ledgerAPI, err := ledger_go.NewLedgerAdmin().Connect(0)
+	if err != nil {
+		return err
+	}
+	ledger.api = meterDevice(ledgerAPI, ledger.metrics)
+	ledger.device = detectDevice(0)
+
+	appVersion, err := ledger.GetVersion()
+	if err != nil {
+		return wrongAppError(ledgerAPI, THORChainAppName, err)
+	}
+	if err := ledger.CheckVersion(*appVersion); err != nil {
+		return err
+	}
+
+	if ledger.metrics != nil {
+		ledger.metrics.Reconnected()
+	}
+	return nil
+}
+
 // SignSECP256K1 signs a transaction using Cosmos user app. It can either use
 // SIGN_MODE_LEGACY_AMINO_JSON (P2=0) or SIGN_MODE_TEXTUAL (P2=1).
+// Amino JSON sign docs are checked with PreflightSignDoc before they are sent.
 // this command requires user confirmation in the device
 func (ledger *LedgerTHORChain) SignSECP256K1(bip32Path []uint32, transaction []byte, p2 byte) ([]byte, error) {
-	switch major := ledger.version.Major; major {
-	case 1:
+	signature, err := ledger.signSECP256K1(bip32Path, transaction, p2)
+	observeSign(ledger.metrics, AuditAppTHORChain, transaction, err)
+	if ledger.audit == nil {
+		return signature, err
+	}
+
+	record := AuditRecord{
+		App:      AuditAppTHORChain,
+		Path:     FormatBip32Path(bip32Path, 3),
+		SignMode: SignMode(p2).String(),
+	}
+	if pubKey, err := ledger.auditPubKey(record.Path, bip32Path); err == nil {
+		record.PubKey = hex.EncodeToString(pubKey)
+	}
+	return auditSign(ledger.audit, record, transaction, signature, err)
+}
+
+// auditPubKey returns the public key of a path, asking the device only the first time
+func (ledger *LedgerTHORChain) auditPubKey(key string, bip32Path []uint32) ([]byte, error) {
+	ledger.pubKeysMtx.Lock()
+	defer ledger.pubKeysMtx.Unlock()
+
+	if pubKey, ok := ledger.pubKeys[key]; ok {
+		return pubKey, nil
+	}
+	pubKey, err := ledger.GetPublicKeySECP256K1(bip32Path)
+	if err != nil {
+		return nil, err
+	}
+	if ledger.pubKeys == nil {
+		ledger.pubKeys = map[string][]byte{}
+	}
+	ledger.pubKeys[key] = pubKey
+	return pubKey, nil
+}
+
+func (ledger *LedgerTHORChain) signSECP256K1(bip32Path []uint32, transaction []byte, p2 byte) ([]byte, error) {
+	caps, err := ledger.Capabilities()
+	if err != nil {
+		return nil, err
+	}
+	if !caps.SupportsSignMode(SignMode(p2)) {
+		return nil, fmt.Errorf("sign mode %s is not supported by app version %s", SignMode(p2), caps.Version)
+	}
+
+	if SignMode(p2) == SignModeLegacyAmino {
+		if err := PreflightSignDoc(transaction, ledger.signDocLimits); err != nil {
+			return nil, err
+		}
+	}
+
+	switch caps.Framing {
+	case FramingPacketCount:
 		return ledger.signv1(bip32Path, transaction)
-	case 2:
-		return ledger.signv2(bip32Path, transaction, p2)
 	default:
-		return nil, fmt.Errorf("App version %d is not supported", major)
+		return ledger.signv2(bip32Path, transaction, p2)
 	}
 }
 
@@ -149,25 +257,20 @@ func (ledger *LedgerTHORChain) GetAddressPubKeySECP256K1(bip32Path []uint32, hrp
 }
 
 func (ledger *LedgerTHORChain) GetBip32bytes(bip32Path []uint32, hardenCount int) ([]byte, error) {
-	var pathBytes []byte
-	var err error
+	caps, err := ledger.Capabilities()
+	if err != nil {
+		return nil, err
+	}
+	if err := caps.CheckPath(bip32Path); err != nil {
+		return nil, err
+	}
 
-	switch major := ledger.version.Major; major {
-	case 1:
-		pathBytes, err = GetBip32bytesv1(bip32Path, 3)
-		if err != nil {
-			return nil, err
-		}
-	case 2:
-		pathBytes, err = GetBip32bytesv2(bip32Path, 3)
-		if err != nil {
-			return nil, err
-		}
+	switch caps.Framing {
+	case FramingPacketCount:
+		return GetBip32bytesv1(bip32Path, 3)
 	default:
-		return nil, fmt.Errorf("App version %d is not supported", major)
+		return GetBip32bytesv2(bip32Path, 3)
 	}
-
-	return pathBytes, nil
 }
 
 func (ledger *LedgerCosmos) signv1(bip32Path []uint32, transaction []byte) ([]byte, error) {
@@ -258,7 +361,12 @@ func (ledger *LedgerCosmos) signv2(bip32Path []uint32, transaction []byte, p2 by
 			message = append(header, transaction[:chunk]...)
 		}
 
+		// The device answers the last packet once the user approved or rejected the request
+		start := time.Now()
 		response, err := ledger.api.Exchange(message)
+		if packetIndex == packetCount && ledger.metrics != nil {
+			ledger.metrics.ConfirmationWaited(time.Since(start))
+		}
 		if err != nil {
 			if err.Error() == "[APDU_CODE_BAD_KEY_HANDLE] The parameters in the data field are incorrect" {
 				// In this special case, we can extract additional info
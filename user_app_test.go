@@ -260,7 +260,7 @@ func Test_UserSign_Fails(t *testing.T) {
 	assert.Error(t, err)
 	errMessage := err.Error()
 
-	if errMessage != "Invalid character in JSON string" && errMessage != "Unexpected characters" {
+	if !strings.HasPrefix(errMessage, "Invalid character in JSON string") && errMessage != "Unexpected characters" {
 		assert.Fail(t, "Unexpected error message returned: "+errMessage)
 	}
 }
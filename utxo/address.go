@@ -0,0 +1,254 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+// Package utxo builds the inbound transactions of THORChain swaps from the UTXO chains: a payment
+// to the vault with the memo in an OP_RETURN output, as unsigned PSBTs. Only the BTC ones can be
+// signed with the Bitcoin app (LedgerBitcoin); LTC, BCH and DOGE need a signer of their own.
+package utxo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+// Chain contains the parameters of a UTXO chain
+type Chain struct {
+	// Name is the chain of the THORChain assets, such as BTC
+	Name string
+	// Bech32HRP is the prefix of the segwit addresses, empty if the chain has no segwit
+	Bech32HRP string
+	// CashAddrPrefix is the prefix of the cashaddr addresses of Bitcoin Cash
+	CashAddrPrefix string
+	// PubKeyHashVersions and ScriptHashVersions are the version bytes of the base58 addresses
+	PubKeyHashVersions []byte
+	ScriptHashVersions []byte
+	// DustLimit is the smallest change output created. A smaller change is left to the fee
+	DustLimit int64
+	// DustThreshold is the smallest inbound observed by THORChain
+	DustThreshold int64
+	TxVersion     int32
+	// RBF signals replace by fee in the sequence of the inputs
+	RBF bool
+}
+
+// Segwit returns true if the chain supports segwit
+func (c Chain) Segwit() bool {
+	return c.Bech32HRP != ""
+}
+
+// BitcoinApp returns true if the Bitcoin app signs the transactions of the chain, which is only the case of BTC
+func (c Chain) BitcoinApp() bool {
+	return c.Name == BTC.Name
+}
+
+// The chains supported by THORChain. The dust limits are those of the default node policies
+var (
+	BTC = Chain{
+		Name: "BTC", Bech32HRP: "bc",
+		PubKeyHashVersions: []byte{0x00}, ScriptHashVersions: []byte{0x05},
+		DustLimit: 546, DustThreshold: 10000, TxVersion: 2, RBF: true,
+	}
+	BTCTestnet = Chain{
+		Name: "BTC", Bech32HRP: "tb",
+		PubKeyHashVersions: []byte{0x6F}, ScriptHashVersions: []byte{0xC4},
+		DustLimit: 546, DustThreshold: 10000, TxVersion: 2, RBF: true,
+	}
+	LTC = Chain{
+		Name: "LTC", Bech32HRP: "ltc",
+		PubKeyHashVersions: []byte{0x30}, ScriptHashVersions: []byte{0x32, 0x05},
+		DustLimit: 5460, DustThreshold: 10000, TxVersion: 2, RBF: true,
+	}
+	BCH = Chain{
+		Name: "BCH", CashAddrPrefix: "bitcoincash",
+		PubKeyHashVersions: []byte{0x00}, ScriptHashVersions: []byte{0x05},
+		DustLimit: 546, DustThreshold: 10000, TxVersion: 2,
+	}
+	DOGE = Chain{
+		Name:               "DOGE",
+		PubKeyHashVersions: []byte{0x1E}, ScriptHashVersions: []byte{0x16},
+		DustLimit: 1000000, DustThreshold: 100000000, TxVersion: 1,
+	}
+)
+
+// AddressScript returns the output script paying an address of the chain
+func (c Chain) AddressScript(address string) ([]byte, error) {
+	if c.Segwit() && strings.HasPrefix(strings.ToLower(address), c.Bech32HRP+"1") {
+		version, program, err := ledger.SegwitAddressDecode(c.Bech32HRP, address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s address %q: %w", c.Name, address, err)
+		}
+		op := byte(0x00)
+		if version > 0 {
+			op = 0x50 + version
+		}
+		return append([]byte{op, byte(len(program))}, program...), nil
+	}
+
+	if c.CashAddrPrefix != "" {
+		if script, ok, err := cashAddrScript(c.CashAddrPrefix, address); ok {
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s address %q: %w", c.Name, address, err)
+			}
+			return script, nil
+		}
+	}
+
+	payload, err := base58CheckDecode(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s address %q: %w", c.Name, address, err)
+	}
+	if len(payload) != 21 {
+		return nil, fmt.Errorf("invalid %s address %q: wrong length", c.Name, address)
+	}
+	switch {
+	case bytes.IndexByte(c.PubKeyHashVersions, payload[0]) >= 0:
+		return p2pkhScript(payload[1:]), nil
+	case bytes.IndexByte(c.ScriptHashVersions, payload[0]) >= 0:
+		return p2shScript(payload[1:]), nil
+	}
+	return nil, fmt.Errorf("invalid %s address %q: unknown version 0x%02x", c.Name, address, payload[0])
+}
+
+func p2pkhScript(hash []byte) []byte {
+	script := append([]byte{0x76, 0xA9, 0x14}, hash...)
+	return append(script, 0x88, 0xAC)
+}
+
+func p2shScript(hash []byte) []byte {
+	script := append([]byte{0xA9, 0x14}, hash...)
+	return append(script, 0x87)
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// base58CheckDecode decodes a base58 string and checks its 4 byte double sha256 checksum
+func base58CheckDecode(s string) ([]byte, error) {
+	n := new(big.Int)
+	for _, c := range s {
+		digit := strings.IndexRune(base58Alphabet, c)
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", c)
+		}
+		n.Mul(n, big.NewInt(58))
+		n.Add(n, big.NewInt(int64(digit)))
+	}
+
+	// Each leading 1 is a zero byte
+	zeros := len(s) - len(strings.TrimLeft(s, "1"))
+	decoded := append(make([]byte, zeros), n.Bytes()...)
+	if len(decoded) < 5 {
+		return nil, errors.New("too short")
+	}
+
+	payload, checksum := decoded[:len(decoded)-4], decoded[len(decoded)-4:]
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	if !bytes.Equal(second[:4], checksum) {
+		return nil, errors.New("invalid base58 checksum")
+	}
+	return payload, nil
+}
+
+const cashAddrCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var cashAddrGenerator = [5]uint64{0x98F2BC8E61, 0x79B76D99E2, 0xF33E5FB3C4, 0xAE2EABE2A8, 0x1E4F43E470}
+
+func cashAddrPolymod(values []byte) uint64 {
+	c := uint64(1)
+	for _, v := range values {
+		top := c >> 35
+		c = (c&0x07FFFFFFFF)<<5 ^ uint64(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				c ^= cashAddrGenerator[i]
+			}
+		}
+	}
+	return c ^ 1
+}
+
+// cashAddrScript decodes a cashaddr address, with or without its prefix. It returns false
+// if the address is not a cashaddr address
+func cashAddrScript(prefix string, address string) ([]byte, bool, error) {
+	lower := strings.ToLower(address)
+	if lower != address && strings.ToUpper(address) != address {
+		return nil, false, nil
+	}
+	payload := strings.TrimPrefix(lower, prefix+":")
+	if payload == lower && strings.Contains(lower, ":") {
+		return nil, true, errors.New("wrong prefix")
+	}
+	if len(payload) != 42 || (payload[0] != 'q' && payload[0] != 'p') {
+		return nil, false, nil
+	}
+
+	values := make([]byte, 0, len(prefix)+1+len(payload))
+	for _, c := range []byte(prefix) {
+		values = append(values, c&0x1F)
+	}
+	values = append(values, 0)
+	for _, c := range payload {
+		v := strings.IndexRune(cashAddrCharset, c)
+		if v < 0 {
+			return nil, true, fmt.Errorf("invalid cashaddr character %q", c)
+		}
+		values = append(values, byte(v))
+	}
+	if cashAddrPolymod(values) != 0 {
+		return nil, true, errors.New("invalid cashaddr checksum")
+	}
+
+	data := values[len(prefix)+1 : len(values)-8]
+	decoded, err := regroupBits(data)
+	if err != nil {
+		return nil, true, err
+	}
+	if len(decoded) != 21 {
+		return nil, true, errors.New("wrong length")
+	}
+	switch decoded[0] {
+	case 0x00:
+		return p2pkhScript(decoded[1:]), true, nil
+	case 0x08:
+		return p2shScript(decoded[1:]), true, nil
+	}
+	return nil, true, fmt.Errorf("unknown cashaddr version 0x%02x", decoded[0])
+}
+
+// regroupBits converts 5 bit values to bytes, dropping the zero padding
+func regroupBits(values []byte) ([]byte, error) {
+	var acc uint32
+	var bits uint
+	var result []byte
+	for _, v := range values {
+		acc = acc<<5 | uint32(v)
+		bits += 5
+		if bits >= 8 {
+			bits -= 8
+			result = append(result, byte(acc>>bits))
+		}
+	}
+	if bits >= 5 || acc&(1<<bits-1) != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return result, nil
+}
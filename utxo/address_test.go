@@ -0,0 +1,106 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package utxo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// base58CheckEncode is the inverse of base58CheckDecode
+func base58CheckEncode(payload []byte) string {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	data := append(append([]byte{}, payload...), second[:4]...)
+
+	var encoded []byte
+	n := new(big.Int).SetBytes(data)
+	mod := new(big.Int)
+	for n.Sign() > 0 {
+		n.DivMod(n, big.NewInt(58), mod)
+		encoded = append([]byte{base58Alphabet[mod.Int64()]}, encoded...)
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		encoded = append([]byte{'1'}, encoded...)
+	}
+	return string(encoded)
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func Test_AddressScript(t *testing.T) {
+	hash := "76a04053bda0a88bda5177b86a15c3b29f559873"
+	tests := []struct {
+		chain   Chain
+		address string
+		script  string
+	}{
+		{BTC, "BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{BTC, "bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7kt5nd6y",
+			"5128751e76e8199196d454941c45d1b3a323f1433bd6751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{BTC, "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa", "76a91462e907b15cbf27d5425399ebf6f0fb50ebb88f1888ac"},
+		{BTC, "1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu", "76a914" + hash + "88ac"},
+		{BTCTestnet, "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7",
+			"00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		{BCH, "bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a", "76a914" + hash + "88ac"},
+		{BCH, "qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a", "76a914" + hash + "88ac"},
+		{BCH, "bitcoincash:ppm2qsznhks23z7629mms6s4cwef74vcwvn0h829pq", "a914" + hash + "87"},
+		{BCH, "1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu", "76a914" + hash + "88ac"},
+		{LTC, base58CheckEncode(append([]byte{0x30}, mustDecodeHex(hash)...)), "76a914" + hash + "88ac"},
+		{LTC, base58CheckEncode(append([]byte{0x32}, mustDecodeHex(hash)...)), "a914" + hash + "87"},
+		{DOGE, base58CheckEncode(append([]byte{0x1E}, mustDecodeHex(hash)...)), "76a914" + hash + "88ac"},
+	}
+	for _, tc := range tests {
+		script, err := tc.chain.AddressScript(tc.address)
+		require.Nil(t, err, "Detected error, err: %s\n", err)
+		assert.Equal(t, tc.script, hex.EncodeToString(script), tc.address)
+	}
+
+	invalid := []struct {
+		chain   Chain
+		address string
+	}{
+		{LTC, "1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu"},
+		{BTC, "1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggv"},
+		{BTC, "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7"},
+		{BTC, "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5"},
+		{BCH, "bchtest:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a"},
+		{BCH, "bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6b"},
+		{DOGE, "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"},
+	}
+	for _, tc := range invalid {
+		_, err := tc.chain.AddressScript(tc.address)
+		assert.Error(t, err, tc.address)
+	}
+
+	_, err := BTC.AddressScript("1BpEi6DfDAUFd7GtittLSdBeYJvcoaVgg0")
+	assert.EqualError(t, err, `invalid BTC address "1BpEi6DfDAUFd7GtittLSdBeYJvcoaVgg0": invalid base58 character '0'`)
+}
@@ -0,0 +1,354 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package utxo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+// MaxMemoSize is the largest memo THORChain reads from an OP_RETURN output
+const MaxMemoSize = 80
+
+const opReturn = 0x6A
+
+// MemoTooLongError the memo does not fit in an OP_RETURN output
+type MemoTooLongError struct {
+	Memo string
+	// Suggestion is the abbreviated form of the memo, see ledger.AbbreviateMemo
+	Suggestion string
+}
+
+func (e MemoTooLongError) Error() string {
+	if len(e.Suggestion) <= MaxMemoSize {
+		return fmt.Sprintf("the memo has %d bytes, more than the %d bytes of an OP_RETURN output: use the abbreviated form %q",
+			len(e.Memo), MaxMemoSize, e.Suggestion)
+	}
+	return fmt.Sprintf("the memo has %d bytes, more than the %d bytes of an OP_RETURN output, and %d bytes abbreviated",
+		len(e.Memo), MaxMemoSize, len(e.Suggestion))
+}
+
+// Derivation is the key of a script of the wallet. It lets the Bitcoin app find the inputs it
+// signs and recognize the change, so it is only accepted for BTC
+type Derivation struct {
+	// PubKey is the compressed public key
+	PubKey []byte
+	// Path is the path of the key. The first three elements are hardened
+	Path []uint32
+}
+
+// UTXO is an output of the wallet that can be spent
+type UTXO struct {
+	// TxID is the id of the transaction, as shown by explorers
+	TxID  string
+	Index uint32
+	Value int64
+	// Script is the output script. P2WPKH and P2PKH outputs are supported
+	Script []byte
+	// PrevTx is the serialized transaction of the output. It is required to spend P2PKH outputs,
+	// and the Bitcoin app warns about segwit inputs without it
+	PrevTx     []byte
+	Derivation *Derivation
+}
+
+// Swap is an inbound of a swap or liquidity operation, sent to the vault of the chain
+type Swap struct {
+	Chain Chain
+	UTXOs []UTXO
+	// Vault is the inbound address of the chain, as returned by /thorchain/inbound_addresses
+	Vault string
+	// Amount is in satoshis
+	Amount int64
+	// FeeRate is in satoshis per virtual byte
+	FeeRate int64
+	Memo    string
+	// ChangeAddress receives the change. THORChain refunds to the address of the first input,
+	// so the change usually goes back to it
+	ChangeAddress    string
+	ChangeDerivation *Derivation
+	// Fingerprint is the master key fingerprint of the derivations
+	Fingerprint []byte
+}
+
+// SwapTx is an unsigned inbound transaction
+type SwapTx struct {
+	PSBT *ledger.PSBT
+	// Inputs are the UTXOs spent, largest first
+	Inputs []UTXO
+	Fee    int64
+	// Change is zero when the change would be dust and is left to the fee
+	Change int64
+	// VSize is the estimated size of the signed transaction, in virtual bytes
+	VSize int64
+}
+
+// BuildSwap returns the transaction paying the vault with the memo in an OP_RETURN output.
+// The outputs are the vault, the memo and the change. UTXOs are spent largest first
+func BuildSwap(s Swap) (*SwapTx, error) {
+	if len(s.Memo) > MaxMemoSize {
+		return nil, &MemoTooLongError{Memo: s.Memo, Suggestion: ledger.AbbreviateMemo(s.Memo)}
+	}
+	if memo := ledger.ParseMemo(s.Memo); !memo.IsKnown() {
+		return nil, fmt.Errorf("unknown memo action %q", memo.Action)
+	}
+	if s.Amount < s.Chain.DustThreshold {
+		return nil, fmt.Errorf("the amount is below the %s dust threshold of %d", s.Chain.Name, s.Chain.DustThreshold)
+	}
+	if s.FeeRate <= 0 {
+		return nil, errors.New("the fee rate should be positive")
+	}
+	if s.hasDerivations() && !s.Chain.BitcoinApp() {
+		return nil, fmt.Errorf("derivations are only used by the Bitcoin app, which cannot sign %s transactions", s.Chain.Name)
+	}
+
+	vaultScript, err := s.Chain.AddressScript(s.Vault)
+	if err != nil {
+		return nil, err
+	}
+	changeScript, err := s.Chain.AddressScript(s.ChangeAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := []ledger.BitcoinTxOut{
+		{Value: s.Amount, Script: vaultScript},
+		{Value: 0, Script: memoScript(s.Memo)},
+	}
+	change := ledger.BitcoinTxOut{Script: changeScript}
+
+	utxos := append([]UTXO{}, s.UTXOs...)
+	sort.SliceStable(utxos, func(i, j int) bool {
+		return utxos[i].Value > utxos[j].Value
+	})
+
+	var inputs []UTXO
+	var weight, total int64
+	for _, utxo := range utxos {
+		w, err := inputWeight(s.Chain, utxo.Script)
+		if err != nil {
+			return nil, fmt.Errorf("input %s:%d: %w", utxo.TxID, utxo.Index, err)
+		}
+		inputs = append(inputs, utxo)
+		weight += w
+		total += utxo.Value
+
+		withChange := vsize(inputs, weight, append(outputs, change)) * s.FeeRate
+		if total-s.Amount-withChange >= s.Chain.DustLimit {
+			change.Value = total - s.Amount - withChange
+			return s.build(inputs, append(outputs, change), withChange, vsize(inputs, weight, append(outputs, change)))
+		}
+		size := vsize(inputs, weight, outputs)
+		if total-s.Amount-size*s.FeeRate >= 0 {
+			return s.build(inputs, outputs, total-s.Amount, size)
+		}
+	}
+
+	needed := s.Amount + vsize(inputs, weight, outputs)*s.FeeRate
+	return nil, fmt.Errorf("insufficient funds: %d available, %d needed", total, needed)
+}
+
+func (s Swap) build(inputs []UTXO, outputs []ledger.BitcoinTxOut, fee int64, size int64) (*SwapTx, error) {
+	sequence := uint32(0xFFFFFFFF)
+	if s.Chain.RBF {
+		sequence = 0xFFFFFFFD
+	}
+
+	tx := &ledger.BitcoinTx{Version: s.Chain.TxVersion, Outputs: outputs}
+	for _, utxo := range inputs {
+		hash, err := parseTxID(utxo.TxID)
+		if err != nil {
+			return nil, err
+		}
+		tx.Inputs = append(tx.Inputs, ledger.BitcoinTxIn{PrevTxHash: hash, PrevIndex: utxo.Index, ScriptSig: []byte{}, Sequence: sequence})
+	}
+
+	psbt, err := ledger.NewPSBT(tx)
+	if err != nil {
+		return nil, err
+	}
+	for i, utxo := range inputs {
+		if err := s.addInput(&psbt.Inputs[i], utxo, tx.Inputs[i].PrevTxHash); err != nil {
+			return nil, fmt.Errorf("input %s:%d: %w", utxo.TxID, utxo.Index, err)
+		}
+	}
+
+	swapTx := &SwapTx{PSBT: psbt, Inputs: inputs, Fee: fee, VSize: size}
+	if len(outputs) > 2 {
+		swapTx.Change = outputs[2].Value
+		if err := s.addDerivation(&psbt.Outputs[2], ledger.PSBTOutBIP32Derivation, s.ChangeDerivation); err != nil {
+			return nil, fmt.Errorf("change: %w", err)
+		}
+	}
+	return swapTx, nil
+}
+
+// hasDerivations returns true if the swap carries key origins for the Bitcoin app
+func (s Swap) hasDerivations() bool {
+	if s.Fingerprint != nil || s.ChangeDerivation != nil {
+		return true
+	}
+	for _, utxo := range s.UTXOs {
+		if utxo.Derivation != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// addInput adds the previous output and the derivation needed to sign an input
+func (s Swap) addInput(input *ledger.PSBTMap, utxo UTXO, hash [32]byte) error {
+	if isP2WPKH(utxo.Script) {
+		input.Set([]byte{ledger.PSBTInWitnessUTXO}, serializeTxOut(utxo.Value, utxo.Script))
+	} else if utxo.PrevTx == nil {
+		return errors.New("the previous transaction is required to spend a P2PKH output")
+	}
+
+	if utxo.PrevTx != nil {
+		prevTx, err := ledger.ParseBitcoinTx(utxo.PrevTx)
+		if err != nil {
+			return err
+		}
+		if prevTx.Hash() != hash {
+			return errors.New("the previous transaction does not match the txid")
+		}
+		if int(utxo.Index) >= len(prevTx.Outputs) {
+			return errors.New("the previous transaction does not have the output")
+		}
+		output := prevTx.Outputs[utxo.Index]
+		if output.Value != utxo.Value || !bytes.Equal(output.Script, utxo.Script) {
+			return errors.New("the output does not match the previous transaction")
+		}
+		input.Set([]byte{ledger.PSBTInNonWitnessUTXO}, utxo.PrevTx)
+	}
+
+	return s.addDerivation(input, ledger.PSBTInBIP32Derivation, utxo.Derivation)
+}
+
+// addDerivation adds a key origin: the fingerprint followed by the little endian path
+func (s Swap) addDerivation(m *ledger.PSBTMap, keyType byte, derivation *Derivation) error {
+	if derivation == nil {
+		return nil
+	}
+	if len(s.Fingerprint) != 4 {
+		return errors.New("the fingerprint should contain 4 bytes")
+	}
+	if len(derivation.PubKey) != 33 {
+		return errors.New("the derivation needs a compressed public key")
+	}
+
+	value := append([]byte{}, s.Fingerprint...)
+	for index, element := range derivation.Path {
+		if index < 3 {
+			element |= 0x80000000
+		}
+		value = append(value, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(value[len(value)-4:], element)
+	}
+	m.Set(append([]byte{keyType}, derivation.PubKey...), value)
+	return nil
+}
+
+// memoScript returns the OP_RETURN script of a memo of at most MaxMemoSize bytes
+func memoScript(memo string) []byte {
+	if len(memo) <= 75 {
+		return append([]byte{opReturn, byte(len(memo))}, memo...)
+	}
+	// OP_PUSHDATA1
+	return append([]byte{opReturn, 0x4C, byte(len(memo))}, memo...)
+}
+
+func isP2WPKH(script []byte) bool {
+	return len(script) == 22 && script[0] == 0x00 && script[1] == 0x14
+}
+
+func isP2PKH(script []byte) bool {
+	return len(script) == 25 && script[0] == 0x76 && script[1] == 0xA9 && script[2] == 0x14 && script[23] == 0x88 && script[24] == 0xAC
+}
+
+// inputWeight returns the weight of a signed input, with a 72 byte signature
+func inputWeight(chain Chain, script []byte) (int64, error) {
+	switch {
+	case isP2WPKH(script) && chain.Segwit():
+		// outpoint, empty script and sequence, then the witness with the signature and the key
+		return 41*4 + 1 + 73 + 34, nil
+	case isP2PKH(script):
+		// outpoint, script with the signature and the key, and sequence
+		return (36 + 1 + 107 + 4) * 4, nil
+	}
+	return 0, fmt.Errorf("unsupported %s output script %x", chain.Name, script)
+}
+
+// vsize returns the virtual size of the signed transaction
+func vsize(inputs []UTXO, inputsWeight int64, outputs []ledger.BitcoinTxOut) int64 {
+	weight := inputsWeight + 4*(4+4+compactSizeLen(len(inputs))+compactSizeLen(len(outputs)))
+	for _, output := range outputs {
+		weight += 4 * (8 + compactSizeLen(len(output.Script)) + int64(len(output.Script)))
+	}
+	segwit := false
+	for _, input := range inputs {
+		if isP2WPKH(input.Script) {
+			segwit = true
+			break
+		}
+	}
+	if segwit {
+		// segwit marker and flag, and the empty witness of every other input
+		weight += 2
+		for _, input := range inputs {
+			if !isP2WPKH(input.Script) {
+				weight++
+			}
+		}
+	}
+	return (weight + 3) / 4
+}
+
+func compactSizeLen(n int) int64 {
+	switch {
+	case n < 0xFD:
+		return 1
+	case n <= 0xFFFF:
+		return 3
+	}
+	return 5
+}
+
+func serializeTxOut(value int64, script []byte) []byte {
+	output := make([]byte, 8, 9+len(script))
+	binary.LittleEndian.PutUint64(output, uint64(value))
+	output = append(output, byte(len(script)))
+	return append(output, script...)
+}
+
+// parseTxID parses a txid shown by explorers, which reverse the hash
+func parseTxID(txID string) ([32]byte, error) {
+	var hash [32]byte
+	b, err := hex.DecodeString(txID)
+	if err != nil || len(b) != 32 {
+		return hash, fmt.Errorf("invalid txid %q", txID)
+	}
+	for i := range b {
+		hash[i] = b[31-i]
+	}
+	return hash, nil
+}
@@ -0,0 +1,290 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package utxo
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ledger "github.com/thorchain/ledger-thorchain-go"
+)
+
+const (
+	testVault  = "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"
+	testChange = "bc1qenxvenxvenxvenxvenxvenxvenxvenxvx46avd"
+	testMemo   = "=:ETH.ETH:0x1111111111111111111111111111111111111111"
+)
+
+var testFingerprint = []byte{0xF5, 0xAC, 0xC2, 0xFD}
+
+func testUTXO(value int64, n byte) UTXO {
+	return UTXO{
+		TxID:   strings.Repeat(hex.EncodeToString([]byte{n}), 32),
+		Index:  uint32(n),
+		Value:  value,
+		Script: append([]byte{0x00, 0x14}, bytes.Repeat([]byte{n}, 20)...),
+		Derivation: &Derivation{
+			PubKey: append([]byte{0x02}, bytes.Repeat([]byte{n}, 32)...),
+			Path:   []uint32{84, 0, 0, 0, uint32(n)},
+		},
+	}
+}
+
+func testSwap(amount int64, feeRate int64) Swap {
+	return Swap{
+		Chain:         BTC,
+		UTXOs:         []UTXO{testUTXO(5000, 1), testUTXO(200000, 2), testUTXO(30000, 3)},
+		Vault:         testVault,
+		Amount:        amount,
+		FeeRate:       feeRate,
+		Memo:          testMemo,
+		ChangeAddress: testChange,
+		ChangeDerivation: &Derivation{
+			PubKey: append([]byte{0x03}, bytes.Repeat([]byte{0xCC}, 32)...),
+			Path:   []uint32{84, 0, 0, 1, 0},
+		},
+		Fingerprint: testFingerprint,
+	}
+}
+
+func Test_BuildSwap(t *testing.T) {
+	swapTx, err := BuildSwap(testSwap(100000, 10))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	// 1 P2WPKH input, P2WPKH vault and change, 52 byte memo: 203.5 virtual bytes
+	assert.Equal(t, int64(204), swapTx.VSize)
+	assert.Equal(t, int64(2040), swapTx.Fee)
+	assert.Equal(t, int64(97960), swapTx.Change)
+	require.Len(t, swapTx.Inputs, 1)
+	assert.Equal(t, int64(200000), swapTx.Inputs[0].Value)
+
+	psbt, err := ledger.ParsePSBTBase64(swapTx.PSBT.Base64())
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	tx, err := psbt.UnsignedTx()
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, int32(2), tx.Version)
+	assert.Equal(t, [32]byte{0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02,
+		0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02}, tx.Inputs[0].PrevTxHash)
+	assert.Equal(t, uint32(2), tx.Inputs[0].PrevIndex)
+	assert.Equal(t, uint32(0xFFFFFFFD), tx.Inputs[0].Sequence)
+
+	require.Len(t, tx.Outputs, 3)
+	assert.Equal(t, int64(100000), tx.Outputs[0].Value)
+	assert.Equal(t, "0014751e76e8199196d454941c45d1b3a323f1433bd6", hex.EncodeToString(tx.Outputs[0].Script))
+	assert.Equal(t, int64(0), tx.Outputs[1].Value)
+	assert.Equal(t, append([]byte{0x6A, byte(len(testMemo))}, testMemo...), tx.Outputs[1].Script)
+	assert.Equal(t, int64(97960), tx.Outputs[2].Value)
+
+	utxo, ok := psbt.Inputs[0].Get([]byte{ledger.PSBTInWitnessUTXO})
+	require.True(t, ok)
+	assert.Equal(t, "400d0300000000001600140202020202020202020202020202020202020202", hex.EncodeToString(utxo))
+	derivation, ok := psbt.Inputs[0].Get(append([]byte{ledger.PSBTInBIP32Derivation}, swapTx.Inputs[0].Derivation.PubKey...))
+	require.True(t, ok)
+	assert.Equal(t, "f5acc2fd54000080000000800000008000000000"+"02000000", hex.EncodeToString(derivation))
+	_, ok = psbt.Outputs[2].Get(append([]byte{ledger.PSBTOutBIP32Derivation, 0x03}, bytes.Repeat([]byte{0xCC}, 32)...))
+	assert.True(t, ok)
+	assert.Empty(t, psbt.Outputs[0])
+}
+
+func Test_BuildSwapInputs(t *testing.T) {
+	// The largest UTXO is not enough: 2 inputs, 271.5 virtual bytes
+	swapTx, err := BuildSwap(testSwap(220000, 10))
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	require.Len(t, swapTx.Inputs, 2)
+	assert.Equal(t, int64(200000), swapTx.Inputs[0].Value)
+	assert.Equal(t, int64(30000), swapTx.Inputs[1].Value)
+	assert.Equal(t, int64(2720), swapTx.Fee)
+	assert.Equal(t, int64(7280), swapTx.Change)
+
+	_, err = BuildSwap(testSwap(235000, 10))
+	assert.EqualError(t, err, "insufficient funds: 235000 available, 238090 needed")
+}
+
+func Test_BuildSwapDustChange(t *testing.T) {
+	s := testSwap(100000, 45)
+	s.UTXOs = []UTXO{testUTXO(110000, 1)}
+	swapTx, err := BuildSwap(s)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, int64(820), swapTx.Change)
+	assert.Len(t, swapTx.PSBT.Outputs, 3)
+
+	// The change would be 412, below the dust limit: it is left to the fee
+	s.FeeRate = 47
+	swapTx, err = BuildSwap(s)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Zero(t, swapTx.Change)
+	assert.Equal(t, int64(10000), swapTx.Fee)
+	assert.Equal(t, int64(173), swapTx.VSize)
+	assert.Len(t, swapTx.PSBT.Outputs, 2)
+}
+
+func Test_BuildSwapMemo(t *testing.T) {
+	s := testSwap(100000, 10)
+	s.Memo = "SWAP:ETH.ETH:0x1111111111111111111111111111111111111111:1500000000/3/0:thor1affiliate:50"
+	_, err := BuildSwap(s)
+	var memoErr *MemoTooLongError
+	require.True(t, errors.As(err, &memoErr))
+	assert.Equal(t, "=:e:0x1111111111111111111111111111111111111111:15e8/3/0:thor1affiliate:50", memoErr.Suggestion)
+	assert.EqualError(t, err, `the memo has 88 bytes, more than the 80 bytes of an OP_RETURN output: use the abbreviated form "`+memoErr.Suggestion+`"`)
+
+	s.Memo = "=:ETH.ETH:0x1111111111111111111111111111111111111111:0/3/0:" + strings.Repeat("x", 40)
+	_, err = BuildSwap(s)
+	assert.EqualError(t, err, "the memo has 99 bytes, more than the 80 bytes of an OP_RETURN output, and 93 bytes abbreviated")
+
+	// The abbreviated memo is accepted, it is pushed with OP_PUSHDATA1 above 75 bytes
+	s.Memo = "=:e:0x1111111111111111111111111111111111111111:15e8/3/0:thor1affiliate:50:extra"
+	swapTx, err := BuildSwap(s)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	tx, err := swapTx.PSBT.UnsignedTx()
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, []byte{0x6A, 0x4C, 79}, tx.Outputs[1].Script[:3])
+
+	s.Memo = "hello"
+	_, err = BuildSwap(s)
+	assert.EqualError(t, err, `unknown memo action "HELLO"`)
+}
+
+func Test_BuildSwapErrors(t *testing.T) {
+	s := testSwap(9999, 10)
+	_, err := BuildSwap(s)
+	assert.EqualError(t, err, "the amount is below the BTC dust threshold of 10000")
+
+	s = testSwap(100000, 0)
+	_, err = BuildSwap(s)
+	assert.EqualError(t, err, "the fee rate should be positive")
+
+	s = testSwap(100000, 10)
+	s.Vault = "ltc1qw508d6qejxtdg4y5r3zarvary0c5xw7kgmn4n9"
+	_, err = BuildSwap(s)
+	assert.Error(t, err)
+
+	// P2PKH outputs need the previous transaction
+	s = testSwap(100000, 10)
+	s.UTXOs[1].Script = p2pkhScript(bytes.Repeat([]byte{0x02}, 20))
+	_, err = BuildSwap(s)
+	assert.EqualError(t, err, "input 0202020202020202020202020202020202020202020202020202020202020202:2: the previous transaction is required to spend a P2PKH output")
+
+	s = testSwap(100000, 10)
+	s.UTXOs[1].Script = []byte{0x51, 0x20}
+	_, err = BuildSwap(s)
+	assert.EqualError(t, err, "input 0202020202020202020202020202020202020202020202020202020202020202:2: unsupported BTC output script 5120")
+
+	s = testSwap(100000, 10)
+	s.Fingerprint = nil
+	_, err = BuildSwap(s)
+	assert.EqualError(t, err, "input 0202020202020202020202020202020202020202020202020202020202020202:2: the fingerprint should contain 4 bytes")
+
+	// The Bitcoin app cannot sign the other chains
+	s = testSwap(100000, 10)
+	s.Chain = LTC
+	_, err = BuildSwap(s)
+	assert.EqualError(t, err, "derivations are only used by the Bitcoin app, which cannot sign LTC transactions")
+}
+
+func Test_BuildSwapMixedInputs(t *testing.T) {
+	script := p2pkhScript(bytes.Repeat([]byte{0x44}, 20))
+	s := testSwap(380000, 10)
+	s.UTXOs = []UTXO{testUTXO(100000, 1)}
+	for i := int64(0); i < 3; i++ {
+		prevTx := &ledger.BitcoinTx{
+			Version: 1,
+			Inputs:  []ledger.BitcoinTxIn{{PrevIndex: 0, ScriptSig: []byte{}, Sequence: 0xFFFFFFFF}},
+			Outputs: []ledger.BitcoinTxOut{{Value: 100001 + i, Script: script}},
+		}
+		hash := prevTx.Hash()
+		txID := make([]byte, 32)
+		for j := range hash {
+			txID[j] = hash[31-j]
+		}
+		s.UTXOs = append(s.UTXOs, UTXO{TxID: hex.EncodeToString(txID), Value: 100001 + i, Script: script, PrevTx: prevTx.Serialize()})
+	}
+
+	swapTx, err := BuildSwap(s)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	require.Len(t, swapTx.Inputs, 4)
+	// 3 P2PKH inputs with an empty witness each and 1 P2WPKH input: 2593 weight units
+	assert.Equal(t, int64(649), swapTx.VSize)
+	assert.Equal(t, int64(6490), swapTx.Fee)
+	assert.Equal(t, int64(400006-380000-6490), swapTx.Change)
+}
+
+func Test_BuildSwapDOGE(t *testing.T) {
+	script := p2pkhScript(bytes.Repeat([]byte{0x44}, 20))
+	prevTx := &ledger.BitcoinTx{
+		Version: 1,
+		Inputs:  []ledger.BitcoinTxIn{{PrevIndex: 0, ScriptSig: []byte{}, Sequence: 0xFFFFFFFF}},
+		Outputs: []ledger.BitcoinTxOut{{Value: 1000, Script: script}, {Value: 500000000, Script: script}},
+	}
+	hash := prevTx.Hash()
+	txID := make([]byte, 32)
+	for i := range hash {
+		txID[i] = hash[31-i]
+	}
+
+	address := base58CheckEncode(append([]byte{0x1E}, bytes.Repeat([]byte{0x44}, 20)...))
+	s := Swap{
+		Chain:         DOGE,
+		UTXOs:         []UTXO{{TxID: hex.EncodeToString(txID), Index: 1, Value: 500000000, Script: script, PrevTx: prevTx.Serialize()}},
+		Vault:         base58CheckEncode(append([]byte{0x16}, bytes.Repeat([]byte{0x55}, 20)...)),
+		Amount:        200000000,
+		FeeRate:       1000,
+		Memo:          "=:b:bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",
+		ChangeAddress: address,
+	}
+
+	swapTx, err := BuildSwap(s)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	// 148 byte input, P2SH vault, 46 byte memo, P2PKH change and 10 bytes of header
+	assert.Equal(t, int64(148+32+57+34+10), swapTx.VSize)
+	assert.Equal(t, int64(500000000-200000000-swapTx.Fee), swapTx.Change)
+
+	tx, err := swapTx.PSBT.UnsignedTx()
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, int32(1), tx.Version)
+	assert.Equal(t, uint32(0xFFFFFFFF), tx.Inputs[0].Sequence)
+	assert.Equal(t, byte(0xA9), tx.Outputs[0].Script[0])
+	value, ok := swapTx.PSBT.Inputs[0].Get([]byte{ledger.PSBTInNonWitnessUTXO})
+	require.True(t, ok)
+	assert.Equal(t, prevTx.Serialize(), value)
+	_, ok = swapTx.PSBT.Inputs[0].Get([]byte{ledger.PSBTInWitnessUTXO})
+	assert.False(t, ok)
+
+	s.UTXOs[0].Value = 400000000
+	_, err = BuildSwap(s)
+	assert.EqualError(t, err, "input "+hex.EncodeToString(txID)+":1: the output does not match the previous transaction")
+
+	s.UTXOs[0].Value = 500000000
+	s.UTXOs[0].TxID = strings.Repeat("00", 32)
+	_, err = BuildSwap(s)
+	assert.EqualError(t, err, "input "+strings.Repeat("00", 32)+":1: the previous transaction does not match the txid")
+}
+
+func Test_ParseTxID(t *testing.T) {
+	hash, err := parseTxID("4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b")
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, byte(0x3b), hash[0])
+	assert.Equal(t, byte(0x4a), hash[31])
+
+	_, err = parseTxID("4a5e")
+	assert.EqualError(t, err, `invalid txid "4a5e"`)
+}
@@ -17,8 +17,12 @@
 package ledger_cosmos_go
 
 import (
+	"crypto/ed25519"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"math"
+	"sync"
 
 	"github.com/zondax/ledger-go"
 )
@@ -33,18 +37,32 @@ const (
 	validatorMessageChunkSize = 250
 )
 
+// validatorProbePath is the path used by Negotiate to find the protocol of the app
+var validatorProbePath = []uint32{44, 118, 0, 0, 0}
+
 // Validator app
 type LedgerTendermintValidator struct {
 	// Add support for this app
 	api ledger_go.LedgerDevice
+
+	// caps is set by Negotiate and selects the protocol of the following commands
+	caps Capabilities
+
+	audit   AuditRecorder
+	metrics Metrics
+
+	// pubKeys caches the public keys reported in audit records, by path
+	pubKeysMtx sync.Mutex
+	pubKeys    map[string][]byte
 }
 
 // RequiredCosmosUserAppVersion indicates the minimum required version of the Tendermint app
 func RequiredTendermintValidatorAppVersion() VersionInfo {
-	return VersionInfo{0, 0, 5, 0}
+	return validatorAppMinimumVersions[0]
 }
 
 // FindLedgerCosmosValidatorApp finds a Cosmos validator app running in a ledger device
+// and negotiates the protocol version
 func FindLedgerTendermintValidatorApp() (_ *LedgerTendermintValidator, rerr error) {
 	ledgerAdmin := ledger_go.NewLedgerAdmin()
 	ledgerAPI, err := ledgerAdmin.Connect(0)
@@ -58,21 +76,15 @@ func FindLedgerTendermintValidatorApp() (_ *LedgerTendermintValidator, rerr erro
 		}
 	}()
 
-	ledgerCosmosValidatorApp := &LedgerTendermintValidator{ledgerAPI}
-	appVersion, err := ledgerCosmosValidatorApp.GetVersion()
-	if err != nil {
+	ledgerCosmosValidatorApp := &LedgerTendermintValidator{api: ledgerAPI}
+	if _, err := ledgerCosmosValidatorApp.Negotiate(); err != nil {
 		if err.Error() == "[APDU_CODE_CLA_NOT_SUPPORTED] Class not supported" {
 			err = errors.New("are you sure the Tendermint Validator app is open?")
 		}
 		return nil, err
 	}
 
-	req := RequiredTendermintValidatorAppVersion()
-	if err := CheckVersion(*appVersion, req); err != nil {
-		return nil, err
-	}
-
-	return ledgerCosmosValidatorApp, err
+	return ledgerCosmosValidatorApp, nil
 }
 
 // Close closes a connection with the Cosmos user app
@@ -80,6 +92,63 @@ func (ledger *LedgerTendermintValidator) Close() error {
 	return ledger.api.Close()
 }
 
+// Negotiate reads the version of the app and asks it which protocol it speaks, which
+// selects the protocol used by the following commands. It returns an error if the version is not supported
+func (ledger *LedgerTendermintValidator) Negotiate() (Capabilities, error) {
+	version, err := ledger.GetVersion()
+	if err != nil {
+		return Capabilities{}, err
+	}
+	if _, err := ValidatorAppCapabilities(*version, FramingPacketCount); err != nil {
+		return Capabilities{}, err
+	}
+
+	framing, err := ledger.probeFraming()
+	if err != nil {
+		return Capabilities{}, err
+	}
+
+	caps, err := ValidatorAppCapabilities(*version, framing)
+	if err != nil {
+		return Capabilities{}, err
+	}
+
+	ledger.caps = caps
+	return caps, nil
+}
+
+// probeFraming requests the public key of validatorProbePath with the fixed 20 byte
+// path of the payload descriptor protocol. Apps speaking the packet count protocol
+// expect a length prefixed path and reject it, so any status word other than OK
+// selects the packet count framing. Only errors without a status word, which do
+// not come from the app, are returned
+func (ledger *LedgerTendermintValidator) probeFraming() (PayloadFraming, error) {
+	pathBytes, err := GetBip32bytesv2(validatorProbePath, 5)
+	if err != nil {
+		return 0, err
+	}
+
+	header := []byte{validatorCLA, validatorINSPublicKeyED25519, 0, 0, byte(len(pathBytes))}
+	_, err = ledger.api.Exchange(append(header, pathBytes...))
+
+	switch StatusWord(err) {
+	case StatusWordOK:
+		return FramingPayloadDescriptor, nil
+	case StatusWordNone:
+		return 0, err
+	default:
+		return FramingPacketCount, nil
+	}
+}
+
+// Capabilities returns the protocol selected by Negotiate
+func (ledger *LedgerTendermintValidator) Capabilities() (Capabilities, error) {
+	if ledger.caps.Framing == 0 {
+		return Capabilities{}, errors.New("validator app protocol has not been negotiated")
+	}
+	return ledger.caps, nil
+}
+
 // GetVersion returns the current version of the Cosmos user app
 func (ledger *LedgerTendermintValidator) GetVersion() (*VersionInfo, error) {
 	message := []byte{validatorCLA, validatorINSGetVersion, 0, 0, 0}
@@ -103,7 +172,7 @@ func (ledger *LedgerTendermintValidator) GetVersion() (*VersionInfo, error) {
 
 // GetPublicKeyED25519 retrieves the public key for the corresponding bip32 derivation path
 func (ledger *LedgerTendermintValidator) GetPublicKeyED25519(bip32Path []uint32) ([]byte, error) {
-	pathBytes, err := GetBip32bytesv1(bip32Path, 10)
+	pathBytes, err := ledger.getBip32bytes(bip32Path)
 	if err != nil {
 		return nil, err
 	}
@@ -117,15 +186,118 @@ func (ledger *LedgerTendermintValidator) GetPublicKeyED25519(bip32Path []uint32)
 		return nil, err
 	}
 
-	if len(response) < 4 {
-		return nil, errors.New("invalid response. Too short")
+	if len(response) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid response: expected a %d byte public key, got %d bytes", ed25519.PublicKeySize, len(response))
 	}
 
 	return response, nil
 }
 
+// SetAuditLog records every following sign request in recorder. Nil disables the audit
+func (ledger *LedgerTendermintValidator) SetAuditLog(recorder AuditRecorder) {
+	ledger.audit = recorder
+}
+
+// SetMetrics reports the following device operations to metrics. Nil disables the metrics
+func (ledger *LedgerTendermintValidator) SetMetrics(metrics Metrics) {
+	ledger.metrics = metrics
+	ledger.api = meterDevice(ledger.api, metrics)
+}
+
 // SignSECP256K1 signs a message/vote using the Tendermint validator app
 func (ledger *LedgerTendermintValidator) SignED25519(bip32Path []uint32, message []byte) ([]byte, error) {
+	signature, err := ledger.signED25519(bip32Path, message)
+	observeSign(ledger.metrics, AuditAppValidator, message, err)
+	if ledger.audit == nil {
+		return signature, err
+	}
+
+	// Every level of a validator path is hardened
+	record := AuditRecord{
+		App:      AuditAppValidator,
+		Path:     FormatBip32Path(bip32Path, len(bip32Path)),
+		SignMode: "ed25519",
+	}
+	if pubKey, err := ledger.auditPubKey(record.Path, bip32Path); err == nil {
+		record.PubKey = hex.EncodeToString(pubKey)
+	}
+	return auditSign(ledger.audit, record, message, signature, err)
+}
+
+// auditPubKey returns the public key of a path, asking the device only the first time
+func (ledger *LedgerTendermintValidator) auditPubKey(key string, bip32Path []uint32) ([]byte, error) {
+	ledger.pubKeysMtx.Lock()
+	defer ledger.pubKeysMtx.Unlock()
+
+	if pubKey, ok := ledger.pubKeys[key]; ok {
+		return pubKey, nil
+	}
+	pubKey, err := ledger.GetPublicKeyED25519(bip32Path)
+	if err != nil {
+		return nil, err
+	}
+	if ledger.pubKeys == nil {
+		ledger.pubKeys = map[string][]byte{}
+	}
+	ledger.pubKeys[key] = pubKey
+	return pubKey, nil
+}
+
+func (ledger *LedgerTendermintValidator) signED25519(bip32Path []uint32, message []byte) ([]byte, error) {
+	caps, err := ledger.Capabilities()
+	if err != nil {
+		return nil, err
+	}
+
+	switch caps.Framing {
+	case FramingPacketCount:
+		return ledger.signv1(bip32Path, message)
+	default:
+		return ledger.signv2(bip32Path, message)
+	}
+}
+
+// getBip32bytes encodes a path for the negotiated protocol. Every level is hardened
+func (ledger *LedgerTendermintValidator) getBip32bytes(bip32Path []uint32) ([]byte, error) {
+	caps, err := ledger.Capabilities()
+	if err != nil {
+		return nil, err
+	}
+	if err := caps.CheckPath(bip32Path); err != nil {
+		return nil, err
+	}
+
+	switch caps.Framing {
+	case FramingPacketCount:
+		return GetBip32bytesv1(bip32Path, 10)
+	default:
+		return GetBip32bytesv2(bip32Path, 5)
+	}
+}
+
+// signv1 sends the chunks with P1=packetIndex and P2=packetCount
+func (ledger *LedgerTendermintValidator) signv1(bip32Path []uint32, message []byte) ([]byte, error) {
+	return ledger.signChunks(bip32Path, message, func(packetIndex, packetCount byte) (byte, byte) {
+		return packetIndex, packetCount
+	})
+}
+
+// signv2 sends the chunks with P1=init/add/last
+func (ledger *LedgerTendermintValidator) signv2(bip32Path []uint32, message []byte) ([]byte, error) {
+	return ledger.signChunks(bip32Path, message, func(packetIndex, packetCount byte) (byte, byte) {
+		switch {
+		case packetIndex == 1:
+			return payloadInit, 0
+		case packetIndex == packetCount:
+			return payloadLast, 0
+		default:
+			return payloadAdd, 0
+		}
+	})
+}
+
+// signChunks sends the path and then the message in chunks, with the P1 and P2 given by params
+func (ledger *LedgerTendermintValidator) signChunks(bip32Path []uint32, message []byte, params func(packetIndex, packetCount byte) (byte, byte)) ([]byte, error) {
 	var packetIndex byte = 1
 	var packetCount = 1 + byte(math.Ceil(float64(len(message))/float64(validatorMessageChunkSize)))
 
@@ -134,17 +306,18 @@ func (ledger *LedgerTendermintValidator) SignED25519(bip32Path []uint32, message
 	var apduMessage []byte
 
 	for packetIndex <= packetCount {
+		p1, p2 := params(packetIndex, packetCount)
 		chunk := validatorMessageChunkSize
 		if packetIndex == 1 {
-			pathBytes, err := GetBip32bytesv1(bip32Path, 10)
+			pathBytes, err := ledger.getBip32bytes(bip32Path)
 			if err != nil {
 				return nil, err
 			}
 			header := []byte{
 				validatorCLA,
 				validatorINSSignED25519,
-				packetIndex,
-				packetCount,
+				p1,
+				p2,
 				byte(len(pathBytes))}
 
 			apduMessage = append(header, pathBytes...)
@@ -155,8 +328,8 @@ func (ledger *LedgerTendermintValidator) SignED25519(bip32Path []uint32, message
 			header := []byte{
 				validatorCLA,
 				validatorINSSignED25519,
-				packetIndex,
-				packetCount,
+				p1,
+				p2,
 				byte(chunk)}
 
 			apduMessage = append(header, message[:chunk]...)
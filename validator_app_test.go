@@ -17,9 +17,13 @@
 package ledger_cosmos_go
 
 import (
+	"crypto/ed25519"
+	"errors"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"testing"
+	"time"
 )
 
 func Test_ValGetVersion(t *testing.T) {
@@ -60,5 +64,131 @@ func Test_ValGetPublicKey(t *testing.T) {
 }
 
 func Test_ValSignED25519(t *testing.T) {
-	t.Skip("Go support is still not available. Please refer to the Rust library")
+	validatorApp, err := FindLedgerTendermintValidatorApp()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer validatorApp.Close()
+
+	path := []uint32{44, 118, 0, 0, 0}
+
+	vote := Vote{
+		Type:      PrevoteType,
+		Height:    time.Now().Unix(),
+		Round:     0,
+		Timestamp: time.Now(),
+	}
+
+	signature, err := validatorApp.SignVote(path, "thorchain-1", vote)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	assert.Equal(
+		t,
+		64,
+		len(signature),
+		"Signature has wrong length: %x, expected length: %x\n", signature, 64)
+}
+
+func Test_ValNegotiate(t *testing.T) {
+	device, _ := newMockValidator(1)
+	validatorApp := &LedgerTendermintValidator{api: device}
+
+	// Nothing is sent before the protocol is negotiated
+	_, err := validatorApp.GetPublicKeyED25519([]uint32{44, 118, 0, 0, 0})
+	assert.Error(t, err)
+	_, err = validatorApp.SignED25519([]uint32{44, 118, 0, 0, 0}, []byte{1})
+	assert.Error(t, err)
+	assert.Empty(t, device.sent)
+
+	caps, err := validatorApp.Negotiate()
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, VersionInfo{0, 0, 9, 0}, caps.Version)
+
+	assert.Equal(t, FramingPacketCount, caps.Framing)
+
+	device.reply(validatorCLA, validatorINSGetVersion, []byte{0, 2, 0, 0}, nil)
+	device.handler = nil
+	_, err = validatorApp.Negotiate()
+	assert.Error(t, err)
+}
+
+func Test_ValNegotiate_Framing(t *testing.T) {
+	// The framing is asked to the app, whatever its version
+	device, _ := newMockValidatorVersion(1, VersionInfo{0, 1, 0, 0}, FramingPacketCount)
+	caps, err := (&LedgerTendermintValidator{api: device}).Negotiate()
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, FramingPacketCount, caps.Framing)
+
+	device, _ = newMockValidatorVersion(1, VersionInfo{0, 0, 9, 0}, FramingPayloadDescriptor)
+	caps, err = (&LedgerTendermintValidator{api: device}).Negotiate()
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, FramingPayloadDescriptor, caps.Framing)
+	assert.Equal(t, []byte{validatorCLA, validatorINSPublicKeyED25519, 0, 0, 20}, device.sent[1][:5])
+
+	// Any status word refusing the probe selects the packet count framing
+	for _, probeErr := range []error{
+		errors.New("[APDU_CODE_COMMAND_NOT_ALLOWED] Command not allowed / User Rejected (no current EF)"),
+		errors.New("Error code: 6f01"),
+	} {
+		device, _ = newMockValidatorVersion(1, VersionInfo{0, 0, 9, 0}, FramingPacketCount)
+		handler := device.handler
+		device.handler = func(command []byte) ([]byte, error) {
+			if command[1] == validatorINSPublicKeyED25519 {
+				return nil, probeErr
+			}
+			return handler(command)
+		}
+		caps, err = (&LedgerTendermintValidator{api: device}).Negotiate()
+		require.Nil(t, err, "Detected error, err: %s\n", err)
+		assert.Equal(t, FramingPacketCount, caps.Framing)
+	}
+
+	// Errors that do not come from the app are returned
+	device, _ = newMockValidatorVersion(1, VersionInfo{0, 0, 9, 0}, FramingPacketCount)
+	handler := device.handler
+	device.handler = func(command []byte) ([]byte, error) {
+		if command[1] == validatorINSPublicKeyED25519 {
+			return nil, errors.New("hidapi: failed to write")
+		}
+		return handler(command)
+	}
+	_, err = (&LedgerTendermintValidator{api: device}).Negotiate()
+	assert.Error(t, err)
+}
+
+func Test_ValSignED25519_PayloadDescriptor(t *testing.T) {
+	device, key := newMockValidatorVersion(1, VersionInfo{0, 1, 0, 0}, FramingPayloadDescriptor)
+	validatorApp := newNegotiatedValidator(t, device)
+	device.sent = nil
+
+	message := make([]byte, 300)
+	signature, err := validatorApp.SignED25519([]uint32{44, 118, 0, 0, 0}, message)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Nil(t, VerifyED25519(key.Public().(ed25519.PublicKey), message, signature))
+
+	require.Len(t, device.sent, 3)
+	assert.Equal(t, []byte{validatorCLA, validatorINSSignED25519, payloadInit, 0, 20}, device.sent[0][:5])
+	assert.Equal(t, []byte{0x2c, 0, 0, 0x80}, device.sent[0][5:9])
+	assert.Equal(t, []byte{0, 0, 0, 0x80}, device.sent[0][21:25], "every level should be hardened")
+	assert.Equal(t, []byte{validatorCLA, validatorINSSignED25519, payloadAdd, 0, 250}, device.sent[1][:5])
+	assert.Equal(t, []byte{validatorCLA, validatorINSSignED25519, payloadLast, 0, 50}, device.sent[2][:5])
+
+	_, err = validatorApp.SignED25519([]uint32{44, 118, 0}, message)
+	assert.Error(t, err)
+}
+
+func Test_ValSignED25519_PacketCount(t *testing.T) {
+	device, key := newMockValidatorVersion(1, VersionInfo{0, 0, 9, 0}, FramingPacketCount)
+	validatorApp := newNegotiatedValidator(t, device)
+	device.sent = nil
+
+	message := make([]byte, 300)
+	signature, err := validatorApp.SignED25519([]uint32{44, 118, 0, 0, 0}, message)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Nil(t, VerifyED25519(key.Public().(ed25519.PublicKey), message, signature))
+
+	require.Len(t, device.sent, 3)
+	assert.Equal(t, []byte{validatorCLA, validatorINSSignED25519, 1, 3, 41}, device.sent[0][:5])
+	assert.Equal(t, []byte{validatorCLA, validatorINSSignED25519, 2, 3, 250}, device.sent[1][:5])
+	assert.Equal(t, []byte{validatorCLA, validatorINSSignED25519, 3, 3, 50}, device.sent[2][:5])
 }
@@ -0,0 +1,118 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// Bech32 prefixes of THORChain validator consensus keys
+const (
+	ConsensusAddressPrefix = "thorvalcons"
+	ConsensusPubKeyPrefix  = "thorcpub"
+
+	AminoPubKeyEd25519Type = "tendermint/PubKeyEd25519"
+
+	consensusAddressSize = 20
+)
+
+// aminoPubKeyEd25519Prefix is the amino type prefix of PubKeyEd25519 followed by the length of the key
+var aminoPubKeyEd25519Prefix = []byte{0x16, 0x24, 0xDE, 0x64, 0x20}
+
+// ConsensusPubKey is the ed25519 public key returned by the validator app
+type ConsensusPubKey []byte
+
+// NewConsensusPubKey checks the length of an ed25519 public key
+func NewConsensusPubKey(pubKey []byte) (ConsensusPubKey, error) {
+	if len(pubKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid ed25519 public key length %d", len(pubKey))
+	}
+	return ConsensusPubKey(pubKey), nil
+}
+
+// Address returns the consensus address: the first 20 bytes of the SHA-256 of the key
+func (k ConsensusPubKey) Address() []byte {
+	hash := sha256.Sum256(k)
+	return hash[:consensusAddressSize]
+}
+
+// Bech32Address returns the consensus address as a thorvalcons string
+func (k ConsensusPubKey) Bech32Address() (string, error) {
+	return k.Bech32AddressWithPrefix(ConsensusAddressPrefix)
+}
+
+// Bech32AddressWithPrefix returns the consensus address with the prefix of another network
+func (k ConsensusPubKey) Bech32AddressWithPrefix(prefix string) (string, error) {
+	return Bech32Encode(prefix, k.Address())
+}
+
+// Bech32PubKey returns the amino encoded key as a thorcpub string
+func (k ConsensusPubKey) Bech32PubKey() (string, error) {
+	return k.Bech32PubKeyWithPrefix(ConsensusPubKeyPrefix)
+}
+
+// Bech32PubKeyWithPrefix returns the amino encoded key with the prefix of another network
+func (k ConsensusPubKey) Bech32PubKeyWithPrefix(prefix string) (string, error) {
+	return Bech32Encode(prefix, append(append([]byte{}, aminoPubKeyEd25519Prefix...), k...))
+}
+
+// AminoPubKey is the amino JSON representation of a public key
+type AminoPubKey struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// AminoJSON returns {"type":"tendermint/PubKeyEd25519","value":"<base64>"}
+func (k ConsensusPubKey) AminoJSON() ([]byte, error) {
+	return json.Marshal(k.AminoPubKey())
+}
+
+// AminoPubKey returns the key in its amino JSON representation
+func (k ConsensusPubKey) AminoPubKey() AminoPubKey {
+	return AminoPubKey{
+		Type:  AminoPubKeyEd25519Type,
+		Value: base64.StdEncoding.EncodeToString(k),
+	}
+}
+
+// PrivValidatorPubKey is the public part of a priv_validator_key.json file.
+// The private key never leaves the device so priv_key is not included
+type PrivValidatorPubKey struct {
+	Address HexBytes    `json:"address"`
+	PubKey  AminoPubKey `json:"pub_key"`
+}
+
+// PrivValidatorPubKey returns the address and pub_key block of priv_validator_key.json
+func (k ConsensusPubKey) PrivValidatorPubKey() PrivValidatorPubKey {
+	return PrivValidatorPubKey{
+		Address: k.Address(),
+		PubKey:  k.AminoPubKey(),
+	}
+}
+
+// GetConsensusPubKey retrieves the ed25519 public key of a path as a ConsensusPubKey
+func (ledger *LedgerTendermintValidator) GetConsensusPubKey(bip32Path []uint32) (ConsensusPubKey, error) {
+	pubKey, err := ledger.GetPublicKeyED25519(bip32Path)
+	if err != nil {
+		return nil, err
+	}
+	return NewConsensusPubKey(pubKey)
+}
@@ -0,0 +1,85 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testConsensusPubKey() []byte {
+	pubKey := make([]byte, 32)
+	for i := range pubKey {
+		pubKey[i] = byte(i + 1)
+	}
+	return pubKey
+}
+
+func Test_ConsensusPubKey(t *testing.T) {
+	pubKey, err := NewConsensusPubKey(testConsensusPubKey())
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+
+	assert.Equal(t, "AE216C2EF5247A3782C135EFA279A3E4CDC61094", fmt.Sprintf("%X", pubKey.Address()))
+
+	address, err := pubKey.Bech32Address()
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, "thorvalcons14cskcth4y3ar0qkpxhh6y7drunxuvyy5xxj70t", address)
+
+	bech32PubKey, err := pubKey.Bech32PubKey()
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, "thorcpub1zcjduepqqypqxpq9qcrsszg2pvxq6rs0zqg3yyc5z5tpwxqergd3c8g7rusq4uz42r", bech32PubKey)
+
+	_, err = NewConsensusPubKey(testConsensusPubKey()[:31])
+	assert.Error(t, err)
+}
+
+func Test_ConsensusPubKey_JSON(t *testing.T) {
+	pubKey := ConsensusPubKey(testConsensusPubKey())
+
+	aminoJSON, err := pubKey.AminoJSON()
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t,
+		`{"type":"tendermint/PubKeyEd25519","value":"AQIDBAUGBwgJCgsMDQ4PEBESExQVFhcYGRobHB0eHyA="}`,
+		string(aminoJSON))
+
+	privValidatorKey, err := json.Marshal(pubKey.PrivValidatorPubKey())
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t,
+		`{"address":"AE216C2EF5247A3782C135EFA279A3E4CDC61094",`+
+			`"pub_key":{"type":"tendermint/PubKeyEd25519","value":"AQIDBAUGBwgJCgsMDQ4PEBESExQVFhcYGRobHB0eHyA="}}`,
+		string(privValidatorKey))
+}
+
+func Test_GetConsensusPubKey(t *testing.T) {
+	device, key := newMockValidator(1)
+	validatorApp := newNegotiatedValidator(t, device)
+
+	pubKey, err := validatorApp.GetConsensusPubKey([]uint32{44, 118, 0, 0, 0})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Equal(t, ConsensusPubKey(key.Public().(ed25519.PublicKey)), pubKey)
+
+	// The app must return exactly 32 bytes
+	device.handler = nil
+	device.reply(validatorCLA, validatorINSPublicKeyED25519, make([]byte, 33), nil)
+	_, err = validatorApp.GetConsensusPubKey([]uint32{44, 118, 0, 0, 0})
+	assert.Error(t, err)
+}
@@ -0,0 +1,335 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 ZondaX AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SignedMsgType is the type of a CometBFT consensus message
+type SignedMsgType byte
+
+const (
+	PrevoteType   SignedMsgType = 1
+	PrecommitType SignedMsgType = 2
+	ProposalType  SignedMsgType = 32
+)
+
+// PartSetHeader identifies the parts of a block
+type PartSetHeader struct {
+	Total uint32
+	Hash  []byte
+}
+
+// BlockID identifies a block. A zero BlockID is a vote for nil
+type BlockID struct {
+	Hash          []byte
+	PartSetHeader PartSetHeader
+}
+
+// IsZero returns true for the nil block id
+func (b BlockID) IsZero() bool {
+	return len(b.Hash) == 0 && b.PartSetHeader.Total == 0 && len(b.PartSetHeader.Hash) == 0
+}
+
+// Vote contains the fields of a CometBFT vote that are covered by the signature
+type Vote struct {
+	Type      SignedMsgType
+	Height    int64
+	Round     int32
+	BlockID   BlockID
+	Timestamp time.Time
+}
+
+// Proposal contains the fields of a CometBFT proposal that are covered by the signature
+type Proposal struct {
+	Height    int64
+	Round     int32
+	POLRound  int32
+	BlockID   BlockID
+	Timestamp time.Time
+}
+
+// VoteSignBytes returns the length-delimited CanonicalVote protobuf encoding that validators sign
+func VoteSignBytes(chainID string, vote Vote) []byte {
+	var msg []byte
+	msg = appendProtoVarint(msg, 1, uint64(vote.Type))
+	msg = appendProtoFixed64(msg, 2, uint64(vote.Height))
+	msg = appendProtoFixed64(msg, 3, uint64(int64(vote.Round)))
+	if !vote.BlockID.IsZero() {
+		msg = appendProtoBytes(msg, 4, canonicalBlockID(vote.BlockID), false)
+	}
+	msg = appendProtoBytes(msg, 5, canonicalTimestamp(vote.Timestamp), true)
+	msg = appendProtoBytes(msg, 6, []byte(chainID), false)
+
+	return appendUvarint(nil, uint64(len(msg)), msg...)
+}
+
+// ProposalSignBytes returns the length-delimited CanonicalProposal protobuf encoding that validators sign
+func ProposalSignBytes(chainID string, proposal Proposal) []byte {
+	var msg []byte
+	msg = appendProtoVarint(msg, 1, uint64(ProposalType))
+	msg = appendProtoFixed64(msg, 2, uint64(proposal.Height))
+	msg = appendProtoFixed64(msg, 3, uint64(int64(proposal.Round)))
+	msg = appendProtoVarint(msg, 4, uint64(int64(proposal.POLRound)))
+	if !proposal.BlockID.IsZero() {
+		msg = appendProtoBytes(msg, 5, canonicalBlockID(proposal.BlockID), false)
+	}
+	msg = appendProtoBytes(msg, 6, canonicalTimestamp(proposal.Timestamp), true)
+	msg = appendProtoBytes(msg, 7, []byte(chainID), false)
+
+	return appendUvarint(nil, uint64(len(msg)), msg...)
+}
+
+// SignVote signs a vote and verifies the signature with the public key of the same path
+// this command requires user confirmation in the device for the first vote
+func (ledger *LedgerTendermintValidator) SignVote(bip32Path []uint32, chainID string, vote Vote) ([]byte, error) {
+	if vote.Type != PrevoteType && vote.Type != PrecommitType {
+		return nil, errors.New("vote type should be prevote or precommit")
+	}
+	return ledger.SignAndVerifyED25519(bip32Path, VoteSignBytes(chainID, vote))
+}
+
+// SignProposal signs a proposal and verifies the signature with the public key of the same path
+// this command requires user confirmation in the device for the first vote
+func (ledger *LedgerTendermintValidator) SignProposal(bip32Path []uint32, chainID string, proposal Proposal) ([]byte, error) {
+	return ledger.SignAndVerifyED25519(bip32Path, ProposalSignBytes(chainID, proposal))
+}
+
+// SignAndVerifyED25519 signs a message and checks the signature against GetPublicKeyED25519
+func (ledger *LedgerTendermintValidator) SignAndVerifyED25519(bip32Path []uint32, message []byte) ([]byte, error) {
+	signature, err := ledger.SignED25519(bip32Path, message)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKey, err := ledger.GetPublicKeyED25519(bip32Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := VerifyED25519(pubKey, message, signature); err != nil {
+		return nil, err
+	}
+
+	return signature, nil
+}
+
+// VerifyED25519 checks a signature returned by the validator app
+func VerifyED25519(pubKey []byte, message []byte, signature []byte) error {
+	if len(pubKey) != ed25519.PublicKeySize {
+		return errors.New("invalid public key length")
+	}
+	if len(signature) != ed25519.SignatureSize {
+		return errors.New("invalid signature length")
+	}
+	if !ed25519.Verify(pubKey, message, signature) {
+		return errors.New("signature verification failed")
+	}
+	return nil
+}
+
+func canonicalBlockID(blockID BlockID) []byte {
+	var psh []byte
+	psh = appendProtoVarint(psh, 1, uint64(blockID.PartSetHeader.Total))
+	psh = appendProtoBytes(psh, 2, blockID.PartSetHeader.Hash, false)
+
+	var msg []byte
+	msg = appendProtoBytes(msg, 1, blockID.Hash, false)
+	// The part set header is not nullable
+	msg = appendProtoBytes(msg, 2, psh, true)
+	return msg
+}
+
+func canonicalTimestamp(t time.Time) []byte {
+	var msg []byte
+	msg = appendProtoVarint(msg, 1, uint64(t.Unix()))
+	msg = appendProtoVarint(msg, 2, uint64(int64(t.Nanosecond())))
+	return msg
+}
+
+// Protobuf helpers. Zero values are omitted as in proto3, unless a field is not nullable
+
+const (
+	protoWireVarint  = 0
+	protoWireFixed64 = 1
+	protoWireBytes   = 2
+)
+
+func appendUvarint(buf []byte, value uint64, rest ...byte) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], value)
+	buf = append(buf, tmp[:n]...)
+	return append(buf, rest...)
+}
+
+func appendProtoVarint(buf []byte, field int, value uint64) []byte {
+	if value == 0 {
+		return buf
+	}
+	buf = appendUvarint(buf, uint64(field<<3|protoWireVarint))
+	return appendUvarint(buf, value)
+}
+
+func appendProtoFixed64(buf []byte, field int, value uint64) []byte {
+	if value == 0 {
+		return buf
+	}
+	buf = appendUvarint(buf, uint64(field<<3|protoWireFixed64))
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], value)
+	return append(buf, tmp[:]...)
+}
+
+func appendProtoBytes(buf []byte, field int, value []byte, always bool) []byte {
+	if len(value) == 0 && !always {
+		return buf
+	}
+	buf = appendUvarint(buf, uint64(field<<3|protoWireBytes))
+	return appendUvarint(buf, uint64(len(value)), value...)
+}
+
+// SignedMessage is a decoded CanonicalVote or CanonicalProposal
+type SignedMessage struct {
+	Type      SignedMsgType
+	Height    int64
+	Round     int64
+	POLRound  int64 // proposals only
+	BlockID   BlockID
+	Timestamp time.Time
+	ChainID   string
+}
+
+// IsProposal returns true if the message is a proposal
+func (m SignedMessage) IsProposal() bool {
+	return m.Type == ProposalType
+}
+
+// DecodeSignBytes decodes the sign bytes produced by VoteSignBytes or ProposalSignBytes
+func DecodeSignBytes(signBytes []byte) (*SignedMessage, error) {
+	size, n := binary.Uvarint(signBytes)
+	if n <= 0 || uint64(len(signBytes)-n) != size {
+		return nil, errors.New("invalid sign bytes: wrong length prefix")
+	}
+
+	fields, err := decodeProtoFields(signBytes[n:])
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &SignedMessage{Timestamp: time.Unix(0, 0).UTC()}
+	if f, ok := fields[1]; ok {
+		msg.Type = SignedMsgType(f.varint)
+	}
+
+	// Field numbers after the round differ between votes and proposals
+	blockIDField, timestampField, chainIDField := 4, 5, 6
+	switch msg.Type {
+	case PrevoteType, PrecommitType:
+	case ProposalType:
+		blockIDField, timestampField, chainIDField = 5, 6, 7
+		msg.POLRound = int64(fields[4].varint)
+	default:
+		return nil, fmt.Errorf("invalid sign bytes: unknown message type %d", msg.Type)
+	}
+
+	msg.Height = int64(fields[2].fixed64)
+	msg.Round = int64(fields[3].fixed64)
+	msg.ChainID = string(fields[chainIDField].bytes)
+
+	if f, ok := fields[blockIDField]; ok {
+		if msg.BlockID, err = decodeBlockID(f.bytes); err != nil {
+			return nil, err
+		}
+	}
+	if f, ok := fields[timestampField]; ok {
+		ts, err := decodeProtoFields(f.bytes)
+		if err != nil {
+			return nil, err
+		}
+		msg.Timestamp = time.Unix(int64(ts[1].varint), int64(ts[2].varint)).UTC()
+	}
+
+	return msg, nil
+}
+
+func decodeBlockID(data []byte) (BlockID, error) {
+	fields, err := decodeProtoFields(data)
+	if err != nil {
+		return BlockID{}, err
+	}
+	psh, err := decodeProtoFields(fields[2].bytes)
+	if err != nil {
+		return BlockID{}, err
+	}
+	return BlockID{
+		Hash: fields[1].bytes,
+		PartSetHeader: PartSetHeader{
+			Total: uint32(psh[1].varint),
+			Hash:  psh[2].bytes,
+		},
+	}, nil
+}
+
+type protoField struct {
+	varint  uint64
+	fixed64 uint64
+	bytes   []byte
+}
+
+// decodeProtoFields decodes a flat protobuf message. Repeated fields keep the last value
+func decodeProtoFields(data []byte) (map[int]protoField, error) {
+	fields := map[int]protoField{}
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errors.New("invalid protobuf: bad field key")
+		}
+		data = data[n:]
+
+		var f protoField
+		switch key & 7 {
+		case protoWireVarint:
+			f.varint, n = binary.Uvarint(data)
+			if n <= 0 {
+				return nil, errors.New("invalid protobuf: bad varint")
+			}
+			data = data[n:]
+		case protoWireFixed64:
+			if len(data) < 8 {
+				return nil, errors.New("invalid protobuf: truncated fixed64")
+			}
+			f.fixed64 = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case protoWireBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return nil, errors.New("invalid protobuf: truncated bytes")
+			}
+			f.bytes = data[n : n+int(size)]
+			data = data[n+int(size):]
+		default:
+			return nil, fmt.Errorf("invalid protobuf: unsupported wire type %d", key&7)
+		}
+		fields[int(key>>3)] = f
+	}
+	return fields, nil
+}
@@ -0,0 +1,167 @@
+/*******************************************************************************
+*   (c) 2018 - 2022 Zondax AG
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+********************************************************************************/
+
+package ledger_thorchain_go
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMockValidator emulates the legacy validator app with a fixed ed25519 key
+func newMockValidator(seed byte) (*mockDevice, ed25519.PrivateKey) {
+	return newMockValidatorVersion(seed, VersionInfo{0, 0, 9, 0}, FramingPacketCount)
+}
+
+// newMockValidatorVersion emulates the validator app of the given version speaking the given protocol
+func newMockValidatorVersion(seed byte, version VersionInfo, framing PayloadFraming) (*mockDevice, ed25519.PrivateKey) {
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))
+	device := newMockDevice()
+
+	pathSize := 41
+	if framing == FramingPayloadDescriptor {
+		pathSize = 20
+	}
+
+	var payload []byte
+	device.handler = func(command []byte) ([]byte, error) {
+		switch command[1] {
+		case validatorINSGetVersion:
+			return []byte{version.AppMode, version.Major, version.Minor, version.Patch}, nil
+		case validatorINSPublicKeyED25519:
+			if len(command[5:]) != pathSize {
+				return nil, errors.New("[APDU_CODE_DATA_INVALID] Referenced data reversibly blocked (invalidated)")
+			}
+			return key.Public().(ed25519.PublicKey), nil
+		case validatorINSSignED25519:
+			first, last := command[2] == 1, command[2] == command[3]
+			if framing == FramingPayloadDescriptor {
+				first, last = command[2] == payloadInit, command[2] == payloadLast
+			}
+			if first {
+				if len(command[5:]) != pathSize {
+					return nil, errors.New("[APDU_CODE_DATA_INVALID] Referenced data reversibly blocked (invalidated)")
+				}
+				payload = nil
+				return nil, nil
+			}
+			payload = append(payload, command[5:]...)
+			if !last {
+				return nil, nil
+			}
+			return ed25519.Sign(key, payload), nil
+		}
+		return nil, errors.New("[APDU_CODE_INS_NOT_SUPPORTED] Instruction code not supported or invalid")
+	}
+	return device, key
+}
+
+// newNegotiatedValidator returns a validator app connected to device, after version negotiation
+func newNegotiatedValidator(t *testing.T, device *mockDevice) *LedgerTendermintValidator {
+	validatorApp := &LedgerTendermintValidator{api: device}
+	_, err := validatorApp.Negotiate()
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	return validatorApp
+}
+
+func Test_VoteSignBytes(t *testing.T) {
+	// Test vectors from CometBFT types/vote_test.go
+	zeroTimestamp := []byte{0x2a, 0xb, 0x8, 0x80, 0x92, 0xb8, 0xc3, 0x98, 0xfe, 0xff, 0xff, 0xff, 0x1}
+
+	assert.Equal(t, append([]byte{0xd}, zeroTimestamp...), VoteSignBytes("", Vote{}))
+
+	expected := []byte{0x21, 0x8, 0x2, 0x11, 0x1, 0, 0, 0, 0, 0, 0, 0, 0x19, 0x1, 0, 0, 0, 0, 0, 0, 0}
+	expected = append(expected, zeroTimestamp...)
+	assert.Equal(t, expected, VoteSignBytes("", Vote{Type: PrecommitType, Height: 1, Round: 1}))
+
+	expected = []byte{0x2e, 0x11, 0x1, 0, 0, 0, 0, 0, 0, 0, 0x19, 0x1, 0, 0, 0, 0, 0, 0, 0}
+	expected = append(expected, zeroTimestamp...)
+	expected = append(expected, 0x32, 0xd)
+	expected = append(expected, "test_chain_id"...)
+	assert.Equal(t, expected, VoteSignBytes("test_chain_id", Vote{Height: 1, Round: 1}))
+}
+
+func Test_VoteSignBytes_BlockID(t *testing.T) {
+	vote := Vote{
+		Type:   PrevoteType,
+		Height: 2,
+		BlockID: BlockID{
+			Hash:          []byte{0xaa, 0xbb},
+			PartSetHeader: PartSetHeader{Total: 1, Hash: []byte{0xcc}},
+		},
+		Timestamp: time.Unix(1, 5).UTC(),
+	}
+
+	expected := []byte{0x21, 0x8, 0x1, 0x11, 0x2, 0, 0, 0, 0, 0, 0, 0}
+	expected = append(expected, 0x22, 0xb, 0xa, 0x2, 0xaa, 0xbb, 0x12, 0x5, 0x8, 0x1, 0x12, 0x1, 0xcc)
+	expected = append(expected, 0x2a, 0x4, 0x8, 0x1, 0x10, 0x5)
+	expected = append(expected, 0x32, 0x1, 'x')
+	assert.Equal(t, expected, VoteSignBytes("x", vote))
+}
+
+func Test_ProposalSignBytes(t *testing.T) {
+	proposal := Proposal{Height: 1, Round: 1, POLRound: -1, Timestamp: time.Unix(1, 0)}
+
+	expected := []byte{0x30, 0x8, 0x20, 0x11, 0x1, 0, 0, 0, 0, 0, 0, 0, 0x19, 0x1, 0, 0, 0, 0, 0, 0, 0}
+	expected = append(expected, 0x20, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x1)
+	expected = append(expected, 0x32, 0x2, 0x8, 0x1)
+	expected = append(expected, 0x3a, 0xb)
+	expected = append(expected, "thorchain-1"...)
+	assert.Equal(t, expected, ProposalSignBytes("thorchain-1", proposal))
+}
+
+func Test_SignVote_Mock(t *testing.T) {
+	for _, framing := range []PayloadFraming{FramingPacketCount, FramingPayloadDescriptor} {
+		device, key := newMockValidatorVersion(1, VersionInfo{0, 1, 0, 0}, framing)
+		testSignVote(t, newNegotiatedValidator(t, device), key)
+	}
+}
+
+func testSignVote(t *testing.T, validatorApp *LedgerTendermintValidator, key ed25519.PrivateKey) {
+	path := []uint32{44, 118, 0, 0, 0}
+	vote := Vote{Type: PrecommitType, Height: 100, Round: 0, Timestamp: time.Unix(1700000000, 0)}
+
+	// Make the payload span several chunks
+	chainID := string(bytes.Repeat([]byte{'a'}, 300))
+
+	signature, err := validatorApp.SignVote(path, chainID, vote)
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.True(t, ed25519.Verify(key.Public().(ed25519.PublicKey), VoteSignBytes(chainID, vote), signature))
+
+	_, err = validatorApp.SignVote(path, chainID, Vote{Type: ProposalType})
+	assert.Error(t, err)
+
+	signature, err = validatorApp.SignProposal(path, "thorchain-1", Proposal{Height: 1, POLRound: -1})
+	require.Nil(t, err, "Detected error, err: %s\n", err)
+	assert.Len(t, signature, ed25519.SignatureSize)
+}
+
+func Test_VerifyED25519(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	pubKey := key.Public().(ed25519.PublicKey)
+	signature := ed25519.Sign(key, []byte("message"))
+
+	assert.Nil(t, VerifyED25519(pubKey, []byte("message"), signature))
+	assert.EqualError(t, VerifyED25519(pubKey, []byte("other"), signature), "signature verification failed")
+	assert.Error(t, VerifyED25519(pubKey[:31], []byte("message"), signature))
+	assert.Error(t, VerifyED25519(pubKey, []byte("message"), signature[:63]))
+}